@@ -4,14 +4,31 @@ import (
 	"database/sql"
 	"log"
 	"net/http"
+	"user/server/config"
+	"user/server/services/account"
+	"user/server/services/apitoken"
+	"user/server/services/attachment"
+	"user/server/services/auth"
 	"user/server/services/channel"
+	"user/server/services/dm"
+	"user/server/services/emoji"
 	"user/server/services/hub"
 	"user/server/services/image"
 	"user/server/services/invite"
+	"user/server/services/loginattempt"
+	"user/server/services/mail"
+	"user/server/services/member"
 	"user/server/services/message"
+	"user/server/services/mfa"
+	"user/server/services/notification"
+	"user/server/services/oidc"
 	"user/server/services/permissions"
 	"user/server/services/room"
+	"user/server/services/search"
+	"user/server/services/session"
 	"user/server/services/user"
+	"user/server/services/utils"
+	"user/server/types"
 
 	"github.com/gorilla/mux"
 )
@@ -19,45 +36,109 @@ import (
 type APIServer struct {
 	addr string
 	db   *sql.DB
+	cfg  config.Config
 }
 
-func NewAPIServer(addr string, db *sql.DB) *APIServer {
+func NewAPIServer(addr string, db *sql.DB, cfg config.Config) *APIServer {
 	return &APIServer{
 		addr: addr,
 		db:   db,
+		cfg:  cfg,
 	}
 }
 
 func (s *APIServer) Run() error {
 	router := mux.NewRouter()
+	router.HandleFunc("/.well-known/jwks.json", utils.CorsHandler(auth.JWKSHandler)).Methods("GET", "OPTIONS")
+
 	subrouter := router.PathPrefix("/api/v1").Subrouter()
 
+	sessionStore := session.NewStore(s.db)
+	auth.SetSessionStore(sessionStore)
+
+	apiTokenStore := apitoken.NewStore(s.db)
+	auth.SetAPITokenStore(apiTokenStore)
+
 	userStore := user.NewStore(s.db)
-	userHandler := user.NewHandler(userStore)
+	mailer := mail.NewMailer(s.cfg)
+	mfaStore := mfa.NewStore(s.db)
+	limiter := auth.NewLoginLimiter(s.loginAttemptStore())
+	userHandler := user.NewHandler(userStore, sessionStore, mfaStore, mailer, s.cfg.AppURL, limiter)
 	userHandler.RegisterRoutes(subrouter)
 
+	accountStore := account.NewStore(s.db)
+	accountHandler := account.NewHandler(accountStore, userStore, sessionStore, mailer, s.cfg.AppURL)
+	accountHandler.RegisterRoutes(subrouter)
+
+	mfaHandler := mfa.NewHandler(mfaStore, userStore, limiter)
+	mfaHandler.RegisterRoutes(subrouter)
+
+	if s.cfg.OIDCIssuerURL != "" {
+		identityStore := oidc.NewStore(s.db)
+		oidcHandler := oidc.NewHandler(identityStore, userStore, s.cfg)
+		oidcHandler.RegisterRoutes(subrouter)
+	}
+
+	permissionStore := permissions.NewStore(s.db)
+	permissionHandler := permissions.NewHandler(permissionStore, userStore)
+	permissionHandler.RegisterRoutes(subrouter)
+
+	// Channel and room listings carry unread counts from the message store.
+	messageStore := message.NewStore(s.db)
+
 	channelStore := channel.NewStore(s.db)
-	channelHandler := channel.NewHandler(channelStore, userStore)
+	channelHandler := channel.NewHandler(channelStore, userStore, permissionStore, messageStore)
 	channelHandler.RegisterRoutes(subrouter)
 
 	roomStore := room.NewStore(s.db)
-	roomHandler := room.NewHandler(roomStore, userStore)
+	roomHandler := room.NewHandler(roomStore, userStore, permissionStore, messageStore)
 	roomHandler.RegisterRoutes(subrouter)
 
-	messageStore := message.NewStore(s.db)
-	messageHandler := message.NewHandler(messageStore, userStore)
+	emojiStore := emoji.NewStore(s.db)
+	emojiHandler := emoji.NewHandler(emojiStore, userStore, permissionStore)
+	emojiHandler.RegisterRoutes(subrouter)
+
+	notificationStore := notification.NewStore(s.db)
+	notificationHandler := notification.NewHandler(notificationStore, userStore)
+	notificationHandler.RegisterRoutes(subrouter)
+	notifier := notification.NewNotifier(notificationStore, permissionStore)
+
+	messageHandler := message.NewHandler(messageStore, userStore, permissionStore, emojiStore, notifier)
 	messageHandler.RegisterRoutes(subrouter)
 
+	conversationStore := dm.NewStore(s.db)
+	conversationHandler := dm.NewHandler(conversationStore, userStore, messageStore, permissionStore)
+	conversationHandler.RegisterRoutes(subrouter)
+
+	blobStore, err := attachment.NewBlobStore(s.cfg)
+	if err != nil {
+		return err
+	}
+	attachmentStore := attachment.NewStore(s.db)
+	attachmentHandler := attachment.NewHandler(attachmentStore, blobStore, userStore, permissionStore,
+		s.cfg.MaxAttachmentSize, s.cfg.AttachmentTypes)
+	attachmentHandler.RegisterRoutes(subrouter)
+	attachmentHandler.StartCleanup()
+
+	searchStore := search.NewStore(s.db)
+	searchHandler := search.NewHandler(searchStore, userStore)
+	searchHandler.RegisterRoutes(subrouter)
+
 	imageStore := image.NewStore(s.db)
 	imageHandler := image.NewHandler(imageStore, userStore)
 	imageHandler.RegisterRoutes(subrouter)
 
-	permissionStore := permissions.NewStore(s.db)
+	memberStore := member.NewStore(s.db)
+	memberHandler := member.NewHandler(memberStore, userStore, permissionStore)
+	memberHandler.RegisterRoutes(subrouter)
 
 	inviteStore := invite.NewStore(s.db)
-	inviteHandler := invite.NewHandler(inviteStore, userStore, permissionStore)
+	inviteHandler := invite.NewHandler(inviteStore, userStore, permissionStore, memberStore, s.cfg.RequireEmailVerification)
 	inviteHandler.RegisterRoutes(subrouter)
 
+	apiTokenHandler := apitoken.NewHandler(apiTokenStore, userStore, channelStore, permissionStore, memberStore)
+	apiTokenHandler.RegisterRoutes(subrouter)
+
 	hubStore := hub.NewStore(s.db)
 	hubHandler := hub.NewHandler(hubStore, channelStore, roomStore, userStore)
 	hubHandler.HubInitialize()
@@ -66,3 +147,10 @@ func (s *APIServer) Run() error {
 	log.Println("Starting server on", s.addr)
 	return http.ListenAndServe(s.addr, router)
 }
+
+func (s *APIServer) loginAttemptStore() types.LoginAttemptStore {
+	if s.cfg.LoginAttemptStore == "memory" {
+		return loginattempt.NewMemoryStore()
+	}
+	return loginattempt.NewStore(s.db)
+}
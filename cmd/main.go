@@ -6,10 +6,19 @@ import (
 	"user/server/cmd/api"
 	"user/server/config"
 	"user/server/db"
+	"user/server/services/auth"
+	"user/server/services/utils"
 )
 
 func main() {
 	cfg := config.GetConfig()
+
+	if err := auth.ConfigureKeys(cfg); err != nil {
+		log.Fatal("Error configuring JWT keys: ", err)
+	}
+	if err := utils.ConfigureTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatal("Error configuring trusted proxies: ", err)
+	}
 	db.Db = db.DbConnect(
 		cfg.DBHost,
 		cfg.DBPort,
@@ -18,7 +27,7 @@ func main() {
 		cfg.DBName,
 	)
 
-	server := api.NewAPIServer(fmt.Sprintf(":%s", cfg.Port), db.Db)
+	server := api.NewAPIServer(fmt.Sprintf(":%s", cfg.Port), db.Db, cfg)
 	if err := server.Run(); err != nil {
 		log.Fatal(err)
 	}
@@ -1,7 +1,9 @@
 package config
 
 import (
+	"log"
 	"os"
+	"strconv"
 )
 
 type Config struct {
@@ -12,27 +14,130 @@ type Config struct {
 	DBUser      string
 	DBPassword  string
 	DBName      string
+
+	// JWTAlgorithm is one of HS256, RS256 or EdDSA. HS256 signs with
+	// JWTSecret, the others with the PEM key in JWTPrivateKeyFile.
+	JWTAlgorithm      string
+	JWTKeyID          string
+	JWTSecret         string
+	JWTPrivateKeyFile string
+	// JWTVerifyKeys lists extra keys that are still accepted while
+	// rotating, as comma separated "kid=ALG:value" entries where value is
+	// the secret for HS256 or a public key PEM file otherwise.
+	JWTVerifyKeys string
+
+	// OIDC login is enabled when OIDCIssuerURL is set. OIDCPostLoginURL is
+	// where the browser lands once the session cookies are set.
+	OIDCIssuerURL    string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCPostLoginURL string
+
+	// AppURL is the frontend base URL used for links in emails. Mail goes
+	// through SMTP when SMTPHost is set, otherwise it is written to MailDir.
+	AppURL       string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	MailFrom     string
+	MailDir      string
+	// RequireEmailVerification blocks joining channels until the user
+	// confirmed their email address.
+	RequireEmailVerification bool
+
+	// LoginAttemptStore is "memory" for a single instance or "postgres" to
+	// share login lockouts between replicas.
+	LoginAttemptStore string
+	// TrustedProxies is a comma separated list of IPs and CIDRs whose
+	// X-Forwarded-For and X-Real-IP headers are believed.
+	TrustedProxies string
+
+	// BlobStore is "local" to keep attachments in BlobDir or "s3" for an
+	// S3 compatible service such as MinIO. AttachmentTypes is a comma
+	// separated list of allowed content types, where a trailing slash
+	// allows a whole family like "image/".
+	BlobStore         string
+	BlobDir           string
+	S3Endpoint        string
+	S3Region          string
+	S3Bucket          string
+	S3AccessKey       string
+	S3SecretKey       string
+	MaxAttachmentSize int64
+	AttachmentTypes   string
 }
 
+const defaultAttachmentTypes = "image/,video/,audio/,text/plain,text/csv,application/pdf,application/zip," +
+	"application/json,application/msword,application/vnd.openxmlformats-officedocument.wordprocessingml.document," +
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet," +
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation,application/octet-stream"
+
 var (
 	Development = Config{
-		Environment: "development",
-		Port:        "8080",
-		DBHost:      "localhost",
-		DBPort:      "5432",
-		DBUser:      "postgres",
-		DBPassword:  "password",
-		DBName:      "chat_app",
+		Environment:  "development",
+		Port:         "8080",
+		DBHost:       "localhost",
+		DBPort:       "5432",
+		DBUser:       "postgres",
+		DBPassword:   "password",
+		DBName:       "chat_app",
+		JWTAlgorithm: "HS256",
+		JWTKeyID:     "development",
+		JWTSecret:    "my_secret_key",
+		// Point OIDCIssuerURL at a local provider to try SSO in development.
+		OIDCRedirectURL:  "http://localhost:8080/api/v1/auth/oidc/callback",
+		OIDCPostLoginURL: "http://localhost:3000/",
+		AppURL:           "http://localhost:3000",
+		MailFrom:         "Go Chat App <no-reply@localhost>",
+		MailDir:          "mail",
+
+		LoginAttemptStore: "memory",
+		BlobStore:         "local",
+		BlobDir:           "uploads",
+		MaxAttachmentSize: 25 << 20,
+		AttachmentTypes:   defaultAttachmentTypes,
 	}
 
 	Production = Config{
-		Environment: "production",
-		Port:        os.Getenv("PORT"),
-		DBHost:      os.Getenv("DB_HOST"),
-		DBPort:      os.Getenv("DB_PORT"),
-		DBUser:      os.Getenv("DB_USER"),
-		DBPassword:  os.Getenv("DB_PASSWORD"),
-		DBName:      os.Getenv("DB_NAME"),
+		Environment:       "production",
+		Port:              os.Getenv("PORT"),
+		DBHost:            os.Getenv("DB_HOST"),
+		DBPort:            os.Getenv("DB_PORT"),
+		DBUser:            os.Getenv("DB_USER"),
+		DBPassword:        os.Getenv("DB_PASSWORD"),
+		DBName:            os.Getenv("DB_NAME"),
+		JWTAlgorithm:      getEnv("JWT_ALGORITHM", "HS256"),
+		JWTKeyID:          os.Getenv("JWT_KEY_ID"),
+		JWTSecret:         os.Getenv("JWT_SECRET"),
+		JWTPrivateKeyFile: os.Getenv("JWT_PRIVATE_KEY_FILE"),
+		JWTVerifyKeys:     os.Getenv("JWT_VERIFY_KEYS"),
+		OIDCIssuerURL:     os.Getenv("OIDC_ISSUER_URL"),
+		OIDCClientID:      os.Getenv("OIDC_CLIENT_ID"),
+		OIDCClientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+		OIDCRedirectURL:   os.Getenv("OIDC_REDIRECT_URL"),
+		OIDCPostLoginURL:  os.Getenv("OIDC_POST_LOGIN_URL"),
+		AppURL:            os.Getenv("APP_URL"),
+		SMTPHost:          os.Getenv("SMTP_HOST"),
+		SMTPPort:          getEnv("SMTP_PORT", "587"),
+		SMTPUsername:      os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:      os.Getenv("SMTP_PASSWORD"),
+		MailFrom:          os.Getenv("MAIL_FROM"),
+		MailDir:           getEnv("MAIL_DIR", "mail"),
+
+		RequireEmailVerification: os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true",
+		LoginAttemptStore:        getEnv("LOGIN_ATTEMPT_STORE", "postgres"),
+		TrustedProxies:           os.Getenv("TRUSTED_PROXIES"),
+		BlobStore:                getEnv("BLOB_STORE", "local"),
+		BlobDir:                  getEnv("BLOB_DIR", "uploads"),
+		S3Endpoint:               os.Getenv("S3_ENDPOINT"),
+		S3Region:                 getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:                 os.Getenv("S3_BUCKET"),
+		S3AccessKey:              os.Getenv("S3_ACCESS_KEY"),
+		S3SecretKey:              os.Getenv("S3_SECRET_KEY"),
+		MaxAttachmentSize:        getEnvInt64("MAX_ATTACHMENT_SIZE", 25<<20),
+		AttachmentTypes:          getEnv("ATTACHMENT_TYPES", defaultAttachmentTypes),
 	}
 )
 
@@ -43,3 +148,23 @@ func GetConfig() Config {
 	}
 	return Development
 }
+
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt64(key string, fallback int64) int64 {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %d", key, value, fallback)
+		return fallback
+	}
+	return n
+}
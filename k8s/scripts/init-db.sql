@@ -15,31 +15,59 @@ CREATE TABLE Users (
     Username varchar(255) UNIQUE NOT NULL,
     Password varchar(255) NOT NULL,
     CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    EmailVerified BOOLEAN NOT NULL DEFAULT FALSE,
+    IsBot BOOLEAN NOT NULL DEFAULT FALSE,
+    OwnerID INT NULL REFERENCES Users(ID) ON DELETE SET NULL,
+    DeactivatedAt TIMESTAMP NULL,
     Avatar BYTEA DEFAULT decode('iVBORw0KGgoAAAANSUhEUgAAAEsAAABNCAMAAADZyWnFAAAAOVBMVEXo6epXWFrs7e37+/zz8/Pv7/D+/v5ZWlz29vb5+fm/wMFiYmTh4uPV1teoqaptbnCFhoh5eXuVlpdyR7wwAAAD/ElEQVRYw61Yi5KDIAxETBQQ8fH/H3sJ0Ko14erNMXWcUdkmYVmSmMFbGMD2Pd2c9QMiPXGnJz1i7/gJ5idAT+h2n9Wj8R6cd0A38A7d6cYXWERjrKWftQAWXp/eZ3kwmjkDWrDmcxAg2+ktDmzreRb2BpxzfNUb1BuAUYa1+P70OsuI8WLHWoMtE+IFHwbR7xegbBzcZoE74tX7sljefDUQLrNyvHrPy4DeI68I3awx36LhxzoyVl+wenqK5sF4zSIOAuGQj8zEvNC0PvYJFC8pzxqyKcwJpl+hKAiEMvQoxpjvomV1Ml/mRFEnfBzDvm7btqYQlQU9ccK9hxSqsE5dGdOWZtGyA8Dk3cDAQqxiIqQxD4ZbgmjZaw8NJjtMlxCreR0rUsWbksyNGu4aL/Q3KDuv3QWKxhSkJci6xPEqtPL3YMX1E4nGkqIU/0L1iiUs4S5A0dglsIpVfBRWcBKxulGKGTOVdZVj/6WHGWyZpfAXXWVOfG0Wg+2CYS7rBJNMwEoalGyYzVxlFRX+aFWxxjEJ/110FbzwKm46luik5b1tRax5aWBt0jYvuioJTRNLChipDxpZ3+NjLMu6KopcXJ9iGdZVWTD3p/EiJ80gnhY26FDiOuakQzkuWqQI8hRQzgSTGsHfxXgZb+QcpMWJsUuiAahgtVxUndR8bHFimhUkBavBifEfsRSu6j62gr+bhyPptJ/NMx/VHdlNQU2G1Bey4ms7qI1FeYkU9zTrSDqWFSzrdv17jfdlLW9YnbJ9ql0trDsv2litDPW2lLSI+tde09X8R7cDV5HUenYruqqIa7e2kmoD+GAhW+RCp5xD5e0nVpOodA559z922VzDNJh/o9eqxh4856samJ23OyeSahf5iODlEzIGSfMn5RCyNV/FO4PjzBWHqBMLlzNWzL+EvDCGtC5jpyWsVM7sYb7C2aOGubiWNq6BGkcav10Y7pqvDh959JzWX4AucNG+82j3rmEq0q67Jsr1WipByr1qDeNr3THv0wOgYt24hVPdUWoYAiU2PUN6q5B91chDLZgxjH8ZvBVK/8jjq370PnV/A9sM3HpDzTSp5ePRG3pXy6CXQC2oBEe9feoNPQcjKDz3hk79ifDQTXawluuu1DCn3tBDXizh6Chh7Q29+zkQ9/FbsG5c59zIefWG8KM35IES6O4rXpEswrs3BLU39GpWVmvj+sVWYqMus269oWorJTi/aA5tQ/THLHf0hm6dvxgatrEWBuilfqGThsc5LeNdyKpsWS/OOnpD1/5qP2SBHbvzGFmdSVkGL8zKZ20hm78GLoeABTu3v7ZlW9c9BYr3JUyXOINp96M9kcVENJHqORwGlie1H21/AGSVWyPVNNOeAAAAAElFTkSuQmCC', 'base64'),
 );
 
 CREATE TABLE Channels (
     ID SERIAL PRIMARY KEY,
     Name VARCHAR(255) NOT NULL,
+    Description TEXT NOT NULL DEFAULT '',
     Avatar BYTEA DEFAULT decode('/9j/4AAQSkZJRgABAQEASABIAAD/2wBDAAMCAgICAgMCAgIDAwMDBAYEBAQEBAgGBgUGCQgKCgkICQkKDA8MCgsOCwkJDRENDg8QEBEQCgwSExIQEw8QEBD/2wBDAQMDAwQDBAgEBAgQCwkLEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBD/wAARCABLAEsDASIAAhEBAxEB/8QAHAAAAgIDAQEAAAAAAAAAAAAABAUCCAMGBwkB/8QARhAAAQIEAwMGBwsNAQAAAAAAAQIDAAQFEQYSIQcIMRMiQVFS0hREYZSxsrMWJDJCRWNxgoWRlRcjJVRiZHJ0dYGSosPw/8QAGQEAAwEBAQAAAAAAAAAAAAAAAAIDBAEF/8QAHhEBAQACAQUBAAAAAAAAAAAAAAECESEDEhMxMoH/2gAMAwEAAhEDEQA/AEMjJjTSHkrJjTSMclLaDSO67DNkmGNoNJqs7X3p9DklNNst+DPJQClSMxvdJubxvyymM3WeTfEciZlB1QYiU/Zi1De7Hs7HCdrXnSO5GdG7Ps9/Xq15yjuRPzYn7Kqr4J5IiqU8kWuVu07Pk8J2s+co7kYlbtuz8eN1nzlHcg82LnZVTHpQWPNhdMyYF9It65u2bPjxmqz50juQHO7tOzxEq+8JqtZm2lrHvpHEJJ7Hkg82LvZVNZyTGukJ1yYznmxt8wwVNpWRqpIJ+6FK5bnnSKp2bPZJoaGLS7qMuleHcQXTe0+x7IxWKSSLCLVbpjebDmIdOE+x7ExPrfB8Pbs/giOxGCcVJyDQemsyUqWGxlQpZKjwACQT0Q25LyQgxu3loZPzv/NyMk5q1ALrqUvLyU8Os5rII5ZKynrILVuvS8EordJUE5kPpUVhtSeSVdBNrEg2NjmGoBGvRFfkDmp06BHWMEyImZSirKb2Uwj+wTyp9kPvimWExhJltv8A4GjsQPUJRsU+a5vi7vqGG/JGB6g1+j5vTxd31DEjvOd5oFlH8A9EKls84w8UAZZo9bafRCxaRnMegzjpI6CLX7oqc+HMR/1Bj2JipUk4LCLc7nIz4dxJ/PsexifV+DYe3eORhbXaQ9U5RphlEu4UPocU2+pQQ4gBQUklIJFwrqjYA0L62jmDVaxjK0WXqzk9MFuaYcca5ZTbqluJl3llSQkaI5qCEnW6TfTjkkVowbOqWAB7i8Oecv8AdhpRMKu06dRMKak5WXZuGZWWUtaU/m0oBzKAPb6PjQHMPTTj8kmj4zn6g0tc03mbLZCy2znSm4TZZzaZh0acRCaoYsrDzDUxIVaadaTLtKcXKrbGVwSqluFRUCLJVlUscQOjohuaOHRuR/8AXgeotAU6cJHizvqGE+Dp6fn6tU26jVkzDjDq20spmkFIAUAVBkJCkp6iVG4MbHVm7UucP7u76hhdarrzTaWFyLKvmx6IBWRmOsTkH89NaN+CR6IGW5zjG+M75JP6DWLebm74GGsSqv8AKLHsTFLpGb0AvFqt07F2HKFhvEKK7iKl01TtQYU2mcnG2SsBkgkBZFxfqifV+DYe1rDN2Gmp6rxrXukrxDKZeiMuqypISW3Ggkk2IzKFhbVPlIvwMLDtO2eDjtAw1+LS/fj4dqOzscdoeGR9ry/fjJpUeMU19rKn3OBKEICkrQyshJvqkJFiDrp9OvAwZUK5UZSbcl5WkNvoCkqRlZUcwUnUFQ0Cyb9FrDUm9giO1bZsOO0fC4+2JfvxE7WdmY0O0nCv41Ld+O/gP6JWZ+ZqC25qity7ZZK0zKGVIzkEC1lajjex6PohpVZm9LnRfxZ4/wCio0v8rezLp2lYU/GpbvwPUNq+zV2nTjbW0fCy1KlnQEprMsSSUKsLZ4468+qRNZqahN+CR6Igt8BZ1hPRJz3mEk/FETXN886xvjOXyM9w1hyzMMvAB5tC7dpIMag0pQULG0N5RayBzjAXbZWmKav4UkwfqCCE0+jK+FTmD9QQml3F2+EYObWvtGAw00mgqGtLlj9QRhcoeHFcaRK/4CIha+0Yipau0YNQB38PYaPyRLj6EiF0xh/DqNU05pJ8gEHPOL7RhbMuL15xg1HKwqEtJA8hdI6ibwvXPEqOsRm1ruRmMDQOP//Z', 'base64')
 );
 
-CREATE TABLE Rooms (
+CREATE TABLE RoomCategories (
     ID SERIAL PRIMARY KEY,
-    Name VARCHAR(255) NOT NULL,
     ChannelID INT NOT NULL,
+    Name VARCHAR(255) NOT NULL,
+    Position INT NOT NULL DEFAULT 0,
+    CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (ChannelID) REFERENCES Channels(ID) ON DELETE CASCADE
 );
 
+CREATE TABLE Rooms (
+    ID SERIAL PRIMARY KEY,
+    Name VARCHAR(255) NOT NULL,
+    ChannelID INT NULL, -- NULL for direct conversations
+    IsPrivate BOOLEAN NOT NULL DEFAULT FALSE,
+    Topic TEXT NOT NULL DEFAULT '',
+    Position INT NOT NULL DEFAULT 0,
+    Kind VARCHAR(16) NOT NULL DEFAULT 'voice' CHECK (Kind IN ('text', 'voice', 'stage', 'announcement', 'dm')),
+    CategoryID INT NULL,
+    CHECK ((Kind = 'dm') = (ChannelID IS NULL)),
+    FOREIGN KEY (ChannelID) REFERENCES Channels(ID) ON DELETE CASCADE,
+    FOREIGN KEY (CategoryID) REFERENCES RoomCategories(ID) ON DELETE SET NULL
+);
+
 CREATE TABLE Messages (
     ID SERIAL PRIMARY KEY,
     RoomID INT NOT NULL,
     SenderID INT NOT NULL, -- Assuming SenderID is a reference to Users.ID
+    ParentID INT NULL,
     Content TEXT,
     Timestamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
     IsRead BOOLEAN,
+    EditedAt TIMESTAMP NULL,
+    DeletedAt TIMESTAMP NULL,
+    Nonce VARCHAR(64) NULL, -- set by the sender so retried sends are stored once
+    SearchVector TSVECTOR GENERATED ALWAYS AS (to_tsvector('english', COALESCE(Content, ''))) STORED,
+    UNIQUE (SenderID, Nonce),
     FOREIGN KEY (RoomID) REFERENCES Rooms(ID) ON DELETE CASCADE,
-    FOREIGN KEY (SenderID) REFERENCES Users(ID)
+    FOREIGN KEY (SenderID) REFERENCES Users(ID),
+    FOREIGN KEY (ParentID) REFERENCES Messages(ID) ON DELETE CASCADE
 );
 
 CREATE TABLE Invites (
@@ -59,19 +87,34 @@ CREATE TABLE Invites (
 	UNIQUE (ChannelID, InviteeID)
 );
 
-CREATE TABLE SeenMessages (
-	user_id INT NOT NULL,
-	message_id INT NOT NULL,
-	seen_time_stamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-	PRIMARY KEY (user_id, message_id),
-	FOREIGN KEY (user_id) REFERENCES Users(ID),
-	FOREIGN KEY (message_id) REFERENCES Messages(ID)
+CREATE TABLE ReadPointers (
+	UserID INT NOT NULL,
+	RoomID INT NOT NULL,
+	LastReadMessageID INT NOT NULL,
+	UpdatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (UserID, RoomID),
+	FOREIGN KEY (UserID) REFERENCES Users(ID) ON DELETE CASCADE,
+	FOREIGN KEY (RoomID) REFERENCES Rooms(ID) ON DELETE CASCADE
+);
+
+CREATE TABLE ChannelRoles (
+	ID SERIAL PRIMARY KEY,
+	ChannelID INT NOT NULL,
+	Name VARCHAR(64) NOT NULL,
+	Permissions BIGINT NOT NULL DEFAULT 0,
+	CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (ChannelID, Name),
+	FOREIGN KEY (ChannelID) REFERENCES Channels(ID) ON DELETE CASCADE
 );
 
 CREATE TABLE ChannelsToUsers (
     user_id INT,
     channel_id INT,
+    role VARCHAR(32) NOT NULL DEFAULT 'member',
+    custom_role_id INT NULL,
+    joined_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
     PRIMARY KEY (user_id, channel_id),
+    FOREIGN KEY (custom_role_id) REFERENCES ChannelRoles(ID) ON DELETE SET NULL,
     FOREIGN KEY (user_id) REFERENCES Users(ID) ON DELETE CASCADE,
     FOREIGN KEY (channel_id) REFERENCES Channels(ID) ON DELETE CASCADE
 );
@@ -84,9 +127,238 @@ CREATE TABLE RoomsToChannels (
     FOREIGN KEY (channel_id) REFERENCES Channels(ID) ON DELETE CASCADE
 );
 
+CREATE TABLE Sessions (
+	ID UUID PRIMARY KEY,
+	UserID INT NOT NULL,
+	Device VARCHAR(255) NOT NULL DEFAULT '',
+	IPAddress VARCHAR(64) NOT NULL DEFAULT '',
+	UserAgent TEXT NOT NULL DEFAULT '',
+	CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	LastSeen TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	ExpiresAt TIMESTAMP NOT NULL,
+	RevokedAt TIMESTAMP NULL,
+	FOREIGN KEY (UserID) REFERENCES Users(ID) ON DELETE CASCADE
+);
+
+CREATE TABLE RefreshTokens (
+	TokenHash VARCHAR(64) PRIMARY KEY,
+	SessionID UUID NOT NULL,
+	CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	ExpiresAt TIMESTAMP NOT NULL,
+	UsedAt TIMESTAMP NULL,
+	FOREIGN KEY (SessionID) REFERENCES Sessions(ID) ON DELETE CASCADE
+);
+
+CREATE TABLE UserIdentities (
+	Issuer VARCHAR(255) NOT NULL,
+	Subject VARCHAR(255) NOT NULL,
+	UserID INT NOT NULL,
+	Email VARCHAR(255) NOT NULL,
+	CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (Issuer, Subject),
+	FOREIGN KEY (UserID) REFERENCES Users(ID) ON DELETE CASCADE
+);
+
+CREATE TABLE UserMFA (
+	UserID INT PRIMARY KEY,
+	Secret VARCHAR(64) NOT NULL,
+	Enabled BOOLEAN NOT NULL DEFAULT FALSE,
+	LastUsedStep BIGINT NOT NULL DEFAULT 0,
+	CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	EnabledAt TIMESTAMP NULL,
+	FOREIGN KEY (UserID) REFERENCES Users(ID) ON DELETE CASCADE
+);
+
+CREATE TABLE MFARecoveryCodes (
+	ID SERIAL PRIMARY KEY,
+	UserID INT NOT NULL,
+	CodeHash VARCHAR(64) NOT NULL,
+	CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UsedAt TIMESTAMP NULL,
+	FOREIGN KEY (UserID) REFERENCES Users(ID) ON DELETE CASCADE
+);
+
+CREATE TABLE MFAChallenges (
+	TokenID UUID PRIMARY KEY,
+	UserID INT NOT NULL,
+	CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	ExpiresAt TIMESTAMP NOT NULL,
+	UsedAt TIMESTAMP NULL,
+	FOREIGN KEY (UserID) REFERENCES Users(ID) ON DELETE CASCADE
+);
+
+CREATE TABLE PasswordResets (
+	TokenID UUID PRIMARY KEY,
+	UserID INT NOT NULL,
+	CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	ExpiresAt TIMESTAMP NOT NULL,
+	UsedAt TIMESTAMP NULL,
+	FOREIGN KEY (UserID) REFERENCES Users(ID) ON DELETE CASCADE
+);
+
+CREATE TABLE LoginAttempts (
+	Key VARCHAR(320) PRIMARY KEY,
+	Failures INT NOT NULL DEFAULT 0,
+	LastFailureAt TIMESTAMP NOT NULL,
+	LockedUntil TIMESTAMP NULL
+);
+
+CREATE TABLE LoginAudit (
+	ID SERIAL PRIMARY KEY,
+	UserID INT NULL,
+	Email VARCHAR(255) NOT NULL,
+	IPAddress VARCHAR(64) NOT NULL DEFAULT '',
+	UserAgent TEXT NOT NULL DEFAULT '',
+	Reason VARCHAR(64) NOT NULL,
+	CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (UserID) REFERENCES Users(ID) ON DELETE SET NULL
+);
+
+CREATE TABLE ApiTokens (
+	ID SERIAL PRIMARY KEY,
+	UserID INT NOT NULL,
+	Name VARCHAR(255) NOT NULL,
+	Prefix VARCHAR(16) NOT NULL,
+	TokenHash VARCHAR(64) UNIQUE NOT NULL,
+	Scopes VARCHAR(64) NOT NULL,
+	CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	LastUsedAt TIMESTAMP NULL,
+	ExpiresAt TIMESTAMP NULL,
+	RevokedAt TIMESTAMP NULL,
+	FOREIGN KEY (UserID) REFERENCES Users(ID) ON DELETE CASCADE
+);
+
+CREATE TABLE RoomAccess (
+	ID SERIAL PRIMARY KEY,
+	RoomID INT NOT NULL,
+	UserID INT NULL,
+	Role VARCHAR(32) NULL,
+	CustomRoleID INT NULL,
+	CHECK (num_nonnulls(UserID, Role, CustomRoleID) = 1),
+	FOREIGN KEY (RoomID) REFERENCES Rooms(ID) ON DELETE CASCADE,
+	FOREIGN KEY (UserID) REFERENCES Users(ID) ON DELETE CASCADE,
+	FOREIGN KEY (CustomRoleID) REFERENCES ChannelRoles(ID) ON DELETE CASCADE
+);
+
+CREATE TABLE ChannelBans (
+	ChannelID INT NOT NULL,
+	UserID INT NOT NULL,
+	BannedBy INT NULL,
+	Reason TEXT NOT NULL DEFAULT '',
+	CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	ExpiresAt TIMESTAMP NULL,
+	PRIMARY KEY (ChannelID, UserID),
+	FOREIGN KEY (ChannelID) REFERENCES Channels(ID) ON DELETE CASCADE,
+	FOREIGN KEY (UserID) REFERENCES Users(ID) ON DELETE CASCADE,
+	FOREIGN KEY (BannedBy) REFERENCES Users(ID) ON DELETE SET NULL
+);
+
+CREATE TABLE ChannelModerationLog (
+	ID SERIAL PRIMARY KEY,
+	ChannelID INT NOT NULL,
+	ActorID INT NULL,
+	TargetID INT NULL,
+	Action VARCHAR(16) NOT NULL,
+	Reason TEXT NOT NULL DEFAULT '',
+	CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (ChannelID) REFERENCES Channels(ID) ON DELETE CASCADE,
+	FOREIGN KEY (ActorID) REFERENCES Users(ID) ON DELETE SET NULL,
+	FOREIGN KEY (TargetID) REFERENCES Users(ID) ON DELETE SET NULL
+);
+
+CREATE TABLE MessageEdits (
+    ID SERIAL PRIMARY KEY,
+    MessageID INT NOT NULL,
+    Content TEXT NOT NULL,
+    EditedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (MessageID) REFERENCES Messages(ID) ON DELETE CASCADE
+);
+
+CREATE TABLE MessageReactions (
+    MessageID INT NOT NULL,
+    UserID INT NOT NULL,
+    Emoji VARCHAR(64) NOT NULL,
+    CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (MessageID, UserID, Emoji),
+    FOREIGN KEY (MessageID) REFERENCES Messages(ID) ON DELETE CASCADE,
+    FOREIGN KEY (UserID) REFERENCES Users(ID) ON DELETE CASCADE
+);
+
+CREATE TABLE ChannelEmoji (
+    ID SERIAL PRIMARY KEY,
+    ChannelID INT NOT NULL,
+    Name VARCHAR(32) NOT NULL,
+    Image BYTEA NOT NULL,
+    CreatedBy INT NOT NULL,
+    CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (ChannelID, Name),
+    FOREIGN KEY (ChannelID) REFERENCES Channels(ID) ON DELETE CASCADE,
+    FOREIGN KEY (CreatedBy) REFERENCES Users(ID)
+);
+
+CREATE TABLE Notifications (
+    ID SERIAL PRIMARY KEY,
+    UserID INT NOT NULL,
+    MessageID INT NOT NULL,
+    RoomID INT NOT NULL,
+    ChannelID INT NOT NULL,
+    ActorID INT NOT NULL,
+    Kind VARCHAR(16) NOT NULL CHECK (Kind IN ('mention', 'room', 'channel')),
+    CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ReadAt TIMESTAMP NULL,
+    UNIQUE (UserID, MessageID),
+    FOREIGN KEY (UserID) REFERENCES Users(ID) ON DELETE CASCADE,
+    FOREIGN KEY (MessageID) REFERENCES Messages(ID) ON DELETE CASCADE,
+    FOREIGN KEY (RoomID) REFERENCES Rooms(ID) ON DELETE CASCADE,
+    FOREIGN KEY (ChannelID) REFERENCES Channels(ID) ON DELETE CASCADE,
+    FOREIGN KEY (ActorID) REFERENCES Users(ID) ON DELETE CASCADE
+);
+
+-- Files uploaded to rooms. MessageID stays NULL until a message links the
+-- upload; unlinked rows expire and their blobs are removed. Deleting the
+-- room only clears RoomID, so the cleanup still finds the blobs to remove.
+CREATE TABLE Attachments (
+    ID SERIAL PRIMARY KEY,
+    MessageID INT NULL,
+    RoomID INT NULL,
+    UploaderID INT NOT NULL,
+    Filename VARCHAR(255) NOT NULL,
+    ContentType VARCHAR(255) NOT NULL,
+    Size BIGINT NOT NULL,
+    Received BIGINT NOT NULL DEFAULT 0,
+    ChunkSize BIGINT NOT NULL DEFAULT 0,
+    StorageKey VARCHAR(255) NOT NULL UNIQUE,
+    CompletedAt TIMESTAMP NULL,
+    CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (MessageID) REFERENCES Messages(ID) ON DELETE SET NULL,
+    FOREIGN KEY (RoomID) REFERENCES Rooms(ID) ON DELETE SET NULL,
+    FOREIGN KEY (UploaderID) REFERENCES Users(ID) ON DELETE CASCADE
+);
+
+-- Direct conversations live in Rooms of kind dm. PairKey ("low:high" user
+-- IDs) keeps a single conversation per pair of users; groups have none.
+CREATE TABLE DirectRooms (
+    RoomID INT PRIMARY KEY,
+    IsGroup BOOLEAN NOT NULL DEFAULT FALSE,
+    PairKey VARCHAR(64) NULL UNIQUE,
+    CreatedBy INT NOT NULL,
+    CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (RoomID) REFERENCES Rooms(ID) ON DELETE CASCADE,
+    FOREIGN KEY (CreatedBy) REFERENCES Users(ID) ON DELETE CASCADE
+);
+
+CREATE TABLE DirectMembers (
+    RoomID INT NOT NULL,
+    UserID INT NOT NULL,
+    JoinedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (RoomID, UserID),
+    FOREIGN KEY (RoomID) REFERENCES DirectRooms(RoomID) ON DELETE CASCADE,
+    FOREIGN KEY (UserID) REFERENCES Users(ID) ON DELETE CASCADE
+);
+
 CREATE INDEX idx_username_users ON Users (Username);
 CREATE INDEX idx_room_id_rooms ON Rooms (ID);
-CREATE INDEX idx_room_id_messages ON Messages (RoomID);
+CREATE INDEX idx_room_id_messages ON Messages (RoomID, Timestamp, ID);
 
 CREATE INDEX idx_user_id_users_to_channels ON ChannelsToUsers (user_id);
 CREATE INDEX idx_room_id_rooms_to_channels ON RoomsToChannels (room_id);
@@ -97,6 +369,29 @@ CREATE INDEX idx_inviter ON Invites(InviterID);
 CREATE INDEX idx_invitee ON Invites(InviteeID);
 CREATE INDEX idx_expiration ON Invites(Expiration);
 
+CREATE INDEX idx_user_id_sessions ON Sessions (UserID);
+CREATE INDEX idx_session_id_refresh_tokens ON RefreshTokens (SessionID);
+CREATE INDEX idx_user_id_user_identities ON UserIdentities (UserID);
+CREATE INDEX idx_user_id_mfa_recovery_codes ON MFARecoveryCodes (UserID);
+CREATE INDEX idx_user_id_mfa_challenges ON MFAChallenges (UserID);
+CREATE INDEX idx_user_id_password_resets ON PasswordResets (UserID);
+CREATE INDEX idx_email_login_audit ON LoginAudit (Email);
+CREATE INDEX idx_created_at_login_audit ON LoginAudit (CreatedAt);
+CREATE INDEX idx_user_id_api_tokens ON ApiTokens (UserID);
+CREATE INDEX idx_room_access_room ON RoomAccess (RoomID);
+CREATE INDEX idx_moderation_log_channel ON ChannelModerationLog (ChannelID, CreatedAt);
+CREATE INDEX idx_room_categories_channel ON RoomCategories (ChannelID);
+CREATE INDEX idx_message_edits_message ON MessageEdits (MessageID);
+CREATE INDEX idx_parent_id_messages ON Messages (ParentID, Timestamp, ID) WHERE ParentID IS NOT NULL;
+CREATE INDEX idx_notifications_user ON Notifications (UserID, ID) WHERE ReadAt IS NULL;
+CREATE INDEX idx_notifications_room ON Notifications (UserID, RoomID) WHERE ReadAt IS NULL;
+CREATE INDEX idx_search_vector_messages ON Messages USING GIN (SearchVector);
+CREATE INDEX idx_message_id_attachments ON Attachments (MessageID);
+CREATE INDEX idx_unlinked_attachments ON Attachments (CreatedAt) WHERE MessageID IS NULL;
+CREATE INDEX idx_orphaned_attachments ON Attachments (ID) WHERE RoomID IS NULL;
+CREATE INDEX idx_user_id_direct_members ON DirectMembers (UserID);
+CREATE INDEX idx_room_id_read_pointers ON ReadPointers (RoomID, LastReadMessageID);
+
 CREATE USER admin WITH PASSWORD 'password';
 
 GRANT ALL PRIVILEGES ON DATABASE chat_app TO admin;
@@ -6,11 +6,20 @@ import (
 	"user/server/cmd/api"
 	"user/server/config"
 	"user/server/db"
+	"user/server/services/auth"
+	"user/server/services/utils"
 )
 
 func main() {
 	cfg := config.GetConfig()
 
+	if err := auth.ConfigureKeys(cfg); err != nil {
+		log.Fatal("Error configuring JWT keys: ", err)
+	}
+	if err := utils.ConfigureTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatal("Error configuring trusted proxies: ", err)
+	}
+
 	// Connect to database using configuration
 	db.Db = db.DbConnect(
 		cfg.DBHost,
@@ -21,7 +30,7 @@ func main() {
 	)
 	defer db.Db.Close()
 
-	server := api.NewAPIServer(fmt.Sprintf(":%s", cfg.Port), db.Db)
+	server := api.NewAPIServer(fmt.Sprintf(":%s", cfg.Port), db.Db, cfg)
 	if err := server.Run(); err != nil {
 		log.Fatal(err)
 	}
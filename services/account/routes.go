@@ -0,0 +1,262 @@
+package account
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"user/server/services/auth"
+	"user/server/services/hub"
+	"user/server/services/utils"
+	"user/server/types"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	passwordResetPurpose = "password-reset"
+	passwordResetTTL     = time.Hour
+	verifyEmailPurpose   = "email-verify"
+	verifyEmailTTL       = 24 * time.Hour
+)
+
+type Handler struct {
+	store        types.PasswordResetStore
+	userStore    types.UserStore
+	sessionStore types.SessionStore
+	mailer       types.Mailer
+	appURL       string
+}
+
+func NewHandler(
+	store types.PasswordResetStore,
+	userStore types.UserStore,
+	sessionStore types.SessionStore,
+	mailer types.Mailer,
+	appURL string) *Handler {
+	return &Handler{
+		store:        store,
+		userStore:    userStore,
+		sessionStore: sessionStore,
+		mailer:       mailer,
+		appURL:       appURL,
+	}
+}
+
+func (h *Handler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/password/forgot", utils.CorsHandler(h.ForgotPasswordHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/password/reset", utils.CorsHandler(h.ResetPasswordHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/email/verify", utils.CorsHandler(h.VerifyEmailHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/email/verify/resend", utils.CorsHandler(
+		auth.WithJWTAuth(h.ResendVerificationHandler, h.userStore),
+	)).Methods("POST", "OPTIONS")
+}
+
+// ForgotPasswordHandler always answers 202 so it can't be used to find
+// out which emails have an account, even when sending the reset fails.
+func (h *Handler) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	payload := &types.ForgotPasswordPayload{}
+	if err := utils.ParseJSON(r, payload); err != nil {
+		handleError(w, "Invalid request payload", http.StatusBadRequest, err)
+		return
+	}
+
+	user, err := h.userStore.GetUserByEmail(strings.TrimSpace(payload.Email))
+	if err == nil && !user.IsBot {
+		err = h.sendPasswordReset(user)
+		if err != nil {
+			log.Println("Error sending password reset: ", err)
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *Handler) sendPasswordReset(user *types.User) error {
+	reset := &types.PasswordReset{
+		TokenID:   uuid.NewString(),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	}
+	err := h.store.CreatePasswordReset(reset)
+	if err != nil {
+		return err
+	}
+
+	token, err := auth.SignToken(passwordResetPurpose, passwordResetTTL, jwt.MapClaims{
+		"username": strconv.Itoa(user.ID),
+		"jti":      reset.TokenID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return h.mailer.Send(types.Mail{
+		To:      user.Username,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your account.\n\n"+
+			"Choose a new password here, the link is valid for one hour:\n%s\n\n"+
+			"If this wasn't you, you can ignore this email.\n",
+			buildLink(h.appURL, "/reset-password", token)),
+	})
+}
+
+// ResetPasswordHandler sets the new password and signs the user out
+// everywhere, since whoever knew the old one may still have a session.
+func (h *Handler) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	payload := &types.ResetPasswordPayload{}
+	if err := utils.ParseJSON(r, payload); err != nil {
+		handleError(w, "Invalid request payload", http.StatusBadRequest, err)
+		return
+	}
+	if payload.Password == "" {
+		handleError(w, "Password is required", http.StatusBadRequest, nil)
+		return
+	}
+
+	claims, err := auth.ParseToken(passwordResetPurpose, payload.Token)
+	if err != nil {
+		handleError(w, "Invalid or expired reset token", http.StatusBadRequest, err)
+		return
+	}
+	tokenID, _ := claims["jti"].(string)
+
+	reset, err := h.store.UsePasswordReset(tokenID)
+	if errors.Is(err, types.ErrPasswordResetNotFound) {
+		handleError(w, "Invalid or expired reset token", http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		handleError(w, "Error resetting password", http.StatusInternalServerError, err)
+		return
+	}
+	if claims["username"] != strconv.Itoa(reset.UserID) {
+		handleError(w, "Invalid or expired reset token", http.StatusBadRequest, nil)
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(payload.Password)
+	if err != nil {
+		handleError(w, "Error resetting password", http.StatusInternalServerError, err)
+		return
+	}
+
+	err = h.userStore.UpdatePassword(reset.UserID, hashedPassword)
+	if err != nil {
+		handleError(w, "Error resetting password", http.StatusInternalServerError, err)
+		return
+	}
+
+	// Following the emailed link proves the user owns the address.
+	err = h.userStore.SetEmailVerified(reset.UserID)
+	if err != nil {
+		log.Println("Error verifying email after password reset: ", err)
+	}
+
+	sessionIDs, err := h.sessionStore.RevokeUserSessions(reset.UserID)
+	if err != nil {
+		handleError(w, "Error revoking sessions", http.StatusInternalServerError, err)
+		return
+	}
+	for _, sessionID := range sessionIDs {
+		hub.HubInstance.DisconnectSession(sessionID)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	payload := &types.VerifyEmailPayload{}
+	if err := utils.ParseJSON(r, payload); err != nil {
+		handleError(w, "Invalid request payload", http.StatusBadRequest, err)
+		return
+	}
+
+	claims, err := auth.ParseToken(verifyEmailPurpose, payload.Token)
+	if err != nil {
+		handleError(w, "Invalid or expired verification token", http.StatusBadRequest, err)
+		return
+	}
+
+	username, _ := claims["username"].(string)
+	userID, err := strconv.Atoi(username)
+	if err != nil {
+		handleError(w, "Invalid or expired verification token", http.StatusBadRequest, err)
+		return
+	}
+
+	user, err := h.userStore.GetUserByID(userID)
+	if err != nil {
+		handleError(w, "Invalid or expired verification token", http.StatusBadRequest, err)
+		return
+	}
+
+	// A link sent to an address the user no longer has is worthless.
+	if claims["email"] != user.Username {
+		handleError(w, "Invalid or expired verification token", http.StatusBadRequest, nil)
+		return
+	}
+
+	if !user.EmailVerified {
+		err = h.userStore.SetEmailVerified(user.ID)
+		if err != nil {
+			handleError(w, "Error verifying email", http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+
+	if user.EmailVerified {
+		handleError(w, "Email already verified", http.StatusConflict, nil)
+		return
+	}
+
+	err := SendVerificationEmail(h.mailer, h.appURL, user)
+	if err != nil {
+		handleError(w, "Error sending verification email", http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func SendVerificationEmail(mailer types.Mailer, appURL string, user *types.User) error {
+	token, err := auth.SignToken(verifyEmailPurpose, verifyEmailTTL, jwt.MapClaims{
+		"username": strconv.Itoa(user.ID),
+		"email":    user.Username,
+	})
+	if err != nil {
+		return err
+	}
+
+	return mailer.Send(types.Mail{
+		To:      user.Username,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Welcome!\n\nPlease confirm your email address by opening this link:\n%s\n",
+			buildLink(appURL, "/verify-email", token)),
+	})
+}
+
+func buildLink(appURL, path, token string) string {
+	return strings.TrimSuffix(appURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+func handleError(w http.ResponseWriter, message string, statusCode int, err error) {
+	if err != nil {
+		log.Println(message, err)
+	} else {
+		log.Println(message)
+	}
+	http.Error(w, message, statusCode)
+}
@@ -0,0 +1,228 @@
+package account
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"user/server/config"
+	"user/server/services/auth"
+	"user/server/services/mail"
+	"user/server/types"
+)
+
+type fakeResetStore struct {
+	resets map[string]*types.PasswordReset
+}
+
+func (s *fakeResetStore) CreatePasswordReset(reset *types.PasswordReset) error {
+	reset.CreatedAt = time.Now()
+	s.resets[reset.TokenID] = reset
+	return nil
+}
+
+func (s *fakeResetStore) UsePasswordReset(tokenID string) (*types.PasswordReset, error) {
+	reset, ok := s.resets[tokenID]
+	if !ok || reset.UsedAt != nil || time.Now().After(reset.ExpiresAt) {
+		return nil, types.ErrPasswordResetNotFound
+	}
+	now := time.Now()
+	reset.UsedAt = &now
+	return reset, nil
+}
+
+type fakeUserStore struct {
+	users []*types.User
+}
+
+func (s *fakeUserStore) GetUserByEmail(email string) (*types.User, error) {
+	for _, user := range s.users {
+		if user.Username == email {
+			return user, nil
+		}
+	}
+	return nil, types.ErrUserNotFound
+}
+
+func (s *fakeUserStore) GetUserByID(id int) (*types.User, error) {
+	for _, user := range s.users {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+	return nil, errors.New("invalid credentials")
+}
+
+func (s *fakeUserStore) CreateUser(user types.User) (int, error) {
+	user.ID = len(s.users) + 1
+	s.users = append(s.users, &user)
+	return user.ID, nil
+}
+
+func (s *fakeUserStore) UpdatePassword(userID int, hashedPassword string) error {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	user.Password = hashedPassword
+	return nil
+}
+
+func (s *fakeUserStore) SetEmailVerified(userID int) error {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	user.EmailVerified = true
+	return nil
+}
+
+// fakeSessionStore only tracks which users were signed out.
+type fakeSessionStore struct {
+	types.SessionStore
+	revokedUsers []int
+}
+
+func (s *fakeSessionStore) RevokeUserSessions(userID int) ([]string, error) {
+	s.revokedUsers = append(s.revokedUsers, userID)
+	return nil, nil
+}
+
+type testEnv struct {
+	handler  *Handler
+	users    *fakeUserStore
+	sessions *fakeSessionStore
+	mailer   *mail.MemoryMailer
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	err := auth.ConfigureKeys(config.Config{JWTAlgorithm: "HS256", JWTKeyID: "test", JWTSecret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashedPassword, _ := auth.HashPassword("old-password")
+	env := &testEnv{
+		users:    &fakeUserStore{},
+		sessions: &fakeSessionStore{},
+		mailer:   mail.NewMemoryMailer(),
+	}
+	env.users.CreateUser(types.User{Username: "jane@example.com", Password: hashedPassword})
+	env.handler = NewHandler(&fakeResetStore{resets: make(map[string]*types.PasswordReset)},
+		env.users, env.sessions, env.mailer, "http://localhost:3000")
+	return env
+}
+
+func post(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	handler(res, httptest.NewRequest("POST", "/", bytes.NewBufferString(body)))
+	return res
+}
+
+// tokenFromMail pulls the token out of the link in the last sent email.
+func tokenFromMail(t *testing.T, mailer *mail.MemoryMailer) string {
+	sent := mailer.Sent()
+	if len(sent) == 0 {
+		t.Fatal("no email was sent")
+	}
+	body := sent[len(sent)-1].Body
+	start := strings.Index(body, "http://")
+	if start < 0 {
+		t.Fatalf("no link in email: %s", body)
+	}
+	link, err := url.Parse(strings.Fields(body[start:])[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return link.Query().Get("token")
+}
+
+func TestPasswordReset(t *testing.T) {
+	env := newTestEnv(t)
+
+	res := post(env.handler.ForgotPasswordHandler, `{"email":"jane@example.com"}`)
+	if res.Code != http.StatusAccepted {
+		t.Fatalf("forgot returned %d", res.Code)
+	}
+	token := tokenFromMail(t, env.mailer)
+
+	res = post(env.handler.ResetPasswordHandler, `{"token":"`+token+`","password":"new-password"}`)
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("reset returned %d: %s", res.Code, res.Body.String())
+	}
+
+	user := env.users.users[0]
+	if !auth.ComparePasswords(user.Password, []byte("new-password")) {
+		t.Error("password was not changed")
+	}
+	if len(env.sessions.revokedUsers) != 1 || env.sessions.revokedUsers[0] != user.ID {
+		t.Errorf("sessions were not revoked: %v", env.sessions.revokedUsers)
+	}
+
+	res = post(env.handler.ResetPasswordHandler, `{"token":"`+token+`","password":"another-password"}`)
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("reusing a reset token returned %d", res.Code)
+	}
+}
+
+func TestForgotPasswordUnknownEmail(t *testing.T) {
+	env := newTestEnv(t)
+
+	res := post(env.handler.ForgotPasswordHandler, `{"email":"nobody@example.com"}`)
+	if res.Code != http.StatusAccepted {
+		t.Fatalf("forgot returned %d", res.Code)
+	}
+	if len(env.mailer.Sent()) != 0 {
+		t.Error("no email should be sent for an unknown address")
+	}
+}
+
+type failingResetStore struct {
+	types.PasswordResetStore
+}
+
+func (failingResetStore) CreatePasswordReset(*types.PasswordReset) error {
+	return errors.New("database is down")
+}
+
+func TestForgotPasswordHidesFailures(t *testing.T) {
+	env := newTestEnv(t)
+	env.handler.store = failingResetStore{}
+
+	for _, email := range []string{"jane@example.com", "nobody@example.com"} {
+		res := post(env.handler.ForgotPasswordHandler, `{"email":"`+email+`"}`)
+		if res.Code != http.StatusAccepted {
+			t.Errorf("%s: forgot returned %d", email, res.Code)
+		}
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	env := newTestEnv(t)
+	user := env.users.users[0]
+
+	err := SendVerificationEmail(env.mailer, "http://localhost:3000", user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := tokenFromMail(t, env.mailer)
+
+	// A reset token must not double as a verification token.
+	resetToken, _ := auth.SignToken(passwordResetPurpose, time.Minute, nil)
+	res := post(env.handler.VerifyEmailHandler, `{"token":"`+resetToken+`"}`)
+	if res.Code != http.StatusBadRequest || user.EmailVerified {
+		t.Fatalf("token with the wrong purpose returned %d", res.Code)
+	}
+
+	res = post(env.handler.VerifyEmailHandler, `{"token":"`+token+`"}`)
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("verify returned %d: %s", res.Code, res.Body.String())
+	}
+	if !user.EmailVerified {
+		t.Error("email was not marked verified")
+	}
+}
@@ -0,0 +1,64 @@
+package account
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"user/server/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) CreatePasswordReset(reset *types.PasswordReset) error {
+	err := s.db.QueryRow(`
+		INSERT INTO PasswordResets (TokenID, UserID, ExpiresAt)
+		VALUES ($1, $2, $3)
+		RETURNING CreatedAt`,
+		reset.TokenID, reset.UserID, reset.ExpiresAt).Scan(&reset.CreatedAt)
+	if err != nil {
+		log.Println("Error creating password reset: ", err)
+		return err
+	}
+	return nil
+}
+
+// UsePasswordReset redeems a reset and retires every other outstanding
+// reset of the same user.
+func (s *Store) UsePasswordReset(tokenID string) (*types.PasswordReset, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println("Error starting transaction: ", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	reset := &types.PasswordReset{}
+	err = tx.QueryRow(`
+		UPDATE PasswordResets SET UsedAt = CURRENT_TIMESTAMP
+		WHERE TokenID = $1 AND UsedAt IS NULL AND ExpiresAt > CURRENT_TIMESTAMP
+		RETURNING TokenID, UserID, CreatedAt, ExpiresAt, UsedAt`, tokenID).Scan(
+		&reset.TokenID, &reset.UserID, &reset.CreatedAt, &reset.ExpiresAt, &reset.UsedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrPasswordResetNotFound
+	}
+	if err != nil {
+		log.Println("Error using password reset: ", err)
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE PasswordResets SET UsedAt = CURRENT_TIMESTAMP
+		WHERE UserID = $1 AND UsedAt IS NULL`, reset.UserID)
+	if err != nil {
+		log.Println("Error retiring password resets: ", err)
+		return nil, err
+	}
+
+	return reset, tx.Commit()
+}
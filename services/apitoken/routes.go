@@ -0,0 +1,421 @@
+package apitoken
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"user/server/services/auth"
+	"user/server/services/hub"
+	"user/server/services/permissions"
+	"user/server/services/utils"
+	"user/server/types"
+
+	"github.com/gorilla/mux"
+)
+
+const maxTokenLifetimeDays = 365
+
+var botNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{1,31}$`)
+
+type Handler struct {
+	store           types.APITokenStore
+	userStore       types.UserStore
+	channelStore    types.ChannelStore
+	permissionStore types.PermissionsStore
+	memberStore     types.MemberStore
+}
+
+func NewHandler(
+	store types.APITokenStore,
+	userStore types.UserStore,
+	channelStore types.ChannelStore,
+	permissionStore types.PermissionsStore,
+	memberStore types.MemberStore) *Handler {
+	return &Handler{
+		store:           store,
+		userStore:       userStore,
+		channelStore:    channelStore,
+		permissionStore: permissionStore,
+		memberStore:     memberStore,
+	}
+}
+
+func (h *Handler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/tokens", utils.CorsHandler(
+		auth.WithSessionAuth(h.GetTokensHandler, h.userStore),
+	)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/tokens", utils.CorsHandler(
+		auth.WithSessionAuth(h.CreateTokenHandler, h.userStore),
+	)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/tokens/{tokenID}", utils.CorsHandler(
+		auth.WithSessionAuth(h.RevokeTokenHandler, h.userStore),
+	)).Methods("DELETE", "OPTIONS")
+
+	r.HandleFunc("/bots", utils.CorsHandler(
+		auth.WithSessionAuth(h.GetBotsHandler, h.userStore),
+	)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/bots", utils.CorsHandler(
+		auth.WithSessionAuth(h.CreateBotHandler, h.userStore),
+	)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/bots/{botID}", utils.CorsHandler(
+		auth.WithSessionAuth(h.DeactivateBotHandler, h.userStore),
+	)).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/bots/{botID}/tokens", utils.CorsHandler(
+		auth.WithSessionAuth(h.GetBotTokensHandler, h.userStore),
+	)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/bots/{botID}/tokens", utils.CorsHandler(
+		auth.WithSessionAuth(h.CreateBotTokenHandler, h.userStore),
+	)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/bots/{botID}/channels/{channelID}", utils.CorsHandler(
+		auth.WithSessionAuth(h.AddBotToChannelHandler, h.userStore),
+	)).Methods("PUT", "OPTIONS")
+}
+
+func (h *Handler) GetTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	h.sendTokens(w, user.ID)
+}
+
+func (h *Handler) CreateTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	h.createToken(w, r, user.ID)
+}
+
+// RevokeTokenHandler revokes one of the caller's tokens or a token of one
+// of their bots.
+func (h *Handler) RevokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+
+	tokenID, err := strconv.Atoi(mux.Vars(r)["tokenID"])
+	if err != nil {
+		handleError(w, "Invalid token ID", http.StatusBadRequest, err)
+		return
+	}
+
+	token, err := h.store.GetAPIToken(tokenID)
+	if errors.Is(err, types.ErrAPITokenNotFound) {
+		handleError(w, "Token not found", http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		handleError(w, "Error getting token", http.StatusInternalServerError, err)
+		return
+	}
+
+	if token.UserID != user.ID {
+		if _, ok := h.ownedBot(w, user, token.UserID); !ok {
+			return
+		}
+	}
+
+	err = h.store.RevokeAPIToken(token.ID)
+	if err != nil {
+		handleError(w, "Error revoking token", http.StatusInternalServerError, err)
+		return
+	}
+
+	hub.HubInstance.DisconnectSession(auth.APITokenConnectionID(token.ID))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) GetBotsHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+
+	bots, err := h.store.GetBotsForOwner(user.ID)
+	if err != nil {
+		handleError(w, "Error getting bots", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, types.BotsResponse{Bots: bots})
+}
+
+// CreateBotHandler creates a bot owned by the caller together with a first
+// token that has every scope.
+func (h *Handler) CreateBotHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user.IsBot {
+		handleError(w, "Bots can't own bots", http.StatusForbidden, nil)
+		return
+	}
+
+	payload := &types.CreateBotPayload{}
+	if err := utils.ParseJSON(r, payload); err != nil {
+		handleError(w, "Invalid request payload", http.StatusBadRequest, err)
+		return
+	}
+
+	name := strings.ToLower(strings.TrimSpace(payload.Name))
+	if !botNamePattern.MatchString(name) {
+		handleError(w, "Bot names are 2-32 lowercase letters, digits, - or _", http.StatusBadRequest, nil)
+		return
+	}
+
+	// Bots never log in with a password, this one is thrown away.
+	secret, _, _, err := auth.GenerateAPIToken()
+	if err != nil {
+		handleError(w, "Error creating bot", http.StatusInternalServerError, err)
+		return
+	}
+	hashedPassword, err := auth.HashPassword(secret)
+	if err != nil {
+		handleError(w, "Error creating bot", http.StatusInternalServerError, err)
+		return
+	}
+
+	ownerID := user.ID
+	bot := types.User{
+		Username: name + "@" + types.BotEmailDomain,
+		Password: hashedPassword,
+		IsBot:    true,
+		OwnerID:  &ownerID,
+	}
+	bot.ID, err = h.userStore.CreateUser(bot)
+	if err != nil {
+		handleError(w, "A bot with this name already exists", http.StatusConflict, err)
+		return
+	}
+
+	token, tokenSecret, err := h.issueToken(bot.ID, "default", types.AllScopes, nil)
+	if err != nil {
+		handleError(w, "Error creating bot token", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusCreated, types.BotCreatedResponse{
+		Bot:      &bot,
+		Token:    tokenSecret,
+		APIToken: token,
+	})
+}
+
+func (h *Handler) DeactivateBotHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+
+	bot, ok := h.botFromPath(w, r, user)
+	if !ok {
+		return
+	}
+
+	tokens, err := h.store.GetAPITokensForUser(bot.ID)
+	if err != nil {
+		handleError(w, "Error getting bot tokens", http.StatusInternalServerError, err)
+		return
+	}
+
+	err = h.store.DeactivateBot(bot.ID)
+	if err != nil {
+		handleError(w, "Error deactivating bot", http.StatusInternalServerError, err)
+		return
+	}
+
+	for _, token := range tokens {
+		hub.HubInstance.DisconnectSession(auth.APITokenConnectionID(token.ID))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) GetBotTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+
+	bot, ok := h.botFromPath(w, r, user)
+	if !ok {
+		return
+	}
+
+	h.sendTokens(w, bot.ID)
+}
+
+func (h *Handler) CreateBotTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+
+	bot, ok := h.botFromPath(w, r, user)
+	if !ok {
+		return
+	}
+
+	if bot.DeactivatedAt != nil {
+		handleError(w, "Bot is deactivated", http.StatusConflict, nil)
+		return
+	}
+
+	h.createToken(w, r, bot.ID)
+}
+
+// AddBotToChannelHandler lets an owner bring their bot into a channel they
+// may invite people to.
+func (h *Handler) AddBotToChannelHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+
+	bot, ok := h.botFromPath(w, r, user)
+	if !ok {
+		return
+	}
+	if bot.DeactivatedAt != nil {
+		handleError(w, "Bot is deactivated", http.StatusConflict, nil)
+		return
+	}
+
+	channelID, err := strconv.Atoi(mux.Vars(r)["channelID"])
+	if err != nil {
+		handleError(w, "Invalid channel", http.StatusBadRequest, err)
+		return
+	}
+
+	if _, ok := permissions.Authorize(w, h.permissionStore, user.ID, channelID, types.PermInvite); !ok {
+		return
+	}
+	_, err = h.permissionStore.GetChannelMember(bot.ID, channelID)
+	if err == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !errors.Is(err, types.ErrNotChannelMember) {
+		handleError(w, "Error adding bot to channel", http.StatusInternalServerError, err)
+		return
+	}
+
+	_, err = h.memberStore.GetActiveBan(channelID, bot.ID)
+	if err == nil {
+		handleError(w, "Bot is banned from this channel", http.StatusForbidden, nil)
+		return
+	}
+	if !errors.Is(err, types.ErrBanNotFound) {
+		handleError(w, "Error adding bot to channel", http.StatusInternalServerError, err)
+		return
+	}
+
+	err = h.channelStore.AddUserToChannel(channelID, bot.ID)
+	if err != nil {
+		handleError(w, "Error adding bot to channel", http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) sendTokens(w http.ResponseWriter, userID int) {
+	tokens, err := h.store.GetAPITokensForUser(userID)
+	if err != nil {
+		handleError(w, "Error getting tokens", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, types.APITokensResponse{Tokens: tokens})
+}
+
+func (h *Handler) createToken(w http.ResponseWriter, r *http.Request, userID int) {
+	payload := &types.CreateAPITokenPayload{}
+	if err := utils.ParseJSON(r, payload); err != nil {
+		handleError(w, "Invalid request payload", http.StatusBadRequest, err)
+		return
+	}
+
+	name := strings.TrimSpace(payload.Name)
+	if name == "" || len(name) > 255 {
+		handleError(w, "Token name is required", http.StatusBadRequest, nil)
+		return
+	}
+
+	scopes, err := validateScopes(payload.Scopes)
+	if err != nil {
+		handleError(w, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	var expiresAt *time.Time
+	if payload.ExpiresInDays < 0 || payload.ExpiresInDays > maxTokenLifetimeDays {
+		handleError(w, "expires_in_days must be between 0 and 365", http.StatusBadRequest, nil)
+		return
+	}
+	if payload.ExpiresInDays > 0 {
+		expires := time.Now().AddDate(0, 0, payload.ExpiresInDays)
+		expiresAt = &expires
+	}
+
+	token, secret, err := h.issueToken(userID, name, scopes, expiresAt)
+	if err != nil {
+		handleError(w, "Error creating token", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusCreated, types.APITokenCreatedResponse{
+		Token:    secret,
+		APIToken: token,
+	})
+}
+
+func (h *Handler) issueToken(userID int, name string, scopes []string, expiresAt *time.Time) (*types.APIToken, string, error) {
+	secret, prefix, hash, err := auth.GenerateAPIToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	token := &types.APIToken{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    prefix,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	err = h.store.CreateAPIToken(token, hash)
+	if err != nil {
+		return nil, "", err
+	}
+	return token, secret, nil
+}
+
+func (h *Handler) botFromPath(w http.ResponseWriter, r *http.Request, owner *types.User) (*types.User, bool) {
+	botID, err := strconv.Atoi(mux.Vars(r)["botID"])
+	if err != nil {
+		handleError(w, "Invalid bot ID", http.StatusBadRequest, err)
+		return nil, false
+	}
+	return h.ownedBot(w, owner, botID)
+}
+
+// ownedBot answers 404 for bots that don't exist or belong to someone else.
+func (h *Handler) ownedBot(w http.ResponseWriter, owner *types.User, botID int) (*types.User, bool) {
+	bot, err := h.userStore.GetUserByID(botID)
+	if err != nil || !bot.IsBot || bot.OwnerID == nil || *bot.OwnerID != owner.ID {
+		handleError(w, "Bot not found", http.StatusNotFound, err)
+		return nil, false
+	}
+	return bot, true
+}
+
+func validateScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+
+	seen := make(map[string]bool)
+	valid := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		known := false
+		for _, s := range types.AllScopes {
+			known = known || s == scope
+		}
+		if !known {
+			return nil, errors.New("unknown scope " + strconv.Quote(scope))
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			valid = append(valid, scope)
+		}
+	}
+	return valid, nil
+}
+
+func handleError(w http.ResponseWriter, message string, statusCode int, err error) {
+	if err != nil {
+		log.Println(message, err)
+	} else {
+		log.Println(message)
+	}
+	http.Error(w, message, statusCode)
+}
@@ -0,0 +1,178 @@
+package apitoken
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"user/server/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+const tokenColumns = `ID, UserID, Name, Prefix, Scopes, CreatedAt, LastUsedAt, ExpiresAt, RevokedAt`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanToken(row rowScanner) (*types.APIToken, error) {
+	token := &types.APIToken{}
+	var scopes string
+	err := row.Scan(&token.ID, &token.UserID, &token.Name, &token.Prefix, &scopes,
+		&token.CreatedAt, &token.LastUsedAt, &token.ExpiresAt, &token.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	token.Scopes = strings.Split(scopes, ",")
+	return token, nil
+}
+
+func (s *Store) CreateAPIToken(token *types.APIToken, tokenHash string) error {
+	err := s.db.QueryRow(`
+		INSERT INTO ApiTokens (UserID, Name, Prefix, TokenHash, Scopes, ExpiresAt)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING ID, CreatedAt`,
+		token.UserID, token.Name, token.Prefix, tokenHash,
+		strings.Join(token.Scopes, ","), token.ExpiresAt).Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		log.Println("Error creating API token: ", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) GetAPIToken(tokenID int) (*types.APIToken, error) {
+	token, err := scanToken(s.db.QueryRow(
+		"SELECT "+tokenColumns+" FROM ApiTokens WHERE ID = $1", tokenID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrAPITokenNotFound
+	}
+	if err != nil {
+		log.Println("Error getting API token: ", err)
+		return nil, err
+	}
+	return token, nil
+}
+
+func (s *Store) GetAPITokenByHash(tokenHash string) (*types.APIToken, error) {
+	token, err := scanToken(s.db.QueryRow(
+		"SELECT "+tokenColumns+" FROM ApiTokens WHERE TokenHash = $1", tokenHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrAPITokenNotFound
+	}
+	if err != nil {
+		log.Println("Error getting API token: ", err)
+		return nil, err
+	}
+	return token, nil
+}
+
+func (s *Store) GetAPITokensForUser(userID int) ([]*types.APIToken, error) {
+	rows, err := s.db.Query(`
+		SELECT `+tokenColumns+` FROM ApiTokens
+		WHERE UserID = $1 AND RevokedAt IS NULL
+		ORDER BY CreatedAt DESC`, userID)
+	if err != nil {
+		log.Println("Error getting API tokens: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]*types.APIToken, 0)
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			log.Println("Error scanning API token: ", err)
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+func (s *Store) TouchAPIToken(tokenID int) error {
+	_, err := s.db.Exec("UPDATE ApiTokens SET LastUsedAt = CURRENT_TIMESTAMP WHERE ID = $1", tokenID)
+	if err != nil {
+		log.Println("Error updating API token last used: ", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) RevokeAPIToken(tokenID int) error {
+	_, err := s.db.Exec(`
+		UPDATE ApiTokens SET RevokedAt = CURRENT_TIMESTAMP
+		WHERE ID = $1 AND RevokedAt IS NULL`, tokenID)
+	if err != nil {
+		log.Println("Error revoking API token: ", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) GetBotsForOwner(ownerID int) ([]*types.User, error) {
+	rows, err := s.db.Query(`
+		SELECT ID, Username, CreatedAt, Avatar, IsBot, OwnerID, DeactivatedAt
+		FROM Users WHERE IsBot = TRUE AND OwnerID = $1
+		ORDER BY CreatedAt`, ownerID)
+	if err != nil {
+		log.Println("Error getting bots: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	bots := make([]*types.User, 0)
+	for rows.Next() {
+		bot := &types.User{}
+		err := rows.Scan(&bot.ID, &bot.Username, &bot.CreatedAt, &bot.Avatar, &bot.IsBot, &bot.OwnerID, &bot.DeactivatedAt)
+		if err != nil {
+			log.Println("Error scanning bot: ", err)
+			return nil, err
+		}
+		bots = append(bots, bot)
+	}
+	return bots, nil
+}
+
+// DeactivateBot marks the bot deactivated, revokes its tokens and takes it
+// out of every channel. The user row stays so its messages keep their
+// sender.
+func (s *Store) DeactivateBot(botID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println("Error starting transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE Users SET DeactivatedAt = CURRENT_TIMESTAMP
+		WHERE ID = $1 AND DeactivatedAt IS NULL`, botID)
+	if err != nil {
+		log.Println("Error deactivating bot: ", err)
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE ApiTokens SET RevokedAt = CURRENT_TIMESTAMP
+		WHERE UserID = $1 AND RevokedAt IS NULL`, botID)
+	if err != nil {
+		log.Println("Error revoking bot tokens: ", err)
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM ChannelsToUsers WHERE user_id = $1", botID)
+	if err != nil {
+		log.Println("Error removing bot from channels: ", err)
+		return err
+	}
+
+	return tx.Commit()
+}
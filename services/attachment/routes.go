@@ -0,0 +1,625 @@
+package attachment
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+	"user/server/services/auth"
+	"user/server/services/permissions"
+	"user/server/services/utils"
+	"user/server/types"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// uploadChunkSize is the size of every chunk of a chunked upload but
+	// the last.
+	uploadChunkSize = 5 << 20
+	// multipartMemory is how much of a multipart upload is kept in memory
+	// before the rest spills to a temporary file. It also covers the form
+	// overhead on top of the attachment size limit.
+	multipartMemory   = 1 << 20
+	maxFilenameLength = 255
+	// Attachments not sent with a message within pendingTTL are removed,
+	// along with those of deleted messages.
+	pendingTTL      = 24 * time.Hour
+	cleanupInterval = time.Hour
+	cleanupBatch    = 100
+)
+
+type Handler struct {
+	store           types.AttachmentStore
+	blobs           types.BlobStore
+	userStore       types.UserStore
+	permissionStore types.PermissionsStore
+	maxSize         int64
+	allowedTypes    []string
+}
+
+// NewHandler limits attachments to maxSize bytes and to the comma
+// separated allowedTypes, see config.Config.
+func NewHandler(store types.AttachmentStore, blobs types.BlobStore, userStore types.UserStore,
+	permissionStore types.PermissionsStore, maxSize int64, allowedTypes string) *Handler {
+	h := &Handler{
+		store:           store,
+		blobs:           blobs,
+		userStore:       userStore,
+		permissionStore: permissionStore,
+		maxSize:         maxSize,
+	}
+	for _, t := range strings.Split(allowedTypes, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			h.allowedTypes = append(h.allowedTypes, t)
+		}
+	}
+	return h
+}
+
+func (h *Handler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/rooms/{roomID}/attachments", utils.CorsHandler(
+		auth.WithJWTAuth(h.UploadHandler, h.userStore),
+	)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/rooms/{roomID}/uploads", utils.CorsHandler(
+		auth.WithJWTAuth(h.CreateUploadHandler, h.userStore),
+	)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/attachments/{attachmentID}/chunks", utils.CorsHandler(
+		auth.WithJWTAuth(h.UploadChunkHandler, h.userStore),
+	)).Methods("PUT", "OPTIONS")
+	r.HandleFunc("/attachments/{attachmentID}", utils.CorsHandler(
+		auth.WithJWTAuth(h.DownloadHandler, h.userStore),
+	)).Methods("GET", "OPTIONS")
+}
+
+// StartCleanup periodically removes expired attachments and their blobs.
+func (h *Handler) StartCleanup() {
+	go func() {
+		for range time.NewTicker(cleanupInterval).C {
+			h.removeExpired()
+		}
+	}()
+}
+
+func (h *Handler) removeExpired() {
+	expired, err := h.store.GetExpiredAttachments(pendingTTL, cleanupBatch)
+	if err != nil {
+		log.Println("Error getting expired attachments:", err)
+		return
+	}
+	for _, a := range expired {
+		if err := h.deleteBlobs(a); err != nil {
+			log.Printf("Error deleting blobs of attachment %d: %v", a.ID, err)
+			continue
+		}
+		if err := h.store.DeleteAttachment(a.ID); err != nil && !errors.Is(err, types.ErrAttachmentNotFound) {
+			log.Printf("Error deleting attachment %d: %v", a.ID, err)
+		}
+	}
+}
+
+func (h *Handler) deleteBlobs(a *types.Attachment) error {
+	if !a.Complete() {
+		for i := int64(0); i*a.ChunkSize < a.Received; i++ {
+			if err := h.blobs.Delete(chunkKey(a.ID, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return h.blobs.Delete(a.StorageKey)
+}
+
+// UploadHandler stores the "file" of a multipart form in one go.
+func (h *Handler) UploadHandler(w http.ResponseWriter, r *http.Request) {
+	roomID, ok := parseID(w, r, "roomID")
+	if !ok {
+		return
+	}
+	user := auth.GetUserFromContext(r.Context())
+	if _, ok := permissions.AuthorizeRoom(w, h.permissionStore, user.ID, roomID, 0); !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxSize+multipartMemory)
+	if err := r.ParseMultipartForm(multipartMemory); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			handleError(w, h.tooLargeMessage(), http.StatusRequestEntityTooLarge, nil)
+			return
+		}
+		handleError(w, "Unable to parse form", http.StatusBadRequest, err)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		handleError(w, "Unable to retrieve file from form", http.StatusBadRequest, err)
+		return
+	}
+	defer file.Close()
+	if header.Size > h.maxSize {
+		handleError(w, h.tooLargeMessage(), http.StatusRequestEntityTooLarge, nil)
+		return
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		handleError(w, "Error reading file", http.StatusInternalServerError, err)
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		handleError(w, "Error reading file", http.StatusInternalServerError, err)
+		return
+	}
+	contentType := detectContentType(head[:n], header.Header.Get("Content-Type"))
+	if !h.allowedType(contentType) {
+		handleError(w, "Files of type "+contentType+" aren't allowed", http.StatusUnsupportedMediaType, nil)
+		return
+	}
+
+	attachment := &types.Attachment{
+		RoomID:      roomID,
+		UploaderID:  user.ID,
+		Filename:    cleanFilename(header.Filename),
+		ContentType: contentType,
+		Size:        header.Size,
+		Received:    header.Size,
+		StorageKey:  storageKey(roomID),
+	}
+	if err := h.blobs.Put(attachment.StorageKey, file, attachment.Size, contentType); err != nil {
+		handleError(w, "Error storing file", http.StatusInternalServerError, err)
+		return
+	}
+	if err := h.store.CreateAttachment(attachment); err != nil {
+		if err := h.blobs.Delete(attachment.StorageKey); err != nil {
+			log.Println("Error deleting blob:", err)
+		}
+		handleError(w, "Error creating attachment", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusCreated, attachment)
+}
+
+// CreateUploadHandler starts a chunked upload for large files. The client
+// then sends the file with UploadChunkHandler in chunk_size pieces.
+func (h *Handler) CreateUploadHandler(w http.ResponseWriter, r *http.Request) {
+	roomID, ok := parseID(w, r, "roomID")
+	if !ok {
+		return
+	}
+	user := auth.GetUserFromContext(r.Context())
+	if _, ok := permissions.AuthorizeRoom(w, h.permissionStore, user.ID, roomID, 0); !ok {
+		return
+	}
+
+	payload := &types.CreateUploadPayload{}
+	if err := utils.ParseJSON(r, payload); err != nil {
+		handleError(w, "Invalid JSON", http.StatusBadRequest, err)
+		return
+	}
+	if payload.Size <= 0 {
+		handleError(w, "size must be positive", http.StatusBadRequest, nil)
+		return
+	}
+	if payload.Size > h.maxSize {
+		handleError(w, h.tooLargeMessage(), http.StatusRequestEntityTooLarge, nil)
+		return
+	}
+	contentType := detectContentType(nil, payload.ContentType)
+	if !h.allowedType(contentType) {
+		handleError(w, "Files of type "+contentType+" aren't allowed", http.StatusUnsupportedMediaType, nil)
+		return
+	}
+
+	attachment := &types.Attachment{
+		RoomID:      roomID,
+		UploaderID:  user.ID,
+		Filename:    cleanFilename(payload.Filename),
+		ContentType: contentType,
+		Size:        payload.Size,
+		ChunkSize:   uploadChunkSize,
+		StorageKey:  storageKey(roomID),
+	}
+	if err := h.store.CreateAttachment(attachment); err != nil {
+		handleError(w, "Error creating upload", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusCreated, attachment)
+}
+
+// UploadChunkHandler takes the next chunk of an upload, placed with a
+// Content-Range header such as "bytes 0-5242879/12000000". Chunks have to
+// arrive in order; when one doesn't continue the upload the response is a
+// 409 with the attachment, whose received field says where to resume.
+func (h *Handler) UploadChunkHandler(w http.ResponseWriter, r *http.Request) {
+	attachmentID, ok := parseID(w, r, "attachmentID")
+	if !ok {
+		return
+	}
+	user := auth.GetUserFromContext(r.Context())
+	attachment, err := h.store.GetAttachment(attachmentID)
+	if err == nil && attachment.UploaderID != user.ID {
+		err = types.ErrAttachmentNotFound
+	}
+	if errors.Is(err, types.ErrAttachmentNotFound) {
+		handleError(w, "Upload not found", http.StatusNotFound, nil)
+		return
+	}
+	if err != nil {
+		handleError(w, "Error getting upload", http.StatusInternalServerError, err)
+		return
+	}
+	if attachment.Complete() {
+		handleError(w, "Upload is already complete", http.StatusConflict, nil)
+		return
+	}
+
+	start, end, total, err := parseContentRange(r.Header.Get("Content-Range"))
+	if err != nil || total != attachment.Size {
+		handleError(w, "Invalid Content-Range", http.StatusBadRequest, err)
+		return
+	}
+	if start != attachment.Received {
+		utils.SendJSONResponse(w, http.StatusConflict, attachment)
+		return
+	}
+	length := end - start + 1
+	if want := chunkLength(attachment, start); length != want {
+		handleError(w, fmt.Sprintf("Chunk must be %d bytes", want), http.StatusBadRequest, nil)
+		return
+	}
+
+	key := chunkKey(attachment.ID, start/attachment.ChunkSize)
+	r.Body = http.MaxBytesReader(w, r.Body, length)
+	if err := h.blobs.Put(key, r.Body, length, "application/octet-stream"); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			handleError(w, "Chunk is larger than its Content-Range", http.StatusBadRequest, nil)
+			return
+		}
+		handleError(w, "Error storing chunk", http.StatusInternalServerError, err)
+		return
+	}
+	err = h.store.AddChunk(attachment.ID, start, length)
+	if errors.Is(err, types.ErrUploadOffset) {
+		handleError(w, "Chunk was already received", http.StatusConflict, nil)
+		return
+	}
+	if err != nil {
+		handleError(w, "Error storing chunk", http.StatusInternalServerError, err)
+		return
+	}
+	attachment.Received += length
+
+	if attachment.Received == attachment.Size {
+		attachment, err = h.completeUpload(attachment)
+		if errors.Is(err, errTypeNotAllowed) {
+			handleError(w, "This file type isn't allowed", http.StatusUnsupportedMediaType, nil)
+			return
+		}
+		if err != nil {
+			handleError(w, "Error completing upload", http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, attachment)
+}
+
+var errTypeNotAllowed = errors.New("content type not allowed")
+
+// completeUpload checks the real content type now that the first bytes
+// are known, then joins the chunks into the attachment's blob.
+func (h *Handler) completeUpload(attachment *types.Attachment) (*types.Attachment, error) {
+	head, err := h.readHead(chunkKey(attachment.ID, 0))
+	if err != nil {
+		return nil, err
+	}
+	contentType := detectContentType(head, attachment.ContentType)
+	if !h.allowedType(contentType) {
+		if err := h.deleteBlobs(attachment); err != nil {
+			log.Printf("Error deleting chunks of attachment %d: %v", attachment.ID, err)
+		}
+		if err := h.store.DeleteAttachment(attachment.ID); err != nil {
+			log.Printf("Error deleting attachment %d: %v", attachment.ID, err)
+		}
+		return nil, errTypeNotAllowed
+	}
+
+	chunks := &chunkReader{blobs: h.blobs, attachment: attachment}
+	err = h.blobs.Put(attachment.StorageKey, chunks, attachment.Size, contentType)
+	chunks.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	completed, err := h.store.CompleteAttachment(attachment.ID, contentType)
+	if err != nil {
+		return nil, err
+	}
+	for i := int64(0); i*attachment.ChunkSize < attachment.Size; i++ {
+		if err := h.blobs.Delete(chunkKey(attachment.ID, i)); err != nil {
+			log.Printf("Error deleting chunk %d of attachment %d: %v", i, attachment.ID, err)
+		}
+	}
+	return completed, nil
+}
+
+func (h *Handler) readHead(key string) ([]byte, error) {
+	body, err := h.blobs.Get(key, 0, 512)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// DownloadHandler serves an attachment to members of its room. Attachments
+// not sent with a message yet are only visible to their uploader. A single
+// byte range may be requested with a Range header.
+func (h *Handler) DownloadHandler(w http.ResponseWriter, r *http.Request) {
+	attachmentID, ok := parseID(w, r, "attachmentID")
+	if !ok {
+		return
+	}
+	user := auth.GetUserFromContext(r.Context())
+	attachment, err := h.store.GetAttachment(attachmentID)
+	if err == nil && (!attachment.Complete() || attachment.MessageID == nil && attachment.UploaderID != user.ID) {
+		err = types.ErrAttachmentNotFound
+	}
+	if errors.Is(err, types.ErrAttachmentNotFound) {
+		handleError(w, "Attachment not found", http.StatusNotFound, nil)
+		return
+	}
+	if err != nil {
+		handleError(w, "Error getting attachment", http.StatusInternalServerError, err)
+		return
+	}
+	if _, ok := permissions.AuthorizeRoom(w, h.permissionStore, user.ID, attachment.RoomID, 0); !ok {
+		return
+	}
+
+	start, length, partial, err := parseRange(r.Header.Get("Range"), attachment.Size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", attachment.Size))
+		handleError(w, "Requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable, nil)
+		return
+	}
+
+	body, err := h.blobs.Get(attachment.StorageKey, start, length)
+	if err != nil {
+		handleError(w, "Error reading attachment", http.StatusInternalServerError, err)
+		return
+	}
+	defer body.Close()
+
+	header := w.Header()
+	header.Set("Content-Type", attachment.ContentType)
+	header.Set("Content-Length", strconv.FormatInt(length, 10))
+	header.Set("Content-Disposition", mime.FormatMediaType(disposition(attachment.ContentType),
+		map[string]string{"filename": attachment.Filename}))
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Content-Security-Policy", "sandbox")
+	header.Set("Cache-Control", "private, max-age=86400")
+	header.Set("Accept-Ranges", "bytes")
+	status := http.StatusOK
+	if partial {
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, attachment.Size))
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+	if _, err := io.Copy(w, body); err != nil {
+		log.Printf("Error sending attachment %d: %v", attachment.ID, err)
+	}
+}
+
+func (h *Handler) tooLargeMessage() string {
+	return fmt.Sprintf("Attachments can be at most %d bytes", h.maxSize)
+}
+
+func (h *Handler) allowedType(contentType string) bool {
+	for _, allowed := range h.allowedTypes {
+		if contentType == allowed || strings.HasSuffix(allowed, "/") && strings.HasPrefix(contentType, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// genericTypes are what content sniffing reports for formats it can't
+// tell apart, such as office documents which are zip files. The client's
+// type is trusted for those, since allowedType still vets it.
+var genericTypes = map[string]bool{
+	"application/octet-stream": true,
+	"application/zip":          true,
+	"text/plain":               true,
+}
+
+// detectContentType sniffs the type from the first bytes of a file and
+// falls back to the declared type when that is inconclusive. Parameters
+// are dropped.
+func detectContentType(head []byte, declared string) string {
+	contentType := "application/octet-stream"
+	if len(head) > 0 {
+		contentType, _, _ = mime.ParseMediaType(http.DetectContentType(head))
+	}
+	if genericTypes[contentType] {
+		if parsed, _, err := mime.ParseMediaType(declared); err == nil {
+			contentType = parsed
+		}
+	}
+	return contentType
+}
+
+// disposition lets browsers show media inline and downloads the rest.
+func disposition(contentType string) string {
+	switch {
+	case contentType == "image/png", contentType == "image/jpeg", contentType == "image/gif",
+		contentType == "image/webp", strings.HasPrefix(contentType, "video/"),
+		strings.HasPrefix(contentType, "audio/"):
+		return "inline"
+	}
+	return "attachment"
+}
+
+func cleanFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "." || name == "/" || strings.TrimSpace(name) == "" {
+		return "file"
+	}
+	for len(name) > maxFilenameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+func storageKey(roomID int) string {
+	return fmt.Sprintf("attachments/%d/%s", roomID, uuid.NewString())
+}
+
+func chunkKey(attachmentID int, index int64) string {
+	return fmt.Sprintf("uploads/%d/%d", attachmentID, index)
+}
+
+func chunkLength(a *types.Attachment, start int64) int64 {
+	if remaining := a.Size - start; remaining < a.ChunkSize {
+		return remaining
+	}
+	return a.ChunkSize
+}
+
+// parseContentRange reads "bytes start-end/total".
+func parseContentRange(value string) (start, end, total int64, err error) {
+	spec, ok := strings.CutPrefix(value, "bytes ")
+	if !ok {
+		return 0, 0, 0, errors.New("Content-Range must be in bytes")
+	}
+	_, err = fmt.Sscanf(spec, "%d-%d/%d", &start, &end, &total)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if start < 0 || end < start || end >= total {
+		return 0, 0, 0, errors.New("Content-Range is out of bounds")
+	}
+	return start, end, total, nil
+}
+
+// parseRange reads a Range header for a file of the given size. Only a
+// single byte range is supported; anything else gets the whole file, as
+// servers may ignore Range. An error means the range can't be satisfied.
+func parseRange(value string, size int64) (start, length int64, partial bool, err error) {
+	spec, ok := strings.CutPrefix(value, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, size, false, nil
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, size, false, nil
+	}
+
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false, errors.New("invalid suffix range")
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true, nil
+	}
+
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false, errors.New("range starts after the end of the file")
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false, errors.New("invalid range")
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, true, nil
+}
+
+// chunkReader reads the chunks of an upload one after the other, opening
+// each as it gets to it.
+type chunkReader struct {
+	blobs      types.BlobStore
+	attachment *types.Attachment
+	index      int64
+	current    io.ReadCloser
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if c.current == nil {
+			if c.index*c.attachment.ChunkSize >= c.attachment.Size {
+				return 0, io.EOF
+			}
+			body, err := c.blobs.Get(chunkKey(c.attachment.ID, c.index), 0, -1)
+			if err != nil {
+				return 0, err
+			}
+			c.current = body
+			c.index++
+		}
+		n, err := c.current.Read(p)
+		if errors.Is(err, io.EOF) {
+			c.current.Close()
+			c.current = nil
+			err = nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+func (c *chunkReader) Close() error {
+	if c.current == nil {
+		return nil
+	}
+	return c.current.Close()
+}
+
+func parseID(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)[name])
+	if err != nil {
+		handleError(w, "Invalid "+name, http.StatusBadRequest, err)
+		return 0, false
+	}
+	return id, true
+}
+
+func handleError(w http.ResponseWriter, message string, statusCode int, err error) {
+	if err != nil {
+		log.Println(message, err)
+	} else {
+		log.Println(message)
+	}
+	http.Error(w, message, statusCode)
+}
@@ -0,0 +1,127 @@
+package attachment
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"user/server/types"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header        string
+		start, length int64
+		partial, fail bool
+	}{
+		{"", 0, 100, false, false},
+		{"bytes=0-9", 0, 10, true, false},
+		{"bytes=90-", 90, 10, true, false},
+		{"bytes=-20", 80, 20, true, false},
+		{"bytes=-500", 0, 100, true, false},
+		{"bytes=50-1000", 50, 50, true, false},
+		{"bytes=0-1,5-6", 0, 100, false, false},
+		{"items=0-1", 0, 100, false, false},
+		{"bytes=100-", 0, 0, false, true},
+		{"bytes=9-2", 0, 0, false, true},
+		{"bytes=-0", 0, 0, false, true},
+	}
+	for _, tt := range tests {
+		start, length, partial, err := parseRange(tt.header, 100)
+		if (err != nil) != tt.fail {
+			t.Errorf("parseRange(%q) error = %v", tt.header, err)
+			continue
+		}
+		if !tt.fail && (start != tt.start || length != tt.length || partial != tt.partial) {
+			t.Errorf("parseRange(%q) = %d, %d, %v", tt.header, start, length, partial)
+		}
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	start, end, total, err := parseContentRange("bytes 5242880-10485759/12000000")
+	if err != nil || start != 5242880 || end != 10485759 || total != 12000000 {
+		t.Errorf("got %d-%d/%d, %v", start, end, total, err)
+	}
+	for _, value := range []string{"", "bytes */100", "bytes 10-5/100", "bytes 0-100/100"} {
+		if _, _, _, err := parseContentRange(value); err == nil {
+			t.Errorf("parseContentRange(%q) should fail", value)
+		}
+	}
+}
+
+func TestContentTypes(t *testing.T) {
+	h := NewHandler(nil, nil, nil, nil, 100, "image/, application/pdf,application/vnd.ms-excel")
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+	tests := []struct {
+		head     []byte
+		declared string
+		want     string
+		allowed  bool
+	}{
+		{png, "application/pdf", "image/png", true},
+		{[]byte("<html><script>alert(1)</script>"), "image/png", "text/html", false},
+		{[]byte("a,b\n1,2"), "application/vnd.ms-excel", "application/vnd.ms-excel", true},
+		{[]byte("plain text"), "", "text/plain", false},
+		{nil, "application/pdf; name=x.pdf", "application/pdf", true},
+	}
+	for _, tt := range tests {
+		got := detectContentType(tt.head, tt.declared)
+		if got != tt.want || h.allowedType(got) != tt.allowed {
+			t.Errorf("detectContentType(%q, %q) = %s, allowed %v", tt.head, tt.declared, got, h.allowedType(got))
+		}
+	}
+}
+
+func TestCleanFilename(t *testing.T) {
+	tests := map[string]string{
+		"report.pdf":             "report.pdf",
+		"../../etc/passwd":       "passwd",
+		`C:\Users\me\cat.png`:    "cat.png",
+		"bad\nname\x00.txt":      "badname.txt",
+		"":                       "file",
+		"dir/":                   "dir",
+		strings.Repeat("é", 200): strings.Repeat("é", 127),
+	}
+	for name, want := range tests {
+		if got := cleanFilename(name); got != want {
+			t.Errorf("cleanFilename(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestChunkedUploadWithLocalStore(t *testing.T) {
+	blobs := NewLocalBlobStore(t.TempDir())
+	a := &types.Attachment{ID: 7, Size: 10, ChunkSize: 4, StorageKey: "attachments/1/file"}
+	for i, chunk := range []string{"0123", "4567", "89"} {
+		if err := blobs.Put(chunkKey(a.ID, int64(i)), strings.NewReader(chunk), int64(len(chunk)), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	chunks := &chunkReader{blobs: blobs, attachment: a}
+	if err := blobs.Put(a.StorageKey, chunks, a.Size, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	chunks.Close()
+
+	body, err := blobs.Get(a.StorageKey, 3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	data, _ := io.ReadAll(body)
+	if string(data) != "34567" {
+		t.Errorf("got %q", data)
+	}
+
+	if err := blobs.Put("short", strings.NewReader("abc"), 4, ""); err == nil {
+		t.Error("Put should fail when the body is shorter than size")
+	}
+	if _, err := blobs.Get("missing", 0, -1); err != types.ErrBlobNotFound {
+		t.Errorf("Get of a missing blob returned %v", err)
+	}
+	if _, err := blobs.Get("../secret", 0, -1); err == nil {
+		t.Error("Get should reject keys leaving the directory")
+	}
+}
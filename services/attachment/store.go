@@ -0,0 +1,142 @@
+package attachment
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+	"user/server/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+const attachmentColumns = `ID, MessageID, RoomID, UploaderID, Filename, ContentType, Size, Received, ChunkSize,
+	StorageKey, CompletedAt, CreatedAt`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAttachment(row scanner) (*types.Attachment, error) {
+	a := &types.Attachment{}
+	var messageID, roomID sql.NullInt64
+	var completedAt sql.NullTime
+	err := row.Scan(&a.ID, &messageID, &roomID, &a.UploaderID, &a.Filename, &a.ContentType, &a.Size,
+		&a.Received, &a.ChunkSize, &a.StorageKey, &completedAt, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if messageID.Valid {
+		id := int(messageID.Int64)
+		a.MessageID = &id
+	}
+	a.RoomID = int(roomID.Int64)
+	if completedAt.Valid {
+		a.CompletedAt = &completedAt.Time
+	}
+	return a, nil
+}
+
+// CreateAttachment inserts the attachment, which is complete unless it has
+// a chunk size.
+func (s *Store) CreateAttachment(a *types.Attachment) error {
+	err := s.db.QueryRow(`
+		INSERT INTO Attachments (RoomID, UploaderID, Filename, ContentType, Size, Received, ChunkSize, StorageKey,
+			CompletedAt)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, CASE WHEN $7 = 0 THEN CURRENT_TIMESTAMP END)
+		RETURNING ID, CompletedAt, CreatedAt`,
+		a.RoomID, a.UploaderID, a.Filename, a.ContentType, a.Size, a.Received, a.ChunkSize, a.StorageKey).
+		Scan(&a.ID, &a.CompletedAt, &a.CreatedAt)
+	if err != nil {
+		log.Println("Error creating attachment: ", err)
+		return err
+	}
+	return nil
+}
+
+// GetAttachment hides the attachments of deleted rooms, which only wait
+// for the cleanup to remove their blobs.
+func (s *Store) GetAttachment(attachmentID int) (*types.Attachment, error) {
+	a, err := scanAttachment(s.db.QueryRow(`
+		SELECT `+attachmentColumns+` FROM Attachments WHERE ID = $1 AND RoomID IS NOT NULL`,
+		attachmentID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrAttachmentNotFound
+	}
+	if err != nil {
+		log.Println("Error getting attachment: ", err)
+		return nil, err
+	}
+	return a, nil
+}
+
+func (s *Store) AddChunk(attachmentID int, offset, size int64) error {
+	res, err := s.db.Exec(`
+		UPDATE Attachments SET Received = Received + $1
+		WHERE ID = $2 AND Received = $3 AND CompletedAt IS NULL AND RoomID IS NOT NULL`, size, attachmentID, offset)
+	if err != nil {
+		log.Println("Error recording attachment chunk: ", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return types.ErrUploadOffset
+	}
+	return nil
+}
+
+func (s *Store) CompleteAttachment(attachmentID int, contentType string) (*types.Attachment, error) {
+	a, err := scanAttachment(s.db.QueryRow(`
+		UPDATE Attachments SET ContentType = $1, CompletedAt = CURRENT_TIMESTAMP
+		WHERE ID = $2 AND Received = Size AND CompletedAt IS NULL AND RoomID IS NOT NULL
+		RETURNING `+attachmentColumns, contentType, attachmentID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrAttachmentNotFound
+	}
+	if err != nil {
+		log.Println("Error completing attachment: ", err)
+		return nil, err
+	}
+	return a, nil
+}
+
+func (s *Store) GetExpiredAttachments(maxAge time.Duration, limit int) ([]*types.Attachment, error) {
+	rows, err := s.db.Query(`
+		SELECT `+attachmentColumns+` FROM Attachments
+		WHERE MessageID IS NULL AND CreatedAt < CURRENT_TIMESTAMP - make_interval(secs => $1)
+			OR RoomID IS NULL
+		ORDER BY ID LIMIT $2`, maxAge.Seconds(), limit)
+	if err != nil {
+		log.Println("Error getting expired attachments: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := make([]*types.Attachment, 0)
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			log.Println("Error scanning attachment: ", err)
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
+
+func (s *Store) DeleteAttachment(attachmentID int) error {
+	res, err := s.db.Exec(`DELETE FROM Attachments WHERE ID = $1`, attachmentID)
+	if err != nil {
+		log.Println("Error deleting attachment: ", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return types.ErrAttachmentNotFound
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"user/server/types"
+)
+
+// APITokenPrefix marks personal access tokens so they are easy to spot in
+// code and logs, and to scan for if one leaks.
+const APITokenPrefix = "gsp_"
+
+const APITokenKey contextKey = "apiToken"
+
+var apiTokenStore types.APITokenStore
+
+var ErrInsufficientScope = errors.New("api token lacks the required scope")
+
+func SetAPITokenStore(store types.APITokenStore) {
+	apiTokenStore = store
+}
+
+// GenerateAPIToken returns the secret to show the user once, and the
+// prefix and hash to store.
+func GenerateAPIToken() (secret, prefix, hash string, err error) {
+	random, err := generateRandomToken()
+	if err != nil {
+		return "", "", "", err
+	}
+	secret = APITokenPrefix + random
+	return secret, secret[:len(APITokenPrefix)+8], HashToken(secret), nil
+}
+
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+func authenticateAPIToken(r *http.Request, secret string, s types.UserStore) (*types.User, error) {
+	if apiTokenStore == nil {
+		return nil, errors.New("api token store not configured")
+	}
+
+	token, err := apiTokenStore.GetAPITokenByHash(HashToken(secret))
+	if err != nil {
+		return nil, err
+	}
+	if !token.IsActive() {
+		return nil, errors.New("api token revoked or expired")
+	}
+
+	scope := requiredScope(r)
+	if !token.HasScope(scope) {
+		log.Println("API token", token.ID, "lacks scope", scope)
+		return nil, ErrInsufficientScope
+	}
+
+	if token.LastUsedAt == nil || time.Since(*token.LastUsedAt) > time.Minute {
+		if err := apiTokenStore.TouchAPIToken(token.ID); err != nil {
+			log.Println("Error updating API token last used: ", err)
+		}
+	}
+
+	user, err := s.GetUserByID(token.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user.DeactivatedAt != nil {
+		return nil, errors.New("api token user deactivated")
+	}
+
+	ctx := r.Context()
+	ctx = context.WithValue(ctx, UserKey, user)
+	ctx = context.WithValue(ctx, APITokenKey, token)
+	if token.ExpiresAt != nil {
+		ctx = context.WithValue(ctx, UserExpirationKey, *token.ExpiresAt)
+	}
+	*r = *r.WithContext(ctx)
+
+	return user, nil
+}
+
+func requiredScope(r *http.Request) string {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return types.ScopeChat
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return types.ScopeRead
+	}
+	return types.ScopeWrite
+}
+
+func GetAPITokenFromContext(ctx context.Context) *types.APIToken {
+	token, _ := ctx.Value(APITokenKey).(*types.APIToken)
+	return token
+}
+
+// ConnectionID identifies what authenticated a WebSocket so it can be
+// dropped when that session or token is revoked.
+func ConnectionID(ctx context.Context) string {
+	if token := GetAPITokenFromContext(ctx); token != nil {
+		return APITokenConnectionID(token.ID)
+	}
+	return GetSessionIDFromContext(ctx)
+}
+
+func APITokenConnectionID(tokenID int) string {
+	return "apitoken:" + strconv.Itoa(tokenID)
+}
+
+// WithSessionAuth only lets browser sessions through. Account settings
+// such as tokens or MFA must not be reachable with an API token.
+func WithSessionAuth(handlerFunc http.HandlerFunc, store types.UserStore) http.HandlerFunc {
+	return WithJWTAuth(func(w http.ResponseWriter, r *http.Request) {
+		if GetSessionIDFromContext(r.Context()) == "" {
+			http.Error(w, "This endpoint requires a browser session", http.StatusForbidden)
+			return
+		}
+		handlerFunc(w, r)
+	}, store)
+}
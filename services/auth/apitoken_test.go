@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"user/server/types"
+)
+
+type fakeAPITokenStore struct {
+	types.APITokenStore
+	tokens  map[string]*types.APIToken
+	touched []int
+}
+
+func (s *fakeAPITokenStore) GetAPITokenByHash(tokenHash string) (*types.APIToken, error) {
+	token, ok := s.tokens[tokenHash]
+	if !ok {
+		return nil, types.ErrAPITokenNotFound
+	}
+	return token, nil
+}
+
+func (s *fakeAPITokenStore) TouchAPIToken(tokenID int) error {
+	s.touched = append(s.touched, tokenID)
+	return nil
+}
+
+type fakeUserStore struct {
+	types.UserStore
+}
+
+func (s *fakeUserStore) GetUserByID(id int) (*types.User, error) {
+	switch id {
+	case 7:
+		return &types.User{ID: 7, Username: "deploy@bots.invalid", IsBot: true}, nil
+	case 8:
+		deactivated := time.Now()
+		return &types.User{ID: 8, Username: "old@bots.invalid", IsBot: true, DeactivatedAt: &deactivated}, nil
+	}
+	return nil, errors.New("invalid credentials")
+}
+
+func TestAPITokenAuth(t *testing.T) {
+	store := &fakeAPITokenStore{tokens: make(map[string]*types.APIToken)}
+	SetAPITokenStore(store)
+	defer SetAPITokenStore(nil)
+
+	addToken := func(id int, scopes []string, revoked bool) string {
+		secret, prefix, hash, err := GenerateAPIToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		token := &types.APIToken{ID: id, UserID: 7, Prefix: prefix, Scopes: scopes}
+		if id == 4 {
+			token.UserID = 8
+		}
+		if revoked {
+			now := time.Now()
+			token.RevokedAt = &now
+		}
+		store.tokens[hash] = token
+		return secret
+	}
+
+	readOnly := addToken(1, []string{types.ScopeRead}, false)
+	chat := addToken(2, []string{types.ScopeChat}, false)
+	revoked := addToken(3, types.AllScopes, true)
+	deactivated := addToken(4, types.AllScopes, false)
+
+	tests := []struct {
+		name      string
+		method    string
+		token     string
+		websocket bool
+		session   bool
+		expected  int
+	}{
+		{name: "Read scope allows GET", method: "GET", token: readOnly, expected: http.StatusOK},
+		{name: "Read scope denies POST", method: "POST", token: readOnly, expected: http.StatusForbidden},
+		{name: "Chat scope allows WebSocket", method: "GET", token: chat, websocket: true, expected: http.StatusOK},
+		{name: "Read scope denies WebSocket", method: "GET", token: readOnly, websocket: true, expected: http.StatusForbidden},
+		{name: "Revoked token", method: "GET", token: revoked, expected: http.StatusUnauthorized},
+		{name: "Deactivated bot", method: "GET", token: deactivated, expected: http.StatusUnauthorized},
+		{name: "Unknown token", method: "GET", token: APITokenPrefix + "nope", expected: http.StatusUnauthorized},
+		{name: "JWT as bearer", method: "GET", token: "eyJhbGciOiJIUzI1NiJ9.e30.x", expected: http.StatusUnauthorized},
+		{name: "Session only endpoint", method: "GET", token: readOnly, session: true, expected: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen *types.User
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				seen = GetUserFromContext(r.Context())
+			}
+			wrapped := WithJWTAuth(handler, &fakeUserStore{})
+			if tt.session {
+				wrapped = WithSessionAuth(handler, &fakeUserStore{})
+			}
+
+			r := httptest.NewRequest(tt.method, "/api/v1/channels", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			if tt.websocket {
+				r.Header.Set("Upgrade", "websocket")
+			}
+			res := httptest.NewRecorder()
+			wrapped(res, r)
+
+			if res.Code != tt.expected {
+				t.Fatalf("expected %d, got %d", tt.expected, res.Code)
+			}
+			if tt.expected == http.StatusOK && (seen == nil || seen.ID != 7) {
+				t.Errorf("handler did not get the token's user: %+v", seen)
+			}
+		})
+	}
+
+	if len(store.touched) == 0 {
+		t.Error("last used timestamp was never updated")
+	}
+}
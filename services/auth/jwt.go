@@ -8,26 +8,32 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 	"user/server/types"
 )
 
-var jwtSecret = []byte("my_secret_key")
+var sessionStore types.SessionStore
 
 type contextKey string
 
 const UserKey contextKey = "userID"
 const UserExpirationKey contextKey = "exp"
+const SessionKey contextKey = "sid"
 
-func GenerateJWTToken(username string) (string, error) {
+const AccessTokenTTL = 15 * time.Minute
 
-	// TODO: Add better expiration time
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+func SetSessionStore(store types.SessionStore) {
+	sessionStore = store
+}
+
+func GenerateJWTToken(username, sessionID string, expiresAt time.Time) (string, error) {
+	tokenString, err := signClaims(jwt.MapClaims{
 		"username": username,
-		"exp":      time.Now().Add(24 * time.Hour).Unix(),
+		"sid":      sessionID,
+		"iat":      time.Now().Unix(),
+		"exp":      expiresAt.Unix(),
 	})
-
-	tokenString, err := token.SignedString(jwtSecret)
 	if err != nil {
 		return "", err
 	}
@@ -38,6 +44,10 @@ func GenerateJWTToken(username string) (string, error) {
 func WithJWTAuth(handlerFunc http.HandlerFunc, store types.UserStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user, err := AuthenticateRequest(r, store)
+		if errors.Is(err, ErrInsufficientScope) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
 		if err != nil {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
@@ -52,26 +62,44 @@ func WithJWTAuth(handlerFunc http.HandlerFunc, store types.UserStore) http.Handl
 }
 
 func AuthenticateRequest(r *http.Request, s types.UserStore) (*types.User, error) {
+	if secret := bearerToken(r); secret != "" {
+		if !strings.HasPrefix(secret, APITokenPrefix) {
+			return nil, ErrInvalidToken
+		}
+		user, err := authenticateAPIToken(r, secret, s)
+		if err != nil {
+			log.Println("Rejecting API token: ", err)
+		}
+		return user, err
+	}
+
 	cookie, err := r.Cookie("jwt_token")
 	if err != nil {
 		log.Println("Error getting JWT token from cookie: ", err)
 		return nil, err // No JWT token found in the cookie
 	}
 
-	token, err := jwt.Parse(cookie.Value, func(token *jwt.Token) (interface{}, error) {
-		if token.Method != jwt.SigningMethodHS256 {
-			return nil, errors.New("unexpected signing method")
-		}
-		return jwtSecret, nil
-	})
+	token, err := jwt.Parse(cookie.Value, verificationKey)
 	if err != nil {
 		log.Println("Error parsing JWT token: ", err)
 		return nil, err // Failed to parse JWT token
 	}
 
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		username := claims["username"].(string)
-		expirationTime := int64(claims["exp"].(float64))
+		if _, ok := claims[purposeClaim]; ok {
+			log.Println("Rejecting purpose token used as access token")
+			return nil, ErrInvalidToken
+		}
+
+		username, _ := claims["username"].(string)
+		sessionID, _ := claims["sid"].(string)
+		expirationTime, _ := claims["exp"].(float64)
+
+		err := checkSession(sessionID)
+		if err != nil {
+			log.Println("Rejecting JWT token: ", err)
+			return nil, err
+		}
 
 		userID, err := strconv.Atoi(username)
 		if err != nil {
@@ -87,7 +115,8 @@ func AuthenticateRequest(r *http.Request, s types.UserStore) (*types.User, error
 
 		ctx := r.Context()
 		ctx = context.WithValue(ctx, UserKey, user)
-		ctx = context.WithValue(ctx, UserExpirationKey, time.Unix(expirationTime, 0))
+		ctx = context.WithValue(ctx, UserExpirationKey, time.Unix(int64(expirationTime), 0))
+		ctx = context.WithValue(ctx, SessionKey, sessionID)
 		*r = *r.WithContext(ctx)
 
 		return user, nil
@@ -96,6 +125,35 @@ func AuthenticateRequest(r *http.Request, s types.UserStore) (*types.User, error
 	return nil, jwt.ValidationError{Inner: errors.New("invalid token"), Errors: jwt.ValidationErrorClaimsInvalid}
 }
 
+func checkSession(sessionID string) error {
+	if sessionID == "" {
+		return errors.New("token has no session")
+	}
+	if sessionStore == nil {
+		return errors.New("session store not configured")
+	}
+
+	session, err := sessionStore.GetSession(sessionID)
+	if err != nil {
+		return err
+	}
+	if !session.IsActive() {
+		return errors.New("session revoked or expired")
+	}
+
+	if time.Since(session.LastSeen) > time.Minute {
+		if err := sessionStore.TouchSession(sessionID); err != nil {
+			log.Println("Error updating session last seen: ", err)
+		}
+	}
+	return nil
+}
+
+func GetSessionIDFromContext(ctx context.Context) string {
+	sessionID, _ := ctx.Value(SessionKey).(string)
+	return sessionID
+}
+
 func GetUserFromContext(ctx context.Context) *types.User {
 	user, ok := ctx.Value(UserKey).(*types.User)
 	if !ok {
@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"user/server/config"
+	"user/server/services/utils"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+type jwtKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	SignKey   interface{}
+	VerifyKey interface{}
+}
+
+type keySet struct {
+	mu      sync.RWMutex
+	signing *jwtKey
+	verify  map[string]*jwtKey
+}
+
+var keys = &keySet{verify: make(map[string]*jwtKey)}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// ConfigureKeys loads the signing key and any extra verification keys
+// kept around while rotating.
+func ConfigureKeys(cfg config.Config) error {
+	if cfg.JWTKeyID == "" {
+		return errors.New("JWT key ID is not configured")
+	}
+
+	signing, err := loadSigningKey(cfg)
+	if err != nil {
+		return err
+	}
+
+	verify := map[string]*jwtKey{signing.ID: signing}
+	for _, entry := range strings.Split(cfg.JWTVerifyKeys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, err := parseVerifyKey(entry)
+		if err != nil {
+			return err
+		}
+		if _, exists := verify[key.ID]; exists {
+			return fmt.Errorf("duplicate JWT key ID %q", key.ID)
+		}
+		verify[key.ID] = key
+	}
+
+	keys.mu.Lock()
+	defer keys.mu.Unlock()
+	keys.signing = signing
+	keys.verify = verify
+	return nil
+}
+
+func loadSigningKey(cfg config.Config) (*jwtKey, error) {
+	key := &jwtKey{ID: cfg.JWTKeyID}
+
+	switch cfg.JWTAlgorithm {
+	case "HS256":
+		if cfg.JWTSecret == "" {
+			return nil, errors.New("JWT secret is not configured")
+		}
+		key.Method = jwt.SigningMethodHS256
+		key.SignKey = []byte(cfg.JWTSecret)
+		key.VerifyKey = key.SignKey
+		return key, nil
+	case "RS256", "EdDSA":
+		data, err := os.ReadFile(cfg.JWTPrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading JWT private key: %w", err)
+		}
+		privateKey, err := parsePrivateKey(data)
+		if err != nil {
+			return nil, err
+		}
+		key.SignKey = privateKey
+		key.VerifyKey = privateKey.(crypto.Signer).Public()
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm %q", cfg.JWTAlgorithm)
+	}
+
+	switch key.SignKey.(type) {
+	case *rsa.PrivateKey:
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		key.Method = SigningMethodEdDSA
+	}
+	if key.Method == nil || key.Method.Alg() != cfg.JWTAlgorithm {
+		return nil, fmt.Errorf("JWT private key does not match algorithm %s", cfg.JWTAlgorithm)
+	}
+	return key, nil
+}
+
+func parseVerifyKey(entry string) (*jwtKey, error) {
+	kid, rest, ok := strings.Cut(entry, "=")
+	if !ok {
+		return nil, fmt.Errorf("invalid JWT verification key %q", entry)
+	}
+	alg, value, ok := strings.Cut(rest, ":")
+	if !ok || kid == "" || value == "" {
+		return nil, fmt.Errorf("invalid JWT verification key %q", entry)
+	}
+
+	key := &jwtKey{ID: kid}
+	switch alg {
+	case "HS256":
+		key.Method = jwt.SigningMethodHS256
+		key.VerifyKey = []byte(value)
+		return key, nil
+	case "RS256", "EdDSA":
+		data, err := os.ReadFile(value)
+		if err != nil {
+			return nil, fmt.Errorf("reading JWT public key %s: %w", kid, err)
+		}
+		publicKey, err := parsePublicKey(data)
+		if err != nil {
+			return nil, err
+		}
+		key.VerifyKey = publicKey
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm %q for key %s", alg, kid)
+	}
+
+	switch key.VerifyKey.(type) {
+	case *rsa.PublicKey:
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.Method = SigningMethodEdDSA
+	}
+	if key.Method == nil || key.Method.Alg() != alg {
+		return nil, fmt.Errorf("JWT public key %s does not match algorithm %s", kid, alg)
+	}
+	return key, nil
+}
+
+func parsePrivateKey(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("JWT private key is not PEM encoded")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+}
+
+func parsePublicKey(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("JWT public key is not PEM encoded")
+	}
+
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+}
+
+func signClaims(claims jwt.MapClaims) (string, error) {
+	keys.mu.RLock()
+	signing := keys.signing
+	keys.mu.RUnlock()
+	if signing == nil {
+		return "", errors.New("JWT signing key not configured")
+	}
+
+	token := jwt.NewWithClaims(signing.Method, claims)
+	token.Header["kid"] = signing.ID
+	return token.SignedString(signing.SignKey)
+}
+
+// verificationKey is the jwt.Keyfunc for our own tokens. The kid header
+// picks the key and the key decides which algorithm is acceptable.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	keys.mu.RLock()
+	defer keys.mu.RUnlock()
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := keys.verify[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+	return key.VerifyKey, nil
+}
+
+func PublicJWKs() JWKSet {
+	keys.mu.RLock()
+	defer keys.mu.RUnlock()
+
+	set := JWKSet{Keys: make([]JWK, 0)}
+	for _, key := range keys.verify {
+		switch publicKey := key.VerifyKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: key.ID,
+				Alg: key.Method.Alg(),
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: key.ID,
+				Alg: key.Method.Alg(),
+				Use: "sig",
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(publicKey),
+			})
+		}
+	}
+	return set
+}
+
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	utils.SendJSONResponse(w, http.StatusOK, PublicJWKs())
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"user/server/config"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("could not write %s: %v", name, err)
+	}
+	return path
+}
+
+func parseOwnToken(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, verificationKey)
+}
+
+func TestSigningAlgorithms(t *testing.T) {
+	dir := t.TempDir()
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edDER, _ := x509.MarshalPKCS8PrivateKey(edKey)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  config.Config
+		alg  string
+	}{
+		{
+			name: "HS256",
+			cfg:  config.Config{JWTAlgorithm: "HS256", JWTKeyID: "hs", JWTSecret: "secret"},
+			alg:  "HS256",
+		},
+		{
+			name: "RS256",
+			cfg: config.Config{JWTAlgorithm: "RS256", JWTKeyID: "rs",
+				JWTPrivateKeyFile: writePEM(t, dir, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))},
+			alg: "RS256",
+		},
+		{
+			name: "EdDSA",
+			cfg: config.Config{JWTAlgorithm: "EdDSA", JWTKeyID: "ed",
+				JWTPrivateKeyFile: writePEM(t, dir, "ed.pem", "PRIVATE KEY", edDER)},
+			alg: "EdDSA",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ConfigureKeys(tt.cfg); err != nil {
+				t.Fatalf("ConfigureKeys: %v", err)
+			}
+
+			tokenString, err := GenerateJWTToken("1", "session", time.Now().Add(time.Minute))
+			if err != nil {
+				t.Fatalf("GenerateJWTToken: %v", err)
+			}
+
+			token, err := parseOwnToken(tokenString)
+			if err != nil || !token.Valid {
+				t.Fatalf("token did not verify: %v", err)
+			}
+			if token.Header["alg"] != tt.alg || token.Header["kid"] != tt.cfg.JWTKeyID {
+				t.Errorf("unexpected header %v", token.Header)
+			}
+
+			jwks := PublicJWKs()
+			if tt.alg == "HS256" && len(jwks.Keys) != 0 {
+				t.Errorf("HS256 secret must not be published, got %v", jwks.Keys)
+			}
+			if tt.alg != "HS256" && (len(jwks.Keys) != 1 || jwks.Keys[0].Kid != tt.cfg.JWTKeyID) {
+				t.Errorf("unexpected JWKS %v", jwks.Keys)
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edDER, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	publicDER, _ := x509.MarshalPKIXPublicKey(edPublic)
+	publicPath := writePEM(t, dir, "old.pub", "PUBLIC KEY", publicDER)
+
+	err = ConfigureKeys(config.Config{JWTAlgorithm: "EdDSA", JWTKeyID: "old",
+		JWTPrivateKeyFile: writePEM(t, dir, "old.pem", "PRIVATE KEY", edDER)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := GenerateJWTToken("1", "session", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ConfigureKeys(config.Config{JWTAlgorithm: "HS256", JWTKeyID: "new", JWTSecret: "new-secret",
+		JWTVerifyKeys: "old=EdDSA:" + publicPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := GenerateJWTToken("1", "session", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tokenString := range []string{oldToken, newToken} {
+		if _, err := parseOwnToken(tokenString); err != nil {
+			t.Errorf("token should verify during rotation: %v", err)
+		}
+	}
+
+	err = ConfigureKeys(config.Config{JWTAlgorithm: "HS256", JWTKeyID: "new", JWTSecret: "new-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseOwnToken(oldToken); err == nil {
+		t.Error("token signed by a retired key should not verify")
+	}
+}
+
+func TestRejectsAlgorithmSwitch(t *testing.T) {
+	err := ConfigureKeys(config.Config{JWTAlgorithm: "HS256", JWTKeyID: "hs", JWTSecret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{"username": "1"})
+	token.Header["kid"] = "hs"
+	tokenString, _ := token.SignedString([]byte("secret"))
+
+	if _, err := parseOwnToken(tokenString); err == nil {
+		t.Error("token signed with a different algorithm should not verify")
+	}
+}
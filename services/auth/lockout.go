@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"user/server/services/utils"
+	"user/server/types"
+)
+
+// LockoutPolicy describes when a key gets locked and for how long. Every
+// failure past Threshold doubles the lock, starting at BaseDelay.
+type LockoutPolicy struct {
+	Threshold int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Failures older than Window are forgotten.
+	Window time.Duration
+}
+
+var (
+	AccountLockoutPolicy = LockoutPolicy{
+		Threshold: 5,
+		BaseDelay: 30 * time.Second,
+		MaxDelay:  time.Hour,
+		Window:    24 * time.Hour,
+	}
+	// IPs get more room since many users can share one NAT.
+	IPLockoutPolicy = LockoutPolicy{
+		Threshold: 20,
+		BaseDelay: 30 * time.Second,
+		MaxDelay:  time.Hour,
+		Window:    time.Hour,
+	}
+)
+
+func (p LockoutPolicy) delay(failures int) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.Threshold; i < failures; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return delay
+}
+
+// LoginLimiter throttles password and MFA attempts per account and per
+// client IP.
+type LoginLimiter struct {
+	store   types.LoginAttemptStore
+	account LockoutPolicy
+	ip      LockoutPolicy
+	now     func() time.Time
+}
+
+func NewLoginLimiter(store types.LoginAttemptStore) *LoginLimiter {
+	return &LoginLimiter{
+		store:   store,
+		account: AccountLockoutPolicy,
+		ip:      IPLockoutPolicy,
+		now:     time.Now,
+	}
+}
+
+// Check returns how long the caller has to wait before trying again, zero
+// if the attempt may go ahead.
+func (l *LoginLimiter) Check(r *http.Request, email string) (time.Duration, error) {
+	now := l.now()
+	var wait time.Duration
+	for _, key := range l.keys(r, email) {
+		attempt, err := l.store.GetAttempt(key)
+		if err != nil {
+			return 0, err
+		}
+		if remaining := attempt.LockedUntil.Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait, nil
+}
+
+func (l *LoginLimiter) Fail(r *http.Request, email string, userID *int, reason string) {
+	now := l.now()
+	keys := l.keys(r, email)
+	policies := []LockoutPolicy{l.account, l.ip}
+	for i, key := range keys {
+		policy := policies[i]
+		failures, err := l.store.RegisterFailure(key, now, policy.Window)
+		if err != nil {
+			log.Println("Error registering login failure: ", err)
+			continue
+		}
+		if delay := policy.delay(failures); delay > 0 {
+			log.Println("Locking login for", key, "for", delay)
+			if err := l.store.LockUntil(key, now.Add(delay)); err != nil {
+				log.Println("Error locking login: ", err)
+			}
+		}
+	}
+
+	err := l.store.RecordLoginFailure(&types.LoginAuditEntry{
+		UserID:    userID,
+		Email:     normalizeEmail(email),
+		IPAddress: utils.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Reason:    reason,
+	})
+	if err != nil {
+		log.Println("Error recording login failure: ", err)
+	}
+}
+
+// Succeed forgets the account's failures. The IP counter is left alone so
+// logging into your own account can't be used to reset it.
+func (l *LoginLimiter) Succeed(email string) {
+	err := l.store.ClearAttempts(accountKey(email))
+	if err != nil {
+		log.Println("Error clearing login failures: ", err)
+	}
+}
+
+func (l *LoginLimiter) keys(r *http.Request, email string) []string {
+	return []string{accountKey(email), "ip:" + utils.ClientIP(r)}
+}
+
+func accountKey(email string) string {
+	return "account:" + normalizeEmail(email)
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
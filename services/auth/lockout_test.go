@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+	"user/server/services/loginattempt"
+)
+
+func TestLockoutPolicyDelay(t *testing.T) {
+	policy := LockoutPolicy{Threshold: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{6, 8 * time.Second},
+		{7, 10 * time.Second},
+		{50, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if delay := policy.delay(tt.failures); delay != tt.expected {
+			t.Errorf("after %d failures expected %v, got %v", tt.failures, tt.expected, delay)
+		}
+	}
+}
+
+func TestLoginLimiter(t *testing.T) {
+	store := loginattempt.NewMemoryStore()
+	limiter := NewLoginLimiter(store)
+	now := time.Unix(1700000000, 0)
+	limiter.now = func() time.Time { return now }
+
+	r := httptest.NewRequest("POST", "/api/v1/auth", nil)
+	r.RemoteAddr = "203.0.113.7:5555"
+
+	for i := 0; i < AccountLockoutPolicy.Threshold; i++ {
+		if wait, _ := limiter.Check(r, "Jane@Example.com"); wait != 0 {
+			t.Fatalf("attempt %d should not be locked, wait %v", i+1, wait)
+		}
+		limiter.Fail(r, "Jane@Example.com", nil, "invalid password")
+	}
+
+	wait, err := limiter.Check(r, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != AccountLockoutPolicy.BaseDelay {
+		t.Fatalf("expected lockout of %v, got %v", AccountLockoutPolicy.BaseDelay, wait)
+	}
+
+	// The IP is still below its own threshold, other accounts are fine.
+	if wait, _ := limiter.Check(r, "bob@example.com"); wait != 0 {
+		t.Errorf("other account should not be locked, wait %v", wait)
+	}
+
+	now = now.Add(AccountLockoutPolicy.BaseDelay)
+	if wait, _ := limiter.Check(r, "jane@example.com"); wait != 0 {
+		t.Errorf("lock should have expired, wait %v", wait)
+	}
+
+	limiter.Fail(r, "jane@example.com", nil, "invalid password")
+	if wait, _ := limiter.Check(r, "jane@example.com"); wait != 2*AccountLockoutPolicy.BaseDelay {
+		t.Errorf("expected the lock to double, got %v", wait)
+	}
+
+	limiter.Succeed("jane@example.com")
+	if wait, _ := limiter.Check(r, "jane@example.com"); wait != 0 {
+		t.Errorf("success should clear the account lock, wait %v", wait)
+	}
+
+	if entries := store.AuditEntries(); len(entries) != AccountLockoutPolicy.Threshold+1 ||
+		entries[0].Email != "jane@example.com" || entries[0].IPAddress != "203.0.113.7" {
+		t.Errorf("unexpected audit entries %+v", entries)
+	}
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"user/server/types"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+)
+
+const mfaTokenPurpose = "mfa"
+
+// IssueMFAToken is handed out after a correct password when the user has
+// MFA enabled. It only proves the first factor and can't be used as a
+// session. Its jti names an MFAChallenge, so it completes one login at most.
+func IssueMFAToken(store types.MFAStore, userID int) (string, error) {
+	challenge := &types.MFAChallenge{
+		TokenID:   uuid.NewString(),
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(MFATokenTTL),
+	}
+	err := store.CreateMFAChallenge(challenge)
+	if err != nil {
+		return "", err
+	}
+
+	return SignToken(mfaTokenPurpose, MFATokenTTL, jwt.MapClaims{
+		"username": strconv.Itoa(userID),
+		"jti":      challenge.TokenID,
+	})
+}
+
+// ParseMFAToken returns the user and the challenge ID of an mfa_token.
+func ParseMFAToken(tokenString string) (int, string, error) {
+	claims, err := ParseToken(mfaTokenPurpose, tokenString)
+	if err != nil {
+		return -1, "", err
+	}
+
+	username, _ := claims["username"].(string)
+	userID, err := strconv.Atoi(username)
+	if err != nil {
+		return -1, "", ErrInvalidToken
+	}
+	tokenID, _ := claims["jti"].(string)
+	if tokenID == "" {
+		return -1, "", ErrInvalidToken
+	}
+	return userID, tokenID, nil
+}
+
+// VerifyMFACode accepts either a current TOTP code or an unused recovery
+// code. Both are single use.
+func VerifyMFACode(store types.MFAStore, mfa *types.UserMFA, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits {
+		step, ok := ValidateTOTP(mfa.Secret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		return store.UseTOTPStep(mfa.UserID, step)
+	}
+
+	if !mfa.Enabled {
+		return false, nil
+	}
+	return store.UseRecoveryCode(mfa.UserID, HashRecoveryCode(code))
+}
@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"user/server/services/utils"
+	"user/server/types"
+
+	"github.com/google/uuid"
+)
+
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+const AccessTokenCookie = "jwt_token"
+const RefreshTokenCookie = "refresh_token"
+
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+func IssueSession(r *http.Request, userID int) (*types.AuthTokens, error) {
+	if sessionStore == nil {
+		return nil, errors.New("session store not configured")
+	}
+
+	userAgent := r.UserAgent()
+	session := &types.Session{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		Device:    describeDevice(userAgent),
+		IPAddress: utils.ClientIP(r),
+		UserAgent: userAgent,
+		ExpiresAt: time.Now().Add(RefreshTokenTTL),
+	}
+	err := sessionStore.CreateSession(session)
+	if err != nil {
+		return nil, err
+	}
+
+	return issueTokens(session)
+}
+
+// RefreshSession rotates a refresh token. Presenting a token that was
+// already rotated means it leaked, so the whole session is revoked.
+func RefreshSession(refreshToken string) (*types.AuthTokens, error) {
+	if sessionStore == nil {
+		return nil, errors.New("session store not configured")
+	}
+	if refreshToken == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	token, err := sessionStore.UseRefreshToken(HashToken(refreshToken))
+	if errors.Is(err, types.ErrRefreshTokenReused) {
+		log.Println("Refresh token reuse detected, revoking session", token.SessionID)
+		if err := sessionStore.RevokeSession(token.SessionID); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+	if errors.Is(err, types.ErrRefreshTokenNotFound) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(token.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	session, err := sessionStore.GetSession(token.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !session.IsActive() {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	session.ExpiresAt = time.Now().Add(RefreshTokenTTL)
+	err = sessionStore.ExtendSession(session.ID, session.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return issueTokens(session)
+}
+
+func issueTokens(session *types.Session) (*types.AuthTokens, error) {
+	refreshToken, err := generateRandomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := &types.AuthTokens{
+		AccessExpiresAt:  time.Now().Add(AccessTokenTTL),
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: session.ExpiresAt,
+		SessionID:        session.ID,
+	}
+
+	err = sessionStore.CreateRefreshToken(&types.RefreshToken{
+		TokenHash: HashToken(refreshToken),
+		SessionID: session.ID,
+		ExpiresAt: tokens.RefreshExpiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tokens.AccessToken, err = GenerateJWTToken(strconv.Itoa(session.UserID), session.ID, tokens.AccessExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateRandomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func SetAuthCookies(w http.ResponseWriter, origin string, tokens *types.AuthTokens) {
+	domain := cookieDomain(origin)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:    AccessTokenCookie,
+		Value:   tokens.AccessToken,
+		Expires: tokens.AccessExpiresAt,
+		Domain:  domain,
+		Path:    "/",
+	})
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     RefreshTokenCookie,
+		Value:    tokens.RefreshToken,
+		Expires:  tokens.RefreshExpiresAt,
+		Domain:   domain,
+		Path:     "/api/v1/auth",
+		HttpOnly: true,
+	})
+}
+
+func ClearAuthCookies(w http.ResponseWriter, origin string) {
+	domain := cookieDomain(origin)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:   AccessTokenCookie,
+		Value:  "",
+		MaxAge: -1,
+		Domain: domain,
+		Path:   "/",
+	})
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     RefreshTokenCookie,
+		Value:    "",
+		MaxAge:   -1,
+		Domain:   domain,
+		Path:     "/api/v1/auth",
+		HttpOnly: true,
+	})
+}
+
+// describeDevice turns a User-Agent into something readable like
+// "Firefox on Linux" for the session list.
+func describeDevice(userAgent string) string {
+	browser := "Unknown browser"
+	switch {
+	case strings.Contains(userAgent, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(userAgent, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(userAgent, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(userAgent, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		browser = "Safari"
+	case userAgent != "":
+		browser = strings.SplitN(userAgent, " ", 2)[0]
+	}
+
+	os := "unknown OS"
+	switch {
+	case strings.Contains(userAgent, "Android"):
+		os = "Android"
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"):
+		os = "iOS"
+	case strings.Contains(userAgent, "Windows"):
+		os = "Windows"
+	case strings.Contains(userAgent, "Mac OS X"):
+		os = "macOS"
+	case strings.Contains(userAgent, "Linux"):
+		os = "Linux"
+	}
+
+	return browser + " on " + os
+}
+
+func cookieDomain(origin string) string {
+	parts := strings.Split(origin, "://")
+	domain := "localhost"
+	if len(parts) > 1 {
+		domainParts := strings.Split(parts[1], ":")
+		domain = domainParts[0]
+	}
+	return domain
+}
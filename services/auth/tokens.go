@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Purpose tokens are short-lived signed tokens for flows other than API
+// access (login state, MFA challenges...). They carry a "purpose" claim so
+// they can never be mistaken for an access token or for each other.
+const purposeClaim = "purpose"
+
+var ErrInvalidToken = errors.New("invalid token")
+
+func SignToken(purpose string, ttl time.Duration, claims jwt.MapClaims) (string, error) {
+	signed := jwt.MapClaims{}
+	for key, value := range claims {
+		signed[key] = value
+	}
+	signed[purposeClaim] = purpose
+	signed["iat"] = time.Now().Unix()
+	signed["exp"] = time.Now().Add(ttl).Unix()
+	return signClaims(signed)
+}
+
+func ParseToken(purpose, tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, verificationKey)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims[purposeClaim] != purpose {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
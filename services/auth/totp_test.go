@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Secret and expected values from RFC 6238 appendix B, truncated to 6 digits.
+var rfcSecret = base32NoPadding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCode(t *testing.T) {
+	tests := []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		code, err := TOTPCode(rfcSecret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.expected {
+			t.Errorf("at %d expected %s, got %s", tt.unix, tt.expected, code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	step, ok := ValidateTOTP(rfcSecret, "081 804", now)
+	if !ok || step != TOTPStep(now) {
+		t.Fatalf("expected current code to validate, got step %d ok %v", step, ok)
+	}
+
+	previous, _ := TOTPCode(rfcSecret, TOTPStep(now)-1)
+	if _, ok := ValidateTOTP(rfcSecret, previous, now); !ok {
+		t.Error("code from the previous step should be accepted")
+	}
+
+	stale, _ := TOTPCode(rfcSecret, TOTPStep(now)-3)
+	if _, ok := ValidateTOTP(rfcSecret, stale, now); ok {
+		t.Error("code from three steps ago should be rejected")
+	}
+
+	if _, ok := ValidateTOTP(rfcSecret, "12345", now); ok {
+		t.Error("short code should be rejected")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("expected %d codes, got %d", recoveryCodeCount, len(codes))
+	}
+
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("unexpected code format %q", code)
+		}
+		if seen[code] {
+			t.Errorf("duplicate code %q", code)
+		}
+		seen[code] = true
+	}
+
+	if HashRecoveryCode(codes[0]) != HashRecoveryCode(" "+strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))) {
+		t.Error("recovery code hash should ignore case, dashes and whitespace")
+	}
+}
@@ -1,6 +1,7 @@
 package channel
 
 import (
+	"errors"
 	"io"
 	"log"
 	"net/http"
@@ -9,19 +10,28 @@ import (
 	"user/server/services/auth"
 	"user/server/services/hub"
 	"user/server/services/image"
+	"user/server/services/permissions"
 	"user/server/services/utils"
 	"user/server/types"
 
 	"github.com/gorilla/mux"
 )
 
+const (
+	maxNameLength        = 255
+	maxDescriptionLength = 1024
+)
+
 type Handler struct {
-	store     types.ChannelStore
-	userStore types.UserStore
+	store           types.ChannelStore
+	userStore       types.UserStore
+	permissionStore types.PermissionsStore
+	readStore       types.ReadStore
 }
 
-func NewHandler(store types.ChannelStore, userStore types.UserStore) *Handler {
-	return &Handler{store: store, userStore: userStore}
+func NewHandler(store types.ChannelStore, userStore types.UserStore, permissionStore types.PermissionsStore,
+	readStore types.ReadStore) *Handler {
+	return &Handler{store: store, userStore: userStore, permissionStore: permissionStore, readStore: readStore}
 }
 
 func (h *Handler) RegisterRoutes(r *mux.Router) {
@@ -39,6 +49,11 @@ func (h *Handler) RegisterRoutes(r *mux.Router) {
 		auth.WithJWTAuth(h.DeleteChannel,
 			h.userStore),
 	)).Methods("DELETE", "OPTIONS")
+
+	r.HandleFunc("/channels/{channelID}", utils.CorsHandler(
+		auth.WithJWTAuth(h.UpdateChannel,
+			h.userStore),
+	)).Methods("PATCH", "OPTIONS")
 }
 
 func (h *Handler) GetChannelsForUser(w http.ResponseWriter, r *http.Request) {
@@ -49,6 +64,23 @@ func (h *Handler) GetChannelsForUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Error getting channels", http.StatusInternalServerError)
 		return
 	}
+	counts, err := h.readStore.GetUnreadCounts(user.ID)
+	if err != nil {
+		log.Println("Error getting unread counts")
+		http.Error(w, "Error getting channels", http.StatusInternalServerError)
+		return
+	}
+
+	byID := make(map[int]*types.Channel, len(channels))
+	for _, channel := range channels {
+		byID[channel.ID] = channel
+	}
+	for _, count := range counts {
+		if channel := byID[count.ChannelID]; channel != nil {
+			channel.UnreadCount += count.UnreadCount
+			channel.MentionCount += count.MentionCount
+		}
+	}
 
 	response := types.ChannelResponse{Channels: channels}
 	utils.SendJSONResponse(w, http.StatusOK, response)
@@ -94,9 +126,10 @@ func (h *Handler) CreateChannel(w http.ResponseWriter, r *http.Request) {
 	channelName := r.FormValue("name")
 
 	channel := &types.Channel{
-		Name:  channelName,
-		Rooms: make(map[int]*types.Room, 0),
-		Avatar: data,
+		Name:        channelName,
+		Description: r.FormValue("description"),
+		Rooms:       make(map[int]*types.Room, 0),
+		Avatar:      data,
 	}
 
 	err = h.store.CreateChannel(channel, user)
@@ -119,6 +152,17 @@ func (h *Handler) DeleteChannel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user := auth.GetUserFromContext(r.Context())
+	member, ok := permissions.Authorize(w, h.permissionStore, user.ID, channelID, types.PermManageChannel)
+	if !ok {
+		return
+	}
+	if member.Role != types.RoleOwner {
+		log.Println("Only the owner can delete channel", channelID)
+		http.Error(w, "Only the channel owner can delete it", http.StatusForbidden)
+		return
+	}
+
 	err = h.store.DeleteChannel(channelID)
 	if err != nil {
 		log.Println("Error: ", err)
@@ -129,3 +173,121 @@ func (h *Handler) DeleteChannel(w http.ResponseWriter, r *http.Request) {
 	hub.HubInstance.RemoveChannel(channelID)
 	w.WriteHeader(http.StatusOK)
 }
+
+// UpdateChannel changes a channel's name, description or avatar. It takes
+// a multipart form like CreateChannel, or JSON when the avatar stays the
+// same. Clients connected to the channel get a channel-updated event.
+func (h *Handler) UpdateChannel(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	channelID, err := strconv.Atoi(vars["channelID"])
+	if err != nil {
+		log.Println("Invalid channel ID")
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	user := auth.GetUserFromContext(r.Context())
+	if _, ok := permissions.Authorize(w, h.permissionStore, user.ID, channelID, types.PermManageChannel); !ok {
+		return
+	}
+
+	channel, err := h.store.GetChannel(channelID)
+	if errors.Is(err, types.ErrChannelNotFound) {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Error: ", err)
+		http.Error(w, "Error updating channel", http.StatusInternalServerError)
+		return
+	}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		err = applyChannelForm(r, channel)
+	} else {
+		err = applyChannelJSON(r, channel)
+	}
+	if err != nil {
+		log.Println("Invalid channel update: ", err)
+		http.Error(w, "Invalid channel update: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	channel.Name = strings.TrimSpace(channel.Name)
+	if channel.Name == "" || len(channel.Name) > maxNameLength {
+		http.Error(w, "Invalid channel name", http.StatusBadRequest)
+		return
+	}
+	if len(channel.Description) > maxDescriptionLength {
+		http.Error(w, "Description is too long", http.StatusBadRequest)
+		return
+	}
+
+	err = h.store.UpdateChannel(channel)
+	if err != nil {
+		log.Println("Error: ", err)
+		http.Error(w, "Error updating channel", http.StatusInternalServerError)
+		return
+	}
+
+	if live := hub.HubInstance.GetChannel(channelID); live != nil {
+		live.Update(channel)
+	}
+	hub.HubInstance.BroadcastToChannel(channelID, 0, utils.Marshal(types.ChannelUpdatedMessage{
+		Type:    "channel-updated",
+		Payload: channel,
+	}))
+
+	utils.SendJSONResponse(w, http.StatusOK, channel)
+}
+
+func applyChannelJSON(r *http.Request, channel *types.Channel) error {
+	payload := &types.UpdateChannelPayload{}
+	if err := utils.ParseJSON(r, payload); err != nil {
+		return errors.New("invalid JSON")
+	}
+	if payload.Name != nil {
+		channel.Name = *payload.Name
+	}
+	if payload.Description != nil {
+		channel.Description = *payload.Description
+	}
+	return nil
+}
+
+func applyChannelForm(r *http.Request, channel *types.Channel) error {
+	if err := r.ParseMultipartForm(image.MAX_UPLOAD_SIZE); err != nil {
+		return errors.New("invalid form data")
+	}
+	if names, ok := r.MultipartForm.Value["name"]; ok && len(names) > 0 {
+		channel.Name = names[0]
+	}
+	if descriptions, ok := r.MultipartForm.Value["description"]; ok && len(descriptions) > 0 {
+		channel.Description = descriptions[0]
+	}
+
+	file, handler, err := r.FormFile("file")
+	if errors.Is(err, http.ErrMissingFile) {
+		return nil
+	}
+	if err != nil {
+		return errors.New("unable to retrieve file from form")
+	}
+	defer file.Close()
+
+	if !strings.HasPrefix(handler.Header.Get("Content-Type"), "image/") {
+		return errors.New("the uploaded file is not an image")
+	}
+
+	fileData, err := io.ReadAll(file)
+	if err != nil {
+		return errors.New("error reading file data")
+	}
+
+	data, err := image.ResizeImage(fileData, image.MAX_WIDTH, image.MAX_HEIGHT)
+	if err != nil {
+		return errors.New("error getting file data")
+	}
+	channel.Avatar = data
+	return nil
+}
@@ -2,6 +2,7 @@ package channel
 
 import (
 	"database/sql"
+	"errors"
 	"log"
 	"user/server/types"
 )
@@ -38,7 +39,7 @@ func (s *Store) GetAllChannels() ([]*types.Channel, error) {
 }
 
 func (s *Store) GetChannelsForUser(userID int) ([]*types.Channel, error) {
-	rows, err := s.db.Query(`SELECT Channels.* 
+	rows, err := s.db.Query(`SELECT Channels.ID, Channels.Name, Channels.Avatar, Channels.Description
                             FROM Channels
                             JOIN ChannelsToUsers 
                             ON Channels.ID = ChannelsToUsers.channel_id
@@ -52,7 +53,7 @@ func (s *Store) GetChannelsForUser(userID int) ([]*types.Channel, error) {
 	channels := make([]*types.Channel, 0)
 	for rows.Next() {
 		channel := &types.Channel{}
-		err := rows.Scan(&channel.ID, &channel.Name, &channel.Avatar)
+		err := rows.Scan(&channel.ID, &channel.Name, &channel.Avatar, &channel.Description)
 		if err != nil {
 			log.Println("Error scanning channel")
 			return nil, err
@@ -63,17 +64,32 @@ func (s *Store) GetChannelsForUser(userID int) ([]*types.Channel, error) {
 	return channels, nil
 }
 
+func (s *Store) GetChannel(channelID int) (*types.Channel, error) {
+	channel := &types.Channel{}
+	err := s.db.QueryRow(`SELECT ID, Name, Avatar, Description FROM Channels WHERE ID = $1`, channelID).
+		Scan(&channel.ID, &channel.Name, &channel.Avatar, &channel.Description)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrChannelNotFound
+	}
+	if err != nil {
+		log.Println("Error getting channel")
+		return nil, err
+	}
+
+	return channel, nil
+}
+
 func (s *Store) CreateChannel(channel *types.Channel, user *types.User) error {
-	err := s.db.QueryRow(`INSERT INTO Channels (Name, Avatar) VALUES ($1, $2)
+	err := s.db.QueryRow(`INSERT INTO Channels (Name, Avatar, Description) VALUES ($1, $2, $3)
 			      RETURNING ID`,
-		channel.Name, channel.Avatar).Scan(&channel.ID)
+		channel.Name, channel.Avatar, channel.Description).Scan(&channel.ID)
 	if err != nil {
 		log.Println("Error creating channel")
 		return err
 	}
 
-	_, err = s.db.Exec(`INSERT INTO ChannelsToUsers (channel_id, user_id) VALUES ($1, $2)`,
-		channel.ID, user.ID)
+	_, err = s.db.Exec(`INSERT INTO ChannelsToUsers (channel_id, user_id, role) VALUES ($1, $2, $3)`,
+		channel.ID, user.ID, types.RoleOwner)
 	if err != nil {
 		log.Println("Error linking channel to user")
 		return err
@@ -82,6 +98,17 @@ func (s *Store) CreateChannel(channel *types.Channel, user *types.User) error {
 	return nil
 }
 
+func (s *Store) UpdateChannel(channel *types.Channel) error {
+	_, err := s.db.Exec(`UPDATE Channels SET Name = $1, Avatar = $2, Description = $3 WHERE ID = $4`,
+		channel.Name, channel.Avatar, channel.Description, channel.ID)
+	if err != nil {
+		log.Println("Error updating channel")
+		return err
+	}
+
+	return nil
+}
+
 func (s *Store) DeleteChannel(channelID int) error {
 	_, err := s.db.Exec(`DELETE FROM Channels WHERE ID = $1`, channelID)
 	if err != nil {
@@ -91,3 +118,14 @@ func (s *Store) DeleteChannel(channelID int) error {
 
 	return nil
 }
+
+func (s *Store) AddUserToChannel(channelID, userID int) error {
+	_, err := s.db.Exec(`INSERT INTO ChannelsToUsers (channel_id, user_id) VALUES ($1, $2)`,
+		channelID, userID)
+	if err != nil {
+		log.Println("Error linking channel to user")
+		return err
+	}
+
+	return nil
+}
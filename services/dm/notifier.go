@@ -0,0 +1,36 @@
+package dm
+
+import (
+	"strconv"
+	"user/server/services/hub"
+	"user/server/services/utils"
+	"user/server/types"
+)
+
+// Notifier takes the place of mention notifications in direct
+// conversations, where everyone is a member anyway. It pushes dm-activity
+// to the other members so their conversation lists stay current even
+// when they aren't connected to the conversation.
+type Notifier struct {
+	store types.ConversationStore
+}
+
+func NewNotifier(store types.ConversationStore) *Notifier {
+	return &Notifier{store: store}
+}
+
+func (n *Notifier) NotifyMentions(msg *types.Message, channelID int) {
+	memberIDs, err := n.store.GetMemberIDs(msg.RoomID)
+	if err != nil || hub.HubInstance == nil {
+		return
+	}
+	event := utils.Marshal(types.ConversationActivityMessage{
+		Type:    types.ConversationActivity,
+		Payload: msg,
+	})
+	for _, memberID := range memberIDs {
+		if memberID != msg.SenderID {
+			hub.HubInstance.SendToUser(strconv.Itoa(memberID), event)
+		}
+	}
+}
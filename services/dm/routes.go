@@ -0,0 +1,345 @@
+package dm
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"user/server/services/auth"
+	"user/server/services/hub"
+	"user/server/services/utils"
+	"user/server/types"
+
+	"github.com/gorilla/mux"
+)
+
+const maxNameLength = 100
+
+type Handler struct {
+	store           types.ConversationStore
+	userStore       types.UserStore
+	messageStore    types.MessageStore
+	permissionStore types.PermissionsStore
+}
+
+func NewHandler(store types.ConversationStore, userStore types.UserStore, messageStore types.MessageStore,
+	permissionStore types.PermissionsStore) *Handler {
+	return &Handler{
+		store:           store,
+		userStore:       userStore,
+		messageStore:    messageStore,
+		permissionStore: permissionStore,
+	}
+}
+
+func (h *Handler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/dms", utils.CorsHandler(
+		auth.WithJWTAuth(h.GetConversationsHandler, h.userStore),
+	)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/dms", utils.CorsHandler(
+		auth.WithJWTAuth(h.CreateConversationHandler, h.userStore),
+	)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/dms/{roomID}", utils.CorsHandler(
+		auth.WithJWTAuth(h.GetConversationHandler, h.userStore),
+	)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/dms/{roomID}/members", utils.CorsHandler(
+		auth.WithJWTAuth(h.AddMemberHandler, h.userStore),
+	)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/dms/{roomID}/members/me", utils.CorsHandler(
+		auth.WithJWTAuth(h.LeaveHandler, h.userStore),
+	)).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/dms/{roomID}/read", utils.CorsHandler(
+		auth.WithJWTAuth(h.MarkReadHandler, h.userStore),
+	)).Methods("PUT", "OPTIONS")
+	r.HandleFunc("/dms/{roomID}/ws", auth.WithJWTAuth(h.ConnectHandler, h.userStore))
+}
+
+// GetConversationsHandler lists the user's conversations, most recently
+// active first.
+func (h *Handler) GetConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	conversations, err := h.store.GetConversations(user.ID)
+	if err != nil {
+		handleError(w, "Error getting conversations", http.StatusInternalServerError, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, types.ConversationsResponse{Conversations: conversations})
+}
+
+// CreateConversationHandler starts a conversation with the listed users,
+// who each have to share a channel with the caller. With one other user
+// the existing conversation is returned if there is one.
+func (h *Handler) CreateConversationHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	payload := &types.CreateConversationPayload{}
+	if err := utils.ParseJSON(r, payload); err != nil {
+		handleError(w, "Invalid JSON", http.StatusBadRequest, err)
+		return
+	}
+
+	memberIDs := otherMembers(payload.UserIDs, user.ID)
+	switch {
+	case len(memberIDs) == 0:
+		handleError(w, "user_ids must name someone else", http.StatusBadRequest, nil)
+		return
+	case len(memberIDs)+1 > types.MaxConversationMembers:
+		handleError(w, "Conversations are limited to "+strconv.Itoa(types.MaxConversationMembers)+" members",
+			http.StatusBadRequest, nil)
+		return
+	}
+	name := strings.TrimSpace(payload.Name)
+	if len(name) > maxNameLength {
+		handleError(w, "name is too long", http.StatusBadRequest, nil)
+		return
+	}
+	if !h.checkShared(w, user.ID, memberIDs) {
+		return
+	}
+
+	conversation := &types.Conversation{
+		Name:      name,
+		IsGroup:   len(memberIDs) > 1,
+		CreatedBy: user.ID,
+	}
+	if !conversation.IsGroup {
+		conversation.Name = ""
+	}
+	roomID, created, err := h.store.CreateConversation(conversation, memberIDs)
+	if err != nil {
+		handleError(w, "Error creating conversation", http.StatusInternalServerError, err)
+		return
+	}
+
+	conversation, err = h.store.GetConversation(roomID, user.ID)
+	if err != nil {
+		handleError(w, "Error getting conversation", http.StatusInternalServerError, err)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+		h.publishConversation(roomID)
+	}
+	utils.SendJSONResponse(w, status, conversation)
+}
+
+func (h *Handler) GetConversationHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	conversation, ok := h.authorize(w, r, user.ID)
+	if !ok {
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, conversation)
+}
+
+// AddMemberHandler adds someone to a group conversation. Conversations
+// between two users stay that way; start a group instead.
+func (h *Handler) AddMemberHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	conversation, ok := h.authorize(w, r, user.ID)
+	if !ok {
+		return
+	}
+	if !conversation.IsGroup {
+		handleError(w, "Members can only be added to group conversations", http.StatusBadRequest, nil)
+		return
+	}
+
+	payload := &types.AddConversationMemberPayload{}
+	if err := utils.ParseJSON(r, payload); err != nil {
+		handleError(w, "Invalid JSON", http.StatusBadRequest, err)
+		return
+	}
+	for _, member := range conversation.Members {
+		if member.UserID == payload.UserID {
+			handleError(w, "User is already a member", http.StatusConflict, nil)
+			return
+		}
+	}
+	if len(conversation.Members) >= types.MaxConversationMembers {
+		handleError(w, "Conversations are limited to "+strconv.Itoa(types.MaxConversationMembers)+" members",
+			http.StatusBadRequest, nil)
+		return
+	}
+	if !h.checkShared(w, user.ID, []int{payload.UserID}) {
+		return
+	}
+
+	if err := h.store.AddMember(conversation.RoomID, payload.UserID); err != nil {
+		handleError(w, "Error adding member", http.StatusInternalServerError, err)
+		return
+	}
+	h.publishConversation(conversation.RoomID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LeaveHandler takes the caller out of a group conversation and closes
+// their connections to it.
+func (h *Handler) LeaveHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	conversation, ok := h.authorize(w, r, user.ID)
+	if !ok {
+		return
+	}
+	if !conversation.IsGroup {
+		handleError(w, "Only group conversations can be left", http.StatusBadRequest, nil)
+		return
+	}
+
+	err := h.store.RemoveMember(conversation.RoomID, user.ID)
+	if err != nil && !errors.Is(err, types.ErrConversationNotFound) {
+		handleError(w, "Error leaving conversation", http.StatusInternalServerError, err)
+		return
+	}
+
+	userID := strconv.Itoa(user.ID)
+	if room := hub.HubInstance.DirectRoom(conversation.RoomID, nil); room != nil {
+		defer hub.HubInstance.ReleaseDirectRoom(room)
+		room.DisconnectClients(func(c *types.Client) bool {
+			return c.ID == userID
+		}, types.CloseLeftConversation, "left conversation")
+		room.UnfollowThreads(userID)
+	}
+	h.publishConversation(conversation.RoomID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MarkReadHandler moves the caller's read pointer up to a message, which
+// resets the unread count of everything before it. Connected members get
+// a read-receipt event when it moves.
+func (h *Handler) MarkReadHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	conversation, ok := h.authorize(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	payload := &types.MarkReadPayload{}
+	if err := utils.ParseJSON(r, payload); err != nil {
+		handleError(w, "Invalid JSON", http.StatusBadRequest, err)
+		return
+	}
+	message, err := h.messageStore.GetMessage(payload.MessageID)
+	if errors.Is(err, types.ErrMessageNotFound) || (err == nil && message.RoomID != conversation.RoomID) {
+		handleError(w, "Message not found", http.StatusNotFound, nil)
+		return
+	}
+	if err != nil {
+		handleError(w, "Error getting message", http.StatusInternalServerError, err)
+		return
+	}
+
+	moved, err := h.messageStore.MarkRead(user.ID, conversation.RoomID, message.ID)
+	if err != nil {
+		handleError(w, "Error marking conversation read", http.StatusInternalServerError, err)
+		return
+	}
+	if room := hub.HubInstance.GetRoom(0, conversation.RoomID); moved && room != nil {
+		room.PublishReadReceipt(user.ID, user.Username, message.ID)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ConnectHandler opens the conversation's WebSocket, which speaks the same
+// protocol as a channel room's text chat.
+func (h *Handler) ConnectHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	conversation, ok := h.authorize(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	ws, err := utils.UpgradeToWebSocket(w, r)
+	if err != nil {
+		log.Println("Could not open WebSocket connection: ", err)
+		return
+	}
+
+	room := hub.DirectRoom(conversation.RoomID)
+	client := hub.NewClient(ws, user, auth.ConnectionID(r.Context()))
+	client.Resume = hub.ParseResumePoint(r)
+	room.Bus.Publish(types.Event{
+		Type:    types.EventRegister,
+		Payload: client,
+	})
+
+	go client.ReadMessages(room, h.messageStore, h.permissionStore, NewNotifier(h.store))
+	go client.WriteMessages()
+}
+
+// authorize loads the conversation named in the URL, answering 404 to
+// anyone who isn't a member.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, userID int) (*types.Conversation, bool) {
+	roomID, err := strconv.Atoi(mux.Vars(r)["roomID"])
+	if err != nil {
+		handleError(w, "Invalid conversation ID", http.StatusBadRequest, err)
+		return nil, false
+	}
+	conversation, err := h.store.GetConversation(roomID, userID)
+	if errors.Is(err, types.ErrConversationNotFound) {
+		handleError(w, "Conversation not found", http.StatusNotFound, nil)
+		return nil, false
+	}
+	if err != nil {
+		handleError(w, "Error getting conversation", http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return conversation, true
+}
+
+func (h *Handler) checkShared(w http.ResponseWriter, userID int, others []int) bool {
+	shared, err := h.store.SharesChannel(userID, others)
+	if err != nil {
+		handleError(w, "Error checking users", http.StatusInternalServerError, err)
+		return false
+	}
+	if !shared {
+		handleError(w, "You can only message people you share a channel with", http.StatusForbidden, nil)
+		return false
+	}
+	return true
+}
+
+// publishConversation pushes dm-updated to the conversation's members,
+// each with their own unread count.
+func (h *Handler) publishConversation(roomID int) {
+	memberIDs, err := h.store.GetMemberIDs(roomID)
+	if err != nil {
+		return
+	}
+	for _, memberID := range memberIDs {
+		conversation, err := h.store.GetConversation(roomID, memberID)
+		if err != nil {
+			continue
+		}
+		hub.HubInstance.SendToUser(strconv.Itoa(memberID), utils.Marshal(types.ConversationMessage{
+			Type:    types.ConversationUpdated,
+			Payload: conversation,
+		}))
+	}
+}
+
+// otherMembers drops duplicates and the caller from ids, sorted.
+func otherMembers(ids []int, userID int) []int {
+	seen := map[int]bool{userID: true}
+	others := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			others = append(others, id)
+		}
+	}
+	sort.Ints(others)
+	return others
+}
+
+func handleError(w http.ResponseWriter, message string, statusCode int, err error) {
+	if err != nil {
+		log.Println(message, err)
+	} else {
+		log.Println(message)
+	}
+	http.Error(w, message, statusCode)
+}
@@ -0,0 +1,85 @@
+package dm
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"user/server/services/auth"
+	"user/server/services/hub"
+	"user/server/types"
+)
+
+type fakeConversationStore struct {
+	types.ConversationStore
+	// channels maps users to the one channel they are in, if any.
+	channels map[int]int
+	created  [][]int
+}
+
+func (s *fakeConversationStore) SharesChannel(userID int, others []int) (bool, error) {
+	for _, other := range others {
+		if s.channels[other] == 0 || s.channels[other] != s.channels[userID] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (s *fakeConversationStore) CreateConversation(c *types.Conversation, memberIDs []int) (int, bool, error) {
+	s.created = append(s.created, memberIDs)
+	return len(s.created), true, nil
+}
+
+func (s *fakeConversationStore) GetConversation(roomID, userID int) (*types.Conversation, error) {
+	return &types.Conversation{RoomID: roomID}, nil
+}
+
+func (s *fakeConversationStore) GetMemberIDs(roomID int) ([]int, error) {
+	return nil, nil
+}
+
+func TestCreateConversation(t *testing.T) {
+	hub.HubInstance = &types.Hub{Channels: make(map[int]*types.Channel)}
+
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+		members  []int
+	}{
+		{name: "Direct", body: `{"user_ids":[2]}`, expected: http.StatusCreated, members: []int{2}},
+		{name: "Group without duplicates", body: `{"user_ids":[3,2,3,1]}`, expected: http.StatusCreated,
+			members: []int{2, 3}},
+		{name: "Only yourself", body: `{"user_ids":[1]}`, expected: http.StatusBadRequest},
+		{name: "No shared channel", body: `{"user_ids":[2,4]}`, expected: http.StatusForbidden},
+		{name: "Too many members", body: `{"user_ids":[2,3,4,5,6,7,8,9,10,11]}`, expected: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeConversationStore{channels: map[int]int{1: 7, 2: 7, 3: 7, 4: 8}}
+			h := NewHandler(store, nil, nil, nil)
+
+			r := httptest.NewRequest("POST", "/dms", bytes.NewBufferString(tt.body))
+			r = r.WithContext(context.WithValue(r.Context(), auth.UserKey, &types.User{ID: 1}))
+			res := httptest.NewRecorder()
+			h.CreateConversationHandler(res, r)
+
+			if res.Code != tt.expected {
+				t.Fatalf("expected %d, got %d", tt.expected, res.Code)
+			}
+			if tt.members != nil && (len(store.created) != 1 || !reflect.DeepEqual(store.created[0], tt.members)) {
+				t.Errorf("created %v, want %v", store.created, tt.members)
+			}
+		})
+	}
+}
+
+func TestPairKey(t *testing.T) {
+	if pairKey(9, 4) != "4:9" || pairKey(4, 9) != "4:9" {
+		t.Errorf("pairKey should not depend on the order of the users")
+	}
+}
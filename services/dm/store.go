@@ -0,0 +1,253 @@
+package dm
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"user/server/services/image"
+	"user/server/services/utils"
+	"user/server/types"
+
+	"github.com/lib/pq"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// conversationQuery selects the conversations $1 is a member of, with
+// their latest top level message, member list and the member's unread
+// count. Callers add the remaining filters and ordering.
+const conversationQuery = `
+	SELECT r.ID, r.Name, d.IsGroup, d.CreatedBy, d.CreatedAt, COALESCE(rp.LastReadMessageID, 0),
+		last.ID, last.SenderID, last.Content, last.Timestamp, last.DeletedAt,
+		COALESCE(last.Timestamp, d.CreatedAt) AS LastActivityAt,
+		(SELECT COUNT(*) FROM Messages u
+		 WHERE u.RoomID = r.ID AND u.ParentID IS NULL AND u.DeletedAt IS NULL
+		 AND u.SenderID <> $1 AND u.ID > COALESCE(rp.LastReadMessageID, 0)) AS UnreadCount,
+		members.Members
+	FROM DirectMembers me
+	JOIN DirectRooms d ON d.RoomID = me.RoomID
+	JOIN Rooms r ON r.ID = d.RoomID
+	LEFT JOIN ReadPointers rp ON rp.RoomID = r.ID AND rp.UserID = me.UserID
+	LEFT JOIN LATERAL (
+		SELECT ID, SenderID, Content, Timestamp, DeletedAt FROM Messages
+		WHERE RoomID = r.ID AND ParentID IS NULL
+		ORDER BY Timestamp DESC, ID DESC LIMIT 1
+	) last ON true
+	LEFT JOIN LATERAL (
+		SELECT COALESCE(json_agg(json_build_object(
+		'user_id', u.ID, 'username', u.Username, 'avatar', encode(u.Avatar, 'base64')) ORDER BY u.ID), '[]') AS Members
+		FROM DirectMembers dm
+		JOIN Users u ON u.ID = dm.UserID
+		WHERE dm.RoomID = r.ID
+	) members ON true
+	WHERE me.UserID = $1`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanConversation(row scanner) (*types.Conversation, error) {
+	c := &types.Conversation{}
+	var lastID, lastSenderID sql.NullInt64
+	var lastContent sql.NullString
+	var lastTimestamp, lastDeletedAt sql.NullTime
+	var membersJson string
+	err := row.Scan(&c.RoomID, &c.Name, &c.IsGroup, &c.CreatedBy, &c.CreatedAt, &c.LastReadMessageID,
+		&lastID, &lastSenderID, &lastContent, &lastTimestamp, &lastDeletedAt,
+		&c.LastActivityAt, &c.UnreadCount, &membersJson)
+	if err != nil {
+		return nil, err
+	}
+
+	if lastID.Valid {
+		c.LastMessage = &types.Message{
+			ID:        int(lastID.Int64),
+			Type:      "chat-message",
+			RoomID:    c.RoomID,
+			SenderID:  int(lastSenderID.Int64),
+			Content:   lastContent.String,
+			Timestamp: lastTimestamp.Time,
+		}
+		if lastDeletedAt.Valid {
+			c.LastMessage.DeletedAt = &lastDeletedAt.Time
+		}
+	}
+
+	var members []struct {
+		UserID   int    `json:"user_id"`
+		Username string `json:"username"`
+		Avatar   []byte `json:"avatar"`
+	}
+	if err := utils.Unmarshal([]byte(membersJson), &members); err != nil {
+		log.Println("Error unmarshalling conversation members: ", err)
+		return nil, err
+	}
+	c.Members = make([]*types.ConversationMember, len(members))
+	for i, m := range members {
+		c.Members[i] = &types.ConversationMember{
+			UserID:   m.UserID,
+			Username: m.Username,
+			Avatar:   image.EncodeB64Image(m.Avatar),
+		}
+	}
+	return c, nil
+}
+
+func (s *Store) GetConversations(userID int) ([]*types.Conversation, error) {
+	rows, err := s.db.Query(conversationQuery+` ORDER BY LastActivityAt DESC, r.ID DESC`, userID)
+	if err != nil {
+		log.Println("Error getting conversations: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	conversations := make([]*types.Conversation, 0)
+	for rows.Next() {
+		c, err := scanConversation(rows)
+		if err != nil {
+			log.Println("Error scanning conversation: ", err)
+			return nil, err
+		}
+		conversations = append(conversations, c)
+	}
+	return conversations, rows.Err()
+}
+
+func (s *Store) GetConversation(roomID, userID int) (*types.Conversation, error) {
+	c, err := scanConversation(s.db.QueryRow(conversationQuery+` AND r.ID = $2`, userID, roomID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrConversationNotFound
+	}
+	if err != nil {
+		log.Println("Error getting conversation: ", err)
+		return nil, err
+	}
+	return c, nil
+}
+
+// pairKey identifies the conversation between two users.
+func pairKey(a, b int) string {
+	if a > b {
+		a, b = b, a
+	}
+	return fmt.Sprintf("%d:%d", a, b)
+}
+
+func (s *Store) CreateConversation(c *types.Conversation, memberIDs []int) (int, bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	var key *string
+	if !c.IsGroup {
+		k := pairKey(c.CreatedBy, memberIDs[0])
+		key = &k
+		var roomID int
+		err := tx.QueryRow(`SELECT RoomID FROM DirectRooms WHERE PairKey = $1`, k).Scan(&roomID)
+		if err == nil {
+			return roomID, false, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Println("Error finding conversation: ", err)
+			return 0, false, err
+		}
+	}
+
+	var roomID int
+	err = tx.QueryRow(`INSERT INTO Rooms (Name, ChannelID, Kind) VALUES ($1, NULL, $2) RETURNING ID`,
+		c.Name, types.RoomKindDirect).Scan(&roomID)
+	if err != nil {
+		log.Println("Error creating conversation room: ", err)
+		return 0, false, err
+	}
+
+	// Two users starting the same conversation at once race on PairKey;
+	// the loser gets the winner's conversation.
+	res, err := tx.Exec(`INSERT INTO DirectRooms (RoomID, IsGroup, PairKey, CreatedBy) VALUES ($1, $2, $3, $4)
+	                     ON CONFLICT (PairKey) DO NOTHING`, roomID, c.IsGroup, key, c.CreatedBy)
+	if err != nil {
+		log.Println("Error creating conversation: ", err)
+		return 0, false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		tx.Rollback()
+		err := s.db.QueryRow(`SELECT RoomID FROM DirectRooms WHERE PairKey = $1`, *key).Scan(&roomID)
+		if err != nil {
+			log.Println("Error finding conversation: ", err)
+			return 0, false, err
+		}
+		return roomID, false, nil
+	}
+
+	_, err = tx.Exec(`INSERT INTO DirectMembers (RoomID, UserID)
+	                  SELECT $1, unnest($2::int[]) ON CONFLICT DO NOTHING`,
+		roomID, pq.Array(append([]int{c.CreatedBy}, memberIDs...)))
+	if err != nil {
+		log.Println("Error adding conversation members: ", err)
+		return 0, false, err
+	}
+	return roomID, true, tx.Commit()
+}
+
+func (s *Store) GetMemberIDs(roomID int) ([]int, error) {
+	rows, err := s.db.Query(`SELECT UserID FROM DirectMembers WHERE RoomID = $1 ORDER BY UserID`, roomID)
+	if err != nil {
+		log.Println("Error getting conversation members: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			log.Println("Error scanning conversation member: ", err)
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *Store) AddMember(roomID, userID int) error {
+	_, err := s.db.Exec(`INSERT INTO DirectMembers (RoomID, UserID) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		roomID, userID)
+	if err != nil {
+		log.Println("Error adding conversation member: ", err)
+	}
+	return err
+}
+
+func (s *Store) RemoveMember(roomID, userID int) error {
+	res, err := s.db.Exec(`DELETE FROM DirectMembers WHERE RoomID = $1 AND UserID = $2`, roomID, userID)
+	if err != nil {
+		log.Println("Error removing conversation member: ", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return types.ErrConversationNotFound
+	}
+	return nil
+}
+
+func (s *Store) SharesChannel(userID int, others []int) (bool, error) {
+	var shared int
+	err := s.db.QueryRow(`
+		SELECT COUNT(DISTINCT theirs.user_id) FROM ChannelsToUsers mine
+		JOIN ChannelsToUsers theirs ON theirs.channel_id = mine.channel_id
+		WHERE mine.user_id = $1 AND theirs.user_id = ANY($2)`, userID, pq.Array(others)).Scan(&shared)
+	if err != nil {
+		log.Println("Error checking shared channels: ", err)
+		return false, err
+	}
+	return shared == len(others), nil
+}
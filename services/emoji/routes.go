@@ -0,0 +1,181 @@
+package emoji
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"user/server/services/auth"
+	"user/server/services/image"
+	"user/server/services/permissions"
+	"user/server/services/utils"
+	"user/server/types"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxEmojiSize  = 64
+	maxUploadSize = 1024 * 1024
+)
+
+type Handler struct {
+	store           types.EmojiStore
+	userStore       types.UserStore
+	permissionStore types.PermissionsStore
+}
+
+func NewHandler(store types.EmojiStore, userStore types.UserStore, permissionStore types.PermissionsStore) *Handler {
+	return &Handler{store: store, userStore: userStore, permissionStore: permissionStore}
+}
+
+func (h *Handler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/channels/{channelID}/emoji", utils.CorsHandler(
+		auth.WithJWTAuth(h.GetEmojiHandler, h.userStore),
+	)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/channels/{channelID}/emoji", utils.CorsHandler(
+		auth.WithJWTAuth(h.CreateEmojiHandler, h.userStore),
+	)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/channels/{channelID}/emoji/{emojiID}", utils.CorsHandler(
+		auth.WithJWTAuth(h.DeleteEmojiHandler, h.userStore),
+	)).Methods("DELETE", "OPTIONS")
+}
+
+func (h *Handler) GetEmojiHandler(w http.ResponseWriter, r *http.Request) {
+	channelID, ok := parseID(w, r, "channelID")
+	if !ok {
+		return
+	}
+	user := auth.GetUserFromContext(r.Context())
+	if _, ok := permissions.Authorize(w, h.permissionStore, user.ID, channelID, 0); !ok {
+		return
+	}
+
+	emoji, err := h.store.GetChannelEmoji(channelID)
+	if err != nil {
+		handleError(w, "Error getting emoji", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, types.ChannelEmojiResponse{Emoji: emoji})
+}
+
+// CreateEmojiHandler takes a multipart form with the emoji's name and an
+// image file, which is shrunk like avatars are.
+func (h *Handler) CreateEmojiHandler(w http.ResponseWriter, r *http.Request) {
+	channelID, ok := parseID(w, r, "channelID")
+	if !ok {
+		return
+	}
+	user := auth.GetUserFromContext(r.Context())
+	if _, ok := permissions.Authorize(w, h.permissionStore, user.ID, channelID, types.PermManageChannel); !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		handleError(w, "Unable to parse form", http.StatusBadRequest, err)
+		return
+	}
+
+	name := r.FormValue("name")
+	if custom, ok := types.CustomEmojiName(name); ok {
+		name = custom
+	}
+	if !types.ValidEmojiName(name) {
+		handleError(w, "Emoji names are 2 to 32 letters, digits or underscores", http.StatusBadRequest, nil)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		handleError(w, "Unable to retrieve file from form", http.StatusBadRequest, err)
+		return
+	}
+	defer file.Close()
+	if !strings.HasPrefix(header.Header.Get("Content-Type"), "image/") {
+		handleError(w, "The uploaded file is not an image", http.StatusBadRequest, nil)
+		return
+	}
+	fileData, err := io.ReadAll(file)
+	if err != nil {
+		handleError(w, "Error reading file data", http.StatusInternalServerError, err)
+		return
+	}
+	data, err := image.ResizeImage(fileData, maxEmojiSize, maxEmojiSize)
+	if err != nil {
+		handleError(w, "Unsupported image", http.StatusBadRequest, err)
+		return
+	}
+
+	_, err = h.store.GetEmojiByName(channelID, name)
+	if err == nil {
+		handleError(w, "An emoji with this name already exists", http.StatusConflict, nil)
+		return
+	}
+	if !errors.Is(err, types.ErrEmojiNotFound) {
+		handleError(w, "Error creating emoji", http.StatusInternalServerError, err)
+		return
+	}
+
+	emoji := &types.ChannelEmoji{
+		ChannelID: channelID,
+		Name:      name,
+		Image:     data,
+		CreatedBy: user.ID,
+	}
+	err = h.store.CreateEmoji(emoji)
+	if err != nil {
+		handleError(w, "Error creating emoji", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusCreated, emoji)
+}
+
+func (h *Handler) DeleteEmojiHandler(w http.ResponseWriter, r *http.Request) {
+	channelID, ok := parseID(w, r, "channelID")
+	if !ok {
+		return
+	}
+	emojiID, ok := parseID(w, r, "emojiID")
+	if !ok {
+		return
+	}
+	user := auth.GetUserFromContext(r.Context())
+	if _, ok := permissions.Authorize(w, h.permissionStore, user.ID, channelID, types.PermManageChannel); !ok {
+		return
+	}
+
+	err := h.store.DeleteEmoji(channelID, emojiID)
+	if errors.Is(err, types.ErrEmojiNotFound) {
+		handleError(w, "Emoji not found", http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		handleError(w, "Error deleting emoji", http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func parseID(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)[name])
+	if err != nil {
+		handleError(w, "Invalid "+name, http.StatusBadRequest, err)
+		return 0, false
+	}
+	return id, true
+}
+
+func handleError(w http.ResponseWriter, message string, statusCode int, err error) {
+	if err != nil {
+		log.Println(message, err)
+	} else {
+		log.Println(message)
+	}
+	http.Error(w, message, statusCode)
+}
@@ -0,0 +1,100 @@
+package emoji
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"user/server/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) GetChannelEmoji(channelID int) ([]*types.ChannelEmoji, error) {
+	rows, err := s.db.Query(`
+		SELECT ID, ChannelID, Name, Image, CreatedBy, CreatedAt
+		FROM ChannelEmoji WHERE ChannelID = $1 ORDER BY Name`, channelID)
+	if err != nil {
+		log.Println("Error getting channel emoji: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	emoji := make([]*types.ChannelEmoji, 0)
+	for rows.Next() {
+		e := &types.ChannelEmoji{}
+		err := rows.Scan(&e.ID, &e.ChannelID, &e.Name, &e.Image, &e.CreatedBy, &e.CreatedAt)
+		if err != nil {
+			log.Println("Error scanning channel emoji: ", err)
+			return nil, err
+		}
+		emoji = append(emoji, e)
+	}
+
+	return emoji, rows.Err()
+}
+
+func (s *Store) GetEmojiByName(channelID int, name string) (*types.ChannelEmoji, error) {
+	e := &types.ChannelEmoji{}
+	err := s.db.QueryRow(`
+		SELECT ID, ChannelID, Name, Image, CreatedBy, CreatedAt
+		FROM ChannelEmoji WHERE ChannelID = $1 AND Name = $2`, channelID, name).
+		Scan(&e.ID, &e.ChannelID, &e.Name, &e.Image, &e.CreatedBy, &e.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrEmojiNotFound
+	}
+	if err != nil {
+		log.Println("Error getting emoji: ", err)
+		return nil, err
+	}
+	return e, nil
+}
+
+func (s *Store) CreateEmoji(e *types.ChannelEmoji) error {
+	err := s.db.QueryRow(`
+		INSERT INTO ChannelEmoji (ChannelID, Name, Image, CreatedBy)
+		VALUES ($1, $2, $3, $4) RETURNING ID, CreatedAt`,
+		e.ChannelID, e.Name, e.Image, e.CreatedBy).Scan(&e.ID, &e.CreatedAt)
+	if err != nil {
+		log.Println("Error creating emoji: ", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteEmoji removes the emoji and every reaction that used it.
+func (s *Store) DeleteEmoji(channelID, emojiID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var name string
+	err = tx.QueryRow(`DELETE FROM ChannelEmoji WHERE ChannelID = $1 AND ID = $2 RETURNING Name`,
+		channelID, emojiID).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return types.ErrEmojiNotFound
+	}
+	if err != nil {
+		log.Println("Error deleting emoji: ", err)
+		return err
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM MessageReactions
+		WHERE Emoji = $1 AND MessageID IN (
+			SELECT m.ID FROM Messages m
+			JOIN Rooms r ON r.ID = m.RoomID
+			WHERE r.ChannelID = $2)`, ":"+name+":", channelID)
+	if err != nil {
+		log.Println("Error deleting emoji reactions: ", err)
+		return err
+	}
+	return tx.Commit()
+}
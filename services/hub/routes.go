@@ -3,6 +3,8 @@ package hub
 import (
 	"fmt"
 	"log"
+	"net/http"
+	"strconv"
 	"sync"
 	"user/server/types"
 
@@ -15,11 +17,12 @@ var (
 )
 
 // TODO: move to a client type definition file
-func NewClient(conn *websocket.Conn, user *types.User) *types.Client {
+func NewClient(conn *websocket.Conn, user *types.User, sessionID string) *types.Client {
 	return &types.Client{
 		WebsocketConnection: conn,
 		Send:                make(chan []byte, 20),
 		ID:                  fmt.Sprint(user.ID),
+		SessionID:           sessionID,
 		Username:            user.Username,
 		Avatar:              user.Avatar,
 		MicEnabled:          false,
@@ -27,6 +30,32 @@ func NewClient(conn *websocket.Conn, user *types.User) *types.Client {
 		ScreenEnabled:       false}
 }
 
+// ParseResumePoint reads the last_seq and epoch query parameters a client
+// reconnects with. Without a valid pair it gets a resync.
+func ParseResumePoint(r *http.Request) *types.ResumePoint {
+	query := r.URL.Query()
+	seq, err := strconv.ParseInt(query.Get("last_seq"), 10, 64)
+	if err != nil || seq < 0 || query.Get("epoch") == "" {
+		return nil
+	}
+	return &types.ResumePoint{Epoch: query.Get("epoch"), Seq: seq}
+}
+
+// DirectRoom returns the live room of a direct conversation, starting it
+// on first use. Callers give it back with HubInstance.ReleaseDirectRoom.
+func DirectRoom(roomID int) *types.Room {
+	return HubInstance.DirectRoom(roomID, func() *types.Room {
+		room := &types.Room{
+			ID:      roomID,
+			Kind:    types.RoomKindDirect,
+			Clients: make(map[*types.Client]*types.ClientInfo),
+			Bus:     types.NewEventBus(),
+			Hub:     HubInstance,
+		}
+		return room
+	})
+}
+
 type Handler struct {
 	store        types.HubStore
 	channelStore types.ChannelStore
@@ -46,7 +75,8 @@ func (h *Handler) HubInitialize() *types.Hub {
 			return
 		}
 		HubInstance = &types.Hub{
-			Channels: make(map[int]*types.Channel),
+			Channels:    make(map[int]*types.Channel),
+			DirectRooms: make(map[int]*types.Room),
 		}
 
 		for _, channel := range channels {
@@ -60,6 +90,7 @@ func (h *Handler) HubInitialize() *types.Hub {
 			for _, room := range rooms {
 				room.Clients = make(map[*types.Client]*types.ClientInfo)
 				room.Bus = types.NewEventBus()
+				room.Hub = HubInstance
 				channel.Rooms[room.ID] = room
 				go room.Run()
 			}
@@ -1,11 +1,13 @@
 package invite
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
 	"time"
 	"user/server/services/auth"
+	"user/server/services/permissions"
 	"user/server/services/utils"
 	"user/server/types"
 
@@ -16,16 +18,26 @@ import (
 var BASE_URL string = "https://backendserver.me/api/v1"
 
 type Handler struct {
-	store           types.InviteStore
-	userStore       types.UserStore
-	permissionStore types.PermissionsStore
+	store                types.InviteStore
+	userStore            types.UserStore
+	permissionStore      types.PermissionsStore
+	memberStore          types.MemberStore
+	requireVerifiedEmail bool
 }
 
 func NewHandler(
 	store types.InviteStore,
 	userStore types.UserStore,
-	permissionStore types.PermissionsStore) *Handler {
-	return &Handler{store: store, userStore: userStore, permissionStore: permissionStore}
+	permissionStore types.PermissionsStore,
+	memberStore types.MemberStore,
+	requireVerifiedEmail bool) *Handler {
+	return &Handler{
+		store:                store,
+		userStore:            userStore,
+		permissionStore:      permissionStore,
+		memberStore:          memberStore,
+		requireVerifiedEmail: requireVerifiedEmail,
+	}
 }
 
 func (h *Handler) RegisterRoutes(r *mux.Router) {
@@ -54,9 +66,7 @@ func (h *Handler) CreateInviteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !h.permissionStore.UserHasPermission(user.ID, channelID) {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		log.Println("Forbidden:", err)
+	if _, ok := permissions.Authorize(w, h.permissionStore, user.ID, channelID, types.PermInvite); !ok {
 		return
 	}
 
@@ -90,6 +100,12 @@ func (h *Handler) AcceptInviteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.requireVerifiedEmail && !user.EmailVerified {
+		http.Error(w, "Verify your email address before joining channels.", http.StatusForbidden)
+		log.Println("Unverified user tried to accept invite:", user.ID)
+		return
+	}
+
 	invite, err := h.store.FindInvite(inviteLink)
 	if err != nil {
 		http.Error(w, "Failed to find Invite.", http.StatusNotFound)
@@ -97,11 +113,29 @@ func (h *Handler) AcceptInviteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if h.permissionStore.UserHasPermission(user.ID, invite.ChanelID) {
+	_, err = h.permissionStore.GetChannelMember(user.ID, invite.ChanelID)
+	if err == nil {
 		http.Error(w, "User already in Channel.", http.StatusConflict)
 		log.Println("User already in Channel.")
 		return
 	}
+	if !errors.Is(err, types.ErrNotChannelMember) {
+		http.Error(w, "Failed to accept invite.", http.StatusInternalServerError)
+		log.Println("Failed to check channel membership.", err)
+		return
+	}
+
+	_, err = h.memberStore.GetActiveBan(invite.ChanelID, user.ID)
+	if err == nil {
+		http.Error(w, "You are banned from this channel.", http.StatusForbidden)
+		log.Println("Banned user tried to accept invite:", user.ID)
+		return
+	}
+	if !errors.Is(err, types.ErrBanNotFound) {
+		http.Error(w, "Failed to accept invite.", http.StatusInternalServerError)
+		log.Println("Failed to check channel bans.", err)
+		return
+	}
 
 	if !isInviteValid(invite) {
 		http.Error(w, "Invite is Invalid.", http.StatusGone)
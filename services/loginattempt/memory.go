@@ -0,0 +1,113 @@
+package loginattempt
+
+import (
+	"log"
+	"sync"
+	"time"
+	"user/server/types"
+)
+
+const (
+	maxAuditEntries = 1000
+	// Longer than any lockout window, see auth.AccountLockoutPolicy.
+	staleAfter    = 48 * time.Hour
+	evictInterval = time.Minute
+)
+
+// MemoryStore is enough when a single instance serves all logins. Audit
+// entries are logged and only the most recent ones are kept.
+type MemoryStore struct {
+	mu       sync.Mutex
+	attempts map[string]*types.LoginAttempt
+	audit    []types.LoginAuditEntry
+	nextID   int
+	evicted  time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{attempts: make(map[string]*types.LoginAttempt)}
+}
+
+func (s *MemoryStore) GetAttempt(key string) (*types.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok {
+		return &types.LoginAttempt{Key: key}, nil
+	}
+	copied := *attempt
+	return &copied, nil
+}
+
+func (s *MemoryStore) RegisterFailure(key string, now time.Time, window time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok || attempt.LastFailureAt.Before(now.Add(-window)) {
+		attempt = &types.LoginAttempt{Key: key}
+		s.attempts[key] = attempt
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = now
+	s.evictStale(now)
+	return attempt.Failures, nil
+}
+
+func (s *MemoryStore) LockUntil(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if attempt, ok := s.attempts[key]; ok {
+		attempt.LockedUntil = until
+	}
+	return nil
+}
+
+func (s *MemoryStore) ClearAttempts(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+func (s *MemoryStore) RecordLoginFailure(entry *types.LoginAuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	entry.ID = s.nextID
+	entry.CreatedAt = time.Now()
+	log.Printf("Login failure for %q from %s: %s", entry.Email, entry.IPAddress, entry.Reason)
+
+	s.audit = append(s.audit, *entry)
+	if len(s.audit) > maxAuditEntries {
+		s.audit = s.audit[len(s.audit)-maxAuditEntries:]
+	}
+	return nil
+}
+
+func (s *MemoryStore) AuditEntries() []types.LoginAuditEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]types.LoginAuditEntry, len(s.audit))
+	copy(entries, s.audit)
+	return entries
+}
+
+// evictStale keeps the map from growing forever under a spray of
+// usernames or IPs.
+func (s *MemoryStore) evictStale(now time.Time) {
+	if now.Sub(s.evicted) < evictInterval {
+		return
+	}
+	s.evicted = now
+	for key, attempt := range s.attempts {
+		if attempt.LastFailureAt.Before(now.Add(-staleAfter)) && attempt.LockedUntil.Before(now) {
+			delete(s.attempts, key)
+		}
+	}
+}
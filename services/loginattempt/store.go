@@ -0,0 +1,88 @@
+package loginattempt
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+	"user/server/types"
+)
+
+// Store keeps login attempts in Postgres so every replica sees the same
+// counters.
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) GetAttempt(key string) (*types.LoginAttempt, error) {
+	attempt := &types.LoginAttempt{Key: key}
+	var lockedUntil sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT Failures, LastFailureAt, LockedUntil
+		FROM LoginAttempts WHERE Key = $1`, key).Scan(
+		&attempt.Failures, &attempt.LastFailureAt, &lockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return attempt, nil
+	}
+	if err != nil {
+		log.Println("Error getting login attempts: ", err)
+		return nil, err
+	}
+	attempt.LockedUntil = lockedUntil.Time
+	return attempt, nil
+}
+
+func (s *Store) RegisterFailure(key string, now time.Time, window time.Duration) (int, error) {
+	var failures int
+	err := s.db.QueryRow(`
+		INSERT INTO LoginAttempts (Key, Failures, LastFailureAt)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (Key) DO UPDATE
+		SET Failures = CASE
+				WHEN LoginAttempts.LastFailureAt < $3 THEN 1
+				ELSE LoginAttempts.Failures + 1
+			END,
+			LastFailureAt = EXCLUDED.LastFailureAt
+		RETURNING Failures`, key, now, now.Add(-window)).Scan(&failures)
+	if err != nil {
+		log.Println("Error registering login failure: ", err)
+		return 0, err
+	}
+	return failures, nil
+}
+
+func (s *Store) LockUntil(key string, until time.Time) error {
+	_, err := s.db.Exec("UPDATE LoginAttempts SET LockedUntil = $2 WHERE Key = $1", key, until)
+	if err != nil {
+		log.Println("Error locking login: ", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) ClearAttempts(key string) error {
+	_, err := s.db.Exec("DELETE FROM LoginAttempts WHERE Key = $1", key)
+	if err != nil {
+		log.Println("Error clearing login attempts: ", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) RecordLoginFailure(entry *types.LoginAuditEntry) error {
+	err := s.db.QueryRow(`
+		INSERT INTO LoginAudit (UserID, Email, IPAddress, UserAgent, Reason)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING ID, CreatedAt`,
+		entry.UserID, entry.Email, entry.IPAddress, entry.UserAgent, entry.Reason).Scan(
+		&entry.ID, &entry.CreatedAt)
+	if err != nil {
+		log.Println("Error recording login failure: ", err)
+		return err
+	}
+	return nil
+}
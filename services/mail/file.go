@@ -0,0 +1,31 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+	"user/server/types"
+)
+
+// FileMailer writes each message to its own .eml file instead of sending
+// it.
+type FileMailer struct {
+	dir     string
+	from    string
+	counter uint64
+}
+
+func NewFileMailer(dir, from string) *FileMailer {
+	return &FileMailer{dir: dir, from: from}
+}
+
+func (m *FileMailer) Send(mail types.Mail) error {
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%d.eml", time.Now().Format("20060102T150405.000"), atomic.AddUint64(&m.counter, 1))
+	return os.WriteFile(filepath.Join(m.dir, name), formatMessage(m.from, mail), 0o600)
+}
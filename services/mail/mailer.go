@@ -0,0 +1,60 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	netmail "net/mail"
+	"strings"
+	"time"
+	"user/server/config"
+	"user/server/types"
+)
+
+// NewMailer sends through SMTP when it is configured and otherwise drops
+// every message as a file into cfg.MailDir, which is handy in development.
+func NewMailer(cfg config.Config) types.Mailer {
+	if cfg.SMTPHost != "" {
+		return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+	}
+	log.Println("SMTP not configured, writing mail to", cfg.MailDir)
+	return NewFileMailer(cfg.MailDir, cfg.MailFrom)
+}
+
+// formatMessage builds a plain text RFC 5322 message.
+func formatMessage(from string, mail types.Mail) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", mail.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", sanitizeHeader(mail.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(mail.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+func sanitizeHeader(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
+
+// ValidateAddress accepts a bare address like "jane@example.com" and
+// rejects display names or anything net/mail can't parse.
+func ValidateAddress(address string) error {
+	parsed, err := parseAddress(address)
+	if err != nil {
+		return err
+	}
+	if parsed != address {
+		return fmt.Errorf("invalid email address %q", address)
+	}
+	return nil
+}
+
+func parseAddress(address string) (string, error) {
+	parsed, err := netmail.ParseAddress(address)
+	if err != nil {
+		return "", err
+	}
+	return parsed.Address, nil
+}
@@ -0,0 +1,31 @@
+package mail
+
+import (
+	"sync"
+	"user/server/types"
+)
+
+// MemoryMailer keeps sent messages around for tests.
+type MemoryMailer struct {
+	mu   sync.Mutex
+	sent []types.Mail
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) Send(mail types.Mail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, mail)
+	return nil
+}
+
+func (m *MemoryMailer) Sent() []types.Mail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sent := make([]types.Mail, len(m.sent))
+	copy(sent, m.sent)
+	return sent
+}
@@ -0,0 +1,29 @@
+package mail
+
+import (
+	"net"
+	"net/smtp"
+	"user/server/types"
+)
+
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPMailer{addr: net.JoinHostPort(host, port), auth: auth, from: from}
+}
+
+func (m *SMTPMailer) Send(mail types.Mail) error {
+	envelopeFrom := m.from
+	if address, err := parseAddress(m.from); err == nil {
+		envelopeFrom = address
+	}
+	return smtp.SendMail(m.addr, m.auth, envelopeFrom, []string{mail.To}, formatMessage(m.from, mail))
+}
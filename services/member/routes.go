@@ -0,0 +1,334 @@
+package member
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"user/server/services/auth"
+	"user/server/services/hub"
+	"user/server/services/permissions"
+	"user/server/services/utils"
+	"user/server/types"
+
+	"github.com/gorilla/mux"
+)
+
+const maxReasonLength = 512
+
+// WebSocket close reasons are limited to 123 bytes.
+const maxCloseReasonLength = 123
+
+type Handler struct {
+	store           types.MemberStore
+	userStore       types.UserStore
+	permissionStore types.PermissionsStore
+}
+
+func NewHandler(store types.MemberStore, userStore types.UserStore, permissionStore types.PermissionsStore) *Handler {
+	return &Handler{store: store, userStore: userStore, permissionStore: permissionStore}
+}
+
+func (h *Handler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/channels/{channelID}/members", utils.CorsHandler(
+		auth.WithJWTAuth(h.GetMembersHandler, h.userStore),
+	)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/channels/{channelID}/members/me", utils.CorsHandler(
+		auth.WithJWTAuth(h.LeaveChannelHandler, h.userStore),
+	)).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/channels/{channelID}/members/{userID}/kick", utils.CorsHandler(
+		auth.WithJWTAuth(h.KickHandler, h.userStore),
+	)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/channels/{channelID}/bans", utils.CorsHandler(
+		auth.WithJWTAuth(h.GetBansHandler, h.userStore),
+	)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/channels/{channelID}/bans/{userID}", utils.CorsHandler(
+		auth.WithJWTAuth(h.BanHandler, h.userStore),
+	)).Methods("PUT", "OPTIONS")
+	r.HandleFunc("/channels/{channelID}/bans/{userID}", utils.CorsHandler(
+		auth.WithJWTAuth(h.UnbanHandler, h.userStore),
+	)).Methods("DELETE", "OPTIONS")
+}
+
+func (h *Handler) GetMembersHandler(w http.ResponseWriter, r *http.Request) {
+	channelID, ok := parseID(w, r, "channelID")
+	if !ok {
+		return
+	}
+	user := auth.GetUserFromContext(r.Context())
+	if _, ok := permissions.Authorize(w, h.permissionStore, user.ID, channelID, 0); !ok {
+		return
+	}
+
+	members, err := h.store.GetChannelMembers(channelID)
+	if err != nil {
+		handleError(w, "Error getting members", http.StatusInternalServerError, err)
+		return
+	}
+
+	online := hub.HubInstance.OnlineUsers(channelID)
+	for _, m := range members {
+		m.Online = online[strconv.Itoa(m.UserID)]
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, types.ChannelMembersResponse{Members: members})
+}
+
+// LeaveChannelHandler removes the caller from the channel. The owner has
+// to delete the channel instead, so it is never left without one.
+func (h *Handler) LeaveChannelHandler(w http.ResponseWriter, r *http.Request) {
+	channelID, ok := parseID(w, r, "channelID")
+	if !ok {
+		return
+	}
+	user := auth.GetUserFromContext(r.Context())
+	member, ok := permissions.Authorize(w, h.permissionStore, user.ID, channelID, 0)
+	if !ok {
+		return
+	}
+	if member.Role == types.RoleOwner {
+		handleError(w, "The owner can't leave the channel", http.StatusConflict, nil)
+		return
+	}
+
+	err := h.store.RemoveMember(channelID, user.ID)
+	if err != nil {
+		handleError(w, "Error leaving channel", http.StatusInternalServerError, err)
+		return
+	}
+
+	hub.HubInstance.DisconnectFromChannel(channelID, strconv.Itoa(user.ID),
+		types.CloseRemovedFromChannel, "left channel")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) KickHandler(w http.ResponseWriter, r *http.Request) {
+	actor, target, ok := h.authorizeModeration(w, r)
+	if !ok {
+		return
+	}
+	if target == nil {
+		handleError(w, "Member not found", http.StatusNotFound, nil)
+		return
+	}
+
+	payload := &types.KickPayload{}
+	if err := parseOptionalJSON(r, payload); err != nil {
+		handleError(w, "Invalid request payload", http.StatusBadRequest, err)
+		return
+	}
+	reason, ok := parseReason(w, payload.Reason)
+	if !ok {
+		return
+	}
+
+	err := h.store.RemoveMember(actor.ChannelID, target.UserID)
+	if err != nil {
+		handleError(w, "Error kicking member", http.StatusInternalServerError, err)
+		return
+	}
+
+	h.logModeration(actor, target.UserID, types.ModerationKick, reason)
+	hub.HubInstance.DisconnectFromChannel(actor.ChannelID, strconv.Itoa(target.UserID),
+		types.CloseRemovedFromChannel, closeReason("kicked", reason))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BanHandler bans a user, whether or not they are currently a member, and
+// removes them from the channel if they are.
+func (h *Handler) BanHandler(w http.ResponseWriter, r *http.Request) {
+	actor, target, ok := h.authorizeModeration(w, r)
+	if !ok {
+		return
+	}
+	targetID, _ := strconv.Atoi(mux.Vars(r)["userID"])
+
+	payload := &types.BanPayload{}
+	if err := parseOptionalJSON(r, payload); err != nil {
+		handleError(w, "Invalid request payload", http.StatusBadRequest, err)
+		return
+	}
+	reason, ok := parseReason(w, payload.Reason)
+	if !ok {
+		return
+	}
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now()) {
+		handleError(w, "Ban expiry must be in the future", http.StatusBadRequest, nil)
+		return
+	}
+	if target == nil {
+		if _, err := h.userStore.GetUserByID(targetID); err != nil {
+			handleError(w, "User not found", http.StatusNotFound, err)
+			return
+		}
+	}
+
+	ban := &types.ChannelBan{
+		ChannelID: actor.ChannelID,
+		UserID:    targetID,
+		BannedBy:  &actor.UserID,
+		Reason:    reason,
+		ExpiresAt: payload.ExpiresAt,
+	}
+	err := h.store.BanMember(ban)
+	if err != nil {
+		handleError(w, "Error banning user", http.StatusInternalServerError, err)
+		return
+	}
+
+	h.logModeration(actor, targetID, types.ModerationBan, reason)
+	hub.HubInstance.DisconnectFromChannel(actor.ChannelID, strconv.Itoa(targetID),
+		types.CloseRemovedFromChannel, closeReason("banned", reason))
+	utils.SendJSONResponse(w, http.StatusOK, ban)
+}
+
+func (h *Handler) GetBansHandler(w http.ResponseWriter, r *http.Request) {
+	channelID, ok := parseID(w, r, "channelID")
+	if !ok {
+		return
+	}
+	user := auth.GetUserFromContext(r.Context())
+	if _, ok := permissions.Authorize(w, h.permissionStore, user.ID, channelID, types.PermKick); !ok {
+		return
+	}
+
+	bans, err := h.store.GetBans(channelID)
+	if err != nil {
+		handleError(w, "Error getting bans", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, types.ChannelBansResponse{Bans: bans})
+}
+
+func (h *Handler) UnbanHandler(w http.ResponseWriter, r *http.Request) {
+	channelID, ok := parseID(w, r, "channelID")
+	if !ok {
+		return
+	}
+	targetID, ok := parseID(w, r, "userID")
+	if !ok {
+		return
+	}
+	user := auth.GetUserFromContext(r.Context())
+	actor, ok := permissions.Authorize(w, h.permissionStore, user.ID, channelID, types.PermKick)
+	if !ok {
+		return
+	}
+
+	err := h.store.Unban(channelID, targetID)
+	if errors.Is(err, types.ErrBanNotFound) {
+		handleError(w, "Ban not found", http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		handleError(w, "Error removing ban", http.StatusInternalServerError, err)
+		return
+	}
+
+	h.logModeration(actor, targetID, types.ModerationUnban, "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizeModeration checks the caller may kick in the channel and
+// outranks the target. target is nil when the user isn't a member.
+func (h *Handler) authorizeModeration(w http.ResponseWriter, r *http.Request) (actor, target *types.ChannelMember, ok bool) {
+	channelID, ok := parseID(w, r, "channelID")
+	if !ok {
+		return nil, nil, false
+	}
+	targetID, ok := parseID(w, r, "userID")
+	if !ok {
+		return nil, nil, false
+	}
+	user := auth.GetUserFromContext(r.Context())
+	actor, ok = permissions.Authorize(w, h.permissionStore, user.ID, channelID, types.PermKick)
+	if !ok {
+		return nil, nil, false
+	}
+	if targetID == user.ID {
+		handleError(w, "You can't moderate yourself", http.StatusBadRequest, nil)
+		return nil, nil, false
+	}
+
+	target, err := h.permissionStore.GetChannelMember(targetID, channelID)
+	if errors.Is(err, types.ErrNotChannelMember) {
+		return actor, nil, true
+	}
+	if err != nil {
+		handleError(w, "Error getting member", http.StatusInternalServerError, err)
+		return nil, nil, false
+	}
+	if !actor.Outranks(target) {
+		handleError(w, "Forbidden", http.StatusForbidden, nil)
+		return nil, nil, false
+	}
+	return actor, target, true
+}
+
+func (h *Handler) logModeration(actor *types.ChannelMember, targetID int, action, reason string) {
+	err := h.store.LogModeration(types.ModerationAction{
+		ChannelID: actor.ChannelID,
+		ActorID:   actor.UserID,
+		TargetID:  targetID,
+		Action:    action,
+		Reason:    reason,
+	})
+	if err != nil {
+		log.Println("Error logging moderation action:", err)
+	}
+}
+
+// parseOptionalJSON reads a body whose fields are all optional, so an
+// empty one is the same as {}.
+func parseOptionalJSON(r *http.Request, v any) error {
+	err := utils.ParseJSON(r, v)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+func parseReason(w http.ResponseWriter, reason string) (string, bool) {
+	reason = strings.TrimSpace(reason)
+	if len(reason) > maxReasonLength {
+		handleError(w, "Reason is too long", http.StatusBadRequest, nil)
+		return "", false
+	}
+	return reason, true
+}
+
+func closeReason(action, reason string) string {
+	if reason != "" {
+		action += ": " + reason
+	}
+	if len(action) > maxCloseReasonLength {
+		action = action[:maxCloseReasonLength]
+		for !utf8.ValidString(action) {
+			action = action[:len(action)-1]
+		}
+	}
+	return action
+}
+
+func parseID(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)[name])
+	if err != nil {
+		handleError(w, "Invalid "+name, http.StatusBadRequest, err)
+		return 0, false
+	}
+	return id, true
+}
+
+func handleError(w http.ResponseWriter, message string, statusCode int, err error) {
+	if err != nil {
+		log.Println(message, err)
+	} else {
+		log.Println(message)
+	}
+	http.Error(w, message, statusCode)
+}
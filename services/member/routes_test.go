@@ -0,0 +1,178 @@
+package member
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+	"user/server/services/auth"
+	"user/server/services/hub"
+	"user/server/types"
+
+	"github.com/gorilla/mux"
+)
+
+type fakePermissionStore struct {
+	types.PermissionsStore
+	roles map[int]string
+}
+
+func (s *fakePermissionStore) GetChannelMember(userID, channelID int) (*types.ChannelMember, error) {
+	role, ok := s.roles[userID]
+	if !ok {
+		return nil, types.ErrNotChannelMember
+	}
+	return &types.ChannelMember{
+		UserID:      userID,
+		ChannelID:   channelID,
+		Role:        role,
+		Permissions: types.RolePermissions(role),
+	}, nil
+}
+
+type fakeMemberStore struct {
+	types.MemberStore
+	permissions *fakePermissionStore
+	bans        map[int]*types.ChannelBan
+	log         []types.ModerationAction
+}
+
+func (s *fakeMemberStore) RemoveMember(channelID, userID int) error {
+	delete(s.permissions.roles, userID)
+	return nil
+}
+
+func (s *fakeMemberStore) BanMember(ban *types.ChannelBan) error {
+	delete(s.permissions.roles, ban.UserID)
+	s.bans[ban.UserID] = ban
+	return nil
+}
+
+func (s *fakeMemberStore) LogModeration(action types.ModerationAction) error {
+	s.log = append(s.log, action)
+	return nil
+}
+
+type fakeUserStore struct {
+	types.UserStore
+}
+
+func (s *fakeUserStore) GetUserByID(id int) (*types.User, error) {
+	if id > 10 {
+		return nil, errors.New("invalid credentials")
+	}
+	return &types.User{ID: id}, nil
+}
+
+const (
+	owner     = 1
+	moderator = 2
+	member    = 3
+	outsider  = 4
+)
+
+func newTestHandler() (*Handler, *fakeMemberStore) {
+	hub.HubInstance = &types.Hub{Channels: make(map[int]*types.Channel)}
+	permissions := &fakePermissionStore{roles: map[int]string{
+		owner:     types.RoleOwner,
+		moderator: types.RoleModerator,
+		member:    types.RoleMember,
+	}}
+	store := &fakeMemberStore{permissions: permissions, bans: make(map[int]*types.ChannelBan)}
+	return NewHandler(store, &fakeUserStore{}, permissions), store
+}
+
+func request(handler http.HandlerFunc, userID, targetID int, body string) int {
+	r := httptest.NewRequest("POST", "/", bytes.NewBufferString(body))
+	r = r.WithContext(context.WithValue(r.Context(), auth.UserKey, &types.User{ID: userID}))
+	r = mux.SetURLVars(r, map[string]string{"channelID": "1", "userID": strconv.Itoa(targetID)})
+	res := httptest.NewRecorder()
+	handler(res, r)
+	return res.Code
+}
+
+func TestKick(t *testing.T) {
+	tests := []struct {
+		name     string
+		actor    int
+		target   int
+		expected int
+	}{
+		{name: "Moderator kicks member", actor: moderator, target: member, expected: http.StatusNoContent},
+		{name: "Moderator cannot kick owner", actor: moderator, target: owner, expected: http.StatusForbidden},
+		{name: "Member cannot kick", actor: member, target: moderator, expected: http.StatusForbidden},
+		{name: "Cannot kick yourself", actor: moderator, target: moderator, expected: http.StatusBadRequest},
+		{name: "Target not a member", actor: owner, target: outsider, expected: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, store := newTestHandler()
+			code := request(h.KickHandler, tt.actor, tt.target, `{"reason":"spam"}`)
+			if code != tt.expected {
+				t.Fatalf("expected %d, got %d", tt.expected, code)
+			}
+			if code == http.StatusNoContent {
+				if _, ok := store.permissions.roles[tt.target]; ok {
+					t.Error("kicked user is still a member")
+				}
+				if len(store.log) != 1 || store.log[0].Action != types.ModerationKick || store.log[0].Reason != "spam" {
+					t.Errorf("kick was not logged: %+v", store.log)
+				}
+			}
+		})
+	}
+}
+
+func TestKickWithoutBody(t *testing.T) {
+	h, store := newTestHandler()
+	if code := request(h.KickHandler, moderator, member, ""); code != http.StatusNoContent {
+		t.Fatalf("kick without a body returned %d", code)
+	}
+	if len(store.log) != 1 || store.log[0].Reason != "" {
+		t.Errorf("unexpected log %+v", store.log)
+	}
+}
+
+func TestBan(t *testing.T) {
+	h, store := newTestHandler()
+
+	code := request(h.BanHandler, moderator, member, `{"reason":"abuse","expires_at":"2001-01-01T00:00:00Z"}`)
+	if code != http.StatusBadRequest {
+		t.Errorf("ban expiring in the past returned %d", code)
+	}
+
+	code = request(h.BanHandler, moderator, member, `{"reason":"abuse"}`)
+	if code != http.StatusOK {
+		t.Fatalf("ban returned %d", code)
+	}
+	if _, ok := store.permissions.roles[member]; ok {
+		t.Error("banned user is still a member")
+	}
+	if ban := store.bans[member]; ban == nil || ban.BannedBy == nil || *ban.BannedBy != moderator || ban.ExpiresAt != nil {
+		t.Errorf("unexpected ban %+v", ban)
+	}
+
+	// Users who never joined can be banned pre-emptively, unknown users can't.
+	if code := request(h.BanHandler, moderator, outsider, `{}`); code != http.StatusOK {
+		t.Errorf("banning a non-member returned %d", code)
+	}
+	if code := request(h.BanHandler, moderator, 42, `{}`); code != http.StatusNotFound {
+		t.Errorf("banning an unknown user returned %d", code)
+	}
+}
+
+func TestCloseReason(t *testing.T) {
+	reason := closeReason("kicked", strings.Repeat("é", 100))
+	if len(reason) > maxCloseReasonLength || !utf8.ValidString(reason) {
+		t.Errorf("invalid close reason %q", reason)
+	}
+	if closeReason("kicked", "") != "kicked" {
+		t.Error("empty reason should not add a separator")
+	}
+}
@@ -0,0 +1,185 @@
+package member
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"user/server/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) GetChannelMembers(channelID int) ([]*types.ChannelMemberInfo, error) {
+	rows, err := s.db.Query(`
+		SELECT u.ID, u.Username, u.Avatar, u.IsBot, c.role, c.custom_role_id, c.joined_at
+		FROM ChannelsToUsers c
+		JOIN Users u ON u.ID = c.user_id
+		WHERE c.channel_id = $1
+		ORDER BY c.joined_at`, channelID)
+	if err != nil {
+		log.Println("Error getting channel members: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := make([]*types.ChannelMemberInfo, 0)
+	for rows.Next() {
+		m := &types.ChannelMemberInfo{}
+		var customRoleID sql.NullInt64
+		err := rows.Scan(&m.UserID, &m.Username, &m.Avatar, &m.IsBot, &m.Role, &customRoleID, &m.JoinedAt)
+		if err != nil {
+			log.Println("Error scanning channel member: ", err)
+			return nil, err
+		}
+		if customRoleID.Valid {
+			id := int(customRoleID.Int64)
+			m.CustomRoleID = &id
+		}
+		members = append(members, m)
+	}
+
+	return members, rows.Err()
+}
+
+func (s *Store) RemoveMember(channelID, userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = removeMember(tx, channelID, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func removeMember(tx *sql.Tx, channelID, userID int) error {
+	_, err := tx.Exec(`DELETE FROM ChannelsToUsers WHERE channel_id = $1 AND user_id = $2`,
+		channelID, userID)
+	if err != nil {
+		log.Println("Error removing channel member: ", err)
+		return err
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM RoomAccess
+		WHERE UserID = $1 AND RoomID IN (SELECT ID FROM Rooms WHERE ChannelID = $2)`,
+		userID, channelID)
+	if err != nil {
+		log.Println("Error removing member from room access: ", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) BanMember(ban *types.ChannelBan) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = removeMember(tx, ban.ChannelID, ban.UserID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO ChannelBans (ChannelID, UserID, BannedBy, Reason, ExpiresAt)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (ChannelID, UserID) DO UPDATE
+		SET BannedBy = EXCLUDED.BannedBy, Reason = EXCLUDED.Reason,
+			ExpiresAt = EXCLUDED.ExpiresAt, CreatedAt = CURRENT_TIMESTAMP
+		RETURNING CreatedAt`,
+		ban.ChannelID, ban.UserID, ban.BannedBy, ban.Reason, ban.ExpiresAt).Scan(&ban.CreatedAt)
+	if err != nil {
+		log.Println("Error banning channel member: ", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Store) GetActiveBan(channelID, userID int) (*types.ChannelBan, error) {
+	ban := &types.ChannelBan{}
+	var expiresAt sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT ChannelID, UserID, BannedBy, Reason, CreatedAt, ExpiresAt
+		FROM ChannelBans
+		WHERE ChannelID = $1 AND UserID = $2
+		AND (ExpiresAt IS NULL OR ExpiresAt > CURRENT_TIMESTAMP)`, channelID, userID).Scan(
+		&ban.ChannelID, &ban.UserID, &ban.BannedBy, &ban.Reason, &ban.CreatedAt, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrBanNotFound
+	}
+	if err != nil {
+		log.Println("Error getting channel ban: ", err)
+		return nil, err
+	}
+	if expiresAt.Valid {
+		ban.ExpiresAt = &expiresAt.Time
+	}
+	return ban, nil
+}
+
+func (s *Store) GetBans(channelID int) ([]*types.ChannelBan, error) {
+	rows, err := s.db.Query(`
+		SELECT ChannelID, UserID, BannedBy, Reason, CreatedAt, ExpiresAt
+		FROM ChannelBans
+		WHERE ChannelID = $1 AND (ExpiresAt IS NULL OR ExpiresAt > CURRENT_TIMESTAMP)
+		ORDER BY CreatedAt DESC`, channelID)
+	if err != nil {
+		log.Println("Error getting channel bans: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	bans := make([]*types.ChannelBan, 0)
+	for rows.Next() {
+		ban := &types.ChannelBan{}
+		var expiresAt sql.NullTime
+		err := rows.Scan(&ban.ChannelID, &ban.UserID, &ban.BannedBy, &ban.Reason, &ban.CreatedAt, &expiresAt)
+		if err != nil {
+			log.Println("Error scanning channel ban: ", err)
+			return nil, err
+		}
+		if expiresAt.Valid {
+			ban.ExpiresAt = &expiresAt.Time
+		}
+		bans = append(bans, ban)
+	}
+
+	return bans, rows.Err()
+}
+
+func (s *Store) Unban(channelID, userID int) error {
+	res, err := s.db.Exec(`DELETE FROM ChannelBans WHERE ChannelID = $1 AND UserID = $2`,
+		channelID, userID)
+	if err != nil {
+		log.Println("Error removing channel ban: ", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return types.ErrBanNotFound
+	}
+	return nil
+}
+
+func (s *Store) LogModeration(action types.ModerationAction) error {
+	_, err := s.db.Exec(`
+		INSERT INTO ChannelModerationLog (ChannelID, ActorID, TargetID, Action, Reason)
+		VALUES ($1, $2, $3, $4, $5)`,
+		action.ChannelID, action.ActorID, action.TargetID, action.Action, action.Reason)
+	if err != nil {
+		log.Println("Error logging moderation action: ", err)
+		return err
+	}
+	return nil
+}
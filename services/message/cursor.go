@@ -0,0 +1,41 @@
+package message
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"user/server/types"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// Cursors are opaque to clients. They encode the timestamp and ID of the
+// message at the edge of a page.
+func encodeCursor(m *types.Message) string {
+	raw := m.Timestamp.Format(time.RFC3339Nano) + "|" + strconv.Itoa(m.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (*types.MessageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	timestamp, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, errInvalidCursor
+	}
+
+	c := &types.MessageCursor{}
+	c.Timestamp, err = time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	c.ID, err = strconv.Atoi(id)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	return c, nil
+}
@@ -1,23 +1,46 @@
 package message
 
 import (
+	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 	"user/server/services/auth"
 	"user/server/services/hub"
+	"user/server/services/permissions"
 	"user/server/services/utils"
 	"user/server/types"
 )
 
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+	// Long enough for flags and ZWJ sequences such as family emoji.
+	maxEmojiLength = 32
+)
+
 type Handler struct {
-	store     types.MessageStore
-	userStore types.UserStore
+	store           types.MessageStore
+	userStore       types.UserStore
+	permissionStore types.PermissionsStore
+	emojiStore      types.EmojiStore
+	notifier        types.Notifier
 }
 
-func NewHandler(store types.MessageStore, userStore types.UserStore) *Handler {
-	return &Handler{store: store, userStore: userStore}
+func NewHandler(store types.MessageStore, userStore types.UserStore, permissionStore types.PermissionsStore,
+	emojiStore types.EmojiStore, notifier types.Notifier) *Handler {
+	return &Handler{
+		store:           store,
+		userStore:       userStore,
+		permissionStore: permissionStore,
+		emojiStore:      emojiStore,
+		notifier:        notifier,
+	}
 }
 
 func (h *Handler) RegisterRoutes(r *mux.Router) {
@@ -32,6 +55,48 @@ func (h *Handler) RegisterRoutes(r *mux.Router) {
 			h.userStore,
 		),
 	)).Methods("PUT", "OPTIONS")
+	r.HandleFunc("/messages/{messageID}", utils.CorsHandler(
+		auth.WithJWTAuth(
+			h.EditMessageHandler,
+			h.userStore,
+		),
+	)).Methods("PATCH", "OPTIONS")
+	r.HandleFunc("/messages/{messageID}", utils.CorsHandler(
+		auth.WithJWTAuth(
+			h.DeleteMessageHandler,
+			h.userStore,
+		),
+	)).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/messages/{messageID}/thread", utils.CorsHandler(
+		auth.WithJWTAuth(
+			h.GetThreadHandler,
+			h.userStore,
+		),
+	)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/messages/{messageID}/follow", utils.CorsHandler(
+		auth.WithJWTAuth(
+			h.FollowThreadHandler,
+			h.userStore,
+		),
+	)).Methods("PUT", "DELETE", "OPTIONS")
+	r.HandleFunc("/messages/{messageID}/reactions/{emoji}", utils.CorsHandler(
+		auth.WithJWTAuth(
+			h.AddReactionHandler,
+			h.userStore,
+		),
+	)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/messages/{messageID}/reactions/{emoji}", utils.CorsHandler(
+		auth.WithJWTAuth(
+			h.RemoveReactionHandler,
+			h.userStore,
+		),
+	)).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/messages/{messageID}/edits", utils.CorsHandler(
+		auth.WithJWTAuth(
+			h.GetMessageEditsHandler,
+			h.userStore,
+		),
+	)).Methods("GET", "OPTIONS")
 }
 
 func (h *Handler) ChattingHandler(w http.ResponseWriter, r *http.Request) {
@@ -65,6 +130,13 @@ func (h *Handler) ChattingHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check access before upgrading so the client gets a plain 403/404
+	// instead of a socket that closes straight away.
+	user := auth.GetUserFromContext(r.Context())
+	if _, ok := permissions.AuthorizeRoom(w, h.permissionStore, user.ID, roomID, 0); !ok {
+		return
+	}
+
 	ws, err := utils.UpgradeToWebSocket(w, r)
 	if err != nil {
 		http.Error(w, "Could not open WebSocket connection", http.StatusBadRequest)
@@ -72,9 +144,9 @@ func (h *Handler) ChattingHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user := auth.GetUserFromContext(r.Context())
-
-	client := hub.NewClient(ws, user)
+	client := hub.NewClient(ws, user, auth.ConnectionID(r.Context()))
+	client.Resume = hub.ParseResumePoint(r)
+	client.Permissions = h.permissionStore
 	if room.Bus == nil {
 		http.Error(w, "Could not connect to the room", http.StatusBadRequest)
 		log.Println("Could not connect to the room: ", err)
@@ -86,7 +158,7 @@ func (h *Handler) ChattingHandler(w http.ResponseWriter, r *http.Request) {
 		Payload: client,
 	})
 
-	go client.ReadMessages(room, h.store)
+	go client.ReadMessages(room, h.store, h.permissionStore, h.notifier)
 
 	go client.WriteMessages()
 }
@@ -97,34 +169,394 @@ func (h *Handler) FetchMessages(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		http.Error(w, "Invalid room", http.StatusBadRequest)
 		log.Println("FetchMessages: Invalid room", err)
+		return
+	}
+
+	user := auth.GetUserFromContext(r.Context())
+	if _, ok := permissions.AuthorizeRoom(w, h.permissionStore, user.ID, roomID, 0); !ok {
+		return
+	}
+
+	page, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		log.Println("FetchMessages:", err)
+		return
 	}
 
-	messages, err := h.store.GetMessagesInRoom(roomID)
+	messages, err := h.store.GetMessagesInRoom(roomID, user.ID, page)
 	if err != nil {
 		http.Error(w, "Unable to fetch messages.", http.StatusInternalServerError)
 		log.Println("Unable to fetch messages from db.", err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, pageResponse(messages, page))
+}
+
+// parsePage reads ?before=, ?after= and ?limit=. Only one cursor may be
+// given.
+func parsePage(r *http.Request) (types.MessagePage, error) {
+	query := r.URL.Query()
+	page := types.MessagePage{Limit: defaultPageSize}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxPageSize {
+			return page, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		page.Limit = n
+	}
+
+	before, after := query.Get("before"), query.Get("after")
+	if before != "" && after != "" {
+		return page, errors.New("before and after can't be combined")
+	}
+	var err error
+	if before != "" {
+		page.Before, err = decodeCursor(before)
+	}
+	if after != "" {
+		page.After, err = decodeCursor(after)
 	}
+	return page, err
+}
 
+// pageResponse trims the extra message the store fetches to detect
+// further pages and sets the cursor to continue from.
+func pageResponse(messages []*types.Message, page types.MessagePage) types.MessagesResponse {
 	response := types.MessagesResponse{Messages: messages}
-	utils.SendJSONResponse(w, http.StatusOK, response)
+	if len(messages) > page.Limit {
+		response.HasMore = true
+		if page.After != nil {
+			response.Messages = messages[:page.Limit]
+		} else {
+			response.Messages = messages[len(messages)-page.Limit:]
+		}
+	}
+
+	if n := len(response.Messages); n > 0 {
+		edge := response.Messages[0]
+		if page.After != nil {
+			edge = response.Messages[n-1]
+		}
+		response.NextCursor = encodeCursor(edge)
+	}
+	return response
 }
 
+// MarkMessageAsSeenHandler moves the caller's read pointer in the message's
+// room up to it. Connected clients get a read-receipt event when it moves.
 func (h *Handler) MarkMessageAsSeenHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
 	user := auth.GetUserFromContext(r.Context())
-	messageID, err := strconv.Atoi(vars["messageID"])
-	if err != nil {
-		log.Println("Invalid message ID", err)
-		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+	message, member, ok := h.authorizeMessage(w, r, user.ID)
+	if !ok {
 		return
 	}
 
-	err = h.store.MarkMessageAsSeen(user.ID, messageID)
+	moved, err := h.store.MarkRead(user.ID, message.RoomID, message.ID)
 	if err != nil {
 		log.Println("Error marking message as seen:", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
+	if room := hub.HubInstance.GetRoom(member.ChannelID, message.RoomID); moved && room != nil {
+		room.PublishReadReceipt(user.ID, user.Username, message.ID)
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// EditMessageHandler lets the author change a message. Connected clients
+// get a message-edited event.
+func (h *Handler) EditMessageHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	message, member, ok := h.authorizeMessage(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	payload := &types.EditMessagePayload{}
+	if err := utils.ParseJSON(r, payload); err != nil {
+		log.Println("Invalid JSON:", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	edited, err := types.EditMessage(h.store, message, user.ID, payload.Content)
+	if !h.checkMessageError(w, err, "Error editing message:") {
+		return
+	}
+
+	if room := hub.HubInstance.GetRoom(member.ChannelID, edited.RoomID); room != nil {
+		room.PublishMessageEvent(types.MessageEdited, edited)
+	}
+	go h.notifier.NotifyMentions(edited, member.ChannelID)
+	utils.SendJSONResponse(w, http.StatusOK, edited)
+}
+
+// DeleteMessageHandler soft-deletes the caller's own message, or removes
+// someone else's when the caller can delete messages. Connected clients get
+// a message-deleted event.
+func (h *Handler) DeleteMessageHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	message, member, ok := h.authorizeMessage(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	deleted, replyIDs, err := types.DeleteMessage(h.store, message, user.ID, member.Can(types.PermDeleteMessages))
+	if !h.checkMessageError(w, err, "Error deleting message:") {
+		return
+	}
+
+	if room := hub.HubInstance.GetRoom(member.ChannelID, deleted.RoomID); room != nil {
+		room.PublishDeletion(h.store, deleted, replyIDs)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// FollowThreadHandler makes the caller follow a thread, or stop following
+// it on DELETE, without having a socket open in its room. Thread events
+// reach every socket the caller has open.
+func (h *Handler) FollowThreadHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	parent, member, ok := h.authorizeMessage(w, r, user.ID)
+	if !ok {
+		return
+	}
+	if parent.ParentID != nil {
+		http.Error(w, "Replies don't have threads", http.StatusBadRequest)
+		return
+	}
+
+	var room *types.Room
+	if member.ChannelID == 0 {
+		room = hub.DirectRoom(parent.RoomID)
+		defer hub.HubInstance.ReleaseDirectRoom(room)
+	} else {
+		room = hub.HubInstance.GetRoom(member.ChannelID, parent.RoomID)
+	}
+	if room == nil {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	room.SubscribeThread(strconv.Itoa(user.ID), parent.ID, r.Method != http.MethodDelete)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetThreadHandler returns a message with a page of its replies. It takes
+// the same parameters as FetchMessages.
+func (h *Handler) GetThreadHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	parent, _, ok := h.authorizeMessage(w, r, user.ID)
+	if !ok {
+		return
+	}
+	if parent.ParentID != nil {
+		http.Error(w, "Replies don't have threads", http.StatusBadRequest)
+		return
+	}
+
+	page, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		log.Println("GetThread:", err)
+		return
+	}
+
+	replies, err := h.store.GetThread(parent.ID, user.ID, page)
+	if err != nil {
+		log.Println("Error getting thread:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	parent.Thread, err = h.store.GetThreadSummary(parent.ID)
+	if err != nil {
+		log.Println("Error getting thread summary:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, types.ThreadResponse{
+		Parent:           parent,
+		MessagesResponse: pageResponse(replies, page),
+	})
+}
+
+func (h *Handler) GetMessageEditsHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	message, _, ok := h.authorizeMessage(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	edits, err := h.store.GetMessageEdits(message.ID)
+	if err != nil {
+		log.Println("Error getting message edits:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, types.MessageEditsResponse{Edits: edits})
+}
+
+// AddReactionHandler reacts to a message with a Unicode emoji or one of the
+// channel's custom emoji written as :name:. Reacting twice is a no-op.
+func (h *Handler) AddReactionHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	message, member, ok := h.authorizeMessage(w, r, user.ID)
+	if !ok {
+		return
+	}
+	if message.DeletedAt != nil {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	emoji := mux.Vars(r)["emoji"]
+	valid, err := h.validEmoji(member.ChannelID, emoji)
+	if err != nil {
+		log.Println("Error checking emoji:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		http.Error(w, "Unknown emoji", http.StatusBadRequest)
+		return
+	}
+
+	added, err := h.store.AddReaction(message.ID, user.ID, emoji)
+	if err != nil {
+		log.Println("Error adding reaction:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if added {
+		h.publishReaction(types.ReactionAdded, member, message, user.ID, emoji)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) RemoveReactionHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	message, member, ok := h.authorizeMessage(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	emoji := mux.Vars(r)["emoji"]
+	err := h.store.RemoveReaction(message.ID, user.ID, emoji)
+	if errors.Is(err, types.ErrReactionNotFound) {
+		http.Error(w, "Reaction not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Error removing reaction:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.publishReaction(types.ReactionRemoved, member, message, user.ID, emoji)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) publishReaction(eventType string, member *types.RoomMember, message *types.Message,
+	userID int, emoji string) {
+	room := hub.HubInstance.GetRoom(member.ChannelID, message.RoomID)
+	if room == nil {
+		return
+	}
+	room.Publish(types.ReactionEvent{
+		Type:      eventType,
+		RoomID:    message.RoomID,
+		MessageID: message.ID,
+		ParentID:  message.ParentID,
+		UserID:    userID,
+		Emoji:     emoji,
+	})
+}
+
+// validEmoji accepts Unicode emoji and the channel's custom emoji, written
+// as :name: with exactly one colon on each side.
+func (h *Handler) validEmoji(channelID int, emoji string) (bool, error) {
+	if strings.HasPrefix(emoji, ":") || strings.HasSuffix(emoji, ":") {
+		name, ok := types.CustomEmojiName(emoji)
+		if !ok {
+			return false, nil
+		}
+		_, err := h.emojiStore.GetEmojiByName(channelID, name)
+		if errors.Is(err, types.ErrEmojiNotFound) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	return isUnicodeEmoji(emoji), nil
+}
+
+// isUnicodeEmoji is a loose check that keeps text out of reactions: short,
+// no letters or spaces, and not plain ASCII.
+func isUnicodeEmoji(s string) bool {
+	if s == "" || len(s) > maxEmojiLength || !utf8.ValidString(s) {
+		return false
+	}
+	ascii := true
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+		if r > unicode.MaxASCII {
+			ascii = false
+		}
+	}
+	return !ascii
+}
+
+// authorizeMessage loads the message named in the URL and checks the user
+// can access its room.
+func (h *Handler) authorizeMessage(w http.ResponseWriter, r *http.Request, userID int) (*types.Message, *types.RoomMember, bool) {
+	messageID, err := strconv.Atoi(mux.Vars(r)["messageID"])
+	if err != nil {
+		log.Println("Invalid message ID", err)
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	message, err := h.store.GetMessage(messageID)
+	if errors.Is(err, types.ErrMessageNotFound) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if err != nil {
+		log.Println("Error getting message:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	member, ok := permissions.AuthorizeRoom(w, h.permissionStore, userID, message.RoomID, 0)
+	if !ok {
+		return nil, nil, false
+	}
+	return message, member, true
+}
+
+func (h *Handler) checkMessageError(w http.ResponseWriter, err error, logMessage string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, types.ErrMessageNotFound):
+		http.Error(w, "Message not found", http.StatusNotFound)
+	case errors.Is(err, types.ErrNotMessageAuthor):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	case errors.Is(err, types.ErrEmptyMessage):
+		http.Error(w, "Message can't be empty", http.StatusBadRequest)
+	case errors.Is(err, types.ErrMessageTooLong):
+		http.Error(w, "Message can't be longer than "+strconv.Itoa(types.MaxMessageLength)+" characters",
+			http.StatusBadRequest)
+	default:
+		log.Println(logMessage, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+	return false
+}
@@ -0,0 +1,241 @@
+package message
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"user/server/services/auth"
+	"user/server/services/hub"
+	"user/server/types"
+
+	"github.com/gorilla/mux"
+)
+
+type fakeMessageStore struct {
+	types.MessageStore
+	messages map[int]*types.Message
+}
+
+func (s *fakeMessageStore) GetMessage(messageID int) (*types.Message, error) {
+	m, ok := s.messages[messageID]
+	if !ok || m.DeletedAt != nil {
+		return nil, types.ErrMessageNotFound
+	}
+	return m, nil
+}
+
+func (s *fakeMessageStore) SoftDeleteMessage(messageID int) (*types.Message, error) {
+	m := s.messages[messageID]
+	now := time.Now()
+	m.Content, m.DeletedAt = "", &now
+	return m, nil
+}
+
+func (s *fakeMessageStore) DeleteMessage(messageID int) ([]int, error) {
+	delete(s.messages, messageID)
+	replyIDs := make([]int, 0)
+	for id, m := range s.messages {
+		if m.ParentID != nil && *m.ParentID == messageID {
+			delete(s.messages, id)
+			replyIDs = append(replyIDs, id)
+		}
+	}
+	return replyIDs, nil
+}
+
+type fakePermissionStore struct {
+	types.PermissionsStore
+	roles map[int]string
+}
+
+func (s *fakePermissionStore) GetRoomMember(userID, roomID int) (*types.RoomMember, error) {
+	role, ok := s.roles[userID]
+	if !ok {
+		return nil, types.ErrNotChannelMember
+	}
+	return &types.RoomMember{
+		ChannelMember: types.ChannelMember{
+			UserID:      userID,
+			ChannelID:   1,
+			Role:        role,
+			Permissions: types.RolePermissions(role),
+		},
+		RoomID:    roomID,
+		CanAccess: true,
+	}, nil
+}
+
+const (
+	author    = 1
+	moderator = 2
+	member    = 3
+)
+
+func TestDeleteMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		actor    int
+		expected int
+		kept     bool
+	}{
+		{name: "Author soft-deletes", actor: author, expected: http.StatusNoContent, kept: true},
+		{name: "Moderator hard-deletes", actor: moderator, expected: http.StatusNoContent, kept: false},
+		{name: "Member cannot delete", actor: member, expected: http.StatusForbidden, kept: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub.HubInstance = &types.Hub{Channels: make(map[int]*types.Channel)}
+			store := &fakeMessageStore{messages: map[int]*types.Message{
+				7: {ID: 7, RoomID: 5, SenderID: author, Content: "hello"},
+			}}
+			permissions := &fakePermissionStore{roles: map[int]string{
+				author:    types.RoleMember,
+				moderator: types.RoleModerator,
+				member:    types.RoleMember,
+			}}
+			h := NewHandler(store, nil, permissions, nil, nil)
+
+			r := httptest.NewRequest("DELETE", "/", nil)
+			r = r.WithContext(context.WithValue(r.Context(), auth.UserKey, &types.User{ID: tt.actor}))
+			r = mux.SetURLVars(r, map[string]string{"messageID": "7"})
+			res := httptest.NewRecorder()
+			h.DeleteMessageHandler(res, r)
+
+			if res.Code != tt.expected {
+				t.Fatalf("expected %d, got %d", tt.expected, res.Code)
+			}
+			m, kept := store.messages[7]
+			if kept != tt.kept {
+				t.Fatalf("message kept = %v", kept)
+			}
+			if tt.actor == author && (m.DeletedAt == nil || m.Content != "") {
+				t.Errorf("message was not soft-deleted: %+v", m)
+			}
+		})
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	m := &types.Message{ID: 42, Timestamp: time.Date(2024, 3, 1, 12, 30, 0, 123456000, time.UTC)}
+	c, err := decodeCursor(encodeCursor(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ID != m.ID || !c.Timestamp.Equal(m.Timestamp) {
+		t.Errorf("got %+v, want %v %v", c, m.ID, m.Timestamp)
+	}
+
+	for _, bad := range []string{"", "not base64!", "bm9waXBl"} {
+		if _, err := decodeCursor(bad); err == nil {
+			t.Errorf("decodeCursor(%q) should fail", bad)
+		}
+	}
+}
+
+func TestParsePage(t *testing.T) {
+	tests := []struct {
+		query string
+		ok    bool
+	}{
+		{query: "", ok: true},
+		{query: "limit=10", ok: true},
+		{query: "limit=0", ok: false},
+		{query: "limit=1000", ok: false},
+		{query: "before=garbage", ok: false},
+		{query: "before=" + encodeCursor(&types.Message{ID: 1}) + "&after=" + encodeCursor(&types.Message{ID: 2}), ok: false},
+	}
+
+	for _, tt := range tests {
+		_, err := parsePage(httptest.NewRequest("GET", "/?"+tt.query, nil))
+		if (err == nil) != tt.ok {
+			t.Errorf("parsePage(%q) error = %v", tt.query, err)
+		}
+	}
+}
+
+func TestPageResponse(t *testing.T) {
+	messages := make([]*types.Message, 4)
+	for i := range messages {
+		messages[i] = &types.Message{ID: i + 1, Timestamp: time.Unix(int64(i), 0)}
+	}
+
+	// Older pages drop the oldest extra message and continue from the
+	// oldest one returned.
+	res := pageResponse(messages, types.MessagePage{Limit: 3})
+	if !res.HasMore || len(res.Messages) != 3 || res.Messages[0].ID != 2 {
+		t.Fatalf("unexpected latest page %+v", res)
+	}
+	if c, _ := decodeCursor(res.NextCursor); c.ID != 2 {
+		t.Errorf("next cursor points at %d", c.ID)
+	}
+
+	// Newer pages drop the newest extra message and continue from the
+	// newest one returned.
+	res = pageResponse(messages, types.MessagePage{Limit: 3, After: &types.MessageCursor{}})
+	if !res.HasMore || len(res.Messages) != 3 || res.Messages[2].ID != 3 {
+		t.Fatalf("unexpected after page %+v", res)
+	}
+	if c, _ := decodeCursor(res.NextCursor); c.ID != 3 {
+		t.Errorf("next cursor points at %d", c.ID)
+	}
+
+	res = pageResponse(messages, types.MessagePage{Limit: 10})
+	if res.HasMore || len(res.Messages) != 4 {
+		t.Errorf("unexpected last page %+v", res)
+	}
+}
+
+func TestIsUnicodeEmoji(t *testing.T) {
+	for emoji, valid := range map[string]bool{
+		"👍":        true,
+		"❤️":       true,
+		"👨‍👩‍👧":    true,
+		"🇳🇱":       true,
+		"":         false,
+		"+1":       false,
+		"ok":       false,
+		"👍 nice":   false,
+		"é":        false,
+		"<script>": false,
+	} {
+		if isUnicodeEmoji(emoji) != valid {
+			t.Errorf("isUnicodeEmoji(%q) = %v", emoji, !valid)
+		}
+	}
+}
+
+type fakeEmojiStore struct {
+	types.EmojiStore
+}
+
+func (fakeEmojiStore) GetEmojiByName(channelID int, name string) (*types.ChannelEmoji, error) {
+	if name != "party_parrot" {
+		return nil, types.ErrEmojiNotFound
+	}
+	return &types.ChannelEmoji{ChannelID: channelID, Name: name}, nil
+}
+
+func TestValidEmoji(t *testing.T) {
+	h := &Handler{emojiStore: fakeEmojiStore{}}
+	for emoji, valid := range map[string]bool{
+		":party_parrot:":   true,
+		"👍":                true,
+		":unknown:":        false,
+		"::party_parrot::": false,
+		":::party_parrot:": false,
+		":party_parrot":    false,
+		":":                false,
+		"::":               false,
+	} {
+		ok, err := h.validEmoji(1, emoji)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != valid {
+			t.Errorf("validEmoji(%q) = %v", emoji, ok)
+		}
+	}
+}
@@ -2,10 +2,16 @@ package message
 
 import (
 	"database/sql"
+	"errors"
 	"log"
+	"sort"
+	"time"
 	"user/server/services/image"
+	"user/server/services/permissions"
 	"user/server/services/utils"
 	"user/server/types"
+
+	"github.com/lib/pq"
 )
 
 type Store struct {
@@ -16,29 +22,96 @@ func NewStore(db *sql.DB) *Store {
 	return &Store{db: db}
 }
 
-func (s *Store) GetMessagesInRoom(roomID int) ([]*types.Message, error) {
+func (s *Store) GetMessagesInRoom(roomID, viewerID int, page types.MessagePage) ([]*types.Message, error) {
+	return s.getMessages("RoomID = $1 AND ParentID IS NULL", roomID, viewerID, page)
+}
+
+func (s *Store) GetThread(parentID, viewerID int, page types.MessagePage) ([]*types.Message, error) {
+	return s.getMessages("ParentID = $1", parentID, viewerID, page)
+}
+
+// threadSummary aggregates the replies to m, which must be in scope.
+const threadSummary = `
+	SELECT COUNT(*) AS ReplyCount, MAX(r.Timestamp) AS LastReplyAt,
+		COALESCE(json_agg(DISTINCT r.SenderID), '[]') AS Participants
+	FROM Messages r
+	WHERE r.ParentID = m.ID AND r.DeletedAt IS NULL`
+
+// getMessages returns a page of the messages matching filter, whose only
+// parameter is $1. Reactions are flagged when viewerID made them.
+func (s *Store) getMessages(filter string, id, viewerID int, page types.MessagePage) ([]*types.Message, error) {
+	// Pick the page first so the aggregations only run on its rows.
+	args := []any{id, page.Limit + 1, viewerID}
+	order := "DESC"
+	switch {
+	case page.Before != nil:
+		filter += " AND (Timestamp, ID) < ($4, $5)"
+		args = append(args, page.Before.Timestamp, page.Before.ID)
+	case page.After != nil:
+		filter, order = filter+" AND (Timestamp, ID) > ($4, $5)", "ASC"
+		args = append(args, page.After.Timestamp, page.After.ID)
+	}
+
 	rows, err := s.db.Query(`
+		WITH page AS (
+			SELECT ID FROM Messages
+			WHERE `+filter+`
+			ORDER BY Timestamp `+order+`, ID `+order+`
+			LIMIT $2
+		)
 		SELECT 
 			m.ID, 
 			m.RoomID, 
 			m.SenderID, 
+			m.ParentID,
 			m.Content, 
 			m.Timestamp, 
 			m.IsRead, 
+			m.EditedAt,
+			m.DeletedAt,
 			u.Avatar, 
 			u.Username,
-			COALESCE(json_agg(json_build_object(
-			'avatar', encode(su.Avatar, 'base64'),
-			'username', su.Username)) FILTER (WHERE su.ID IS NOT NULL), '[]') as SeenBy
-		FROM Messages m
+			seen.SeenBy,
+			thread.ReplyCount,
+			thread.LastReplyAt,
+			thread.Participants,
+			reactions.Reactions,
+			attachments.Attachments
+		FROM page
+		JOIN Messages m ON m.ID = page.ID
 		JOIN Users u ON u.ID = m.SenderID
-		LEFT JOIN SeenMessages sm ON sm.message_id = m.ID
-		LEFT JOIN Users su ON su.ID = sm.user_id
-		WHERE m.RoomID = $1
-		GROUP BY m.ID, u.Avatar, u.Username
-	`, roomID)
+		LEFT JOIN LATERAL (
+			SELECT COALESCE(json_agg(json_build_object(
+			'avatar', encode(su.Avatar, 'base64'),
+			'username', su.Username)), '[]') AS SeenBy
+			FROM ReadPointers rp
+			JOIN Users su ON su.ID = rp.UserID
+			WHERE rp.RoomID = m.RoomID AND rp.LastReadMessageID = m.ID
+		) seen ON true
+		LEFT JOIN LATERAL (`+threadSummary+`) thread ON true
+		LEFT JOIN LATERAL (
+			SELECT COALESCE(json_agg(json_build_object(
+			'emoji', Emoji, 'count', Count, 'me', Me) ORDER BY FirstAt), '[]') AS Reactions
+			FROM (
+				SELECT Emoji, COUNT(*) AS Count, bool_or(UserID = $3) AS Me, MIN(CreatedAt) AS FirstAt
+				FROM MessageReactions
+				WHERE MessageID = m.ID
+				GROUP BY Emoji
+			) grouped
+		) reactions ON true
+		LEFT JOIN LATERAL (
+			SELECT COALESCE(json_agg(json_build_object(
+			'id', a.ID, 'message_id', a.MessageID, 'room_id', a.RoomID, 'uploader_id', a.UploaderID,
+			'filename', a.Filename, 'content_type', a.ContentType, 'size', a.Size, 'received', a.Received,
+			'completed_at', a.CompletedAt AT TIME ZONE 'UTC', 'created_at', a.CreatedAt AT TIME ZONE 'UTC')
+			ORDER BY a.ID), '[]') AS Attachments
+			FROM Attachments a
+			WHERE a.MessageID = m.ID
+		) attachments ON true
+		ORDER BY m.Timestamp, m.ID
+	`, args...)
 	if err != nil {
-		log.Println("Error getting messages in room: ", err)
+		log.Println("Error getting messages: ", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -47,24 +120,49 @@ func (s *Store) GetMessagesInRoom(roomID int) ([]*types.Message, error) {
 	for rows.Next() {
 		m := &types.Message{}
 		var avatarBytes []byte
-		var seenByJson string
+		var seenByJson, reactionsJson, attachmentsJson string
+		var parentID sql.NullInt64
+		var editedAt, deletedAt sql.NullTime
+		thread := &threadRow{}
 
 		err := rows.Scan(
 			&m.ID,
 			&m.RoomID,
 			&m.SenderID,
+			&parentID,
 			&m.Content,
 			&m.Timestamp,
 			&m.IsRead,
+			&editedAt,
+			&deletedAt,
 			&avatarBytes,
 			&m.SenderName,
-			&seenByJson)
+			&seenByJson,
+			&thread.replyCount,
+			&thread.lastReplyAt,
+			&thread.participants,
+			&reactionsJson,
+			&attachmentsJson)
 		if err != nil {
 			log.Println("Error scanning message row: ", err)
 			return nil, err
 		}
 
 		m.SenderAvatar = image.EncodeB64Image(avatarBytes)
+		m.ParentID = nullableID(parentID)
+		m.EditedAt = nullableTime(editedAt)
+		m.DeletedAt = nullableTime(deletedAt)
+		if m.Thread, err = thread.summary(); err != nil {
+			return nil, err
+		}
+		if err = utils.Unmarshal([]byte(reactionsJson), &m.Reactions); err != nil {
+			log.Println("Error unmarshalling reactions: ", err)
+			return nil, err
+		}
+		if err = utils.Unmarshal([]byte(attachmentsJson), &m.Attachments); err != nil {
+			log.Println("Error unmarshalling attachments: ", err)
+			return nil, err
+		}
 
 		var seenBy []struct {
 			Avatar   []byte `json:"avatar"`
@@ -86,30 +184,400 @@ func (s *Store) GetMessagesInRoom(roomID int) ([]*types.Message, error) {
 		messages = append(messages, m)
 	}
 
-	return messages, nil
+	return messages, rows.Err()
+}
+
+type threadRow struct {
+	replyCount   int
+	lastReplyAt  sql.NullTime
+	participants string
+}
+
+// summary returns nil for messages without replies.
+func (t *threadRow) summary() (*types.ThreadSummary, error) {
+	if t.replyCount == 0 {
+		return nil, nil
+	}
+	summary := &types.ThreadSummary{
+		ReplyCount:  t.replyCount,
+		LastReplyAt: nullableTime(t.lastReplyAt),
+	}
+	err := utils.Unmarshal([]byte(t.participants), &summary.ParticipantIDs)
+	if err != nil {
+		log.Println("Error unmarshalling thread participants: ", err)
+		return nil, err
+	}
+	return summary, nil
+}
+
+func (s *Store) GetThreadSummary(parentID int) (*types.ThreadSummary, error) {
+	thread := &threadRow{}
+	err := s.db.QueryRow(`SELECT thread.* FROM Messages m, LATERAL (`+threadSummary+`) thread
+                          WHERE m.ID = $1`, parentID).
+		Scan(&thread.replyCount, &thread.lastReplyAt, &thread.participants)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrMessageNotFound
+	}
+	if err != nil {
+		log.Println("Error getting thread summary: ", err)
+		return nil, err
+	}
+
+	summary, err := thread.summary()
+	if summary == nil && err == nil {
+		summary = &types.ThreadSummary{ParticipantIDs: make([]int, 0)}
+	}
+	return summary, err
+}
+
+func (s *Store) GetMessage(messageID int) (*types.Message, error) {
+	m, err := scanMessage(s.db.QueryRow(`SELECT `+messageColumns+` FROM Messages WHERE ID = $1`, messageID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrMessageNotFound
+	}
+	if err != nil {
+		log.Println("Error getting message: ", err)
+		return nil, err
+	}
+	return m, nil
+}
+
+const messageColumns = "ID, RoomID, SenderID, ParentID, Content, Timestamp, IsRead, EditedAt, DeletedAt"
+
+func scanMessage(row *sql.Row) (*types.Message, error) {
+	m := &types.Message{}
+	var parentID sql.NullInt64
+	var editedAt, deletedAt sql.NullTime
+	err := row.Scan(&m.ID, &m.RoomID, &m.SenderID, &parentID, &m.Content, &m.Timestamp, &m.IsRead,
+		&editedAt, &deletedAt)
+	if err != nil {
+		return nil, err
+	}
+	m.ParentID = nullableID(parentID)
+	m.EditedAt = nullableTime(editedAt)
+	m.DeletedAt = nullableTime(deletedAt)
+	return m, nil
+}
+
+func nullableID(id sql.NullInt64) *int {
+	if !id.Valid {
+		return nil
+	}
+	v := int(id.Int64)
+	return &v
+}
+
+func nullableTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
 }
 
 func (s *Store) CreateMessage(m *types.Message) error {
-	err := s.db.QueryRow("INSERT INTO Messages (RoomID, SenderID, Content, IsRead) VALUES ($1, $2, $3, $4) RETURNING ID",
-		m.RoomID, m.SenderID, m.Content, false).Scan(&m.ID)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`INSERT INTO Messages (RoomID, SenderID, ParentID, Content, IsRead, Nonce)
+                       VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+                       ON CONFLICT (SenderID, Nonce) DO NOTHING
+                       RETURNING ID, Timestamp`,
+		m.RoomID, m.SenderID, m.ParentID, m.Content, false, m.Nonce).Scan(&m.ID, &m.Timestamp)
+	if errors.Is(err, sql.ErrNoRows) {
+		return duplicateMessage(tx, m)
+	}
 	if err != nil {
 		log.Println("Error creating message: ", err)
 		return err
 	}
-	return nil
+
+	if len(m.AttachmentIDs) > 0 {
+		if m.Attachments, err = linkAttachments(tx, m); err != nil {
+			return err
+		}
+		m.AttachmentIDs = nil
+	}
+	return tx.Commit()
 }
 
-// TODO: change to use websocket to do this kind of update
-func (s *Store) MarkMessageAsSeen(userID int, messageID int) error {
-	_, err := s.db.Exec(`
-        INSERT INTO SeenMessages (message_id, user_id)
-        VALUES ($1, $2)
-        ON CONFLICT (message_id, user_id) DO NOTHING
-    `, messageID, userID)
+// duplicateMessage fills in the message stored the first time its nonce
+// was sent.
+func duplicateMessage(tx *sql.Tx, m *types.Message) error {
+	var roomID int
+	err := tx.QueryRow(`SELECT ID, RoomID, Timestamp FROM Messages WHERE SenderID = $1 AND Nonce = $2`,
+		m.SenderID, m.Nonce).Scan(&m.ID, &roomID, &m.Timestamp)
 	if err != nil {
-		log.Println("Error marking message as seen:", err)
+		log.Println("Error getting duplicate message: ", err)
 		return err
 	}
+	if roomID != m.RoomID {
+		return types.ErrNonceReused
+	}
+	return types.ErrDuplicateMessage
+}
+
+func linkAttachments(tx *sql.Tx, m *types.Message) ([]*types.Attachment, error) {
+	rows, err := tx.Query(`
+		UPDATE Attachments SET MessageID = $1
+		WHERE ID = ANY($2) AND RoomID = $3 AND UploaderID = $4 AND MessageID IS NULL AND CompletedAt IS NOT NULL
+		RETURNING ID, MessageID, RoomID, UploaderID, Filename, ContentType, Size, Received, CompletedAt, CreatedAt`,
+		m.ID, pq.Array(m.AttachmentIDs), m.RoomID, m.SenderID)
+	if err != nil {
+		log.Println("Error linking attachments: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := make([]*types.Attachment, 0, len(m.AttachmentIDs))
+	for rows.Next() {
+		a := &types.Attachment{}
+		err := rows.Scan(&a.ID, &a.MessageID, &a.RoomID, &a.UploaderID, &a.Filename, &a.ContentType, &a.Size,
+			&a.Received, &a.CompletedAt, &a.CreatedAt)
+		if err != nil {
+			log.Println("Error scanning attachment: ", err)
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(attachments) != len(m.AttachmentIDs) {
+		return nil, types.ErrAttachmentNotFound
+	}
+	sort.Slice(attachments, func(i, j int) bool { return attachments[i].ID < attachments[j].ID })
+	return attachments, nil
+}
+
+func (s *Store) EditMessage(messageID int, content string) (*types.Message, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
-	return err
+	_, err = tx.Exec(`
+		INSERT INTO MessageEdits (MessageID, Content)
+		SELECT ID, COALESCE(Content, '') FROM Messages WHERE ID = $1 AND DeletedAt IS NULL`, messageID)
+	if err != nil {
+		log.Println("Error saving message edit: ", err)
+		return nil, err
+	}
+
+	m, err := scanMessage(tx.QueryRow(`
+		UPDATE Messages SET Content = $1, EditedAt = CURRENT_TIMESTAMP
+		WHERE ID = $2 AND DeletedAt IS NULL
+		RETURNING `+messageColumns, content, messageID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrMessageNotFound
+	}
+	if err != nil {
+		log.Println("Error editing message: ", err)
+		return nil, err
+	}
+	return m, tx.Commit()
+}
+
+func (s *Store) GetMessageEdits(messageID int) ([]*types.MessageEdit, error) {
+	rows, err := s.db.Query(`
+		SELECT MessageID, Content, EditedAt FROM MessageEdits
+		WHERE MessageID = $1 ORDER BY EditedAt, ID`, messageID)
+	if err != nil {
+		log.Println("Error getting message edits: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	edits := make([]*types.MessageEdit, 0)
+	for rows.Next() {
+		e := &types.MessageEdit{}
+		if err := rows.Scan(&e.MessageID, &e.Content, &e.EditedAt); err != nil {
+			log.Println("Error scanning message edit: ", err)
+			return nil, err
+		}
+		edits = append(edits, e)
+	}
+	return edits, rows.Err()
+}
+
+func (s *Store) SoftDeleteMessage(messageID int) (*types.Message, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	m, err := scanMessage(tx.QueryRow(`
+		UPDATE Messages SET Content = '', DeletedAt = CURRENT_TIMESTAMP
+		WHERE ID = $1 AND DeletedAt IS NULL
+		RETURNING `+messageColumns, messageID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrMessageNotFound
+	}
+	if err != nil {
+		log.Println("Error deleting message: ", err)
+		return nil, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM MessageEdits WHERE MessageID = $1`, messageID)
+	if err != nil {
+		log.Println("Error deleting message edits: ", err)
+		return nil, err
+	}
+
+	_, err = tx.Exec(`UPDATE Attachments SET MessageID = NULL WHERE MessageID = $1`, messageID)
+	if err != nil {
+		log.Println("Error unlinking attachments: ", err)
+		return nil, err
+	}
+	return m, tx.Commit()
+}
+
+func (s *Store) DeleteMessage(messageID int) ([]int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println("Error starting transaction: ", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT ID FROM Messages WHERE ParentID = $1 FOR UPDATE`, messageID)
+	if err != nil {
+		log.Println("Error getting replies: ", err)
+		return nil, err
+	}
+	replyIDs := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			log.Println("Error scanning reply: ", err)
+			return nil, err
+		}
+		replyIDs = append(replyIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The replies go with it through ON DELETE CASCADE.
+	res, err := tx.Exec(`DELETE FROM Messages WHERE ID = $1`, messageID)
+	if err != nil {
+		log.Println("Error deleting message: ", err)
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, types.ErrMessageNotFound
+	}
+	return replyIDs, tx.Commit()
+}
+
+// AddReaction reports whether the reaction is new.
+func (s *Store) AddReaction(messageID, userID int, emoji string) (bool, error) {
+	res, err := s.db.Exec(`
+		INSERT INTO MessageReactions (MessageID, UserID, Emoji) VALUES ($1, $2, $3)
+		ON CONFLICT (MessageID, UserID, Emoji) DO NOTHING`, messageID, userID, emoji)
+	if err != nil {
+		log.Println("Error adding reaction: ", err)
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (s *Store) RemoveReaction(messageID, userID int, emoji string) error {
+	res, err := s.db.Exec(`DELETE FROM MessageReactions WHERE MessageID = $1 AND UserID = $2 AND Emoji = $3`,
+		messageID, userID, emoji)
+	if err != nil {
+		log.Println("Error removing reaction: ", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return types.ErrReactionNotFound
+	}
+	return nil
+}
+
+func (s *Store) MarkRead(userID, roomID, messageID int) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		INSERT INTO ReadPointers (UserID, RoomID, LastReadMessageID)
+		SELECT $1, $2, $3 WHERE EXISTS (SELECT 1 FROM Messages WHERE ID = $3 AND RoomID = $2)
+		ON CONFLICT (UserID, RoomID) DO UPDATE
+		SET LastReadMessageID = EXCLUDED.LastReadMessageID, UpdatedAt = CURRENT_TIMESTAMP
+		WHERE ReadPointers.LastReadMessageID < EXCLUDED.LastReadMessageID`, userID, roomID, messageID)
+	if err != nil {
+		log.Println("Error moving read pointer: ", err)
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+
+	_, err = tx.Exec(`UPDATE Notifications SET ReadAt = CURRENT_TIMESTAMP
+	                  WHERE UserID = $1 AND RoomID = $2 AND MessageID <= $3 AND ReadAt IS NULL`,
+		userID, roomID, messageID)
+	if err != nil {
+		log.Println("Error marking notifications read: ", err)
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// unreadCounts selects the rooms the user can read with their unread
+// messages and mentions, leaving out rooms that have neither. Its
+// parameters are permissions.VisibleRoomsArgs.
+const unreadCounts = `
+	WITH visible AS (` + permissions.VisibleRooms + `)
+	SELECT r.ID, COALESCE(r.ChannelID, 0), unread.Count, mentions.Count
+	FROM visible
+	JOIN Rooms r ON r.ID = visible.ID
+	LEFT JOIN ReadPointers rp ON rp.RoomID = r.ID AND rp.UserID = $1
+	CROSS JOIN LATERAL (
+		SELECT COUNT(*) AS Count FROM Messages m
+		WHERE m.RoomID = r.ID AND m.ParentID IS NULL AND m.DeletedAt IS NULL
+		AND m.SenderID <> $1 AND m.ID > COALESCE(rp.LastReadMessageID, 0)
+	) unread
+	CROSS JOIN LATERAL (
+		SELECT COUNT(*) AS Count FROM Notifications n
+		WHERE n.UserID = $1 AND n.RoomID = r.ID AND n.ReadAt IS NULL
+	) mentions
+	WHERE (unread.Count > 0 OR mentions.Count > 0)`
+
+func (s *Store) GetUnreadCounts(userID int) ([]*types.UnreadCount, error) {
+	return s.getUnreadCounts(unreadCounts, permissions.VisibleRoomsArgs(userID)...)
+}
+
+func (s *Store) GetChannelUnreadCounts(userID, channelID int) ([]*types.UnreadCount, error) {
+	return s.getUnreadCounts(unreadCounts+` AND r.ChannelID = $4`,
+		append(permissions.VisibleRoomsArgs(userID), channelID)...)
+}
+
+func (s *Store) getUnreadCounts(query string, args ...any) ([]*types.UnreadCount, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		log.Println("Error getting unread counts: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]*types.UnreadCount, 0)
+	for rows.Next() {
+		c := &types.UnreadCount{}
+		if err := rows.Scan(&c.RoomID, &c.ChannelID, &c.UnreadCount, &c.MentionCount); err != nil {
+			log.Println("Error scanning unread count: ", err)
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
 }
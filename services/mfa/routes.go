@@ -0,0 +1,260 @@
+package mfa
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"user/server/services/auth"
+	"user/server/services/utils"
+	"user/server/types"
+
+	"github.com/gorilla/mux"
+)
+
+const totpIssuer = "Go Chat App"
+
+type Handler struct {
+	store     types.MFAStore
+	userStore types.UserStore
+	limiter   *auth.LoginLimiter
+}
+
+// NewHandler counts wrong codes against the same limiter as logins, so a
+// session can't be used to guess them either.
+func NewHandler(store types.MFAStore, userStore types.UserStore, limiter *auth.LoginLimiter) *Handler {
+	return &Handler{store: store, userStore: userStore, limiter: limiter}
+}
+
+func (h *Handler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/mfa", utils.CorsHandler(
+		auth.WithSessionAuth(h.GetStatusHandler, h.userStore),
+	)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/mfa/enroll", utils.CorsHandler(
+		auth.WithSessionAuth(h.EnrollHandler, h.userStore),
+	)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/mfa/confirm", utils.CorsHandler(
+		auth.WithSessionAuth(h.ConfirmHandler, h.userStore),
+	)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/mfa/disable", utils.CorsHandler(
+		auth.WithSessionAuth(h.DisableHandler, h.userStore),
+	)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/mfa/recovery-codes", utils.CorsHandler(
+		auth.WithSessionAuth(h.RegenerateRecoveryCodesHandler, h.userStore),
+	)).Methods("POST", "OPTIONS")
+}
+
+func (h *Handler) GetStatusHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+
+	mfa, err := h.store.GetMFA(user.ID)
+	if errors.Is(err, types.ErrMFANotFound) {
+		utils.SendJSONResponse(w, http.StatusOK, types.MFAStatusResponse{})
+		return
+	}
+	if err != nil {
+		handleError(w, "Error getting MFA status", http.StatusInternalServerError, err)
+		return
+	}
+
+	remaining := 0
+	if mfa.Enabled {
+		remaining, err = h.store.CountRecoveryCodes(user.ID)
+		if err != nil {
+			handleError(w, "Error getting MFA status", http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, types.MFAStatusResponse{
+		Enabled:                mfa.Enabled,
+		RemainingRecoveryCodes: remaining,
+	})
+}
+
+func (h *Handler) EnrollHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+
+	mfa, err := h.store.GetMFA(user.ID)
+	if err == nil && mfa.Enabled {
+		handleError(w, "MFA is already enabled", http.StatusConflict, nil)
+		return
+	}
+	if err != nil && !errors.Is(err, types.ErrMFANotFound) {
+		handleError(w, "Error enrolling MFA", http.StatusInternalServerError, err)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		handleError(w, "Error enrolling MFA", http.StatusInternalServerError, err)
+		return
+	}
+
+	err = h.store.SavePendingSecret(user.ID, secret)
+	if err != nil {
+		handleError(w, "Error enrolling MFA", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, types.MFAEnrollResponse{
+		Secret:          secret,
+		ProvisioningURI: auth.TOTPProvisioningURI(totpIssuer, user.Username, secret),
+	})
+}
+
+// ConfirmHandler enables MFA once the user shows a code from the newly
+// enrolled authenticator, and returns the recovery codes exactly once.
+func (h *Handler) ConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+
+	payload := &types.MFACodePayload{}
+	if err := utils.ParseJSON(r, payload); err != nil {
+		handleError(w, "Invalid request payload", http.StatusBadRequest, err)
+		return
+	}
+
+	mfa, err := h.store.GetMFA(user.ID)
+	if errors.Is(err, types.ErrMFANotFound) {
+		handleError(w, "MFA enrollment not started", http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		handleError(w, "Error confirming MFA", http.StatusInternalServerError, err)
+		return
+	}
+	if mfa.Enabled {
+		handleError(w, "MFA is already enabled", http.StatusConflict, nil)
+		return
+	}
+
+	ok, err := auth.VerifyMFACode(h.store, mfa, payload.Code)
+	if err != nil {
+		handleError(w, "Error confirming MFA", http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		handleError(w, "Invalid code", http.StatusUnauthorized, nil)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		handleError(w, "Error confirming MFA", http.StatusInternalServerError, err)
+		return
+	}
+
+	err = h.store.EnableMFA(user.ID, hashes)
+	if err != nil {
+		handleError(w, "Error confirming MFA", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, types.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (h *Handler) DisableHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+
+	if !h.verifyEnabledCode(w, r, user) {
+		return
+	}
+
+	err := h.store.DisableMFA(user.ID)
+	if err != nil {
+		handleError(w, "Error disabling MFA", http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) RegenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+
+	if !h.verifyEnabledCode(w, r, user) {
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		handleError(w, "Error generating recovery codes", http.StatusInternalServerError, err)
+		return
+	}
+
+	err = h.store.ReplaceRecoveryCodes(user.ID, hashes)
+	if err != nil {
+		handleError(w, "Error generating recovery codes", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, types.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// verifyEnabledCode guards changes to an enabled MFA setup behind a fresh
+// code, so a stolen session alone can't turn MFA off. Wrong codes count
+// towards the account's login lockout.
+func (h *Handler) verifyEnabledCode(w http.ResponseWriter, r *http.Request, user *types.User) bool {
+	payload := &types.MFACodePayload{}
+	if err := utils.ParseJSON(r, payload); err != nil {
+		handleError(w, "Invalid request payload", http.StatusBadRequest, err)
+		return false
+	}
+
+	wait, err := h.limiter.Check(r, user.Username)
+	if err != nil {
+		handleError(w, "Internal server error", http.StatusInternalServerError, err)
+		return false
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		handleError(w, "Too many failed attempts, try again later", http.StatusTooManyRequests, nil)
+		return false
+	}
+
+	mfa, err := h.store.GetMFA(user.ID)
+	if errors.Is(err, types.ErrMFANotFound) || (err == nil && !mfa.Enabled) {
+		handleError(w, "MFA is not enabled", http.StatusBadRequest, err)
+		return false
+	}
+	if err != nil {
+		handleError(w, "Error getting MFA settings", http.StatusInternalServerError, err)
+		return false
+	}
+
+	ok, err := auth.VerifyMFACode(h.store, mfa, payload.Code)
+	if err != nil {
+		handleError(w, "Error verifying code", http.StatusInternalServerError, err)
+		return false
+	}
+	if !ok {
+		h.limiter.Fail(r, user.Username, &user.ID, "invalid mfa code")
+		handleError(w, "Invalid code", http.StatusUnauthorized, nil)
+		return false
+	}
+	h.limiter.Succeed(user.Username)
+	return true
+}
+
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashRecoveryCode(code)
+	}
+	return codes, hashes, nil
+}
+
+func handleError(w http.ResponseWriter, message string, statusCode int, err error) {
+	if err != nil {
+		log.Println(message, err)
+	} else {
+		log.Println(message)
+	}
+	http.Error(w, message, statusCode)
+}
@@ -0,0 +1,53 @@
+package mfa
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"user/server/services/auth"
+	"user/server/services/loginattempt"
+	"user/server/types"
+)
+
+type fakeStore struct {
+	types.MFAStore
+	recoveryCode string
+	disabled     bool
+}
+
+func (s *fakeStore) GetMFA(userID int) (*types.UserMFA, error) {
+	return &types.UserMFA{UserID: userID, Enabled: true}, nil
+}
+
+func (s *fakeStore) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	return codeHash == auth.HashRecoveryCode(s.recoveryCode), nil
+}
+
+func (s *fakeStore) DisableMFA(userID int) error {
+	s.disabled = true
+	return nil
+}
+
+func TestDisableLimitsGuesses(t *testing.T) {
+	store := &fakeStore{recoveryCode: "right-code"}
+	h := NewHandler(store, nil, auth.NewLoginLimiter(loginattempt.NewMemoryStore()))
+	disable := func(code string) int {
+		r := httptest.NewRequest("POST", "/mfa/disable", bytes.NewBufferString(`{"code":"`+code+`"}`))
+		r = r.WithContext(context.WithValue(r.Context(), auth.UserKey,
+			&types.User{ID: 1, Username: "jane@example.com"}))
+		res := httptest.NewRecorder()
+		h.DisableHandler(res, r)
+		return res.Code
+	}
+
+	for i := 0; i < auth.AccountLockoutPolicy.Threshold; i++ {
+		if code := disable("wrong-code"); code != http.StatusUnauthorized {
+			t.Fatalf("guess %d returned %d", i+1, code)
+		}
+	}
+	if code := disable("right-code"); code != http.StatusTooManyRequests || store.disabled {
+		t.Errorf("locked out account returned %d, disabled %v", code, store.disabled)
+	}
+}
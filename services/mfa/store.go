@@ -0,0 +1,208 @@
+package mfa
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"user/server/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) GetMFA(userID int) (*types.UserMFA, error) {
+	mfa := &types.UserMFA{}
+	err := s.db.QueryRow(`
+		SELECT UserID, Secret, Enabled, LastUsedStep, CreatedAt, EnabledAt
+		FROM UserMFA WHERE UserID = $1`, userID).Scan(
+		&mfa.UserID, &mfa.Secret, &mfa.Enabled, &mfa.LastUsedStep,
+		&mfa.CreatedAt, &mfa.EnabledAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrMFANotFound
+	}
+	if err != nil {
+		log.Println("Error getting MFA settings: ", err)
+		return nil, err
+	}
+	return mfa, nil
+}
+
+// SavePendingSecret starts (or restarts) enrollment. It never touches a
+// secret that is already enabled.
+func (s *Store) SavePendingSecret(userID int, secret string) error {
+	result, err := s.db.Exec(`
+		INSERT INTO UserMFA (UserID, Secret)
+		VALUES ($1, $2)
+		ON CONFLICT (UserID) DO UPDATE
+		SET Secret = EXCLUDED.Secret, LastUsedStep = 0, CreatedAt = CURRENT_TIMESTAMP
+		WHERE UserMFA.Enabled = FALSE`, userID, secret)
+	if err != nil {
+		log.Println("Error saving MFA secret: ", err)
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return errors.New("mfa already enabled")
+	}
+	return nil
+}
+
+func (s *Store) EnableMFA(userID int, recoveryCodeHashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println("Error starting transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE UserMFA SET Enabled = TRUE, EnabledAt = CURRENT_TIMESTAMP
+		WHERE UserID = $1`, userID)
+	if err != nil {
+		log.Println("Error enabling MFA: ", err)
+		return err
+	}
+
+	err = replaceRecoveryCodes(tx, userID, recoveryCodeHashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) DisableMFA(userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println("Error starting transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM MFARecoveryCodes WHERE UserID = $1", userID)
+	if err != nil {
+		log.Println("Error deleting recovery codes: ", err)
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM UserMFA WHERE UserID = $1", userID)
+	if err != nil {
+		log.Println("Error disabling MFA: ", err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) UseTOTPStep(userID int, step int64) (bool, error) {
+	result, err := s.db.Exec(`
+		UPDATE UserMFA SET LastUsedStep = $2
+		WHERE UserID = $1 AND LastUsedStep < $2`, userID, step)
+	if err != nil {
+		log.Println("Error recording TOTP step: ", err)
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+func (s *Store) ReplaceRecoveryCodes(userID int, recoveryCodeHashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println("Error starting transaction: ", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	err = replaceRecoveryCodes(tx, userID, recoveryCodeHashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	result, err := s.db.Exec(`
+		UPDATE MFARecoveryCodes SET UsedAt = CURRENT_TIMESTAMP
+		WHERE UserID = $1 AND CodeHash = $2 AND UsedAt IS NULL`, userID, codeHash)
+	if err != nil {
+		log.Println("Error using recovery code: ", err)
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+func (s *Store) CountRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM MFARecoveryCodes
+		WHERE UserID = $1 AND UsedAt IS NULL`, userID).Scan(&count)
+	if err != nil {
+		log.Println("Error counting recovery codes: ", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *Store) CreateMFAChallenge(challenge *types.MFAChallenge) error {
+	err := s.db.QueryRow(`
+		INSERT INTO MFAChallenges (TokenID, UserID, ExpiresAt)
+		VALUES ($1, $2, $3)
+		RETURNING CreatedAt`,
+		challenge.TokenID, challenge.UserID, challenge.ExpiresAt).Scan(&challenge.CreatedAt)
+	if err != nil {
+		log.Println("Error creating MFA challenge: ", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) UseMFAChallenge(tokenID string, userID int) error {
+	result, err := s.db.Exec(`
+		UPDATE MFAChallenges SET UsedAt = CURRENT_TIMESTAMP
+		WHERE TokenID = $1 AND UserID = $2
+		AND UsedAt IS NULL AND ExpiresAt > CURRENT_TIMESTAMP`, tokenID, userID)
+	if err != nil {
+		log.Println("Error using MFA challenge: ", err)
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return types.ErrMFAChallengeNotFound
+	}
+	return nil
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int, recoveryCodeHashes []string) error {
+	_, err := tx.Exec("DELETE FROM MFARecoveryCodes WHERE UserID = $1", userID)
+	if err != nil {
+		log.Println("Error deleting recovery codes: ", err)
+		return err
+	}
+
+	for _, codeHash := range recoveryCodeHashes {
+		_, err = tx.Exec(`
+			INSERT INTO MFARecoveryCodes (UserID, CodeHash)
+			VALUES ($1, $2)`, userID, codeHash)
+		if err != nil {
+			log.Println("Error creating recovery code: ", err)
+			return err
+		}
+	}
+	return nil
+}
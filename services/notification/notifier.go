@@ -0,0 +1,127 @@
+package notification
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+	"user/server/services/hub"
+	"user/server/services/utils"
+	"user/server/types"
+)
+
+// Usernames are email addresses or bot names, so a mention runs up to the
+// next character that can't be part of either.
+var mentionPattern = regexp.MustCompile(`(?:^|[\s(])@([\w.%+\-]+(?:@[\w\-]+(?:\.[\w\-]+)+)?)`)
+
+// maxMentions caps how many users one message can mention by name.
+const maxMentions = 20
+
+type mentions struct {
+	usernames []string
+	room      bool
+	channel   bool
+}
+
+func parseMentions(content string) mentions {
+	var m mentions
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		name := strings.ToLower(strings.TrimRight(match[1], ".-"))
+		switch {
+		case name == "room":
+			m.room = true
+		case name == "channel":
+			m.channel = true
+		case name != "" && !seen[name] && len(m.usernames) < maxMentions:
+			seen[name] = true
+			m.usernames = append(m.usernames, name)
+		}
+	}
+	return m
+}
+
+type Notifier struct {
+	store           types.NotificationStore
+	permissionStore types.PermissionsStore
+}
+
+func NewNotifier(store types.NotificationStore, permissionStore types.PermissionsStore) *Notifier {
+	return &Notifier{store: store, permissionStore: permissionStore}
+}
+
+// NotifyMentions stores a notification for everyone the message mentions
+// who can read its room, and pushes it to their open connections. @room
+// and @channel only count when the author has PermMentionEveryone; they
+// reach every member who can read the room. Users already notified about
+// the message, such as when it is edited, are skipped.
+func (n *Notifier) NotifyMentions(msg *types.Message, channelID int) {
+	found := parseMentions(msg.Content)
+	kinds := make(map[int]string)
+
+	if len(found.usernames) > 0 {
+		ids, err := n.store.GetMentionedMembers(channelID, found.usernames)
+		if err != nil {
+			return
+		}
+		for _, id := range ids {
+			kinds[id] = types.NotificationMention
+		}
+	}
+
+	if found.room || found.channel {
+		author, err := n.permissionStore.GetChannelMember(msg.SenderID, channelID)
+		if err == nil && author.Can(types.PermMentionEveryone) {
+			ids, err := n.store.GetChannelMemberIDs(channelID)
+			if err != nil {
+				return
+			}
+			kind := types.NotificationRoom
+			if found.channel {
+				kind = types.NotificationChannel
+			}
+			for _, id := range ids {
+				if _, ok := kinds[id]; !ok {
+					kinds[id] = kind
+				}
+			}
+		}
+	}
+	delete(kinds, msg.SenderID)
+
+	for userID, kind := range kinds {
+		member, err := n.permissionStore.GetRoomMember(userID, msg.RoomID)
+		if err != nil || !member.CanAccess {
+			continue
+		}
+
+		notification := &types.Notification{
+			UserID:    userID,
+			MessageID: msg.ID,
+			RoomID:    msg.RoomID,
+			ChannelID: channelID,
+			ActorID:   msg.SenderID,
+			ActorName: msg.SenderName,
+			Kind:      kind,
+			Excerpt:   excerpt(msg.Content),
+		}
+		created, err := n.store.CreateNotification(notification)
+		if err != nil || !created {
+			continue
+		}
+
+		if hub.HubInstance != nil {
+			hub.HubInstance.SendToUser(strconv.Itoa(userID), utils.Marshal(types.NotificationMessage{
+				Type:    "notification",
+				Payload: notification,
+			}))
+		}
+	}
+}
+
+func excerpt(content string) string {
+	if utf8.RuneCountInString(content) <= types.NotificationExcerptLength {
+		return content
+	}
+	return string([]rune(content)[:types.NotificationExcerptLength])
+}
@@ -0,0 +1,113 @@
+package notification
+
+import (
+	"reflect"
+	"testing"
+	"user/server/types"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		content   string
+		usernames []string
+		room      bool
+		channel   bool
+	}{
+		{content: "hi @alice@example.com, see this", usernames: []string{"alice@example.com"}},
+		{content: "@deploy-bot. run it", usernames: []string{"deploy-bot"}},
+		{content: "ping @Room and (@bob@example.org)", usernames: []string{"bob@example.org"}, room: true},
+		{content: "@channel @channel", channel: true},
+		{content: "mail me at carol@example.com", usernames: nil},
+		{content: "@bot @BOT", usernames: []string{"bot"}},
+	}
+
+	for _, tt := range tests {
+		m := parseMentions(tt.content)
+		if !reflect.DeepEqual(m.usernames, tt.usernames) || m.room != tt.room || m.channel != tt.channel {
+			t.Errorf("parseMentions(%q) = %+v", tt.content, m)
+		}
+	}
+}
+
+type fakeStore struct {
+	types.NotificationStore
+	members       map[string]int
+	notifications []*types.Notification
+}
+
+func (s *fakeStore) GetMentionedMembers(channelID int, usernames []string) ([]int, error) {
+	ids := make([]int, 0)
+	for _, username := range usernames {
+		if id, ok := s.members[username]; ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (s *fakeStore) GetChannelMemberIDs(channelID int) ([]int, error) {
+	ids := make([]int, 0)
+	for _, id := range s.members {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (s *fakeStore) CreateNotification(n *types.Notification) (bool, error) {
+	s.notifications = append(s.notifications, n)
+	return true, nil
+}
+
+type fakePermissionStore struct {
+	types.PermissionsStore
+	roles   map[int]string
+	blocked map[int]bool
+}
+
+func (s *fakePermissionStore) GetChannelMember(userID, channelID int) (*types.ChannelMember, error) {
+	return &types.ChannelMember{
+		UserID:      userID,
+		ChannelID:   channelID,
+		Role:        s.roles[userID],
+		Permissions: types.RolePermissions(s.roles[userID]),
+	}, nil
+}
+
+func (s *fakePermissionStore) GetRoomMember(userID, roomID int) (*types.RoomMember, error) {
+	member, _ := s.GetChannelMember(userID, 1)
+	return &types.RoomMember{ChannelMember: *member, RoomID: roomID, CanAccess: !s.blocked[userID]}, nil
+}
+
+func TestNotifyMentions(t *testing.T) {
+	newNotifier := func() (*Notifier, *fakeStore) {
+		store := &fakeStore{members: map[string]int{"mod": 1, "alice": 2, "bob": 3, "carol": 4}}
+		permissions := &fakePermissionStore{
+			roles:   map[int]string{1: types.RoleModerator, 2: types.RoleMember, 3: types.RoleMember, 4: types.RoleMember},
+			blocked: map[int]bool{4: true},
+		}
+		return NewNotifier(store, permissions), store
+	}
+	notified := func(store *fakeStore) map[int]string {
+		kinds := make(map[int]string)
+		for _, n := range store.notifications {
+			kinds[n.UserID] = n.Kind
+		}
+		return kinds
+	}
+
+	// Members can't notify everyone, and nobody is notified about a room
+	// they can't read.
+	n, store := newNotifier()
+	n.NotifyMentions(&types.Message{ID: 1, RoomID: 9, SenderID: 2, Content: "@bob @carol @channel"}, 1)
+	if got := notified(store); !reflect.DeepEqual(got, map[int]string{3: types.NotificationMention}) {
+		t.Errorf("member mention notified %v", got)
+	}
+
+	// Moderators can, and the author is left out.
+	n, store = newNotifier()
+	n.NotifyMentions(&types.Message{ID: 2, RoomID: 9, SenderID: 1, Content: "@alice @room"}, 1)
+	want := map[int]string{2: types.NotificationMention, 3: types.NotificationRoom}
+	if got := notified(store); !reflect.DeepEqual(got, want) {
+		t.Errorf("moderator mention notified %v", got)
+	}
+}
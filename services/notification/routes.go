@@ -0,0 +1,123 @@
+package notification
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"user/server/services/auth"
+	"user/server/services/utils"
+	"user/server/types"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+type Handler struct {
+	store     types.NotificationStore
+	userStore types.UserStore
+}
+
+func NewHandler(store types.NotificationStore, userStore types.UserStore) *Handler {
+	return &Handler{store: store, userStore: userStore}
+}
+
+func (h *Handler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/notifications", utils.CorsHandler(
+		auth.WithJWTAuth(h.GetNotificationsHandler, h.userStore),
+	)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/notifications/read", utils.CorsHandler(
+		auth.WithJWTAuth(h.MarkAllReadHandler, h.userStore),
+	)).Methods("PUT", "OPTIONS")
+	r.HandleFunc("/notifications/{notificationID}/read", utils.CorsHandler(
+		auth.WithJWTAuth(h.MarkReadHandler, h.userStore),
+	)).Methods("PUT", "OPTIONS")
+}
+
+// GetNotificationsHandler lists the caller's notifications, newest first.
+// ?unread=true leaves out read ones, ?before= takes the last ID of the
+// previous page and ?limit= caps the page size.
+func (h *Handler) GetNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	unreadOnly := query.Get("unread") == "true"
+
+	limit := defaultPageSize
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxPageSize {
+			handleError(w, "Invalid limit", http.StatusBadRequest, err)
+			return
+		}
+		limit = n
+	}
+	beforeID := 0
+	if value := query.Get("before"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			handleError(w, "Invalid before", http.StatusBadRequest, err)
+			return
+		}
+		beforeID = n
+	}
+
+	user := auth.GetUserFromContext(r.Context())
+	notifications, err := h.store.GetNotifications(user.ID, unreadOnly, beforeID, limit)
+	if err != nil {
+		handleError(w, "Error getting notifications", http.StatusInternalServerError, err)
+		return
+	}
+	unread, err := h.store.CountUnread(user.ID)
+	if err != nil {
+		handleError(w, "Error getting notifications", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, types.NotificationsResponse{
+		Notifications: notifications,
+		UnreadCount:   unread,
+	})
+}
+
+func (h *Handler) MarkReadHandler(w http.ResponseWriter, r *http.Request) {
+	notificationID, err := strconv.Atoi(mux.Vars(r)["notificationID"])
+	if err != nil {
+		handleError(w, "Invalid notification ID", http.StatusBadRequest, err)
+		return
+	}
+
+	user := auth.GetUserFromContext(r.Context())
+	err = h.store.MarkRead(user.ID, notificationID)
+	if errors.Is(err, types.ErrNotificationNotFound) {
+		handleError(w, "Notification not found", http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		handleError(w, "Error updating notification", http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) MarkAllReadHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if err := h.store.MarkAllRead(user.ID); err != nil {
+		handleError(w, "Error updating notifications", http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleError(w http.ResponseWriter, message string, statusCode int, err error) {
+	if err != nil {
+		log.Println(message, err)
+	} else {
+		log.Println(message)
+	}
+	http.Error(w, message, statusCode)
+}
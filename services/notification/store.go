@@ -0,0 +1,140 @@
+package notification
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"user/server/types"
+
+	"github.com/lib/pq"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) GetMentionedMembers(channelID int, usernames []string) ([]int, error) {
+	lowered := make([]string, len(usernames))
+	for i, username := range usernames {
+		lowered[i] = strings.ToLower(username)
+	}
+	return s.queryIDs(`
+		SELECT u.ID FROM Users u
+		JOIN ChannelsToUsers c ON c.user_id = u.ID
+		WHERE c.channel_id = $1 AND lower(u.Username) = ANY($2)`, channelID, pq.Array(lowered))
+}
+
+func (s *Store) GetChannelMemberIDs(channelID int) ([]int, error) {
+	return s.queryIDs(`SELECT user_id FROM ChannelsToUsers WHERE channel_id = $1`, channelID)
+}
+
+func (s *Store) queryIDs(query string, args ...any) ([]int, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		log.Println("Error getting mentioned users: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			log.Println("Error scanning mentioned user: ", err)
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *Store) CreateNotification(n *types.Notification) (bool, error) {
+	err := s.db.QueryRow(`
+		INSERT INTO Notifications (UserID, MessageID, RoomID, ChannelID, ActorID, Kind)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (UserID, MessageID) DO NOTHING
+		RETURNING ID, CreatedAt`,
+		n.UserID, n.MessageID, n.RoomID, n.ChannelID, n.ActorID, n.Kind).Scan(&n.ID, &n.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		log.Println("Error creating notification: ", err)
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *Store) GetNotifications(userID int, unreadOnly bool, beforeID, limit int) ([]*types.Notification, error) {
+	rows, err := s.db.Query(`
+		SELECT n.ID, n.UserID, n.MessageID, n.RoomID, n.ChannelID, n.ActorID, u.Username,
+			n.Kind, left(COALESCE(m.Content, ''), $5), n.CreatedAt, n.ReadAt
+		FROM Notifications n
+		JOIN Messages m ON m.ID = n.MessageID
+		JOIN Users u ON u.ID = n.ActorID
+		WHERE n.UserID = $1 AND (NOT $2 OR n.ReadAt IS NULL) AND ($3 = 0 OR n.ID < $3)
+		ORDER BY n.ID DESC
+		LIMIT $4`, userID, unreadOnly, beforeID, limit, types.NotificationExcerptLength)
+	if err != nil {
+		log.Println("Error getting notifications: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := make([]*types.Notification, 0)
+	for rows.Next() {
+		n := &types.Notification{}
+		var readAt sql.NullTime
+		err := rows.Scan(&n.ID, &n.UserID, &n.MessageID, &n.RoomID, &n.ChannelID, &n.ActorID, &n.ActorName,
+			&n.Kind, &n.Excerpt, &n.CreatedAt, &readAt)
+		if err != nil {
+			log.Println("Error scanning notification: ", err)
+			return nil, err
+		}
+		if readAt.Valid {
+			n.ReadAt = &readAt.Time
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+func (s *Store) CountUnread(userID int) (int, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM Notifications WHERE UserID = $1 AND ReadAt IS NULL`, userID).
+		Scan(&count)
+	if err != nil {
+		log.Println("Error counting notifications: ", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *Store) MarkRead(userID, notificationID int) error {
+	res, err := s.db.Exec(`
+		UPDATE Notifications SET ReadAt = COALESCE(ReadAt, CURRENT_TIMESTAMP)
+		WHERE ID = $1 AND UserID = $2`, notificationID, userID)
+	if err != nil {
+		log.Println("Error marking notification as read: ", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return types.ErrNotificationNotFound
+	}
+	return nil
+}
+
+func (s *Store) MarkAllRead(userID int) error {
+	_, err := s.db.Exec(`UPDATE Notifications SET ReadAt = CURRENT_TIMESTAMP WHERE UserID = $1 AND ReadAt IS NULL`,
+		userID)
+	if err != nil {
+		log.Println("Error marking notifications as read: ", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+	"user/server/config"
+	"user/server/services/auth"
+	"user/server/types"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+)
+
+var errUnverifiedAccount = errors.New("an account with the email exists but its email isn't verified")
+
+const loginStateCookie = "oidc_login"
+const loginStateTTL = 10 * time.Minute
+
+type Handler struct {
+	store        types.IdentityStore
+	userStore    types.UserStore
+	provider     *provider
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	postLoginURL string
+}
+
+func NewHandler(store types.IdentityStore, userStore types.UserStore, cfg config.Config) *Handler {
+	return &Handler{
+		store:        store,
+		userStore:    userStore,
+		provider:     newProvider(cfg.OIDCIssuerURL),
+		clientID:     cfg.OIDCClientID,
+		clientSecret: cfg.OIDCClientSecret,
+		redirectURL:  cfg.OIDCRedirectURL,
+		postLoginURL: cfg.OIDCPostLoginURL,
+	}
+}
+
+func (h *Handler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/auth/oidc/login", h.LoginHandler).Methods("GET")
+	r.HandleFunc("/auth/oidc/callback", h.CallbackHandler).Methods("GET")
+}
+
+func (h *Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	doc, err := h.provider.getDiscovery()
+	if err != nil {
+		log.Println("Error getting OIDC discovery document: ", err)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	state, err := randomString()
+	if err != nil {
+		log.Println("Error generating OIDC state: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomString()
+	if err != nil {
+		log.Println("Error generating OIDC nonce: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	verifier, err := randomString()
+	if err != nil {
+		log.Println("Error generating PKCE verifier: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The pending login lives in a signed cookie so any replica can finish it.
+	loginState, err := auth.SignToken("oidc-login", loginStateTTL, jwt.MapClaims{
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+	})
+	if err != nil {
+		log.Println("Error signing OIDC login state: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginStateCookie,
+		Value:    loginState,
+		Path:     "/api/v1/auth/oidc",
+		Expires:  time.Now().Add(loginStateTTL),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {h.clientID},
+		"redirect_uri":          {h.redirectURL},
+		"scope":                 {"openid email profile"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	authURL, err := url.Parse(doc.AuthorizationEndpoint)
+	if err != nil {
+		log.Println("Invalid authorization endpoint: ", err)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+	existing := authURL.Query()
+	for key, values := range query {
+		existing[key] = values
+	}
+	authURL.RawQuery = existing.Encode()
+
+	http.Redirect(w, r, authURL.String(), http.StatusFound)
+}
+
+func (h *Handler) CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if providerError := query.Get("error"); providerError != "" {
+		log.Println("OIDC provider returned error: ", providerError, query.Get("error_description"))
+		http.Error(w, "Login was not completed", http.StatusUnauthorized)
+		return
+	}
+
+	cookie, err := r.Cookie(loginStateCookie)
+	if err != nil {
+		log.Println("Missing OIDC login state: ", err)
+		http.Error(w, "Login session expired", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginStateCookie,
+		Value:    "",
+		Path:     "/api/v1/auth/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	loginState, err := auth.ParseToken("oidc-login", cookie.Value)
+	if err != nil {
+		log.Println("Invalid OIDC login state: ", err)
+		http.Error(w, "Login session expired", http.StatusBadRequest)
+		return
+	}
+
+	state, _ := loginState["state"].(string)
+	if state == "" || query.Get("state") != state {
+		log.Println("OIDC state mismatch")
+		http.Error(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		log.Println("OIDC callback without code")
+		http.Error(w, "Missing authorization code", http.StatusBadRequest)
+		return
+	}
+
+	verifier, _ := loginState["verifier"].(string)
+	tokens, err := h.provider.exchangeCode(h.clientID, h.clientSecret, h.redirectURL, code, verifier)
+	if err != nil {
+		log.Println("Error exchanging OIDC code: ", err)
+		http.Error(w, "Could not complete login", http.StatusBadGateway)
+		return
+	}
+
+	claims, err := h.provider.verifyIDToken(tokens.IDToken, h.clientID)
+	if err != nil {
+		log.Println("Invalid OIDC id_token: ", err)
+		http.Error(w, "Could not complete login", http.StatusUnauthorized)
+		return
+	}
+
+	nonce, _ := loginState["nonce"].(string)
+	if nonce == "" || claims.Nonce != nonce {
+		log.Println("OIDC nonce mismatch")
+		http.Error(w, "Could not complete login", http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := h.resolveUser(claims)
+	if err != nil {
+		log.Println("Error resolving OIDC user: ", err)
+		http.Error(w, "Could not complete login", http.StatusForbidden)
+		return
+	}
+
+	session, err := auth.IssueSession(r, userID)
+	if err != nil {
+		log.Println("Error generating JWT token: ", err)
+		http.Error(w, "Error generating JWT token", http.StatusInternalServerError)
+		return
+	}
+
+	auth.SetAuthCookies(w, siteOrigin(h.postLoginURL), session)
+	http.Redirect(w, r, h.postLoginURL, http.StatusFound)
+}
+
+// resolveUser finds the user linked to the identity. The first login links
+// to an existing account whose email is already verified, or creates one.
+// An unverified account may have been registered by someone else with the
+// address, so it has to be linked from a session of its own instead.
+func (h *Handler) resolveUser(claims *idTokenClaims) (int, error) {
+	identity, err := h.store.GetIdentity(h.provider.issuer, claims.Subject)
+	if err == nil {
+		return identity.UserID, nil
+	}
+	if !errors.Is(err, types.ErrIdentityNotFound) {
+		return -1, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return -1, errors.New("identity provider did not return a verified email")
+	}
+	if types.IsBotEmail(claims.Email) {
+		return -1, errors.New("identity provider returned a bot email")
+	}
+
+	userID := -1
+	user, err := h.userStore.GetUserByEmail(claims.Email)
+	switch {
+	case err == nil:
+		if !user.EmailVerified {
+			return -1, errUnverifiedAccount
+		}
+		userID = user.ID
+	case errors.Is(err, types.ErrUserNotFound):
+		// OIDC users have no usable password until they reset it.
+		password, err := randomString()
+		if err != nil {
+			return -1, err
+		}
+		hashedPassword, err := auth.HashPassword(password)
+		if err != nil {
+			return -1, err
+		}
+		userID, err = h.userStore.CreateUser(types.User{
+			Username: claims.Email,
+			Password: hashedPassword,
+		})
+		if err != nil {
+			return -1, err
+		}
+	default:
+		return -1, err
+	}
+
+	// The provider vouched for the address, no need to send our own link.
+	err = h.userStore.SetEmailVerified(userID)
+	if err != nil {
+		return -1, err
+	}
+
+	err = h.store.LinkIdentity(&types.UserIdentity{
+		Issuer:  h.provider.issuer,
+		Subject: claims.Subject,
+		UserID:  userID,
+		Email:   claims.Email,
+	})
+	if err != nil {
+		return -1, err
+	}
+	return userID, nil
+}
+
+func siteOrigin(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
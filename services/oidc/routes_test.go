@@ -0,0 +1,407 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+	"user/server/config"
+	"user/server/services/auth"
+	"user/server/types"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// mockProvider is a minimal OIDC provider: discovery, JWKS and a token
+// endpoint that checks the PKCE verifier before issuing an id_token.
+type mockProvider struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+
+	mu            sync.Mutex
+	challenge     string
+	nonce         string
+	subject       string
+	email         string
+	emailVerified bool
+	audience      interface{}
+}
+
+func newMockProvider(t *testing.T, clientID string) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &mockProvider{key: key, clientID: clientID, audience: clientID}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "mock",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "valid-code" ||
+			base64.RawURLEncoding.EncodeToString(sum[:]) != p.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":            p.server.URL,
+			"sub":            p.subject,
+			"aud":            p.audience,
+			"exp":            time.Now().Add(time.Minute).Unix(),
+			"iat":            time.Now().Unix(),
+			"nonce":          p.nonce,
+			"email":          p.email,
+			"email_verified": p.emailVerified,
+		})
+		token.Header["kid"] = "mock"
+		idToken, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "opaque",
+			"token_type":   "Bearer",
+			"id_token":     idToken,
+		})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// authorize plays the part of the browser at the provider's login page.
+func (p *mockProvider) authorize(t *testing.T, location string) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if query.Get("client_id") != p.clientID || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization request %s", location)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.challenge = query.Get("code_challenge")
+	if p.nonce == "" {
+		p.nonce = query.Get("nonce")
+	}
+	return query.Get("state")
+}
+
+type fakeIdentityStore struct {
+	identities map[string]*types.UserIdentity
+}
+
+func (s *fakeIdentityStore) GetIdentity(issuer, subject string) (*types.UserIdentity, error) {
+	identity, ok := s.identities[issuer+"|"+subject]
+	if !ok {
+		return nil, types.ErrIdentityNotFound
+	}
+	return identity, nil
+}
+
+func (s *fakeIdentityStore) LinkIdentity(identity *types.UserIdentity) error {
+	s.identities[identity.Issuer+"|"+identity.Subject] = identity
+	return nil
+}
+
+type fakeUserStore struct {
+	users []*types.User
+}
+
+func (s *fakeUserStore) GetUserByEmail(email string) (*types.User, error) {
+	for _, user := range s.users {
+		if user.Username == email {
+			return user, nil
+		}
+	}
+	return nil, types.ErrUserNotFound
+}
+
+func (s *fakeUserStore) GetUserByID(id int) (*types.User, error) {
+	for _, user := range s.users {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+	return nil, errors.New("invalid credentials")
+}
+
+func (s *fakeUserStore) CreateUser(user types.User) (int, error) {
+	user.ID = len(s.users) + 1
+	s.users = append(s.users, &user)
+	return user.ID, nil
+}
+
+func (s *fakeUserStore) UpdatePassword(userID int, hashedPassword string) error {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	user.Password = hashedPassword
+	return nil
+}
+
+func (s *fakeUserStore) SetEmailVerified(userID int) error {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	user.EmailVerified = true
+	return nil
+}
+
+type fakeSessionStore struct {
+	sessions map[string]*types.Session
+}
+
+func (s *fakeSessionStore) CreateSession(session *types.Session) error {
+	session.CreatedAt = time.Now()
+	session.LastSeen = time.Now()
+	s.sessions[session.ID] = session
+	return nil
+}
+
+func (s *fakeSessionStore) GetSession(sessionID string) (*types.Session, error) {
+	session, ok := s.sessions[sessionID]
+	if !ok {
+		return nil, types.ErrSessionNotFound
+	}
+	return session, nil
+}
+
+func (s *fakeSessionStore) GetActiveSessionsForUser(userID int) ([]*types.Session, error) {
+	return nil, nil
+}
+
+func (s *fakeSessionStore) TouchSession(sessionID string) error { return nil }
+
+func (s *fakeSessionStore) ExtendSession(sessionID string, expiresAt time.Time) error { return nil }
+
+func (s *fakeSessionStore) RevokeSession(sessionID string) error { return nil }
+
+func (s *fakeSessionStore) RevokeUserSessions(userID int) ([]string, error) { return nil, nil }
+
+func (s *fakeSessionStore) CreateRefreshToken(token *types.RefreshToken) error { return nil }
+
+func (s *fakeSessionStore) UseRefreshToken(tokenHash string) (*types.RefreshToken, error) {
+	return nil, types.ErrRefreshTokenNotFound
+}
+
+type testEnv struct {
+	provider   *mockProvider
+	handler    *Handler
+	users      *fakeUserStore
+	identities *fakeIdentityStore
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	err := auth.ConfigureKeys(config.Config{JWTAlgorithm: "HS256", JWTKeyID: "test", JWTSecret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.SetSessionStore(&fakeSessionStore{sessions: make(map[string]*types.Session)})
+
+	provider := newMockProvider(t, "chat-app")
+	provider.subject = "employee-1"
+	provider.email = "jane@example.com"
+	provider.emailVerified = true
+
+	env := &testEnv{
+		provider:   provider,
+		users:      &fakeUserStore{},
+		identities: &fakeIdentityStore{identities: make(map[string]*types.UserIdentity)},
+	}
+	env.handler = NewHandler(env.identities, env.users, config.Config{
+		OIDCIssuerURL:    provider.server.URL,
+		OIDCClientID:     "chat-app",
+		OIDCClientSecret: "client-secret",
+		OIDCRedirectURL:  "http://localhost:8080/api/v1/auth/oidc/callback",
+		OIDCPostLoginURL: "http://localhost:3000/",
+	})
+	return env
+}
+
+// login runs the browser side of the flow and returns the callback response.
+func (env *testEnv) login(t *testing.T, tamperState bool) *httptest.ResponseRecorder {
+	loginRes := httptest.NewRecorder()
+	env.handler.LoginHandler(loginRes, httptest.NewRequest("GET", "/api/v1/auth/oidc/login", nil))
+	if loginRes.Code != http.StatusFound {
+		t.Fatalf("login returned %d: %s", loginRes.Code, loginRes.Body.String())
+	}
+
+	state := env.provider.authorize(t, loginRes.Header().Get("Location"))
+	if tamperState {
+		state = "forged"
+	}
+
+	callback := httptest.NewRequest("GET", "/api/v1/auth/oidc/callback?code=valid-code&state="+url.QueryEscape(state), nil)
+	for _, cookie := range loginRes.Result().Cookies() {
+		callback.AddCookie(cookie)
+	}
+
+	res := httptest.NewRecorder()
+	env.handler.CallbackHandler(res, callback)
+	return res
+}
+
+func findCookie(res *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range res.Result().Cookies() {
+		if cookie.Name == name && cookie.Value != "" {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestLoginCreatesAndLinksUser(t *testing.T) {
+	env := newTestEnv(t)
+
+	res := env.login(t, false)
+	if res.Code != http.StatusFound || res.Header().Get("Location") != "http://localhost:3000/" {
+		t.Fatalf("callback returned %d (%s): %s", res.Code, res.Header().Get("Location"), res.Body.String())
+	}
+	if findCookie(res, auth.AccessTokenCookie) == nil || findCookie(res, auth.RefreshTokenCookie) == nil {
+		t.Fatal("callback did not set the session cookies")
+	}
+
+	if len(env.users.users) != 1 || env.users.users[0].Username != "jane@example.com" {
+		t.Fatalf("expected one user for jane, got %+v", env.users.users)
+	}
+	if !env.users.users[0].EmailVerified {
+		t.Error("email vouched for by the provider should be marked verified")
+	}
+	identity, err := env.identities.GetIdentity(env.provider.server.URL, "employee-1")
+	if err != nil || identity.UserID != env.users.users[0].ID {
+		t.Fatalf("identity was not linked: %v %+v", err, identity)
+	}
+
+	// A second login reuses the link instead of creating another user.
+	env.provider.nonce = ""
+	res = env.login(t, false)
+	if res.Code != http.StatusFound || len(env.users.users) != 1 {
+		t.Fatalf("second login returned %d with %d users", res.Code, len(env.users.users))
+	}
+}
+
+func TestLoginLinksExistingAccountByEmail(t *testing.T) {
+	env := newTestEnv(t)
+	env.users.CreateUser(types.User{Username: "jane@example.com", Password: "hash", EmailVerified: true})
+
+	res := env.login(t, false)
+	if res.Code != http.StatusFound {
+		t.Fatalf("callback returned %d: %s", res.Code, res.Body.String())
+	}
+	if len(env.users.users) != 1 {
+		t.Fatalf("expected the existing user to be linked, got %d users", len(env.users.users))
+	}
+	identity, err := env.identities.GetIdentity(env.provider.server.URL, "employee-1")
+	if err != nil || identity.UserID != env.users.users[0].ID {
+		t.Fatalf("identity was not linked: %v %+v", err, identity)
+	}
+}
+
+func TestLoginDoesNotLinkUnverifiedAccount(t *testing.T) {
+	env := newTestEnv(t)
+	env.users.CreateUser(types.User{Username: "jane@example.com", Password: "hash"})
+
+	res := env.login(t, false)
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d: %s", res.Code, res.Body.String())
+	}
+	if findCookie(res, auth.AccessTokenCookie) != nil {
+		t.Error("rejected login must not set a session cookie")
+	}
+	if _, err := env.identities.GetIdentity(env.provider.server.URL, "employee-1"); err == nil {
+		t.Error("identity was linked to an account whose email was never verified")
+	}
+	if len(env.users.users) != 1 || env.users.users[0].EmailVerified {
+		t.Errorf("got users %+v", env.users.users)
+	}
+}
+
+func TestLoginRejections(t *testing.T) {
+	tests := []struct {
+		name        string
+		setup       func(p *mockProvider)
+		tamperState bool
+		expected    int
+	}{
+		{
+			name:        "State mismatch",
+			tamperState: true,
+			expected:    http.StatusBadRequest,
+		},
+		{
+			name:     "Nonce mismatch",
+			setup:    func(p *mockProvider) { p.nonce = "replayed" },
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name:     "Wrong audience",
+			setup:    func(p *mockProvider) { p.audience = []string{"another-app"} },
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name:     "Unverified email",
+			setup:    func(p *mockProvider) { p.emailVerified = false },
+			expected: http.StatusForbidden,
+		},
+		{
+			name:     "Bot email",
+			setup:    func(p *mockProvider) { p.email = "deploy@bots.invalid" },
+			expected: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			if tt.setup != nil {
+				tt.setup(env.provider)
+			}
+
+			res := env.login(t, tt.tamperState)
+			if res.Code != tt.expected {
+				t.Fatalf("expected %d, got %d: %s", tt.expected, res.Code, res.Body.String())
+			}
+			if findCookie(res, auth.AccessTokenCookie) != nil {
+				t.Error("rejected login must not set a session cookie")
+			}
+			if len(env.users.users) != 0 {
+				t.Errorf("rejected login created users: %+v", env.users.users)
+			}
+		})
+	}
+}
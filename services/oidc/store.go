@@ -0,0 +1,46 @@
+package oidc
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"user/server/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) GetIdentity(issuer, subject string) (*types.UserIdentity, error) {
+	identity := &types.UserIdentity{}
+	err := s.db.QueryRow(`
+		SELECT Issuer, Subject, UserID, Email, CreatedAt
+		FROM UserIdentities WHERE Issuer = $1 AND Subject = $2`, issuer, subject).Scan(
+		&identity.Issuer, &identity.Subject, &identity.UserID,
+		&identity.Email, &identity.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrIdentityNotFound
+	}
+	if err != nil {
+		log.Println("Error getting identity: ", err)
+		return nil, err
+	}
+	return identity, nil
+}
+
+func (s *Store) LinkIdentity(identity *types.UserIdentity) error {
+	err := s.db.QueryRow(`
+		INSERT INTO UserIdentities (Issuer, Subject, UserID, Email)
+		VALUES ($1, $2, $3, $4)
+		RETURNING CreatedAt`,
+		identity.Issuer, identity.Subject, identity.UserID, identity.Email).Scan(&identity.CreatedAt)
+	if err != nil {
+		log.Println("Error linking identity: ", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package permissions
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"user/server/types"
+)
+
+// Authorize loads the user's membership and checks it carries perm. On
+// failure it writes the response and returns false: 404 for non-members,
+// so private channels don't leak, and 403 for missing permissions.
+// Pass 0 to only require membership.
+func Authorize(w http.ResponseWriter, store types.PermissionsStore,
+	userID, channelID int, perm types.Permission) (*types.ChannelMember, bool) {
+	member, err := store.GetChannelMember(userID, channelID)
+	return check(w, member, err, perm)
+}
+
+// AuthorizeRoom is Authorize for the channel that owns roomID. Members the
+// room's allow-list leaves out get a 403.
+func AuthorizeRoom(w http.ResponseWriter, store types.PermissionsStore,
+	userID, roomID int, perm types.Permission) (*types.RoomMember, bool) {
+	member, err := store.GetRoomMember(userID, roomID)
+	if err != nil {
+		check(w, nil, err, perm)
+		return nil, false
+	}
+	if _, ok := check(w, &member.ChannelMember, nil, perm); !ok {
+		return nil, false
+	}
+
+	if !member.CanAccess {
+		log.Println("User", userID, "is not on the allow-list of room", roomID)
+		http.Error(w, "You don't have access to this room", http.StatusForbidden)
+		return nil, false
+	}
+	return member, true
+}
+
+func check(w http.ResponseWriter, member *types.ChannelMember, err error,
+	perm types.Permission) (*types.ChannelMember, bool) {
+	if errors.Is(err, types.ErrNotChannelMember) {
+		handleError(w, "Channel not found", http.StatusNotFound, err)
+		return nil, false
+	}
+	if err != nil {
+		handleError(w, "Error checking permissions", http.StatusInternalServerError, err)
+		return nil, false
+	}
+
+	if !member.Can(perm) {
+		log.Println("User", member.UserID, "lacks permission", perm, "in channel", member.ChannelID)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	return member, true
+}
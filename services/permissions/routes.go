@@ -0,0 +1,314 @@
+package permissions
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"user/server/services/auth"
+	"user/server/services/utils"
+	"user/server/types"
+
+	"github.com/gorilla/mux"
+)
+
+const maxRoleNameLength = 64
+
+type Handler struct {
+	store     types.PermissionsStore
+	userStore types.UserStore
+}
+
+func NewHandler(store types.PermissionsStore, userStore types.UserStore) *Handler {
+	return &Handler{store: store, userStore: userStore}
+}
+
+func (h *Handler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/channels/{channelID}/roles", utils.CorsHandler(
+		auth.WithJWTAuth(h.GetRolesHandler, h.userStore),
+	)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/channels/{channelID}/roles", utils.CorsHandler(
+		auth.WithJWTAuth(h.CreateRoleHandler, h.userStore),
+	)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/channels/{channelID}/roles/{roleID}", utils.CorsHandler(
+		auth.WithJWTAuth(h.UpdateRoleHandler, h.userStore),
+	)).Methods("PUT", "OPTIONS")
+	r.HandleFunc("/channels/{channelID}/roles/{roleID}", utils.CorsHandler(
+		auth.WithJWTAuth(h.DeleteRoleHandler, h.userStore),
+	)).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/channels/{channelID}/members/{userID}/role", utils.CorsHandler(
+		auth.WithJWTAuth(h.SetMemberRoleHandler, h.userStore),
+	)).Methods("PUT", "OPTIONS")
+}
+
+func (h *Handler) GetRolesHandler(w http.ResponseWriter, r *http.Request) {
+	channelID, ok := parseID(w, r, "channelID")
+	if !ok {
+		return
+	}
+	user := auth.GetUserFromContext(r.Context())
+	if _, ok := Authorize(w, h.store, user.ID, channelID, 0); !ok {
+		return
+	}
+
+	roles, err := h.store.GetChannelRoles(channelID)
+	if err != nil {
+		handleError(w, "Error getting roles", http.StatusInternalServerError, err)
+		return
+	}
+
+	builtin := make([]types.BuiltinRole, 0)
+	for _, name := range []string{types.RoleOwner, types.RoleAdmin, types.RoleModerator, types.RoleMember} {
+		builtin = append(builtin, types.BuiltinRole{Name: name, Permissions: types.RolePermissions(name)})
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, types.ChannelRolesResponse{
+		BuiltinRoles: builtin,
+		CustomRoles:  roles,
+	})
+}
+
+func (h *Handler) CreateRoleHandler(w http.ResponseWriter, r *http.Request) {
+	channelID, ok := parseID(w, r, "channelID")
+	if !ok {
+		return
+	}
+	user := auth.GetUserFromContext(r.Context())
+	member, ok := Authorize(w, h.store, user.ID, channelID, types.PermManageRoles)
+	if !ok {
+		return
+	}
+
+	payload, ok := parseRolePayload(w, r, member)
+	if !ok {
+		return
+	}
+
+	role := &types.ChannelRole{
+		ChannelID:   channelID,
+		Name:        payload.Name,
+		Permissions: payload.Permissions,
+	}
+	err := h.store.CreateChannelRole(role)
+	if err != nil {
+		handleError(w, "A role with this name already exists", http.StatusConflict, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusCreated, role)
+}
+
+func (h *Handler) UpdateRoleHandler(w http.ResponseWriter, r *http.Request) {
+	channelID, ok := parseID(w, r, "channelID")
+	if !ok {
+		return
+	}
+	roleID, ok := parseID(w, r, "roleID")
+	if !ok {
+		return
+	}
+	user := auth.GetUserFromContext(r.Context())
+	member, ok := Authorize(w, h.store, user.ID, channelID, types.PermManageRoles)
+	if !ok {
+		return
+	}
+
+	role, ok := h.getManageableRole(w, member, channelID, roleID)
+	if !ok || !h.outranksRoleHolders(w, member, channelID, roleID) {
+		return
+	}
+
+	payload, ok := parseRolePayload(w, r, member)
+	if !ok {
+		return
+	}
+
+	role.Name = payload.Name
+	role.Permissions = payload.Permissions
+	err := h.store.UpdateChannelRole(role)
+	if err != nil {
+		handleError(w, "A role with this name already exists", http.StatusConflict, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, role)
+}
+
+func (h *Handler) DeleteRoleHandler(w http.ResponseWriter, r *http.Request) {
+	channelID, ok := parseID(w, r, "channelID")
+	if !ok {
+		return
+	}
+	roleID, ok := parseID(w, r, "roleID")
+	if !ok {
+		return
+	}
+	user := auth.GetUserFromContext(r.Context())
+	member, ok := Authorize(w, h.store, user.ID, channelID, types.PermManageRoles)
+	if !ok {
+		return
+	}
+
+	if _, ok := h.getManageableRole(w, member, channelID, roleID); !ok {
+		return
+	}
+	if !h.outranksRoleHolders(w, member, channelID, roleID) {
+		return
+	}
+
+	err := h.store.DeleteChannelRole(channelID, roleID)
+	if err != nil {
+		handleError(w, "Error deleting role", http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetMemberRoleHandler changes a member's built-in role and custom role.
+// Ownership can't be handed out here, and nobody can promote someone to
+// their own rank or manage someone at or above it.
+func (h *Handler) SetMemberRoleHandler(w http.ResponseWriter, r *http.Request) {
+	channelID, ok := parseID(w, r, "channelID")
+	if !ok {
+		return
+	}
+	targetID, ok := parseID(w, r, "userID")
+	if !ok {
+		return
+	}
+	user := auth.GetUserFromContext(r.Context())
+	member, ok := Authorize(w, h.store, user.ID, channelID, types.PermManageRoles)
+	if !ok {
+		return
+	}
+
+	payload := &types.MemberRolePayload{}
+	if err := utils.ParseJSON(r, payload); err != nil {
+		handleError(w, "Invalid request payload", http.StatusBadRequest, err)
+		return
+	}
+	if payload.Role == "" {
+		payload.Role = types.RoleMember
+	}
+	if !types.IsBuiltinRole(payload.Role) || payload.Role == types.RoleOwner {
+		handleError(w, "Invalid role", http.StatusBadRequest, nil)
+		return
+	}
+
+	target, err := h.store.GetChannelMember(targetID, channelID)
+	if errors.Is(err, types.ErrNotChannelMember) {
+		handleError(w, "Member not found", http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		handleError(w, "Error getting member", http.StatusInternalServerError, err)
+		return
+	}
+
+	if !member.Outranks(target) || types.RoleRank(payload.Role) >= types.RoleRank(member.Role) {
+		handleError(w, "Forbidden", http.StatusForbidden, nil)
+		return
+	}
+
+	if payload.CustomRoleID != nil {
+		if _, ok := h.getManageableRole(w, member, channelID, *payload.CustomRoleID); !ok {
+			return
+		}
+	}
+
+	err = h.store.SetMemberRole(targetID, channelID, payload.Role, payload.CustomRoleID)
+	if err != nil {
+		handleError(w, "Error setting role", http.StatusInternalServerError, err)
+		return
+	}
+
+	target, err = h.store.GetChannelMember(targetID, channelID)
+	if err != nil {
+		handleError(w, "Error getting member", http.StatusInternalServerError, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, target)
+}
+
+// getManageableRole loads a custom role the member is allowed to edit or
+// hand out, which means it can't grant anything the member lacks.
+func (h *Handler) getManageableRole(w http.ResponseWriter, member *types.ChannelMember,
+	channelID, roleID int) (*types.ChannelRole, bool) {
+	role, err := h.store.GetChannelRole(channelID, roleID)
+	if errors.Is(err, types.ErrChannelRoleNotFound) {
+		handleError(w, "Role not found", http.StatusNotFound, err)
+		return nil, false
+	}
+	if err != nil {
+		handleError(w, "Error getting role", http.StatusInternalServerError, err)
+		return nil, false
+	}
+	if !member.Can(role.Permissions) {
+		handleError(w, "Forbidden", http.StatusForbidden, nil)
+		return nil, false
+	}
+	return role, true
+}
+
+// outranksRoleHolders checks that the member outranks everyone else the
+// custom role is assigned to, so editing or deleting it can't strip
+// permissions from someone they couldn't manage directly.
+func (h *Handler) outranksRoleHolders(w http.ResponseWriter, member *types.ChannelMember,
+	channelID, roleID int) bool {
+	holders, err := h.store.GetRoleHolders(channelID, roleID)
+	if err != nil {
+		handleError(w, "Error getting role", http.StatusInternalServerError, err)
+		return false
+	}
+	for _, holder := range holders {
+		if holder.UserID != member.UserID && !member.Outranks(holder) {
+			handleError(w, "Forbidden", http.StatusForbidden, nil)
+			return false
+		}
+	}
+	return true
+}
+
+func parseRolePayload(w http.ResponseWriter, r *http.Request,
+	member *types.ChannelMember) (*types.ChannelRolePayload, bool) {
+	payload := &types.ChannelRolePayload{}
+	if err := utils.ParseJSON(r, payload); err != nil {
+		handleError(w, "Invalid request payload", http.StatusBadRequest, err)
+		return nil, false
+	}
+
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.Name == "" || len(payload.Name) > maxRoleNameLength || types.IsBuiltinRole(payload.Name) {
+		handleError(w, "Invalid role name", http.StatusBadRequest, nil)
+		return nil, false
+	}
+	if payload.Permissions&^types.PermAll != 0 {
+		handleError(w, "Unknown permissions", http.StatusBadRequest, nil)
+		return nil, false
+	}
+	if !member.Can(payload.Permissions) {
+		handleError(w, "Cannot grant permissions you don't have", http.StatusForbidden, nil)
+		return nil, false
+	}
+	return payload, true
+}
+
+func parseID(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)[name])
+	if err != nil {
+		handleError(w, "Invalid "+name, http.StatusBadRequest, err)
+		return 0, false
+	}
+	return id, true
+}
+
+func handleError(w http.ResponseWriter, message string, statusCode int, err error) {
+	if err != nil {
+		log.Println(message, err)
+	} else {
+		log.Println(message)
+	}
+	http.Error(w, message, statusCode)
+}
@@ -0,0 +1,256 @@
+package permissions
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"user/server/services/auth"
+	"user/server/types"
+
+	"github.com/gorilla/mux"
+)
+
+type fakeStore struct {
+	types.PermissionsStore
+	members map[int]*types.ChannelMember
+	roles   map[int]*types.ChannelRole
+}
+
+func (s *fakeStore) GetChannelMember(userID, channelID int) (*types.ChannelMember, error) {
+	member, ok := s.members[userID]
+	if !ok || member.ChannelID != channelID {
+		return nil, types.ErrNotChannelMember
+	}
+	result := *member
+	result.Permissions = types.RolePermissions(member.Role)
+	if role, ok := s.roles[intValue(member.CustomRoleID)]; ok && member.Role != types.RoleOwner {
+		result.Permissions = role.Permissions
+	}
+	return &result, nil
+}
+
+func (s *fakeStore) SetMemberRole(userID, channelID int, role string, customRoleID *int) error {
+	s.members[userID].Role = role
+	s.members[userID].CustomRoleID = customRoleID
+	return nil
+}
+
+func (s *fakeStore) GetChannelRole(channelID, roleID int) (*types.ChannelRole, error) {
+	role, ok := s.roles[roleID]
+	if !ok || role.ChannelID != channelID {
+		return nil, types.ErrChannelRoleNotFound
+	}
+	return role, nil
+}
+
+func (s *fakeStore) CreateChannelRole(role *types.ChannelRole) error {
+	role.ID = len(s.roles) + 1
+	s.roles[role.ID] = role
+	return nil
+}
+
+func (s *fakeStore) UpdateChannelRole(role *types.ChannelRole) error {
+	s.roles[role.ID] = role
+	return nil
+}
+
+func (s *fakeStore) DeleteChannelRole(channelID, roleID int) error {
+	delete(s.roles, roleID)
+	return nil
+}
+
+func (s *fakeStore) GetRoleHolders(channelID, roleID int) ([]*types.ChannelMember, error) {
+	holders := make([]*types.ChannelMember, 0)
+	for _, m := range s.members {
+		if m.ChannelID == channelID && intValue(m.CustomRoleID) == roleID {
+			holders = append(holders, m)
+		}
+	}
+	return holders, nil
+}
+
+func intValue(i *int) int {
+	if i == nil {
+		return 0
+	}
+	return *i
+}
+
+const (
+	owner     = 1
+	admin     = 2
+	moderator = 3
+	member    = 4
+	stranger  = 5
+)
+
+func newTestStore() *fakeStore {
+	store := &fakeStore{
+		members: make(map[int]*types.ChannelMember),
+		roles: map[int]*types.ChannelRole{
+			1: {ID: 1, ChannelID: 10, Name: "dj", Permissions: types.PermSpeak | types.PermManageRooms},
+		},
+	}
+	for userID, role := range map[int]string{
+		owner: types.RoleOwner, admin: types.RoleAdmin, moderator: types.RoleModerator, member: types.RoleMember,
+	} {
+		store.members[userID] = &types.ChannelMember{UserID: userID, ChannelID: 10, Role: role}
+	}
+	return store
+}
+
+func request(handler http.HandlerFunc, userID int, vars map[string]string, body string) int {
+	r := httptest.NewRequest("PUT", "/", bytes.NewBufferString(body))
+	r = r.WithContext(context.WithValue(r.Context(), auth.UserKey, &types.User{ID: userID}))
+	r = mux.SetURLVars(r, vars)
+	res := httptest.NewRecorder()
+	handler(res, r)
+	return res.Code
+}
+
+func TestSetMemberRole(t *testing.T) {
+	tests := []struct {
+		name     string
+		actor    int
+		target   int
+		body     string
+		expected int
+	}{
+		{name: "Owner promotes to admin", actor: owner, target: member, body: `{"role":"admin"}`, expected: http.StatusOK},
+		{name: "Admin promotes to moderator", actor: admin, target: member, body: `{"role":"moderator"}`, expected: http.StatusOK},
+		{name: "Admin cannot promote to admin", actor: admin, target: member, body: `{"role":"admin"}`, expected: http.StatusForbidden},
+		{name: "Admin cannot demote owner", actor: admin, target: owner, body: `{"role":"member"}`, expected: http.StatusForbidden},
+		{name: "Ownership cannot be assigned", actor: owner, target: admin, body: `{"role":"owner"}`, expected: http.StatusBadRequest},
+		{name: "Moderator cannot manage roles", actor: moderator, target: member, body: `{"role":"member"}`, expected: http.StatusForbidden},
+		{name: "Non member gets not found", actor: stranger, target: member, body: `{"role":"member"}`, expected: http.StatusNotFound},
+		{name: "Unknown target", actor: owner, target: stranger, body: `{"role":"member"}`, expected: http.StatusNotFound},
+		{name: "Custom role", actor: admin, target: member, body: `{"role":"member","custom_role_id":1}`, expected: http.StatusOK},
+		{name: "Unknown custom role", actor: admin, target: member, body: `{"role":"member","custom_role_id":9}`, expected: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(newTestStore(), nil)
+			vars := map[string]string{"channelID": "10", "userID": strconv.Itoa(tt.target)}
+			code := request(h.SetMemberRoleHandler, tt.actor, vars, tt.body)
+			if code != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, code)
+			}
+		})
+	}
+}
+
+func TestCustomRoleReplacesPermissions(t *testing.T) {
+	store := newTestStore()
+	h := NewHandler(store, nil)
+
+	vars := map[string]string{"channelID": "10", "userID": strconv.Itoa(member)}
+	code := request(h.SetMemberRoleHandler, owner, vars, `{"role":"member","custom_role_id":1}`)
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+
+	m, _ := store.GetChannelMember(member, 10)
+	if !m.Can(types.PermManageRooms) || m.Can(types.PermShareScreen) {
+		t.Errorf("unexpected permissions %b", m.Permissions)
+	}
+}
+
+func TestCreateRoleCannotEscalate(t *testing.T) {
+	store := newTestStore()
+	store.roles[2] = &types.ChannelRole{ID: 2, ChannelID: 10, Name: "roles", Permissions: types.PermManageRoles}
+	two := 2
+	store.members[member].CustomRoleID = &two
+	h := NewHandler(store, nil)
+
+	vars := map[string]string{"channelID": "10"}
+	code := request(h.CreateRoleHandler, member, vars, `{"name":"mods","permissions":16}`)
+	if code != http.StatusForbidden {
+		t.Errorf("granting kick without having it returned %d", code)
+	}
+	code = request(h.CreateRoleHandler, admin, vars, `{"name":"mods","permissions":16}`)
+	if code != http.StatusCreated {
+		t.Errorf("admin creating a role returned %d", code)
+	}
+	code = request(h.CreateRoleHandler, admin, vars, `{"name":"admin","permissions":0}`)
+	if code != http.StatusBadRequest {
+		t.Errorf("shadowing a built-in role returned %d", code)
+	}
+}
+
+func TestEditRoleHeldByHigherRank(t *testing.T) {
+	store := newTestStore()
+	store.roles[2] = &types.ChannelRole{ID: 2, ChannelID: 10, Name: "roles",
+		Permissions: types.PermManageRoles | types.PermSpeak | types.PermManageRooms}
+	store.roles[3] = &types.ChannelRole{ID: 3, ChannelID: 10, Name: "guests", Permissions: types.PermSpeak}
+	one, two, three := 1, 2, 3
+	store.members[moderator].CustomRoleID = &two
+	store.members[admin].CustomRoleID = &one
+	store.members[member].CustomRoleID = &three
+	h := NewHandler(store, nil)
+
+	vars := map[string]string{"channelID": "10", "roleID": "1"}
+	if code := request(h.UpdateRoleHandler, moderator, vars, `{"name":"dj","permissions":0}`); code != http.StatusForbidden {
+		t.Errorf("stripping an admin's role returned %d", code)
+	}
+	if code := request(h.DeleteRoleHandler, moderator, vars, ""); code != http.StatusForbidden {
+		t.Errorf("deleting an admin's role returned %d", code)
+	}
+	if _, ok := store.roles[1]; !ok || store.roles[1].Permissions == 0 {
+		t.Errorf("admin's role changed: %+v", store.roles[1])
+	}
+
+	vars["roleID"] = "3"
+	if code := request(h.UpdateRoleHandler, moderator, vars, `{"name":"guests","permissions":0}`); code != http.StatusOK {
+		t.Errorf("editing a member's role returned %d", code)
+	}
+	if code := request(h.DeleteRoleHandler, moderator, vars, ""); code != http.StatusNoContent {
+		t.Errorf("deleting a member's role returned %d", code)
+	}
+}
+
+type fakeRoomStore struct {
+	types.PermissionsStore
+	member *types.RoomMember
+}
+
+func (s *fakeRoomStore) GetRoomMember(userID, roomID int) (*types.RoomMember, error) {
+	if s.member == nil {
+		return nil, types.ErrNotChannelMember
+	}
+	return s.member, nil
+}
+
+func TestAuthorizeRoom(t *testing.T) {
+	memberOf := func(role string, canAccess bool) *types.RoomMember {
+		return &types.RoomMember{
+			ChannelMember: types.ChannelMember{Role: role, Permissions: types.RolePermissions(role)},
+			CanAccess:     canAccess,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		member   *types.RoomMember
+		perm     types.Permission
+		expected int
+	}{
+		{name: "Not in channel", member: nil, expected: http.StatusNotFound},
+		{name: "Private room, not listed", member: memberOf(types.RoleMember, false), expected: http.StatusForbidden},
+		{name: "Private room, listed", member: memberOf(types.RoleMember, true), expected: http.StatusOK},
+		{name: "Missing permission", member: memberOf(types.RoleMember, true), perm: types.PermManageRooms, expected: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			_, ok := AuthorizeRoom(res, &fakeRoomStore{member: tt.member}, 1, 1, tt.perm)
+			if ok != (tt.expected == http.StatusOK) || (!ok && res.Code != tt.expected) {
+				t.Errorf("expected %d, got %d (ok=%v)", tt.expected, res.Code, ok)
+			}
+		})
+	}
+}
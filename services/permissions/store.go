@@ -2,8 +2,11 @@ package permissions
 
 import (
 	"database/sql"
+	"errors"
 	"log"
 	"user/server/types"
+
+	"github.com/lib/pq"
 )
 
 type Store struct {
@@ -14,32 +17,224 @@ func NewStore(db *sql.DB) *Store {
 	return &Store{db: db}
 }
 
-func (s *Store) UserHasPermission(userID, channelID int) bool {
-	rows, err := s.db.Query(`SELECT Channels.* 
-                            FROM Channels
-                            JOIN ChannelsToUsers 
-                            ON Channels.ID = ChannelsToUsers.channel_id
-                            WHERE ChannelsToUsers.user_id = $1`, userID)
+// VisibleRooms selects the rooms $1 can read, mirroring
+// PermissionsStore.GetRoomMember: public rooms of their channels, private
+// ones they are listed on, every room where they can manage rooms, and
+// their direct conversations. Its parameters are VisibleRoomsArgs.
+const VisibleRooms = `
+	SELECT r.ID FROM Rooms r
+	JOIN ChannelsToUsers c ON c.channel_id = r.ChannelID AND c.user_id = $1
+	LEFT JOIN ChannelRoles cr ON cr.ID = c.custom_role_id
+	WHERE NOT r.IsPrivate
+	OR c.role = 'owner'
+	OR (c.custom_role_id IS NULL AND c.role = ANY($2))
+	OR (c.custom_role_id IS NOT NULL AND cr.Permissions & $3 <> 0)
+	OR EXISTS (SELECT 1 FROM RoomAccess a
+	           WHERE a.RoomID = r.ID
+	           AND (a.UserID = c.user_id OR a.Role = c.role OR a.CustomRoleID = c.custom_role_id))
+	UNION
+	SELECT RoomID FROM DirectMembers WHERE UserID = $1`
+
+// VisibleRoomsArgs are the parameters of VisibleRooms: the user, the
+// built-in roles that can manage rooms and PermManageRooms.
+func VisibleRoomsArgs(userID int) []any {
+	return []any{userID, pq.Array(types.RolesWith(types.PermManageRooms)), int64(types.PermManageRooms)}
+}
+
+func (s *Store) GetChannelMember(userID, channelID int) (*types.ChannelMember, error) {
+	member := &types.ChannelMember{}
+	err := scanMember(s.db.QueryRow(`SELECT ChannelsToUsers.user_id, ChannelsToUsers.channel_id,
+                            ChannelsToUsers.role, ChannelsToUsers.custom_role_id, ChannelRoles.Permissions
+                            FROM ChannelsToUsers
+                            LEFT JOIN ChannelRoles
+                            ON ChannelRoles.ID = ChannelsToUsers.custom_role_id
+                            WHERE ChannelsToUsers.user_id = $1 AND ChannelsToUsers.channel_id = $2`,
+		userID, channelID), member)
 	if err != nil {
-		log.Println("Error getting channels for user")
-		return false
+		return nil, err
+	}
+	return member, nil
+}
+
+func (s *Store) GetRoomMember(userID, roomID int) (*types.RoomMember, error) {
+	member := &types.RoomMember{RoomID: roomID}
+	var isPrivate, listed bool
+	err := scanMember(s.db.QueryRow(`SELECT ChannelsToUsers.user_id, ChannelsToUsers.channel_id,
+                            ChannelsToUsers.role, ChannelsToUsers.custom_role_id, ChannelRoles.Permissions,
+                            Rooms.IsPrivate,
+                            EXISTS (SELECT 1 FROM RoomAccess
+                                    WHERE RoomAccess.RoomID = Rooms.ID
+                                    AND (RoomAccess.UserID = ChannelsToUsers.user_id
+                                         OR RoomAccess.Role = ChannelsToUsers.role
+                                         OR RoomAccess.CustomRoleID = ChannelsToUsers.custom_role_id))
+                            FROM Rooms
+                            JOIN ChannelsToUsers
+                            ON ChannelsToUsers.channel_id = Rooms.ChannelID
+                            LEFT JOIN ChannelRoles
+                            ON ChannelRoles.ID = ChannelsToUsers.custom_role_id
+                            WHERE ChannelsToUsers.user_id = $1 AND Rooms.ID = $2`,
+		userID, roomID), &member.ChannelMember, &isPrivate, &listed)
+	if errors.Is(err, types.ErrNotChannelMember) {
+		return s.getConversationMember(userID, roomID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	member.CanAccess = !isPrivate || listed || member.Can(types.PermManageRooms)
+	return member, nil
+}
+
+// getConversationMember treats members of a direct conversation like
+// channel members without any permissions, in channel 0.
+func (s *Store) getConversationMember(userID, roomID int) (*types.RoomMember, error) {
+	var exists bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM DirectMembers WHERE RoomID = $1 AND UserID = $2)`,
+		roomID, userID).Scan(&exists)
+	if err != nil {
+		log.Println("Error getting conversation member")
+		return nil, err
+	}
+	if !exists {
+		return nil, types.ErrNotChannelMember
+	}
+	return &types.RoomMember{
+		ChannelMember: types.ChannelMember{UserID: userID, Role: types.RoleMember},
+		RoomID:        roomID,
+		CanAccess:     true,
+	}, nil
+}
+
+// scanMember reads the common membership columns into member, followed by
+// any extra columns the query selected.
+func scanMember(row *sql.Row, member *types.ChannelMember, extra ...any) error {
+	var customRoleID sql.NullInt64
+	var customPermissions sql.NullInt64
+	dest := append([]any{&member.UserID, &member.ChannelID, &member.Role, &customRoleID, &customPermissions}, extra...)
+	err := row.Scan(dest...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return types.ErrNotChannelMember
+	}
+	if err != nil {
+		log.Println("Error getting channel member")
+		return err
+	}
+
+	member.Permissions = types.RolePermissions(member.Role)
+	if customRoleID.Valid && member.Role != types.RoleOwner {
+		id := int(customRoleID.Int64)
+		member.CustomRoleID = &id
+		member.Permissions = types.Permission(customPermissions.Int64)
+	}
+	return nil
+}
+
+func (s *Store) SetMemberRole(userID, channelID int, role string, customRoleID *int) error {
+	res, err := s.db.Exec(`UPDATE ChannelsToUsers SET role = $1, custom_role_id = $2
+                           WHERE user_id = $3 AND channel_id = $4`,
+		role, customRoleID, userID, channelID)
+	if err != nil {
+		log.Println("Error setting member role")
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return types.ErrNotChannelMember
+	}
+	return nil
+}
+
+func (s *Store) GetChannelRoles(channelID int) ([]*types.ChannelRole, error) {
+	rows, err := s.db.Query(`SELECT ID, ChannelID, Name, Permissions FROM ChannelRoles
+                             WHERE ChannelID = $1 ORDER BY ID`, channelID)
+	if err != nil {
+		log.Println("Error getting channel roles")
+		return nil, err
 	}
 	defer rows.Close()
 
-	channels := make([]*types.Channel, 0)
+	roles := make([]*types.ChannelRole, 0)
 	for rows.Next() {
-		channel := &types.Channel{}
-		err := rows.Scan(&channel.ID, &channel.Name, &channel.Avatar)
+		role := &types.ChannelRole{}
+		err := rows.Scan(&role.ID, &role.ChannelID, &role.Name, &role.Permissions)
 		if err != nil {
-			log.Println("Error scanning channel")
-			return false
+			log.Println("Error scanning channel role")
+			return nil, err
 		}
-		channels = append(channels, channel)
+		roles = append(roles, role)
 	}
-	for _, channel := range channels {
-		if channel.ID == channelID {
-			return true
+	return roles, rows.Err()
+}
+
+func (s *Store) GetRoleHolders(channelID, roleID int) ([]*types.ChannelMember, error) {
+	rows, err := s.db.Query(`SELECT user_id, channel_id, role FROM ChannelsToUsers
+                             WHERE channel_id = $1 AND custom_role_id = $2`, channelID, roleID)
+	if err != nil {
+		log.Println("Error getting role holders")
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := make([]*types.ChannelMember, 0)
+	for rows.Next() {
+		member := &types.ChannelMember{}
+		if err := rows.Scan(&member.UserID, &member.ChannelID, &member.Role); err != nil {
+			log.Println("Error scanning role holder")
+			return nil, err
 		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func (s *Store) GetChannelRole(channelID, roleID int) (*types.ChannelRole, error) {
+	role := &types.ChannelRole{}
+	err := s.db.QueryRow(`SELECT ID, ChannelID, Name, Permissions FROM ChannelRoles
+                          WHERE ID = $1 AND ChannelID = $2`, roleID, channelID).
+		Scan(&role.ID, &role.ChannelID, &role.Name, &role.Permissions)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrChannelRoleNotFound
+	}
+	if err != nil {
+		log.Println("Error getting channel role")
+		return nil, err
+	}
+	return role, nil
+}
+
+func (s *Store) CreateChannelRole(role *types.ChannelRole) error {
+	err := s.db.QueryRow(`INSERT INTO ChannelRoles (ChannelID, Name, Permissions)
+                          VALUES ($1, $2, $3) RETURNING ID`,
+		role.ChannelID, role.Name, role.Permissions).Scan(&role.ID)
+	if err != nil {
+		log.Println("Error creating channel role")
+		return err
+	}
+	return nil
+}
+
+func (s *Store) UpdateChannelRole(role *types.ChannelRole) error {
+	res, err := s.db.Exec(`UPDATE ChannelRoles SET Name = $1, Permissions = $2
+                           WHERE ID = $3 AND ChannelID = $4`,
+		role.Name, role.Permissions, role.ID, role.ChannelID)
+	if err != nil {
+		log.Println("Error updating channel role")
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return types.ErrChannelRoleNotFound
+	}
+	return nil
+}
+
+func (s *Store) DeleteChannelRole(channelID, roleID int) error {
+	res, err := s.db.Exec(`DELETE FROM ChannelRoles WHERE ID = $1 AND ChannelID = $2`,
+		roleID, channelID)
+	if err != nil {
+		log.Println("Error deleting channel role")
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return types.ErrChannelRoleNotFound
 	}
-	return false
+	return nil
 }
@@ -1,24 +1,35 @@
 package room
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"user/server/services/auth"
 	"user/server/services/hub"
+	"user/server/services/permissions"
 	"user/server/services/utils"
 	"user/server/types"
 
 	"github.com/gorilla/mux"
 )
 
+const (
+	maxNameLength  = 255
+	maxTopicLength = 1024
+)
+
 type Handler struct {
-	store     types.RoomStore
-	userStore types.UserStore
+	store           types.RoomStore
+	userStore       types.UserStore
+	permissionStore types.PermissionsStore
+	readStore       types.ReadStore
 }
 
-func NewHandler(store types.RoomStore, userStore types.UserStore) *Handler {
-	return &Handler{store: store, userStore: userStore}
+func NewHandler(store types.RoomStore, userStore types.UserStore, permissionStore types.PermissionsStore,
+	readStore types.ReadStore) *Handler {
+	return &Handler{store: store, userStore: userStore, permissionStore: permissionStore, readStore: readStore}
 }
 
 func (h *Handler) RegisterRoutes(r *mux.Router) {
@@ -38,6 +49,48 @@ func (h *Handler) RegisterRoutes(r *mux.Router) {
 			auth.WithJWTAuth(h.DeleteRoom,
 				h.userStore),
 		)).Methods("DELETE", "OPTIONS")
+
+	r.HandleFunc("/Room/{roomID}",
+		utils.CorsHandler(
+			auth.WithJWTAuth(h.UpdateRoom,
+				h.userStore),
+		)).Methods("PATCH", "OPTIONS")
+
+	r.HandleFunc("/Room/{roomID}/access",
+		utils.CorsHandler(
+			auth.WithJWTAuth(h.GetRoomAccess,
+				h.userStore),
+		)).Methods("GET", "OPTIONS")
+
+	r.HandleFunc("/Room/{roomID}/access",
+		utils.CorsHandler(
+			auth.WithJWTAuth(h.SetRoomAccess,
+				h.userStore),
+		)).Methods("PUT", "OPTIONS")
+
+	r.HandleFunc("/channels/{channelID}/categories",
+		utils.CorsHandler(
+			auth.WithJWTAuth(h.GetCategories,
+				h.userStore),
+		)).Methods("GET", "OPTIONS")
+
+	r.HandleFunc("/channels/{channelID}/categories",
+		utils.CorsHandler(
+			auth.WithJWTAuth(h.CreateCategory,
+				h.userStore),
+		)).Methods("POST", "OPTIONS")
+
+	r.HandleFunc("/channels/{channelID}/categories/{categoryID}",
+		utils.CorsHandler(
+			auth.WithJWTAuth(h.UpdateCategory,
+				h.userStore),
+		)).Methods("PATCH", "OPTIONS")
+
+	r.HandleFunc("/channels/{channelID}/categories/{categoryID}",
+		utils.CorsHandler(
+			auth.WithJWTAuth(h.DeleteCategory,
+				h.userStore),
+		)).Methods("DELETE", "OPTIONS")
 }
 
 func (h *Handler) GetRoomsInChannel(w http.ResponseWriter, r *http.Request) {
@@ -49,12 +102,35 @@ func (h *Handler) GetRoomsInChannel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rooms, err := h.store.GetRoomsInChannel(channelID)
+	user := auth.GetUserFromContext(r.Context())
+	member, ok := permissions.Authorize(w, h.permissionStore, user.ID, channelID, 0)
+	if !ok {
+		return
+	}
+
+	rooms, err := h.store.GetRoomsForMember(member)
 	if err != nil {
 		log.Println("Error getting rooms")
 		http.Error(w, "Error getting rooms", http.StatusInternalServerError)
 		return
 	}
+	counts, err := h.readStore.GetChannelUnreadCounts(user.ID, channelID)
+	if err != nil {
+		log.Println("Error getting unread counts")
+		http.Error(w, "Error getting rooms", http.StatusInternalServerError)
+		return
+	}
+
+	byID := make(map[int]*types.UnreadCount, len(counts))
+	for _, count := range counts {
+		byID[count.RoomID] = count
+	}
+	for _, room := range rooms {
+		if count := byID[room.ID]; count != nil {
+			room.UnreadCount = count.UnreadCount
+			room.MentionCount = count.MentionCount
+		}
+	}
 
 	response := types.RoomsResponse{Rooms: rooms}
 	utils.SendJSONResponse(w, http.StatusOK, response)
@@ -68,8 +144,26 @@ func (h *Handler) CreateRoom(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
+
+	user := auth.GetUserFromContext(r.Context())
+	if _, ok := permissions.Authorize(w, h.permissionStore, user.ID, room.ChannelID, types.PermManageRooms); !ok {
+		return
+	}
+
+	if room.Kind == "" {
+		room.Kind = types.RoomKindVoice
+	}
+	if !types.IsRoomKind(room.Kind) {
+		http.Error(w, "Invalid room kind", http.StatusBadRequest)
+		return
+	}
+	if room.CategoryID != nil && !h.categoryExists(w, room.ChannelID, *room.CategoryID) {
+		return
+	}
+
 	room.Clients = make(map[*types.Client]*types.ClientInfo)
 	room.Bus = types.NewEventBus()
+	room.Hub = hub.HubInstance
 
 	err = h.store.CreateRoom(room)
 	if err != nil {
@@ -92,6 +186,11 @@ func (h *Handler) DeleteRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user := auth.GetUserFromContext(r.Context())
+	if _, ok := permissions.AuthorizeRoom(w, h.permissionStore, user.ID, roomID, types.PermManageRooms); !ok {
+		return
+	}
+
 	room, err := h.store.DeleteRoom(roomID)
 	if err != nil {
 		log.Println("Error: ", err)
@@ -102,3 +201,404 @@ func (h *Handler) DeleteRoom(w http.ResponseWriter, r *http.Request) {
 	hub.HubInstance.RemoveRoom(room.ChannelID, roomID)
 	w.WriteHeader(http.StatusOK)
 }
+
+// UpdateRoom changes a room's name, topic, position or category and pushes a
+// room-updated event to everyone in the channel.
+func (h *Handler) UpdateRoom(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID, err := strconv.Atoi(vars["roomID"])
+	if err != nil {
+		log.Println("Invalid room ID")
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	user := auth.GetUserFromContext(r.Context())
+	if _, ok := permissions.AuthorizeRoom(w, h.permissionStore, user.ID, roomID, types.PermManageRooms); !ok {
+		return
+	}
+
+	payload := &types.UpdateRoomPayload{}
+	err = utils.ParseJSON(r, payload)
+	if err != nil {
+		log.Println("Invalid JSON: ", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	room, err := h.store.GetRoom(roomID)
+	if errors.Is(err, types.ErrRoomNotFound) {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Error: ", err)
+		http.Error(w, "Error updating room", http.StatusInternalServerError)
+		return
+	}
+
+	if payload.Name != nil {
+		room.Name = strings.TrimSpace(*payload.Name)
+	}
+	if payload.Topic != nil {
+		room.Topic = *payload.Topic
+	}
+	if payload.Position != nil {
+		room.Position = *payload.Position
+	}
+	if payload.CategoryID != nil {
+		if *payload.CategoryID == 0 {
+			room.CategoryID = nil
+		} else if h.categoryExists(w, room.ChannelID, *payload.CategoryID) {
+			room.CategoryID = payload.CategoryID
+		} else {
+			return
+		}
+	}
+	if room.Name == "" || len(room.Name) > maxNameLength {
+		http.Error(w, "Invalid room name", http.StatusBadRequest)
+		return
+	}
+	if len(room.Topic) > maxTopicLength {
+		http.Error(w, "Topic is too long", http.StatusBadRequest)
+		return
+	}
+
+	err = h.store.UpdateRoom(room)
+	if err != nil {
+		log.Println("Error: ", err)
+		http.Error(w, "Error updating room", http.StatusInternalServerError)
+		return
+	}
+
+	info := room.ToResponse()
+	if live := hub.HubInstance.GetRoom(room.ChannelID, roomID); live != nil {
+		live.Update(room)
+		live.PublishUpdate()
+		info = live.Info()
+	}
+	h.broadcastRoom(room, utils.Marshal(info))
+
+	utils.SendJSONResponse(w, http.StatusOK, room)
+}
+
+// broadcastRoom sends an event about a room to the channel's other rooms;
+// the room numbers and sends its own copy, see Room.PublishUpdate. Private
+// rooms are only described to the online members on their allow-list.
+func (h *Handler) broadcastRoom(room *types.Room, msg []byte) {
+	if !room.IsPrivate {
+		hub.HubInstance.BroadcastToChannel(room.ChannelID, room.ID, msg)
+		return
+	}
+	for userID := range hub.HubInstance.OnlineUsers(room.ChannelID) {
+		id, err := strconv.Atoi(userID)
+		if err != nil {
+			continue
+		}
+		member, err := h.permissionStore.GetRoomMember(id, room.ID)
+		if err != nil {
+			if !errors.Is(err, types.ErrNotChannelMember) {
+				log.Println("Error checking room access: ", err)
+			}
+			continue
+		}
+		if member.CanAccess {
+			hub.HubInstance.SendToChannelUser(room.ChannelID, room.ID, userID, msg)
+		}
+	}
+}
+
+func (h *Handler) GetRoomAccess(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID, err := strconv.Atoi(vars["roomID"])
+	if err != nil {
+		log.Println("Invalid room ID")
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	user := auth.GetUserFromContext(r.Context())
+	if _, ok := permissions.AuthorizeRoom(w, h.permissionStore, user.ID, roomID, types.PermManageRooms); !ok {
+		return
+	}
+
+	access, err := h.store.GetRoomAccess(roomID)
+	if err != nil {
+		log.Println("Error: ", err)
+		http.Error(w, "Error getting room access", http.StatusInternalServerError)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, access)
+}
+
+// SetRoomAccess replaces a room's privacy flag and allow-list, then drops
+// live connections of anyone who is no longer let in.
+func (h *Handler) SetRoomAccess(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID, err := strconv.Atoi(vars["roomID"])
+	if err != nil {
+		log.Println("Invalid room ID")
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	user := auth.GetUserFromContext(r.Context())
+	member, ok := permissions.AuthorizeRoom(w, h.permissionStore, user.ID, roomID, types.PermManageRooms)
+	if !ok {
+		return
+	}
+
+	access := &types.RoomAccess{}
+	err = utils.ParseJSON(r, access)
+	if err != nil {
+		log.Println("Invalid JSON: ", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	access.RoomID = roomID
+
+	if err := h.validateRoomAccess(member.ChannelID, access); err != nil {
+		log.Println("Invalid room access: ", err)
+		http.Error(w, "Invalid room access: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.store.SetRoomAccess(access)
+	if err != nil {
+		log.Println("Error: ", err)
+		http.Error(w, "Error updating room access", http.StatusInternalServerError)
+		return
+	}
+
+	if room := hub.HubInstance.GetRoom(member.ChannelID, roomID); room != nil {
+		h.dropUnauthorizedClients(room)
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, access)
+}
+
+func (h *Handler) validateRoomAccess(channelID int, access *types.RoomAccess) error {
+	for _, role := range access.Roles {
+		if !types.IsBuiltinRole(role) {
+			return errors.New("unknown role " + role)
+		}
+	}
+	for _, roleID := range access.CustomRoleIDs {
+		if _, err := h.permissionStore.GetChannelRole(channelID, roleID); err != nil {
+			return errors.New("unknown custom role " + strconv.Itoa(roleID))
+		}
+	}
+	for _, userID := range access.UserIDs {
+		if _, err := h.permissionStore.GetChannelMember(userID, channelID); err != nil {
+			return errors.New("user " + strconv.Itoa(userID) + " is not a channel member")
+		}
+	}
+	return nil
+}
+
+func (h *Handler) dropUnauthorizedClients(room *types.Room) {
+	allowed := make(map[string]bool)
+	canAccess := func(id string) bool {
+		ok, seen := allowed[id]
+		if !seen {
+			userID, _ := strconv.Atoi(id)
+			member, err := h.permissionStore.GetRoomMember(userID, room.ID)
+			ok = err == nil && member.CanAccess
+			allowed[id] = ok
+		}
+		return ok
+	}
+	room.DisconnectClients(func(c *types.Client) bool {
+		return !canAccess(c.ID)
+	}, types.CloseRoomAccessRevoked, "room access revoked")
+	for _, userID := range room.ThreadFollowers() {
+		if !canAccess(userID) {
+			room.UnfollowThreads(userID)
+		}
+	}
+}
+
+// categoryExists writes a 400 when categoryID isn't one of the channel's
+// categories.
+func (h *Handler) categoryExists(w http.ResponseWriter, channelID, categoryID int) bool {
+	_, err := h.store.GetCategory(channelID, categoryID)
+	if errors.Is(err, types.ErrCategoryNotFound) {
+		http.Error(w, "Invalid category", http.StatusBadRequest)
+		return false
+	}
+	if err != nil {
+		log.Println("Error: ", err)
+		http.Error(w, "Error getting category", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) GetCategories(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	channelID, err := strconv.Atoi(vars["channelID"])
+	if err != nil {
+		log.Println("Invalid Channel ID")
+		http.Error(w, "Invalid Channel ID", http.StatusBadRequest)
+		return
+	}
+
+	user := auth.GetUserFromContext(r.Context())
+	if _, ok := permissions.Authorize(w, h.permissionStore, user.ID, channelID, 0); !ok {
+		return
+	}
+
+	categories, err := h.store.GetCategories(channelID)
+	if err != nil {
+		log.Println("Error: ", err)
+		http.Error(w, "Error getting categories", http.StatusInternalServerError)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, types.RoomCategoriesResponse{Categories: categories})
+}
+
+func (h *Handler) CreateCategory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	channelID, err := strconv.Atoi(vars["channelID"])
+	if err != nil {
+		log.Println("Invalid Channel ID")
+		http.Error(w, "Invalid Channel ID", http.StatusBadRequest)
+		return
+	}
+
+	user := auth.GetUserFromContext(r.Context())
+	if _, ok := permissions.Authorize(w, h.permissionStore, user.ID, channelID, types.PermManageRooms); !ok {
+		return
+	}
+
+	payload := &types.RoomCategoryPayload{}
+	err = utils.ParseJSON(r, payload)
+	if err != nil {
+		log.Println("Invalid JSON: ", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	category := &types.RoomCategory{ChannelID: channelID}
+	if !applyCategoryPayload(w, category, payload) {
+		return
+	}
+
+	err = h.store.CreateCategory(category)
+	if err != nil {
+		log.Println("Error: ", err)
+		http.Error(w, "Error creating category", http.StatusInternalServerError)
+		return
+	}
+
+	h.broadcastCategory(category, "category-created")
+	utils.SendJSONResponse(w, http.StatusCreated, category)
+}
+
+func (h *Handler) UpdateCategory(w http.ResponseWriter, r *http.Request) {
+	channelID, categoryID, ok := h.authorizeCategory(w, r)
+	if !ok {
+		return
+	}
+
+	payload := &types.RoomCategoryPayload{}
+	err := utils.ParseJSON(r, payload)
+	if err != nil {
+		log.Println("Invalid JSON: ", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	category, err := h.store.GetCategory(channelID, categoryID)
+	if errors.Is(err, types.ErrCategoryNotFound) {
+		http.Error(w, "Category not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Error: ", err)
+		http.Error(w, "Error updating category", http.StatusInternalServerError)
+		return
+	}
+	if !applyCategoryPayload(w, category, payload) {
+		return
+	}
+
+	err = h.store.UpdateCategory(category)
+	if err != nil {
+		log.Println("Error: ", err)
+		http.Error(w, "Error updating category", http.StatusInternalServerError)
+		return
+	}
+
+	h.broadcastCategory(category, "category-updated")
+	utils.SendJSONResponse(w, http.StatusOK, category)
+}
+
+// DeleteCategory removes a category. Its rooms stay and become
+// uncategorized.
+func (h *Handler) DeleteCategory(w http.ResponseWriter, r *http.Request) {
+	channelID, categoryID, ok := h.authorizeCategory(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.store.DeleteCategory(channelID, categoryID)
+	if errors.Is(err, types.ErrCategoryNotFound) {
+		http.Error(w, "Category not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Error: ", err)
+		http.Error(w, "Error deleting category", http.StatusInternalServerError)
+		return
+	}
+
+	hub.HubInstance.UncategorizeRooms(channelID, categoryID)
+	h.broadcastCategory(&types.RoomCategory{ID: categoryID, ChannelID: channelID}, "category-deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) authorizeCategory(w http.ResponseWriter, r *http.Request) (channelID, categoryID int, ok bool) {
+	vars := mux.Vars(r)
+	channelID, err := strconv.Atoi(vars["channelID"])
+	if err != nil {
+		log.Println("Invalid Channel ID")
+		http.Error(w, "Invalid Channel ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	categoryID, err = strconv.Atoi(vars["categoryID"])
+	if err != nil {
+		log.Println("Invalid category ID")
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+
+	user := auth.GetUserFromContext(r.Context())
+	if _, ok := permissions.Authorize(w, h.permissionStore, user.ID, channelID, types.PermManageRooms); !ok {
+		return 0, 0, false
+	}
+	return channelID, categoryID, true
+}
+
+func applyCategoryPayload(w http.ResponseWriter, category *types.RoomCategory, payload *types.RoomCategoryPayload) bool {
+	if payload.Name != nil {
+		category.Name = strings.TrimSpace(*payload.Name)
+	}
+	if payload.Position != nil {
+		category.Position = *payload.Position
+	}
+	if category.Name == "" || len(category.Name) > maxNameLength {
+		http.Error(w, "Invalid category name", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) broadcastCategory(category *types.RoomCategory, eventType string) {
+	hub.HubInstance.BroadcastToChannel(category.ChannelID, 0, utils.Marshal(types.RoomCategoryMessage{
+		Type:    eventType,
+		Payload: category,
+	}))
+}
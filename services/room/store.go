@@ -2,6 +2,7 @@ package room
 
 import (
 	"database/sql"
+	"errors"
 	"log"
 	"user/server/types"
 )
@@ -15,36 +16,95 @@ func NewStore(db *sql.DB) *Store {
 }
 
 func (s *Store) GetRoomsInChannel(channelID int) ([]*types.Room, error) {
-	rows, err := s.db.Query(`SELECT Rooms.* 
+	rows, err := s.db.Query(`SELECT Rooms.ID, Rooms.Name, Rooms.ChannelID, Rooms.IsPrivate, Rooms.Topic, Rooms.Position,
+                                   Rooms.Kind, Rooms.CategoryID
                             FROM Rooms
                             JOIN RoomsToChannels 
                             ON Rooms.ID= RoomsToChannels.room_id
-                            WHERE RoomsToChannels.channel_id = $1`, channelID)
+                            WHERE RoomsToChannels.channel_id = $1
+                            ORDER BY Rooms.Position, Rooms.ID`, channelID)
 	if err != nil {
 		log.Println("Error getting rooms in channel")
 		return nil, err
 	}
+	return scanRooms(rows)
+}
+
+func (s *Store) GetRoomsForMember(member *types.ChannelMember) ([]*types.Room, error) {
+	rows, err := s.db.Query(`SELECT Rooms.ID, Rooms.Name, Rooms.ChannelID, Rooms.IsPrivate, Rooms.Topic, Rooms.Position,
+                                   Rooms.Kind, Rooms.CategoryID
+                            FROM Rooms
+                            JOIN RoomsToChannels 
+                            ON Rooms.ID= RoomsToChannels.room_id
+                            WHERE RoomsToChannels.channel_id = $1
+                            AND (NOT Rooms.IsPrivate OR $2 OR EXISTS (
+                                SELECT 1 FROM RoomAccess
+                                WHERE RoomAccess.RoomID = Rooms.ID
+                                AND (RoomAccess.UserID = $3
+                                     OR RoomAccess.Role = $4
+                                     OR RoomAccess.CustomRoleID = $5)))
+                            ORDER BY Rooms.Position, Rooms.ID`,
+		member.ChannelID, member.Can(types.PermManageRooms), member.UserID, member.Role, member.CustomRoleID)
+	if err != nil {
+		log.Println("Error getting rooms for member")
+		return nil, err
+	}
+	return scanRooms(rows)
+}
+
+func scanRooms(rows *sql.Rows) ([]*types.Room, error) {
 	defer rows.Close()
 
 	rooms := make([]*types.Room, 0)
 	for rows.Next() {
 		room := &types.Room{}
-		err := rows.Scan(&room.ID, &room.Name, &room.ChannelID)
+		var categoryID sql.NullInt64
+		err := rows.Scan(&room.ID, &room.Name, &room.ChannelID, &room.IsPrivate, &room.Topic, &room.Position,
+			&room.Kind, &categoryID)
 		if err != nil {
 			log.Println("Error scanning room")
 			return nil, err
 		}
+		room.CategoryID = nullableID(categoryID)
 		rooms = append(rooms, room)
 	}
 
 	return rooms, nil
 }
 
+func nullableID(id sql.NullInt64) *int {
+	if !id.Valid {
+		return nil
+	}
+	v := int(id.Int64)
+	return &v
+}
+
+func (s *Store) GetRoom(roomID int) (*types.Room, error) {
+	room := &types.Room{}
+	var categoryID sql.NullInt64
+	err := s.db.QueryRow(`SELECT ID, Name, ChannelID, IsPrivate, Topic, Position, Kind, CategoryID
+                          FROM Rooms WHERE ID = $1`, roomID).
+		Scan(&room.ID, &room.Name, &room.ChannelID, &room.IsPrivate, &room.Topic, &room.Position,
+			&room.Kind, &categoryID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrRoomNotFound
+	}
+	if err != nil {
+		log.Println("Error getting room")
+		return nil, err
+	}
+	room.CategoryID = nullableID(categoryID)
+
+	return room, nil
+}
+
 func (s *Store) CreateRoom(room *types.Room) error {
 	err := s.db.QueryRow(`
 			INSERT INTO Rooms 
-			(Name, ChannelID) 
-			VALUES ($1, $2) RETURNING ID`, room.Name, room.ChannelID).Scan(&room.ID)
+			(Name, ChannelID, IsPrivate, Topic, Position, Kind, CategoryID) 
+			VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING ID`,
+		room.Name, room.ChannelID, room.IsPrivate, room.Topic, room.Position, room.Kind, room.CategoryID).Scan(&room.ID)
 	if err != nil {
 		log.Println("Error creating room")
 		return err
@@ -60,6 +120,17 @@ func (s *Store) CreateRoom(room *types.Room) error {
 	return nil
 }
 
+func (s *Store) UpdateRoom(room *types.Room) error {
+	_, err := s.db.Exec(`UPDATE Rooms SET Name = $1, Topic = $2, Position = $3, CategoryID = $4 WHERE ID = $5`,
+		room.Name, room.Topic, room.Position, room.CategoryID, room.ID)
+	if err != nil {
+		log.Println("Error updating room")
+		return err
+	}
+
+	return nil
+}
+
 func (s *Store) DeleteRoom(roomID int) (*types.Room, error) {
 	room := &types.Room{}
 	err := s.db.QueryRow(`DELETE FROM Rooms WHERE ID = $1
@@ -71,3 +142,165 @@ func (s *Store) DeleteRoom(roomID int) (*types.Room, error) {
 
 	return room, nil
 }
+
+func (s *Store) GetRoomAccess(roomID int) (*types.RoomAccess, error) {
+	access := &types.RoomAccess{
+		RoomID:        roomID,
+		UserIDs:       make([]int, 0),
+		Roles:         make([]string, 0),
+		CustomRoleIDs: make([]int, 0),
+	}
+	err := s.db.QueryRow(`SELECT IsPrivate FROM Rooms WHERE ID = $1`, roomID).Scan(&access.IsPrivate)
+	if err != nil {
+		log.Println("Error getting room")
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`SELECT UserID, Role, CustomRoleID FROM RoomAccess WHERE RoomID = $1`, roomID)
+	if err != nil {
+		log.Println("Error getting room access")
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID, customRoleID sql.NullInt64
+		var role sql.NullString
+		err := rows.Scan(&userID, &role, &customRoleID)
+		if err != nil {
+			log.Println("Error scanning room access")
+			return nil, err
+		}
+		switch {
+		case userID.Valid:
+			access.UserIDs = append(access.UserIDs, int(userID.Int64))
+		case role.Valid:
+			access.Roles = append(access.Roles, role.String)
+		case customRoleID.Valid:
+			access.CustomRoleIDs = append(access.CustomRoleIDs, int(customRoleID.Int64))
+		}
+	}
+
+	return access, rows.Err()
+}
+
+// SetRoomAccess replaces the room's privacy flag and allow-list.
+func (s *Store) SetRoomAccess(access *types.RoomAccess) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE Rooms SET IsPrivate = $1 WHERE ID = $2`, access.IsPrivate, access.RoomID)
+	if err != nil {
+		log.Println("Error updating room privacy")
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM RoomAccess WHERE RoomID = $1`, access.RoomID)
+	if err != nil {
+		log.Println("Error clearing room access")
+		return err
+	}
+
+	for _, userID := range access.UserIDs {
+		_, err = tx.Exec(`INSERT INTO RoomAccess (RoomID, UserID) VALUES ($1, $2)`, access.RoomID, userID)
+		if err != nil {
+			log.Println("Error adding user to room access")
+			return err
+		}
+	}
+	for _, role := range access.Roles {
+		_, err = tx.Exec(`INSERT INTO RoomAccess (RoomID, Role) VALUES ($1, $2)`, access.RoomID, role)
+		if err != nil {
+			log.Println("Error adding role to room access")
+			return err
+		}
+	}
+	for _, roleID := range access.CustomRoleIDs {
+		_, err = tx.Exec(`INSERT INTO RoomAccess (RoomID, CustomRoleID) VALUES ($1, $2)`, access.RoomID, roleID)
+		if err != nil {
+			log.Println("Error adding custom role to room access")
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) GetCategories(channelID int) ([]*types.RoomCategory, error) {
+	rows, err := s.db.Query(`SELECT ID, ChannelID, Name, Position FROM RoomCategories
+                            WHERE ChannelID = $1 ORDER BY Position, ID`, channelID)
+	if err != nil {
+		log.Println("Error getting room categories")
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := make([]*types.RoomCategory, 0)
+	for rows.Next() {
+		category := &types.RoomCategory{}
+		err := rows.Scan(&category.ID, &category.ChannelID, &category.Name, &category.Position)
+		if err != nil {
+			log.Println("Error scanning room category")
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+
+	return categories, rows.Err()
+}
+
+func (s *Store) GetCategory(channelID, categoryID int) (*types.RoomCategory, error) {
+	category := &types.RoomCategory{}
+	err := s.db.QueryRow(`SELECT ID, ChannelID, Name, Position FROM RoomCategories
+                          WHERE ChannelID = $1 AND ID = $2`, channelID, categoryID).
+		Scan(&category.ID, &category.ChannelID, &category.Name, &category.Position)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrCategoryNotFound
+	}
+	if err != nil {
+		log.Println("Error getting room category")
+		return nil, err
+	}
+
+	return category, nil
+}
+
+func (s *Store) CreateCategory(category *types.RoomCategory) error {
+	err := s.db.QueryRow(`INSERT INTO RoomCategories (ChannelID, Name, Position)
+                          VALUES ($1, $2, $3) RETURNING ID`,
+		category.ChannelID, category.Name, category.Position).Scan(&category.ID)
+	if err != nil {
+		log.Println("Error creating room category")
+		return err
+	}
+
+	return nil
+}
+
+func (s *Store) UpdateCategory(category *types.RoomCategory) error {
+	_, err := s.db.Exec(`UPDATE RoomCategories SET Name = $1, Position = $2 WHERE ID = $3`,
+		category.Name, category.Position, category.ID)
+	if err != nil {
+		log.Println("Error updating room category")
+		return err
+	}
+
+	return nil
+}
+
+// DeleteCategory leaves the category's rooms in place, uncategorized.
+func (s *Store) DeleteCategory(channelID, categoryID int) error {
+	res, err := s.db.Exec(`DELETE FROM RoomCategories WHERE ChannelID = $1 AND ID = $2`, channelID, categoryID)
+	if err != nil {
+		log.Println("Error deleting room category")
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return types.ErrCategoryNotFound
+	}
+
+	return nil
+}
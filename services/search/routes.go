@@ -0,0 +1,160 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"user/server/services/auth"
+	"user/server/services/utils"
+	"user/server/types"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 50
+	// Deep pages of ranked results get expensive, and nobody reads them.
+	maxOffset      = 1000
+	maxQueryLength = 256
+)
+
+type Handler struct {
+	store     types.SearchStore
+	userStore types.UserStore
+}
+
+func NewHandler(store types.SearchStore, userStore types.UserStore) *Handler {
+	return &Handler{store: store, userStore: userStore}
+}
+
+func (h *Handler) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/search/messages", utils.CorsHandler(
+		auth.WithJWTAuth(h.SearchMessagesHandler, h.userStore),
+	)).Methods("GET", "OPTIONS")
+}
+
+// SearchMessagesHandler runs a full-text search over the messages the
+// caller can read. q takes web search syntax ("quoted phrases", -excluded,
+// or) plus has:attachment. channel_id, room_id, sender_id, since and until
+// narrow it down; limit and offset page through it.
+func (h *Handler) SearchMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	query, err := parseQuery(r.URL.Query(), user.ID)
+	if err != nil {
+		handleError(w, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	results, err := h.store.SearchMessages(query)
+	if err != nil {
+		handleError(w, "Error searching messages", http.StatusInternalServerError, err)
+		return
+	}
+
+	response := types.SearchResponse{Results: results}
+	if len(results) > query.Limit {
+		response.Results = results[:query.Limit]
+		response.HasMore = true
+		response.NextOffset = query.Offset + query.Limit
+	}
+	utils.SendJSONResponse(w, http.StatusOK, response)
+}
+
+func parseQuery(values url.Values, userID int) (*types.SearchQuery, error) {
+	query := &types.SearchQuery{UserID: userID, Limit: defaultPageSize}
+
+	terms := make([]string, 0)
+	for _, term := range strings.Fields(values.Get("q")) {
+		if strings.EqualFold(term, "has:attachment") {
+			query.HasAttachment = true
+			continue
+		}
+		terms = append(terms, term)
+	}
+	query.Text = strings.Join(terms, " ")
+	if query.Text == "" {
+		return nil, errors.New("q is required")
+	}
+	if len(query.Text) > maxQueryLength {
+		return nil, errors.New("q is too long")
+	}
+	if values.Get("has") == "attachment" {
+		query.HasAttachment = true
+	}
+
+	var err error
+	if query.ChannelID, err = optionalID(values, "channel_id"); err != nil {
+		return nil, err
+	}
+	if query.RoomID, err = optionalID(values, "room_id"); err != nil {
+		return nil, err
+	}
+	if query.SenderID, err = optionalID(values, "sender_id"); err != nil {
+		return nil, err
+	}
+	if query.Since, err = optionalTime(values, "since"); err != nil {
+		return nil, err
+	}
+	if query.Until, err = optionalTime(values, "until"); err != nil {
+		return nil, err
+	}
+	if query.Since != nil && query.Until != nil && !query.Since.Before(*query.Until) {
+		return nil, errors.New("since must be before until")
+	}
+
+	if value := values.Get("limit"); value != "" {
+		query.Limit, err = strconv.Atoi(value)
+		if err != nil || query.Limit < 1 || query.Limit > maxPageSize {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+	}
+	if value := values.Get("offset"); value != "" {
+		query.Offset, err = strconv.Atoi(value)
+		if err != nil || query.Offset < 0 || query.Offset > maxOffset {
+			return nil, fmt.Errorf("offset must be between 0 and %d", maxOffset)
+		}
+	}
+	return query, nil
+}
+
+func optionalID(values url.Values, name string) (*int, error) {
+	value := values.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, errors.New("invalid " + name)
+	}
+	return &id, nil
+}
+
+// optionalTime takes a date or an RFC 3339 timestamp. Dates are midnight
+// UTC.
+func optionalTime(values url.Values, name string) (*time.Time, error) {
+	value := values.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, errors.New("invalid " + name + ", use YYYY-MM-DD or RFC 3339")
+}
+
+func handleError(w http.ResponseWriter, message string, statusCode int, err error) {
+	if err != nil {
+		log.Println(message, err)
+	} else {
+		log.Println(message)
+	}
+	http.Error(w, message, statusCode)
+}
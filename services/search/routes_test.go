@@ -0,0 +1,47 @@
+package search
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	q, err := parseQuery(url.Values{
+		"q":         {"deploy failed"},
+		"room_id":   {"4"},
+		"since":     {"2024-01-01"},
+		"until":     {"2024-02-01T12:00:00Z"},
+		"limit":     {"10"},
+		"offset":    {"20"},
+		"sender_id": {"7"},
+	}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.UserID != 1 || q.Text != "deploy failed" || *q.RoomID != 4 || *q.SenderID != 7 || q.ChannelID != nil {
+		t.Errorf("unexpected query %+v", q)
+	}
+	if !q.Since.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) || q.Limit != 10 || q.Offset != 20 {
+		t.Errorf("unexpected query %+v", q)
+	}
+
+	q, err = parseQuery(url.Values{"q": {"has:attachment invoice"}}, 1)
+	if err != nil || !q.HasAttachment || q.Text != "invoice" {
+		t.Errorf("has:attachment: %+v, %v", q, err)
+	}
+
+	for _, values := range []url.Values{
+		{},
+		{"q": {"   "}},
+		{"q": {"x"}, "room_id": {"abc"}},
+		{"q": {"x"}, "since": {"yesterday"}},
+		{"q": {"x"}, "since": {"2024-02-01"}, "until": {"2024-01-01"}},
+		{"q": {"x"}, "limit": {"500"}},
+		{"q": {"x"}, "offset": {"-1"}},
+	} {
+		if _, err := parseQuery(values, 1); err == nil {
+			t.Errorf("parseQuery(%v) should fail", values)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package search
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"user/server/services/permissions"
+	"user/server/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// The snippet is built from escaped content so clients can render it as
+// HTML.
+const snippet = `ts_headline('english',
+	replace(replace(replace(m.Content, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+	query.q, 'StartSel=<mark>, StopSel=</mark>, MaxWords=35, MinWords=15')`
+
+func (s *Store) SearchMessages(q *types.SearchQuery) ([]*types.SearchResult, error) {
+	args := append(permissions.VisibleRoomsArgs(q.UserID), q.Text)
+	param := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	filters := ""
+	if q.ChannelID != nil {
+		filters += " AND r.ChannelID = " + param(*q.ChannelID)
+	}
+	if q.RoomID != nil {
+		filters += " AND m.RoomID = " + param(*q.RoomID)
+	}
+	if q.SenderID != nil {
+		filters += " AND m.SenderID = " + param(*q.SenderID)
+	}
+	if q.Since != nil {
+		filters += " AND m.Timestamp >= " + param(*q.Since)
+	}
+	if q.Until != nil {
+		filters += " AND m.Timestamp < " + param(*q.Until)
+	}
+	if q.HasAttachment {
+		filters += " AND EXISTS (SELECT 1 FROM Attachments a WHERE a.MessageID = m.ID)"
+	}
+
+	rows, err := s.db.Query(`
+		WITH visible AS (`+permissions.VisibleRooms+`),
+		query AS (SELECT websearch_to_tsquery('english', $4) AS q)
+		SELECT m.ID, m.RoomID, COALESCE(r.ChannelID, 0), m.ParentID, m.SenderID, u.Username, m.Timestamp,
+			`+snippet+`, ts_rank_cd(m.SearchVector, query.q) AS rank
+		FROM Messages m
+		JOIN visible ON visible.ID = m.RoomID
+		JOIN Rooms r ON r.ID = m.RoomID
+		JOIN Users u ON u.ID = m.SenderID
+		CROSS JOIN query
+		WHERE m.SearchVector @@ query.q AND m.DeletedAt IS NULL`+filters+`
+		ORDER BY rank DESC, m.Timestamp DESC, m.ID DESC
+		LIMIT `+param(q.Limit+1)+` OFFSET `+param(q.Offset), args...)
+	if err != nil {
+		log.Println("Error searching messages: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]*types.SearchResult, 0)
+	for rows.Next() {
+		r := &types.SearchResult{}
+		var parentID sql.NullInt64
+		err := rows.Scan(&r.MessageID, &r.RoomID, &r.ChannelID, &parentID, &r.SenderID, &r.SenderName,
+			&r.Timestamp, &r.Snippet, &r.Rank)
+		if err != nil {
+			log.Println("Error scanning search result: ", err)
+			return nil, err
+		}
+		if parentID.Valid {
+			id := int(parentID.Int64)
+			r.ParentID = &id
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
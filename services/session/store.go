@@ -0,0 +1,180 @@
+package session
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+	"user/server/types"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) CreateSession(session *types.Session) error {
+	err := s.db.QueryRow(`
+		INSERT INTO Sessions (ID, UserID, Device, IPAddress, UserAgent, ExpiresAt)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING CreatedAt, LastSeen`,
+		session.ID, session.UserID, session.Device, session.IPAddress,
+		session.UserAgent, session.ExpiresAt).Scan(&session.CreatedAt, &session.LastSeen)
+	if err != nil {
+		log.Println("Error creating session: ", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) GetSession(sessionID string) (*types.Session, error) {
+	session := &types.Session{}
+	err := s.db.QueryRow(`
+		SELECT ID, UserID, Device, IPAddress, UserAgent,
+		       CreatedAt, LastSeen, ExpiresAt, RevokedAt
+		FROM Sessions WHERE ID = $1`, sessionID).Scan(
+		&session.ID, &session.UserID, &session.Device, &session.IPAddress,
+		&session.UserAgent, &session.CreatedAt, &session.LastSeen,
+		&session.ExpiresAt, &session.RevokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrSessionNotFound
+	}
+	if err != nil {
+		log.Println("Error getting session: ", err)
+		return nil, err
+	}
+	return session, nil
+}
+
+func (s *Store) GetActiveSessionsForUser(userID int) ([]*types.Session, error) {
+	rows, err := s.db.Query(`
+		SELECT ID, UserID, Device, IPAddress, UserAgent,
+		       CreatedAt, LastSeen, ExpiresAt, RevokedAt
+		FROM Sessions
+		WHERE UserID = $1 AND RevokedAt IS NULL AND ExpiresAt > CURRENT_TIMESTAMP
+		ORDER BY LastSeen DESC`, userID)
+	if err != nil {
+		log.Println("Error getting sessions for user: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make([]*types.Session, 0)
+	for rows.Next() {
+		session := &types.Session{}
+		err := rows.Scan(
+			&session.ID, &session.UserID, &session.Device, &session.IPAddress,
+			&session.UserAgent, &session.CreatedAt, &session.LastSeen,
+			&session.ExpiresAt, &session.RevokedAt)
+		if err != nil {
+			log.Println("Error scanning session: ", err)
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+func (s *Store) TouchSession(sessionID string) error {
+	_, err := s.db.Exec(`UPDATE Sessions SET LastSeen = CURRENT_TIMESTAMP WHERE ID = $1`, sessionID)
+	if err != nil {
+		log.Println("Error touching session: ", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) ExtendSession(sessionID string, expiresAt time.Time) error {
+	_, err := s.db.Exec(`
+		UPDATE Sessions SET ExpiresAt = $1, LastSeen = CURRENT_TIMESTAMP
+		WHERE ID = $2 AND RevokedAt IS NULL`, expiresAt, sessionID)
+	if err != nil {
+		log.Println("Error extending session: ", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) RevokeSession(sessionID string) error {
+	_, err := s.db.Exec(`
+		UPDATE Sessions SET RevokedAt = CURRENT_TIMESTAMP
+		WHERE ID = $1 AND RevokedAt IS NULL`, sessionID)
+	if err != nil {
+		log.Println("Error revoking session: ", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) RevokeUserSessions(userID int) ([]string, error) {
+	rows, err := s.db.Query(`
+		UPDATE Sessions SET RevokedAt = CURRENT_TIMESTAMP
+		WHERE UserID = $1 AND RevokedAt IS NULL
+		RETURNING ID`, userID)
+	if err != nil {
+		log.Println("Error revoking sessions for user: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessionIDs := make([]string, 0)
+	for rows.Next() {
+		var sessionID string
+		if err := rows.Scan(&sessionID); err != nil {
+			log.Println("Error scanning session ID: ", err)
+			return nil, err
+		}
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+
+	return sessionIDs, nil
+}
+
+func (s *Store) CreateRefreshToken(token *types.RefreshToken) error {
+	err := s.db.QueryRow(`
+		INSERT INTO RefreshTokens (TokenHash, SessionID, ExpiresAt)
+		VALUES ($1, $2, $3)
+		RETURNING CreatedAt`,
+		token.TokenHash, token.SessionID, token.ExpiresAt).Scan(&token.CreatedAt)
+	if err != nil {
+		log.Println("Error creating refresh token: ", err)
+		return err
+	}
+	return nil
+}
+
+// UseRefreshToken marks the token as used. A token that was already used
+// is returned together with ErrRefreshTokenReused so the caller can revoke
+// its session.
+func (s *Store) UseRefreshToken(tokenHash string) (*types.RefreshToken, error) {
+	token := &types.RefreshToken{TokenHash: tokenHash}
+	err := s.db.QueryRow(`
+		UPDATE RefreshTokens SET UsedAt = CURRENT_TIMESTAMP
+		WHERE TokenHash = $1 AND UsedAt IS NULL
+		RETURNING SessionID, CreatedAt, ExpiresAt, UsedAt`, tokenHash).Scan(
+		&token.SessionID, &token.CreatedAt, &token.ExpiresAt, &token.UsedAt)
+	if err == nil {
+		return token, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		log.Println("Error using refresh token: ", err)
+		return nil, err
+	}
+
+	err = s.db.QueryRow(`
+		SELECT SessionID, CreatedAt, ExpiresAt, UsedAt
+		FROM RefreshTokens WHERE TokenHash = $1`, tokenHash).Scan(
+		&token.SessionID, &token.CreatedAt, &token.ExpiresAt, &token.UsedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		log.Println("Error getting refresh token: ", err)
+		return nil, err
+	}
+	return token, types.ErrRefreshTokenReused
+}
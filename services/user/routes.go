@@ -2,13 +2,16 @@ package user
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
-	"strings"
-	"time"
+	"user/server/services/account"
 	"user/server/services/auth"
+	"user/server/services/hub"
 	"user/server/services/image"
+	"user/server/services/mail"
 	"user/server/services/utils"
 	"user/server/types"
 
@@ -16,16 +19,49 @@ import (
 )
 
 type Handler struct {
-	store types.UserStore
+	store        types.UserStore
+	sessionStore types.SessionStore
+	mfaStore     types.MFAStore
+	mailer       types.Mailer
+	appURL       string
+	limiter      *auth.LoginLimiter
 }
 
-func NewHandler(store types.UserStore) *Handler {
-	return &Handler{store: store}
+func NewHandler(
+	store types.UserStore,
+	sessionStore types.SessionStore,
+	mfaStore types.MFAStore,
+	mailer types.Mailer,
+	appURL string,
+	limiter *auth.LoginLimiter) *Handler {
+	return &Handler{
+		store:        store,
+		sessionStore: sessionStore,
+		mfaStore:     mfaStore,
+		mailer:       mailer,
+		appURL:       appURL,
+		limiter:      limiter,
+	}
 }
 
 func (h *Handler) RegisterRoutes(r *mux.Router) {
 	r.HandleFunc("/auth", utils.CorsHandler(h.AuthHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/auth/mfa", utils.CorsHandler(h.MFAAuthHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/auth/refresh", utils.CorsHandler(h.RefreshHandler)).Methods("POST", "OPTIONS")
 	r.HandleFunc("/register", utils.CorsHandler(h.RegisterHandler)).Methods("POST", "OPTIONS")
+
+	r.HandleFunc("/logout", utils.CorsHandler(
+		auth.WithSessionAuth(h.LogoutHandler, h.store),
+	)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/logout-all", utils.CorsHandler(
+		auth.WithSessionAuth(h.LogoutAllHandler, h.store),
+	)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/sessions", utils.CorsHandler(
+		auth.WithSessionAuth(h.GetSessionsHandler, h.store),
+	)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/sessions/{sessionID}", utils.CorsHandler(
+		auth.WithSessionAuth(h.RevokeSessionHandler, h.store),
+	)).Methods("DELETE", "OPTIONS")
 }
 
 func (h *Handler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
@@ -37,6 +73,15 @@ func (h *Handler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := mail.ValidateAddress(user.Email); err != nil {
+		handleError(w, "Invalid email address", http.StatusBadRequest, err)
+		return
+	}
+	if types.IsBotEmail(user.Email) {
+		handleError(w, "Invalid email address", http.StatusBadRequest, nil)
+		return
+	}
+
 	profilePictureData, err := validateAndDecodeImage(user.Avatar)
 	if err != nil {
 		handleError(w, "Invalid base64 image", http.StatusBadRequest, err)
@@ -55,13 +100,19 @@ func (h *Handler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := generateJWTToken(userID)
+	tokens, err := issueSession(r, userID)
 	if err != nil {
 		handleError(w, "Error generating JWT token", http.StatusInternalServerError, err)
 		return
 	}
 
-	setJWTCookie(w, origin, token)
+	// The account works without it, the user can ask for a new link later.
+	err = account.SendVerificationEmail(h.mailer, h.appURL, &types.User{ID: userID, Username: user.Email})
+	if err != nil {
+		log.Println("Error sending verification email: ", err)
+	}
+
+	setAuthCookies(w, origin, tokens)
 
 	prepareResponse(w, userID, user.Avatar, "User registered successfully", http.StatusCreated)
 }
@@ -75,28 +126,258 @@ func (h *Handler) AuthHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.rejectLockedOut(w, r, creds.Email) {
+		return
+	}
+
 	user, err := getUserByEmail(h.store, creds.Email)
 	if err != nil {
+		h.limiter.Fail(r, creds.Email, nil, "unknown account")
 		handleError(w, "Error getting user by email", http.StatusUnauthorized, err)
 		return
 	}
 
 	if !comparePasswords(user.Password, []byte(creds.Password)) {
+		h.limiter.Fail(r, creds.Email, &user.ID, "invalid password")
 		handleError(w, "Invalid credentials", http.StatusUnauthorized, nil)
 		return
 	}
 
-	token, err := generateJWTToken(int(user.ID))
+	mfa, err := h.mfaStore.GetMFA(user.ID)
+	if err != nil && !errors.Is(err, types.ErrMFANotFound) {
+		handleError(w, "Error getting MFA settings", http.StatusInternalServerError, err)
+		return
+	}
+	if err == nil && mfa.Enabled {
+		mfaToken, err := auth.IssueMFAToken(h.mfaStore, user.ID)
+		if err != nil {
+			handleError(w, "Error generating MFA token", http.StatusInternalServerError, err)
+			return
+		}
+
+		utils.SendJSONResponse(w, http.StatusOK, types.MFAChallengeResponse{
+			Success:     true,
+			MFARequired: true,
+			MFAToken:    mfaToken,
+		})
+		return
+	}
+
+	h.limiter.Succeed(user.Username)
+
+	tokens, err := issueSession(r, user.ID)
 	if err != nil {
 		handleError(w, "Error generating JWT token", http.StatusInternalServerError, err)
 		return
 	}
 
-	setJWTCookie(w, origin, token)
+	setAuthCookies(w, origin, tokens)
 
 	prepareResponse(w, int(user.ID), image.EncodeB64Image(user.Avatar), "Authentication successful", http.StatusOK)
 }
 
+// MFAAuthHandler is the second login step for users with MFA enabled. It
+// trades the mfa_token from AuthHandler and a TOTP or recovery code for a
+// session. Wrong codes count against the same lockout as wrong passwords,
+// and each mfa_token can only complete one login.
+func (h *Handler) MFAAuthHandler(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+
+	payload := &types.MFALoginPayload{}
+	if err := utils.ParseJSON(r, payload); err != nil {
+		handleError(w, "Invalid request body", http.StatusBadRequest, err)
+		return
+	}
+
+	userID, tokenID, err := auth.ParseMFAToken(payload.MFAToken)
+	if err != nil {
+		handleError(w, "Invalid or expired MFA token", http.StatusUnauthorized, err)
+		return
+	}
+
+	user, err := h.store.GetUserByID(userID)
+	if err != nil {
+		handleError(w, "Error getting user", http.StatusUnauthorized, err)
+		return
+	}
+
+	if h.rejectLockedOut(w, r, user.Username) {
+		return
+	}
+
+	mfa, err := h.mfaStore.GetMFA(userID)
+	if errors.Is(err, types.ErrMFANotFound) || (err == nil && !mfa.Enabled) {
+		handleError(w, "Invalid or expired MFA token", http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		handleError(w, "Error getting MFA settings", http.StatusInternalServerError, err)
+		return
+	}
+
+	ok, err := auth.VerifyMFACode(h.mfaStore, mfa, payload.Code)
+	if err != nil {
+		handleError(w, "Error verifying code", http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		h.limiter.Fail(r, user.Username, &user.ID, "invalid mfa code")
+		handleError(w, "Invalid code", http.StatusUnauthorized, nil)
+		return
+	}
+
+	// The token is only spent by a correct code, so a typo doesn't send the
+	// user back to the password step.
+	err = h.mfaStore.UseMFAChallenge(tokenID, user.ID)
+	if errors.Is(err, types.ErrMFAChallengeNotFound) {
+		h.limiter.Fail(r, user.Username, &user.ID, "reused mfa token")
+		handleError(w, "Invalid or expired MFA token", http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		handleError(w, "Error verifying code", http.StatusInternalServerError, err)
+		return
+	}
+
+	h.limiter.Succeed(user.Username)
+
+	tokens, err := issueSession(r, user.ID)
+	if err != nil {
+		handleError(w, "Error generating JWT token", http.StatusInternalServerError, err)
+		return
+	}
+
+	setAuthCookies(w, origin, tokens)
+
+	prepareResponse(w, user.ID, image.EncodeB64Image(user.Avatar), "Authentication successful", http.StatusOK)
+}
+
+// rejectLockedOut answers 429 with a Retry-After header while the account
+// or the client IP is locked out.
+func (h *Handler) rejectLockedOut(w http.ResponseWriter, r *http.Request, email string) bool {
+	wait, err := h.limiter.Check(r, email)
+	if err != nil {
+		handleError(w, "Internal server error", http.StatusInternalServerError, err)
+		return true
+	}
+	if wait <= 0 {
+		return false
+	}
+
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	handleError(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests, nil)
+	return true
+}
+
+func (h *Handler) RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+
+	refreshToken := ""
+	if cookie, err := r.Cookie(auth.RefreshTokenCookie); err == nil {
+		refreshToken = cookie.Value
+	} else {
+		payload := &types.RefreshRequestPayload{}
+		if err := utils.ParseJSON(r, payload); err == nil {
+			refreshToken = payload.RefreshToken
+		}
+	}
+
+	tokens, err := auth.RefreshSession(refreshToken)
+	if errors.Is(err, auth.ErrInvalidRefreshToken) {
+		handleError(w, "Invalid refresh token", http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		handleError(w, "Error refreshing session", http.StatusInternalServerError, err)
+		return
+	}
+
+	setAuthCookies(w, origin, tokens)
+
+	utils.SendJSONResponse(w, http.StatusOK, types.RefreshResponse{
+		Success:   true,
+		ExpiresAt: tokens.AccessExpiresAt,
+	})
+}
+
+func (h *Handler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	sessionID := auth.GetSessionIDFromContext(r.Context())
+
+	err := h.sessionStore.RevokeSession(sessionID)
+	if err != nil {
+		handleError(w, "Error logging out", http.StatusInternalServerError, err)
+		return
+	}
+
+	hub.HubInstance.DisconnectSession(sessionID)
+	auth.ClearAuthCookies(w, origin)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) LogoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	user := auth.GetUserFromContext(r.Context())
+
+	sessionIDs, err := h.sessionStore.RevokeUserSessions(user.ID)
+	if err != nil {
+		handleError(w, "Error logging out", http.StatusInternalServerError, err)
+		return
+	}
+
+	for _, sessionID := range sessionIDs {
+		hub.HubInstance.DisconnectSession(sessionID)
+	}
+	auth.ClearAuthCookies(w, origin)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) GetSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	currentSessionID := auth.GetSessionIDFromContext(r.Context())
+
+	sessions, err := h.sessionStore.GetActiveSessionsForUser(user.ID)
+	if err != nil {
+		handleError(w, "Error getting sessions", http.StatusInternalServerError, err)
+		return
+	}
+
+	for _, session := range sessions {
+		session.Current = session.ID == currentSessionID
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, types.SessionsResponse{Sessions: sessions})
+}
+
+func (h *Handler) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	sessionID := mux.Vars(r)["sessionID"]
+	user := auth.GetUserFromContext(r.Context())
+
+	session, err := h.sessionStore.GetSession(sessionID)
+	if errors.Is(err, types.ErrSessionNotFound) || (err == nil && session.UserID != user.ID) {
+		handleError(w, "Session not found", http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		handleError(w, "Error getting session", http.StatusInternalServerError, err)
+		return
+	}
+
+	err = h.sessionStore.RevokeSession(sessionID)
+	if err != nil {
+		handleError(w, "Error revoking session", http.StatusInternalServerError, err)
+		return
+	}
+
+	hub.HubInstance.DisconnectSession(sessionID)
+	if sessionID == auth.GetSessionIDFromContext(r.Context()) {
+		auth.ClearAuthCookies(w, origin)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func decodeRegisterRequestBody(r *http.Request) (*types.RegisterUserPayload, error) {
 	user := &types.RegisterUserPayload{}
 	err := json.NewDecoder(r.Body).Decode(user)
@@ -134,25 +415,12 @@ func comparePasswords(hashedPassword string, plainPassword []byte) bool {
 	return auth.ComparePasswords(hashedPassword, plainPassword)
 }
 
-func generateJWTToken(userID int) (string, error) {
-	return auth.GenerateJWTToken(strconv.Itoa(userID))
+func issueSession(r *http.Request, userID int) (*types.AuthTokens, error) {
+	return auth.IssueSession(r, userID)
 }
 
-func setJWTCookie(w http.ResponseWriter, origin, token string) {
-	parts := strings.Split(origin, "://")
-	domain := "localhost"
-	if len(parts) > 1 {
-		domainParts := strings.Split(parts[1], ":")
-		domain = domainParts[0]
-	}
-
-	http.SetCookie(w, &http.Cookie{
-		Name:    "jwt_token",
-		Value:   token,
-		Expires: time.Now().Add(24 * time.Hour),
-		Domain:  domain,
-		Path:    "/",
-	})
+func setAuthCookies(w http.ResponseWriter, origin string, tokens *types.AuthTokens) {
+	auth.SetAuthCookies(w, origin, tokens)
 }
 
 func prepareResponse(w http.ResponseWriter, userID int, avatar string, message string, statusCode int) {
@@ -30,9 +30,9 @@ func (s *Store) CreateUser(user types.User) (int, error) {
 	var userID int
 	err = s.db.QueryRow(`
 				INSERT INTO Users 
-				(Username, Password, Avatar) 
-				VALUES ($1, $2, $3) RETURNING ID`,
-		user.Username, user.Password, user.Avatar).Scan(&userID)
+				(Username, Password, Avatar, IsBot, OwnerID) 
+				VALUES ($1, $2, $3, $4, $5) RETURNING ID`,
+		user.Username, user.Password, user.Avatar, user.IsBot, user.OwnerID).Scan(&userID)
 	if err != nil {
 		log.Println("Error creating user")
 		return -1, err
@@ -42,16 +42,16 @@ func (s *Store) CreateUser(user types.User) (int, error) {
 
 // TODO: don't fetch password
 func (s *Store) GetUserByEmail(username string) (*types.User, error) {
-	rows, err := s.db.Query("SELECT ID, Username, Password, CreatedAt, Avatar FROM Users WHERE Username = $1", username)
-	defer rows.Close()
+	rows, err := s.db.Query("SELECT ID, Username, Password, CreatedAt, Avatar, EmailVerified, IsBot, OwnerID, DeactivatedAt FROM Users WHERE Username = $1", username)
 	if err != nil {
 		log.Println("Error querying database: ", err)
 		return nil, err
 	}
+	defer rows.Close()
 
 	user := new(types.User)
 	for rows.Next() {
-		err = rows.Scan(&user.ID, &user.Username, &user.Password, &user.CreatedAt, &user.Avatar)
+		err = rows.Scan(&user.ID, &user.Username, &user.Password, &user.CreatedAt, &user.Avatar, &user.EmailVerified, &user.IsBot, &user.OwnerID, &user.DeactivatedAt)
 		if err != nil {
 			log.Println("Error scanning rows: ", err)
 			return nil, err
@@ -61,12 +61,11 @@ func (s *Store) GetUserByEmail(username string) (*types.User, error) {
 			return user, nil
 		}
 	}
-	log.Println("Invalid credentials")
-	return nil, errors.New("invalid credentials")
+	return nil, types.ErrUserNotFound
 }
 
 func (s *Store) GetUserByID(userID int) (*types.User, error) {
-	rows, err := s.db.Query("SELECT ID, Username, Password, CreatedAt, Avatar FROM Users WHERE ID = $1", userID)
+	rows, err := s.db.Query("SELECT ID, Username, Password, CreatedAt, Avatar, EmailVerified, IsBot, OwnerID, DeactivatedAt FROM Users WHERE ID = $1", userID)
 	defer rows.Close()
 	if err != nil {
 		log.Println("Error querying database: ", err)
@@ -75,7 +74,7 @@ func (s *Store) GetUserByID(userID int) (*types.User, error) {
 
 	user := new(types.User)
 	for rows.Next() {
-		err = rows.Scan(&user.ID, &user.Username, &user.Password, &user.CreatedAt, &user.Avatar)
+		err = rows.Scan(&user.ID, &user.Username, &user.Password, &user.CreatedAt, &user.Avatar, &user.EmailVerified, &user.IsBot, &user.OwnerID, &user.DeactivatedAt)
 		if err != nil {
 			log.Println("Error scanning rows: ", err)
 			return nil, err
@@ -88,3 +87,21 @@ func (s *Store) GetUserByID(userID int) (*types.User, error) {
 	log.Println("Invalid credentials")
 	return nil, errors.New("invalid credentials")
 }
+
+func (s *Store) UpdatePassword(userID int, hashedPassword string) error {
+	_, err := s.db.Exec("UPDATE Users SET Password = $1 WHERE ID = $2", hashedPassword, userID)
+	if err != nil {
+		log.Println("Error updating password: ", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) SetEmailVerified(userID int) error {
+	_, err := s.db.Exec("UPDATE Users SET EmailVerified = TRUE WHERE ID = $1", userID)
+	if err != nil {
+		log.Println("Error verifying email: ", err)
+		return err
+	}
+	return nil
+}
@@ -142,3 +142,46 @@ func jsonEqual(a, b any) bool {
 	bj, _ := json.Marshal(b)
 	return string(aj) == string(bj)
 }
+
+func TestClientIP(t *testing.T) {
+	if err := ConfigureTrustedProxies("10.0.0.0/8, 192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	defer ConfigureTrustedProxies("")
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		expected   string
+	}{
+		{"Direct client", "203.0.113.7:5555", "198.51.100.1", "198.51.100.2", "203.0.113.7"},
+		{"Through the proxy", "10.1.2.3:5555", "203.0.113.7", "", "203.0.113.7"},
+		{"Spoofed first hop", "10.1.2.3:5555", "198.51.100.1, 203.0.113.7", "", "203.0.113.7"},
+		{"Chained proxies", "10.1.2.3:5555", "203.0.113.7, 192.0.2.1", "", "203.0.113.7"},
+		{"Only proxies", "10.1.2.3:5555", "10.9.9.9", "", "10.9.9.9"},
+		{"Garbage hop", "10.1.2.3:5555", "junk", "", "10.1.2.3"},
+		{"Real IP", "10.1.2.3:5555", "", "203.0.113.7", "203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if ip := ClientIP(r); ip != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, ip)
+			}
+		})
+	}
+
+	if err := ConfigureTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Error("expected an invalid CIDR to be rejected")
+	}
+}
@@ -0,0 +1,38 @@
+package types
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrPasswordResetNotFound = errors.New("password reset not found")
+
+// PasswordReset tracks a reset token by its ID so the signed token can only
+// be redeemed once.
+type PasswordReset struct {
+	TokenID   string     `json:"-"`
+	UserID    int        `json:"user_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
+
+type PasswordResetStore interface {
+	CreatePasswordReset(reset *PasswordReset) error
+	// UsePasswordReset marks an unused, unexpired reset as used and
+	// returns it, or ErrPasswordResetNotFound.
+	UsePasswordReset(tokenID string) (*PasswordReset, error)
+}
+
+type ForgotPasswordPayload struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordPayload struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+type VerifyEmailPayload struct {
+	Token string `json:"token" validate:"required"`
+}
@@ -0,0 +1,88 @@
+package types
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrAPITokenNotFound = errors.New("api token not found")
+
+// Scopes an API token can carry. read covers GET requests, write every
+// other REST call and chat the WebSocket chat endpoint.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeChat  = "chat"
+)
+
+var AllScopes = []string{ScopeRead, ScopeWrite, ScopeChat}
+
+// APIToken is a personal access token. Only a hash of the secret is
+// stored, Prefix is kept so users can tell their tokens apart.
+type APIToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+func (t *APIToken) IsActive() bool {
+	if t.RevokedAt != nil {
+		return false
+	}
+	return t.ExpiresAt == nil || time.Now().Before(*t.ExpiresAt)
+}
+
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type APITokenStore interface {
+	CreateAPIToken(token *APIToken, tokenHash string) error
+	GetAPIToken(tokenID int) (*APIToken, error)
+	GetAPITokenByHash(tokenHash string) (*APIToken, error)
+	GetAPITokensForUser(userID int) ([]*APIToken, error)
+	TouchAPIToken(tokenID int) error
+	RevokeAPIToken(tokenID int) error
+	GetBotsForOwner(ownerID int) ([]*User, error)
+	DeactivateBot(botID int) error
+}
+
+type CreateAPITokenPayload struct {
+	Name          string   `json:"name" validate:"required"`
+	Scopes        []string `json:"scopes" validate:"required"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+type CreateBotPayload struct {
+	Name string `json:"name" validate:"required"`
+}
+
+type APITokenCreatedResponse struct {
+	Token    string    `json:"token"`
+	APIToken *APIToken `json:"api_token"`
+}
+
+type APITokensResponse struct {
+	Tokens []*APIToken `json:"tokens"`
+}
+
+type BotCreatedResponse struct {
+	Bot      *User     `json:"bot"`
+	Token    string    `json:"token"`
+	APIToken *APIToken `json:"api_token"`
+}
+
+type BotsResponse struct {
+	Bots []*User `json:"bots"`
+}
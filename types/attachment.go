@@ -0,0 +1,68 @@
+package types
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	ErrBlobNotFound       = errors.New("blob not found")
+	// ErrUploadOffset means a chunk didn't start where the upload left off.
+	ErrUploadOffset = errors.New("chunk does not continue the upload")
+)
+
+// BlobStore keeps the contents of attachments. Keys are slash separated
+// paths chosen by the server.
+type BlobStore interface {
+	Put(key string, body io.Reader, size int64, contentType string) error
+	// Get reads length bytes starting at offset, or everything after
+	// offset when length is negative.
+	Get(key string, offset, length int64) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// Attachment is a file uploaded to a room. It belongs to the uploader
+// until a chat-message lists it in attachment_ids, which links it to that
+// message. Chunked uploads are incomplete until Received reaches Size.
+// RoomID is 0 once the room is deleted.
+type Attachment struct {
+	ID          int        `json:"id"`
+	MessageID   *int       `json:"message_id,omitempty"`
+	RoomID      int        `json:"room_id"`
+	UploaderID  int        `json:"uploader_id"`
+	Filename    string     `json:"filename"`
+	ContentType string     `json:"content_type"`
+	Size        int64      `json:"size"`
+	Received    int64      `json:"received"`
+	ChunkSize   int64      `json:"chunk_size,omitempty"`
+	StorageKey  string     `json:"-"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func (a *Attachment) Complete() bool {
+	return a.CompletedAt != nil
+}
+
+type AttachmentStore interface {
+	CreateAttachment(attachment *Attachment) error
+	GetAttachment(attachmentID int) (*Attachment, error)
+	// AddChunk moves Received from offset to offset+size, failing with
+	// ErrUploadOffset when another chunk got there first.
+	AddChunk(attachmentID int, offset, size int64) error
+	CompleteAttachment(attachmentID int, contentType string) (*Attachment, error)
+	// GetExpiredAttachments returns attachments that were never linked to
+	// a message, or whose message is gone, and are older than maxAge, as
+	// well as those of deleted rooms whatever their age.
+	GetExpiredAttachments(maxAge time.Duration, limit int) ([]*Attachment, error)
+	DeleteAttachment(attachmentID int) error
+}
+
+// CreateUploadPayload starts a chunked upload.
+type CreateUploadPayload struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
@@ -1,26 +1,52 @@
 package types
 
-import "sync"
+import (
+	"errors"
+	"sync"
+)
+
+var ErrChannelNotFound = errors.New("channel not found")
 
 type Channel struct {
-	mu    sync.RWMutex
-	ID    int           `json:"id"`
-	Name  string        `json:"name"`
-	Rooms map[int]*Room `json:"-"`
-	Avatar    []byte    `json:"avatar"`
+	mu          sync.RWMutex
+	ID          int           `json:"id"`
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Rooms       map[int]*Room `json:"-"`
+	Avatar      []byte        `json:"avatar"`
+	// UnreadCount and MentionCount add up those of the rooms the member
+	// who fetched the channel can read.
+	UnreadCount  int `json:"unread_count,omitempty"`
+	MentionCount int `json:"mention_count,omitempty"`
 }
 
 type ChannelStore interface {
 	GetAllChannels() ([]*Channel, error)
 	GetChannelsForUser(userID int) ([]*Channel, error)
+	GetChannel(channelID int) (*Channel, error)
 	CreateChannel(channel *Channel, user *User) error
+	UpdateChannel(channel *Channel) error
 	DeleteChannel(channelID int) error
+	AddUserToChannel(channelID, userID int) error
 }
 
 type ChannelResponse struct {
 	Channels []*Channel `json:"channels"`
 }
 
+// UpdateChannelPayload is the JSON form of PATCH /channels/{id}. Nil
+// fields are left unchanged. The avatar can only be sent as a multipart
+// form.
+type UpdateChannelPayload struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+type ChannelUpdatedMessage struct {
+	Type    string   `json:"type"`
+	Payload *Channel `json:"payload"`
+}
+
 func (c *Channel) GetRoom(roomID int) *Room {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -30,3 +56,12 @@ func (c *Channel) GetRoom(roomID int) *Room {
 	}
 	return room
 }
+
+// Update applies new details to the channel kept in the hub.
+func (c *Channel) Update(updated *Channel) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Name = updated.Name
+	c.Description = updated.Description
+	c.Avatar = updated.Avatar
+}
@@ -1,8 +1,12 @@
 package types
 
 import (
+	"encoding/base64"
+	"errors"
 	"log"
+	"strconv"
 	"sync"
+	"time"
 	"user/server/services/utils"
 
 	"github.com/gorilla/websocket"
@@ -28,14 +32,19 @@ type Client struct {
 	Send                chan []byte
 	Username            string
 	ID                  string
+	SessionID           string
 	Avatar              []byte
 	MicEnabled          bool
 	VideoEnabled        bool
 	ScreenEnabled       bool
 	IsAnswerer          bool
+	// Resume is where the client left off before reconnecting, if it did.
+	Resume *ResumePoint
+	// Permissions is checked again for every track the client sends.
+	Permissions PermissionsStore
 }
 
-func (c *Client) ReadMessages(room *Room, store MessageStore) {
+func (c *Client) ReadMessages(room *Room, store MessageStore, permissions PermissionsStore, notifier Notifier) {
 	defer func() {
 		c.WebsocketConnection.Close()
 		room.Bus.Publish(Event{
@@ -49,39 +58,277 @@ func (c *Client) ReadMessages(room *Room, store MessageStore) {
 			log.Printf("Error reading WebSocket message: %v", err)
 			return
 		}
-		c.handleMessage(message, room, store)
+		c.handleMessage(message, room, store, permissions, notifier)
 	}
 }
 
-func (c *Client) handleMessage(message []byte, room *Room, store MessageStore) {
-	var msg Message
-	err := utils.Unmarshal(message, &msg)
+func (c *Client) handleMessage(message []byte, room *Room, store MessageStore, permissions PermissionsStore,
+	notifier Notifier) {
+	var frame Message
+	if err := utils.Unmarshal(message, &frame); err != nil {
+		c.reject(room, invalidFrame("frames must be JSON messages"), &frame)
+		return
+	}
+	senderID, err := strconv.Atoi(c.ID)
 	if err != nil {
-		log.Println("Error unmarshalling JSON message", err)
+		log.Printf("Error parsing client id: %v", err)
 		return
 	}
-	if msg.Type == "chat-message" {
-		if err := store.CreateMessage(&msg); err != nil {
-			log.Println("Error creating message:", err)
-			return
+	msg, err := ParseFrame(&frame, senderID, room)
+	if err != nil {
+		c.reject(room, err, &frame)
+		return
+	}
+	if perm := requiredPermission(msg, room); perm != 0 && !c.can(permissions, room, perm) {
+		log.Printf("Rejecting %s from client %s: missing permission %d", msg.Type, c.ID, perm)
+		c.reject(room, &FrameError{Code: FrameForbidden, Message: "missing permission"}, msg)
+		return
+	}
+
+	switch msg.Type {
+	case "chat-message":
+		err = c.createMessage(msg, room, store, notifier)
+	case "message-edit":
+		err = c.editMessage(msg, room, store, notifier)
+	case "message-delete":
+		err = c.deleteMessage(msg, room, store, permissions)
+	case "thread-subscribe", "thread-unsubscribe":
+		err = c.subscribeThread(msg, room, store)
+	case "mark-read":
+		err = c.markRead(msg, room, store)
+	default:
+		room.Publish(msg)
+	}
+	if err != nil {
+		c.reject(room, err, msg)
+	}
+}
+
+// reject tells the client why its frame wasn't handled.
+func (c *Client) reject(room *Room, err error, frame *Message) {
+	frameErr := toFrameError(err, frame.Type)
+	if frameErr.Code == FrameInternal {
+		log.Printf("Error handling %s from client %s: %v", frame.Type, c.ID, err)
+	}
+	if frame.Type == "chat-message" {
+		c.ack(room, frame, frameErr)
+		return
+	}
+	room.SendToClient(c, utils.Marshal(ErrorMessage{Type: ErrorEvent, Payload: frameErr}))
+}
+
+// ack answers a chat-message, with the stored message when frameErr is
+// nil.
+func (c *Client) ack(room *Room, msg *Message, frameErr *FrameError) {
+	ack := MessageAck{Type: MessageAckEvent, Nonce: msg.Nonce, RoomID: room.ID, Error: frameErr}
+	if frameErr == nil {
+		ack.ID = msg.ID
+		ack.Timestamp = &msg.Timestamp
+	}
+	room.SendToClient(c, utils.Marshal(ack))
+}
+
+// createMessage stores a chat-message, whose author is shown with the
+// client's current name and avatar.
+func (c *Client) createMessage(msg *Message, room *Room, store MessageStore, notifier Notifier) error {
+	if msg.ParentID != nil {
+		if err := c.validReply(msg, room, store); err != nil {
+			return err
 		}
+	}
+	msg.SenderName = c.Username
+	if len(c.Avatar) > 0 {
+		msg.SenderAvatar = base64.StdEncoding.EncodeToString(c.Avatar)
+	}
+	err := store.CreateMessage(msg)
+	if errors.Is(err, ErrDuplicateMessage) {
+		ack := MessageAck{Type: MessageAckEvent, Nonce: msg.Nonce, ID: msg.ID, RoomID: room.ID,
+			Timestamp: &msg.Timestamp, Duplicate: true}
+		room.SendToClient(c, utils.Marshal(ack))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.ack(room, msg, nil)
 
-		broadcastMessage := utils.Marshal(msg)
-		if broadcastMessage == nil {
-			log.Println("Error marshalling message for broadcast:", err)
-			return
+	if msg.ParentID != nil {
+		room.SubscribeThread(c.ID, *msg.ParentID, true)
+	}
+	room.Publish(msg)
+	if msg.ParentID != nil {
+		room.PublishThreadUpdate(store, *msg.ParentID)
+	}
+	go notifier.NotifyMentions(msg, room.ChannelID)
+	return nil
+}
+
+// editMessage handles a message-edit frame carrying the message ID and
+// its new content.
+func (c *Client) editMessage(msg *Message, room *Room, store MessageStore, notifier Notifier) error {
+	m, err := c.roomMessage(msg, room, store)
+	if err != nil {
+		return err
+	}
+	edited, err := EditMessage(store, m, msg.SenderID, msg.Content)
+	if err != nil {
+		return err
+	}
+	room.PublishMessageEvent(MessageEdited, edited)
+	go notifier.NotifyMentions(edited, room.ChannelID)
+	return nil
+}
+
+// deleteMessage handles a message-delete frame carrying the message ID.
+func (c *Client) deleteMessage(msg *Message, room *Room, store MessageStore, permissions PermissionsStore) error {
+	m, err := c.roomMessage(msg, room, store)
+	if err != nil {
+		return err
+	}
+	canModerate := m.SenderID != msg.SenderID && c.can(permissions, room, PermDeleteMessages)
+	deleted, replyIDs, err := DeleteMessage(store, m, msg.SenderID, canModerate)
+	if err != nil {
+		return err
+	}
+	room.PublishDeletion(store, deleted, replyIDs)
+	return nil
+}
+
+// subscribeThread handles thread-subscribe and thread-unsubscribe frames
+// carrying the ID of the thread's first message.
+func (c *Client) subscribeThread(msg *Message, room *Room, store MessageStore) error {
+	parent, err := c.roomMessage(msg, room, store)
+	if err != nil {
+		return err
+	}
+	if parent.ParentID != nil {
+		return invalidFrame("replies don't have threads")
+	}
+	room.SubscribeThread(c.ID, parent.ID, msg.Type == "thread-subscribe")
+	return nil
+}
+
+// markRead handles a mark-read frame carrying the ID of the latest message
+// the client has read.
+func (c *Client) markRead(msg *Message, room *Room, store MessageStore) error {
+	m, err := c.roomMessage(msg, room, store)
+	if err != nil {
+		return err
+	}
+	moved, err := store.MarkRead(msg.SenderID, room.ID, m.ID)
+	if err != nil {
+		return err
+	}
+	if moved {
+		room.PublishReadReceipt(msg.SenderID, c.Username, m.ID)
+	}
+	return nil
+}
+
+// validReply checks a reply's parent is a message of the same room that
+// isn't a reply itself and hasn't been deleted.
+func (c *Client) validReply(msg *Message, room *Room, store MessageStore) error {
+	parent, err := store.GetMessage(*msg.ParentID)
+	if err != nil {
+		return err
+	}
+	if parent.RoomID != room.ID {
+		return ErrMessageNotFound
+	}
+	if parent.ParentID != nil || parent.DeletedAt != nil {
+		return invalidFrame("message %d can't have replies", parent.ID)
+	}
+	return nil
+}
+
+// roomMessage loads the message a frame refers to, making sure it belongs
+// to the client's room.
+func (c *Client) roomMessage(msg *Message, room *Room, store MessageStore) (*Message, error) {
+	m, err := store.GetMessage(msg.ID)
+	if err != nil {
+		return nil, err
+	}
+	if m.RoomID != room.ID {
+		return nil, ErrMessageNotFound
+	}
+	return m, nil
+}
+
+// requiredPermission returns what a frame needs beyond channel
+// membership, which was checked when the socket was opened. Announcement
+// rooms restrict posting and stages restrict every frame that publishes
+// media, whatever its type.
+func requiredPermission(msg *Message, room *Room) Permission {
+	var perm Permission
+	if enabled(msg.IsMicEnabled) || msg.TrackType == "audio" {
+		perm |= PermSpeak
+	}
+	if enabled(msg.IsScreenEnabled) || msg.TrackType == "screen" {
+		perm |= PermShareScreen
+	}
+	if room.Kind == RoomKindStage && publishesMedia(msg) {
+		perm |= PermStageSpeaker
+	}
+	if msg.Type == "chat-message" && room.Kind == RoomKindAnnouncement {
+		perm |= PermPostAnnouncements
+	}
+	return perm
+}
+
+func enabled(flag *bool) bool {
+	return flag != nil && *flag
+}
+
+// publishesMedia reports whether a frame turns on, declares or negotiates
+// media the client sends.
+func publishesMedia(msg *Message) bool {
+	switch msg.Type {
+	case "webrtc-tracks":
+		return enabled(msg.IsMicEnabled) || enabled(msg.IsVideoEnabled) || enabled(msg.IsScreenEnabled)
+	case "track-metadata":
+		return true
+	case "webrtc-offer":
+		return offerSends(msg.Offer)
+	}
+	return false
+}
+
+// offerSends reports whether an offer has a media section the client
+// sends on. Sections without a direction attribute are sendrecv.
+func offerSends(offer *webrtc.SessionDescription) bool {
+	parsed, err := offer.Unmarshal()
+	if err != nil {
+		return true
+	}
+	for _, media := range parsed.MediaDescriptions {
+		if media.MediaName.Media != "audio" && media.MediaName.Media != "video" || media.MediaName.Port.Value == 0 {
+			continue
 		}
+		if _, ok := media.Attribute("recvonly"); ok {
+			continue
+		}
+		if _, ok := media.Attribute("inactive"); ok {
+			continue
+		}
+		return true
+	}
+	return false
+}
 
-		room.Bus.Publish(Event{
-			Type:    EventBroadcast,
-			Payload: broadcastMessage,
-		})
-	} else {
-		room.Bus.Publish(Event{
-			Type:    EventBroadcast,
-			Payload: message,
-		})
+// can looks the permission up on every use rather than caching it on the
+// client, so role changes apply to open sockets straight away.
+func (c *Client) can(permissions PermissionsStore, room *Room, perm Permission) bool {
+	userID, err := strconv.Atoi(c.ID)
+	if err != nil {
+		log.Printf("Error parsing client id: %v", err)
+		return false
 	}
+	member, err := permissions.GetChannelMember(userID, room.ChannelID)
+	if err != nil {
+		log.Printf("Error checking permissions for client %s: %v", c.ID, err)
+		return false
+	}
+	return member.Can(perm)
 }
 
 func (c *Client) WriteMessages() {
@@ -104,6 +351,33 @@ func (c *Client) WriteMessages() {
 	}
 }
 
+// Disconnect sends a close frame and drops the socket. ReadMessages then
+// unregisters the client from its room as usual.
+func (c *Client) Disconnect(code int, reason string) {
+	deadline := time.Now().Add(time.Second)
+	err := c.WebsocketConnection.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason), deadline)
+	if err != nil {
+		log.Println("Error sending close frame:", err)
+	}
+	c.WebsocketConnection.Close()
+}
+
+func (r *Room) DisconnectClients(match func(*Client) bool, code int, reason string) {
+	r.mu.RLock()
+	clients := make([]*Client, 0)
+	for client := range r.Clients {
+		if match(client) {
+			clients = append(clients, client)
+		}
+	}
+	r.mu.RUnlock()
+
+	for _, client := range clients {
+		client.Disconnect(code, reason)
+	}
+}
+
 func (r *Room) GetClientByID(clientID string) *Client {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
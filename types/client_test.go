@@ -0,0 +1,180 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+type fakeMessageStore struct {
+	MessageStore
+	nonces map[string]*Message
+	read   map[int]int
+	nextID int
+}
+
+func (s *fakeMessageStore) CreateMessage(m *Message) error {
+	if first, ok := s.nonces[m.Nonce]; ok && m.Nonce != "" {
+		m.ID, m.Timestamp = first.ID, first.Timestamp
+		return ErrDuplicateMessage
+	}
+	s.nextID++
+	m.ID, m.Timestamp = s.nextID, time.Now()
+	s.nonces[m.Nonce] = m
+	return nil
+}
+
+func (s *fakeMessageStore) GetMessage(messageID int) (*Message, error) {
+	for _, m := range s.nonces {
+		if m.ID == messageID {
+			return m, nil
+		}
+	}
+	return nil, ErrMessageNotFound
+}
+
+func (s *fakeMessageStore) MarkRead(userID, roomID, messageID int) (bool, error) {
+	if s.read[userID] >= messageID {
+		return false, nil
+	}
+	s.read[userID] = messageID
+	return true, nil
+}
+
+type fakeNotifier struct{}
+
+func (fakeNotifier) NotifyMentions(*Message, int) {}
+
+func TestHandleMessageAcksRetriedSendOnce(t *testing.T) {
+	client := &Client{ID: "1", Username: "ada", Send: make(chan []byte, 4)}
+	room := &Room{ID: 3, Kind: RoomKindText, Bus: NewEventBus(),
+		Clients: map[*Client]*ClientInfo{client: {}}}
+	broadcasts := make(chan Event, 4)
+	room.Bus.Subscribe(EventBroadcast, broadcasts)
+	store := &fakeMessageStore{nonces: map[string]*Message{}}
+
+	frame := []byte(`{"type":"chat-message","sender_id":2,"room_id":9,"content":"hi","nonce":"n1"}`)
+	for i := 0; i < 2; i++ {
+		client.handleMessage(frame, room, store, nil, fakeNotifier{})
+	}
+
+	var acks [2]MessageAck
+	for i := range acks {
+		if err := json.Unmarshal(<-client.Send, &acks[i]); err != nil {
+			t.Fatal(err)
+		}
+		if acks[i].Type != MessageAckEvent || acks[i].Nonce != "n1" || acks[i].ID != 1 || acks[i].Error != nil {
+			t.Errorf("ack %d: got %+v", i, acks[i])
+		}
+	}
+	if acks[0].Duplicate || !acks[1].Duplicate {
+		t.Errorf("got duplicate %v then %v", acks[0].Duplicate, acks[1].Duplicate)
+	}
+
+	if len(broadcasts) != 1 {
+		t.Fatalf("got %d broadcasts, want 1", len(broadcasts))
+	}
+	var msg Message
+	if err := json.Unmarshal((<-broadcasts).Payload.([]byte), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.SenderID != 1 || msg.RoomID != 3 || msg.SenderName != "ada" {
+		t.Errorf("broadcast the frame's sender: %+v", msg)
+	}
+}
+
+func TestHandleMessageAcksRejectedSend(t *testing.T) {
+	client := &Client{ID: "1", Send: make(chan []byte, 1)}
+	room := &Room{ID: 3, Kind: RoomKindText, Bus: NewEventBus(),
+		Clients: map[*Client]*ClientInfo{client: {}}}
+
+	client.handleMessage([]byte(`{"type":"chat-message","content":" ","nonce":"n1"}`), room,
+		&fakeMessageStore{nonces: map[string]*Message{}}, nil, fakeNotifier{})
+
+	var ack MessageAck
+	if err := json.Unmarshal(<-client.Send, &ack); err != nil {
+		t.Fatal(err)
+	}
+	if ack.Nonce != "n1" || ack.ID != 0 || ack.Error == nil || ack.Error.Code != FrameInvalid {
+		t.Errorf("got %+v", ack)
+	}
+}
+
+func TestHandleMessageMarksRead(t *testing.T) {
+	client := &Client{ID: "1", Username: "ada", Send: make(chan []byte, 1)}
+	room := &Room{ID: 3, Kind: RoomKindText, Bus: NewEventBus(),
+		Clients: map[*Client]*ClientInfo{client: {}}}
+	broadcasts := make(chan Event, 4)
+	room.Bus.Subscribe(EventBroadcast, broadcasts)
+	store := &fakeMessageStore{
+		nonces: map[string]*Message{"a": {ID: 5, RoomID: 3}, "b": {ID: 6, RoomID: 4}},
+		read:   map[int]int{},
+	}
+
+	for _, frame := range []string{
+		`{"type":"mark-read","id":5,"sender_id":2}`,
+		`{"type":"mark-read","id":5}`,
+	} {
+		client.handleMessage([]byte(frame), room, store, nil, fakeNotifier{})
+	}
+	if store.read[1] != 5 || store.read[2] != 0 {
+		t.Errorf("got read pointers %v", store.read)
+	}
+	if len(broadcasts) != 1 {
+		t.Fatalf("got %d read receipts, want 1", len(broadcasts))
+	}
+	var receipt ReadReceipt
+	if err := json.Unmarshal((<-broadcasts).Payload.([]byte), &receipt); err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Type != ReadReceiptEvent || receipt.UserID != 1 || receipt.MessageID != 5 || receipt.RoomID != 3 {
+		t.Errorf("got %+v", receipt)
+	}
+
+	client.handleMessage([]byte(`{"type":"mark-read","id":6}`), room, store, nil, fakeNotifier{})
+	var rejected ErrorMessage
+	if err := json.Unmarshal(<-client.Send, &rejected); err != nil {
+		t.Fatal(err)
+	}
+	if rejected.Payload.Code != FrameNotFound || store.read[1] != 5 {
+		t.Errorf("message from another room: got %+v", rejected.Payload)
+	}
+}
+
+func offerWith(direction string) *webrtc.SessionDescription {
+	sdp := "v=0\r\no=- 1 1 IN IP4 0.0.0.0\r\ns=-\r\nt=0 0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\nc=IN IP4 0.0.0.0\r\na=mid:0\r\n"
+	if direction != "" {
+		sdp += "a=" + direction + "\r\n"
+	}
+	return &webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp}
+}
+
+func TestRequiredPermissionOnStage(t *testing.T) {
+	stage := &Room{Kind: RoomKindStage}
+	on, off := true, false
+	tests := []struct {
+		name string
+		msg  Message
+		want Permission
+	}{
+		{"video on", Message{Type: "webrtc-tracks", IsVideoEnabled: &on}, PermStageSpeaker},
+		{"mic on", Message{Type: "webrtc-tracks", IsMicEnabled: &on}, PermSpeak | PermStageSpeaker},
+		{"all off", Message{Type: "webrtc-tracks", IsMicEnabled: &off, IsVideoEnabled: &off}, 0},
+		{"video metadata", Message{Type: "track-metadata", TrackType: "video"}, PermStageSpeaker},
+		{"sending offer", Message{Type: "webrtc-offer", Offer: offerWith("")}, PermStageSpeaker},
+		{"receiving offer", Message{Type: "webrtc-offer", Offer: offerWith("recvonly")}, 0},
+		{"ice candidate", Message{Type: "webrtc-ice-candidate"}, 0},
+	}
+	for _, tt := range tests {
+		if got := requiredPermission(&tt.msg, stage); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+	if got := requiredPermission(&Message{Type: "track-metadata", TrackType: "video"},
+		&Room{Kind: RoomKindVoice}); got != 0 {
+		t.Errorf("video in a voice room needs %d", got)
+	}
+}
@@ -0,0 +1,91 @@
+package types
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrConversationNotFound = errors.New("conversation not found")
+	ErrNoSharedChannel      = errors.New("users don't share a channel")
+)
+
+// MaxConversationMembers caps group conversations, creator included.
+const MaxConversationMembers = 10
+
+// Direct conversation events, pushed to every member wherever they are
+// connected. dm-updated carries the conversation when it is created or its
+// members change, dm-activity a message sent in it.
+const (
+	ConversationUpdated  = "dm-updated"
+	ConversationActivity = "dm-activity"
+)
+
+// Conversation is a direct conversation between two users, or a group of
+// up to MaxConversationMembers. It is backed by a room of kind dm, so its
+// messages use the room endpoints with RoomID. UnreadCount and
+// LastReadMessageID are those of the user who fetched it.
+type Conversation struct {
+	RoomID            int                   `json:"room_id"`
+	Name              string                `json:"name,omitempty"`
+	IsGroup           bool                  `json:"is_group"`
+	CreatedBy         int                   `json:"created_by"`
+	CreatedAt         time.Time             `json:"created_at"`
+	Members           []*ConversationMember `json:"members"`
+	LastMessage       *Message              `json:"last_message,omitempty"`
+	LastActivityAt    time.Time             `json:"last_activity_at"`
+	LastReadMessageID int                   `json:"last_read_message_id"`
+	UnreadCount       int                   `json:"unread_count"`
+}
+
+type ConversationMember struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	Avatar   string `json:"avatar,omitempty"`
+}
+
+type ConversationStore interface {
+	// GetConversations lists the user's conversations, most recently
+	// active first.
+	GetConversations(userID int) ([]*Conversation, error)
+	// GetConversation returns ErrConversationNotFound unless userID is a
+	// member.
+	GetConversation(roomID, userID int) (*Conversation, error)
+	// CreateConversation creates the room and adds the creator and
+	// memberIDs. A conversation between two users is only created once:
+	// when it exists already its room ID is returned with created false.
+	CreateConversation(conversation *Conversation, memberIDs []int) (roomID int, created bool, err error)
+	GetMemberIDs(roomID int) ([]int, error)
+	AddMember(roomID, userID int) error
+	RemoveMember(roomID, userID int) error
+	// SharesChannel reports whether every one of others is in at least one
+	// channel with userID.
+	SharesChannel(userID int, others []int) (bool, error)
+}
+
+type CreateConversationPayload struct {
+	UserIDs []int  `json:"user_ids"`
+	Name    string `json:"name"`
+}
+
+type AddConversationMemberPayload struct {
+	UserID int `json:"user_id"`
+}
+
+type MarkReadPayload struct {
+	MessageID int `json:"message_id"`
+}
+
+type ConversationsResponse struct {
+	Conversations []*Conversation `json:"conversations"`
+}
+
+type ConversationMessage struct {
+	Type    string        `json:"type"`
+	Payload *Conversation `json:"payload"`
+}
+
+type ConversationActivityMessage struct {
+	Type    string   `json:"type"`
+	Payload *Message `json:"payload"`
+}
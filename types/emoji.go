@@ -0,0 +1,48 @@
+package types
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var ErrEmojiNotFound = errors.New("emoji not found")
+
+var emojiNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{2,32}$`)
+
+func ValidEmojiName(name string) bool {
+	return emojiNamePattern.MatchString(name)
+}
+
+// CustomEmojiName returns name for a reaction written as :name: with a
+// valid name, and false for anything else.
+func CustomEmojiName(emoji string) (string, bool) {
+	if !strings.HasPrefix(emoji, ":") || !strings.HasSuffix(emoji, ":") || len(emoji) < 2 {
+		return "", false
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(emoji, ":"), ":")
+	return name, ValidEmojiName(name)
+}
+
+// ChannelEmoji is a custom emoji uploaded to a channel. Reactions refer to
+// it as :name:.
+type ChannelEmoji struct {
+	ID        int       `json:"id"`
+	ChannelID int       `json:"channel_id"`
+	Name      string    `json:"name"`
+	Image     []byte    `json:"image"`
+	CreatedBy int       `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type EmojiStore interface {
+	GetChannelEmoji(channelID int) ([]*ChannelEmoji, error)
+	GetEmojiByName(channelID int, name string) (*ChannelEmoji, error)
+	CreateEmoji(emoji *ChannelEmoji) error
+	DeleteEmoji(channelID, emojiID int) error
+}
+
+type ChannelEmojiResponse struct {
+	Emoji []*ChannelEmoji `json:"emoji"`
+}
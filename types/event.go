@@ -35,6 +35,18 @@ func (bus *EventBus) Subscribe(eventType string, ch chan Event) {
 	bus.subscribers[eventType] = append(bus.subscribers[eventType], ch)
 }
 
+func (bus *EventBus) Unsubscribe(eventType string, ch chan Event) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	subscribers := bus.subscribers[eventType]
+	for i, subscriber := range subscribers {
+		if subscriber == ch {
+			bus.subscribers[eventType] = append(subscribers[:i:i], subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
 func (bus *EventBus) Publish(event Event) {
 	bus.mu.RLock()
 	defer bus.mu.RUnlock()
@@ -0,0 +1,225 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pion/webrtc/v4"
+)
+
+var ErrMessageTooLong = errors.New("message is too long")
+
+// MaxMessageLength caps message content, in characters.
+const MaxMessageLength = 4000
+
+// MaxMessageAttachments caps the uploads sent with one message.
+const MaxMessageAttachments = 10
+
+// MaxNonceLength caps the nonce of a chat-message.
+const MaxNonceLength = 64
+
+const (
+	maxSDPLength       = 64 << 10
+	maxCandidateLength = 1024
+	maxTrackIDLength   = 128
+)
+
+// ErrorEvent is sent to a client whose frame was rejected, with a
+// FrameError as its payload. Rejected chat messages are answered with a
+// failed MessageAckEvent instead.
+const ErrorEvent = "error"
+
+// MessageAckEvent answers every chat-message, only to its sender.
+const MessageAckEvent = "message-ack"
+
+// Codes of FrameError.
+const (
+	FrameInvalid     = "invalid-frame"
+	FrameUnknownType = "unknown-type"
+	FrameForbidden   = "forbidden"
+	FrameNotFound    = "not-found"
+	FrameInternal    = "internal-error"
+)
+
+// FrameError tells a client why one of its frames was rejected. FrameType
+// is the type of that frame, when it had one.
+type FrameError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	FrameType string `json:"frame_type,omitempty"`
+}
+
+func (e *FrameError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+type ErrorMessage struct {
+	Type    string      `json:"type"`
+	Payload *FrameError `json:"payload"`
+}
+
+// MessageAck tells the sender of a chat-message the ID and timestamp it
+// was stored with, or why it wasn't. Duplicate is set when the nonce had
+// been sent before, in which case nothing was stored or broadcast again.
+type MessageAck struct {
+	Type      string      `json:"type"`
+	Nonce     string      `json:"nonce,omitempty"`
+	ID        int         `json:"id,omitempty"`
+	RoomID    int         `json:"room_id"`
+	Timestamp *time.Time  `json:"timestamp,omitempty"`
+	Duplicate bool        `json:"duplicate,omitempty"`
+	Error     *FrameError `json:"error,omitempty"`
+}
+
+func invalidFrame(format string, args ...any) *FrameError {
+	return &FrameError{Code: FrameInvalid, Message: fmt.Sprintf(format, args...)}
+}
+
+// ParseFrame checks a frame a client sent on a room's socket and returns
+// the message the server acts on. Only the fields the frame type uses are
+// copied, and the sender and room always come from the connection rather
+// than the frame, so a client can't speak or signal for someone else.
+func ParseFrame(frame *Message, senderID int, room *Room) (*Message, error) {
+	msg := &Message{Type: frame.Type, RoomID: room.ID, SenderID: senderID}
+	switch frame.Type {
+	case "chat-message":
+		content, err := messageContent(frame.Content, len(frame.AttachmentIDs) > 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(frame.AttachmentIDs) > MaxMessageAttachments {
+			return nil, invalidFrame("a message can have up to %d attachments", MaxMessageAttachments)
+		}
+		if frame.ParentID != nil && *frame.ParentID <= 0 {
+			return nil, invalidFrame("parent_id must be a message ID")
+		}
+		if len(frame.Nonce) > MaxNonceLength {
+			return nil, invalidFrame("nonce can't be longer than %d bytes", MaxNonceLength)
+		}
+		msg.Nonce = frame.Nonce
+		msg.Content = content
+		msg.ParentID = frame.ParentID
+		msg.AttachmentIDs = frame.AttachmentIDs
+		return msg, nil
+	case "message-edit":
+		content, err := messageContent(frame.Content, false)
+		if err != nil {
+			return nil, err
+		}
+		msg.Content = content
+		fallthrough
+	case "message-delete", "thread-subscribe", "thread-unsubscribe", "mark-read":
+		if frame.ID <= 0 {
+			return nil, invalidFrame("id must be a message ID")
+		}
+		msg.ID = frame.ID
+		return msg, nil
+	case "webrtc-offer", "webrtc-answer", "webrtc-ice-candidate", "webrtc-tracks", "track-metadata":
+		if !room.HasMedia() {
+			return nil, invalidFrame("%s rooms have no media", room.Kind)
+		}
+		return msg, parseMediaFrame(frame, msg)
+	}
+	return nil, &FrameError{Code: FrameUnknownType, Message: fmt.Sprintf("unknown frame type %q", frame.Type)}
+}
+
+func parseMediaFrame(frame, msg *Message) error {
+	switch frame.Type {
+	case "webrtc-offer":
+		if err := checkSessionDescription(frame.Offer, webrtc.SDPTypeOffer); err != nil {
+			return err
+		}
+		msg.Offer = frame.Offer
+	case "webrtc-answer":
+		if err := checkSessionDescription(frame.Answer, webrtc.SDPTypeAnswer); err != nil {
+			return err
+		}
+		msg.Answer = frame.Answer
+	case "webrtc-ice-candidate":
+		if frame.Candidate == nil {
+			return invalidFrame("candidate is required")
+		}
+		if len(frame.Candidate.Candidate) > maxCandidateLength {
+			return invalidFrame("candidate is too long")
+		}
+		msg.Candidate = frame.Candidate
+	case "webrtc-tracks":
+		if frame.IsMicEnabled == nil && frame.IsVideoEnabled == nil && frame.IsScreenEnabled == nil {
+			return invalidFrame("isMicEnabled, isVideoEnabled or isScreenEnabled is required")
+		}
+		msg.IsMicEnabled = frame.IsMicEnabled
+		msg.IsVideoEnabled = frame.IsVideoEnabled
+		msg.IsScreenEnabled = frame.IsScreenEnabled
+	case "track-metadata":
+		switch frame.TrackType {
+		case "audio", "video", "screen":
+		default:
+			return invalidFrame("track_type must be audio, video or screen")
+		}
+		if !validTrackID(frame.TrackID) || !validTrackID(frame.StreamID) {
+			return invalidFrame("track_id and stream_id must be 1 to %d characters", maxTrackIDLength)
+		}
+		msg.TrackType = frame.TrackType
+		msg.TrackID = frame.TrackID
+		msg.StreamID = frame.StreamID
+	}
+	return nil
+}
+
+// messageContent trims content and checks its length. Messages may only
+// be empty when they carry attachments.
+func messageContent(content string, hasAttachments bool) (string, error) {
+	if !utf8.ValidString(content) {
+		return "", invalidFrame("content must be UTF-8")
+	}
+	content = strings.TrimSpace(content)
+	if content == "" && !hasAttachments {
+		return "", ErrEmptyMessage
+	}
+	if utf8.RuneCountInString(content) > MaxMessageLength {
+		return "", ErrMessageTooLong
+	}
+	return content, nil
+}
+
+func checkSessionDescription(sd *webrtc.SessionDescription, want webrtc.SDPType) error {
+	if sd == nil || sd.Type != want || sd.SDP == "" {
+		return invalidFrame("a session description of type %s is required", want)
+	}
+	if len(sd.SDP) > maxSDPLength {
+		return invalidFrame("session description is too long")
+	}
+	if _, err := sd.Unmarshal(); err != nil {
+		return invalidFrame("invalid session description")
+	}
+	return nil
+}
+
+func validTrackID(id string) bool {
+	return id != "" && len(id) <= maxTrackIDLength
+}
+
+// toFrameError turns an error handling a frame into what the client is
+// told. Unexpected errors are only described as internal.
+func toFrameError(err error, frameType string) *FrameError {
+	var frameErr *FrameError
+	switch {
+	case errors.As(err, &frameErr):
+		copied := *frameErr
+		frameErr = &copied
+	case errors.Is(err, ErrMessageNotFound):
+		frameErr = &FrameError{Code: FrameNotFound, Message: "message not found"}
+	case errors.Is(err, ErrNotMessageAuthor):
+		frameErr = &FrameError{Code: FrameForbidden, Message: err.Error()}
+	case errors.Is(err, ErrEmptyMessage), errors.Is(err, ErrMessageTooLong), errors.Is(err, ErrAttachmentNotFound),
+		errors.Is(err, ErrNonceReused):
+		frameErr = &FrameError{Code: FrameInvalid, Message: err.Error()}
+	default:
+		frameErr = &FrameError{Code: FrameInternal, Message: "something went wrong"}
+	}
+	frameErr.FrameType = frameType
+	return frameErr
+}
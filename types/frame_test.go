@@ -0,0 +1,87 @@
+package types
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestParseFrameStampsSender(t *testing.T) {
+	room := &Room{ID: 3, Kind: RoomKindText}
+	frame := &Message{
+		ID:         99,
+		Type:       "chat-message",
+		RoomID:     7,
+		SenderID:   2,
+		SenderName: "someone else",
+		Content:    "  hi  ",
+		IsRead:     true,
+	}
+	msg, err := ParseFrame(frame, 1, room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Message{Type: "chat-message", RoomID: 3, SenderID: 1, Content: "hi"}
+	if msg.ID != 0 || msg.RoomID != want.RoomID || msg.SenderID != want.SenderID ||
+		msg.SenderName != "" || msg.Content != want.Content || msg.IsRead {
+		t.Errorf("got %+v, want %+v", msg, want)
+	}
+}
+
+func TestParseFrameRejects(t *testing.T) {
+	text := &Room{ID: 3, Kind: RoomKindText}
+	voice := &Room{ID: 4, Kind: RoomKindVoice}
+	parent := 0
+	tests := []struct {
+		name  string
+		frame Message
+		room  *Room
+		want  error
+		code  string
+	}{
+		{"unknown type", Message{Type: "room-updated"}, text, nil, FrameUnknownType},
+		{"server event", Message{Type: MessageEdited, ID: 1}, text, nil, FrameUnknownType},
+		{"empty", Message{Type: "chat-message", Content: " "}, text, ErrEmptyMessage, ""},
+		{"too long", Message{Type: "chat-message", Content: strings.Repeat("é", MaxMessageLength+1)}, text,
+			ErrMessageTooLong, ""},
+		{"bad parent", Message{Type: "chat-message", Content: "hi", ParentID: &parent}, text, nil, FrameInvalid},
+		{"edit without id", Message{Type: "message-edit", Content: "hi"}, text, nil, FrameInvalid},
+		{"media in text room", Message{Type: "webrtc-ice-candidate", Candidate: &webrtc.ICECandidateInit{}},
+			text, nil, FrameInvalid},
+		{"answer without sdp", Message{Type: "webrtc-answer"}, voice, nil, FrameInvalid},
+		{"offer as answer", Message{Type: "webrtc-answer",
+			Answer: &webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: "v=0"}}, voice, nil, FrameInvalid},
+		{"bad track type", Message{Type: "track-metadata", TrackType: "data", TrackID: "t", StreamID: "s"},
+			voice, nil, FrameInvalid},
+		{"empty tracks", Message{Type: "webrtc-tracks"}, voice, nil, FrameInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseFrame(&tt.frame, 1, tt.room)
+			if err == nil {
+				t.Fatal("frame was accepted")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+			if tt.code != "" {
+				if got := toFrameError(err, tt.frame.Type).Code; got != tt.code {
+					t.Errorf("got code %s, want %s", got, tt.code)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFrameAllowsAttachmentOnlyMessages(t *testing.T) {
+	frame := &Message{Type: "chat-message", AttachmentIDs: []int{5}}
+	msg, err := ParseFrame(frame, 1, &Room{ID: 3, Kind: RoomKindText})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.AttachmentIDs) != 1 {
+		t.Errorf("attachments were dropped: %+v", msg)
+	}
+}
@@ -2,9 +2,19 @@ package types
 
 import "sync"
 
+const (
+	CloseSessionRevoked     = 4001
+	CloseRoomAccessRevoked  = 4002
+	CloseRemovedFromChannel = 4003
+	CloseLeftConversation   = 4004
+)
+
 type Hub struct {
 	mu       sync.RWMutex
 	Channels map[int]*Channel
+	// DirectRooms holds the live rooms of direct conversations, which
+	// are started when someone first connects.
+	DirectRooms map[int]*Room
 }
 
 func (h *Hub) GetChannel(channelID int) *Channel {
@@ -30,9 +40,13 @@ func (h *Hub) RemoveChannel(channelID int) {
 }
 
 // TODO: I have a suspicion I need to mutex the channels and rooms too
+// GetRoom looks direct conversations up with a channelID of 0.
 func (h *Hub) GetRoom(channelID, roomID int) *Room {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	if channelID == 0 {
+		return h.DirectRooms[roomID]
+	}
 	if channel, ok := h.Channels[channelID]; ok {
 		if room, ok := channel.Rooms[roomID]; ok {
 			return room
@@ -41,6 +55,44 @@ func (h *Hub) GetRoom(channelID, roomID int) *Room {
 	return nil
 }
 
+// DirectRoom returns the live room of a direct conversation, calling
+// create to build and start it if nobody connected yet. A nil create only
+// looks the room up. Every room returned must be given back with
+// ReleaseDirectRoom, which a room's client does when it unregisters.
+func (h *Hub) DirectRoom(roomID int, create func() *Room) *Room {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, ok := h.DirectRooms[roomID]
+	if !ok {
+		if create == nil {
+			return nil
+		}
+		if h.DirectRooms == nil {
+			h.DirectRooms = make(map[int]*Room)
+		}
+		room = create()
+		room.stop = make(chan struct{})
+		h.DirectRooms[roomID] = room
+		go room.Run()
+	}
+	room.users++
+	return room
+}
+
+// ReleaseDirectRoom gives back a room returned by DirectRoom. Once nobody
+// uses it and none of its threads are followed, the room is stopped and
+// forgotten until someone connects again.
+func (h *Hub) ReleaseDirectRoom(room *Room) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room.users--
+	if room.users > 0 || len(room.ThreadFollowers()) > 0 || h.DirectRooms[room.ID] != room {
+		return
+	}
+	delete(h.DirectRooms, room.ID)
+	close(room.stop)
+}
+
 func (h *Hub) AddRoom(channelID, roomID int, room *Room) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
@@ -56,3 +108,106 @@ func (h *Hub) RemoveRoom(channelID, roomID int) {
 		delete(channel.Rooms, roomID)
 	}
 }
+
+func (h *Hub) rooms() []*Room {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	rooms := make([]*Room, 0)
+	for _, channel := range h.Channels {
+		channel.mu.RLock()
+		for _, room := range channel.Rooms {
+			rooms = append(rooms, room)
+		}
+		channel.mu.RUnlock()
+	}
+	for _, room := range h.DirectRooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+func (h *Hub) channelRooms(channelID int) []*Room {
+	channel := h.GetChannel(channelID)
+	if channel == nil {
+		return nil
+	}
+	channel.mu.RLock()
+	defer channel.mu.RUnlock()
+	rooms := make([]*Room, 0, len(channel.Rooms))
+	for _, room := range channel.Rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// OnlineUsers returns the IDs of users connected to any room of the channel.
+func (h *Hub) OnlineUsers(channelID int) map[string]bool {
+	online := make(map[string]bool)
+	for _, room := range h.channelRooms(channelID) {
+		room.mu.RLock()
+		for client := range room.Clients {
+			online[client.ID] = true
+		}
+		room.mu.RUnlock()
+	}
+	return online
+}
+
+// DisconnectFromChannel drops every connection the user has to the
+// channel's rooms and the threads they follow there.
+func (h *Hub) DisconnectFromChannel(channelID int, userID string, code int, reason string) {
+	for _, room := range h.channelRooms(channelID) {
+		room.DisconnectClients(func(c *Client) bool {
+			return c.ID == userID
+		}, code, reason)
+		room.UnfollowThreads(userID)
+	}
+}
+
+// BroadcastToChannel sends a server event to everyone connected to any of
+// the channel's rooms but skipRoomID, which is 0 to skip none.
+func (h *Hub) BroadcastToChannel(channelID, skipRoomID int, msg []byte) {
+	for _, room := range h.channelRooms(channelID) {
+		if room.ID != skipRoomID {
+			room.Broadcast(msg)
+		}
+	}
+}
+
+// SendToChannelUser sends a server event to the connections the user has
+// open in the channel's rooms but skipRoomID.
+func (h *Hub) SendToChannelUser(channelID, skipRoomID int, userID string, msg []byte) {
+	for _, room := range h.channelRooms(channelID) {
+		if room.ID != skipRoomID {
+			room.SendToUser(userID, msg)
+		}
+	}
+}
+
+// UncategorizeRooms mirrors a deleted category onto the channel's live
+// rooms.
+func (h *Hub) UncategorizeRooms(channelID, categoryID int) {
+	for _, room := range h.channelRooms(channelID) {
+		room.mu.Lock()
+		if room.CategoryID != nil && *room.CategoryID == categoryID {
+			room.CategoryID = nil
+		}
+		room.mu.Unlock()
+	}
+}
+
+// SendToUser sends a server event to every connection the user has open,
+// whichever room it is in.
+func (h *Hub) SendToUser(userID string, msg []byte) {
+	for _, room := range h.rooms() {
+		room.SendToUser(userID, msg)
+	}
+}
+
+func (h *Hub) DisconnectSession(sessionID string) {
+	for _, room := range h.rooms() {
+		room.DisconnectClients(func(c *Client) bool {
+			return c.SessionID == sessionID
+		}, CloseSessionRevoked, "session revoked")
+	}
+}
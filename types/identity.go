@@ -0,0 +1,23 @@
+package types
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrIdentityNotFound = errors.New("identity not found")
+
+// UserIdentity links a local user to an account at an external identity
+// provider, keyed by the provider's issuer and subject.
+type UserIdentity struct {
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	UserID    int       `json:"user_id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type IdentityStore interface {
+	GetIdentity(issuer, subject string) (*UserIdentity, error)
+	LinkIdentity(identity *UserIdentity) error
+}
@@ -0,0 +1,33 @@
+package types
+
+import "time"
+
+// LoginAttempt counts consecutive failed logins for a key such as an
+// account or an IP address.
+type LoginAttempt struct {
+	Key           string    `json:"key"`
+	Failures      int       `json:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+	LockedUntil   time.Time `json:"locked_until"`
+}
+
+type LoginAuditEntry struct {
+	ID        int       `json:"id"`
+	UserID    *int      `json:"user_id,omitempty"`
+	Email     string    `json:"email"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type LoginAttemptStore interface {
+	// GetAttempt returns a zero LoginAttempt for keys without failures.
+	GetAttempt(key string) (*LoginAttempt, error)
+	// RegisterFailure bumps the failure count, starting over when the last
+	// failure is older than window, and returns the new count.
+	RegisterFailure(key string, now time.Time, window time.Duration) (int, error)
+	LockUntil(key string, until time.Time) error
+	ClearAttempts(key string) error
+	RecordLoginFailure(entry *LoginAuditEntry) error
+}
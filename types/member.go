@@ -0,0 +1,76 @@
+package types
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrBanNotFound = errors.New("ban not found")
+
+// Moderation actions recorded in the channel's moderation log.
+const (
+	ModerationKick  = "kick"
+	ModerationBan   = "ban"
+	ModerationUnban = "unban"
+)
+
+type ChannelMemberInfo struct {
+	UserID       int       `json:"user_id"`
+	Username     string    `json:"username"`
+	Avatar       []byte    `json:"avatar"`
+	IsBot        bool      `json:"is_bot"`
+	Role         string    `json:"role"`
+	CustomRoleID *int      `json:"custom_role_id,omitempty"`
+	JoinedAt     time.Time `json:"joined_at"`
+	Online       bool      `json:"online"`
+}
+
+// ChannelBan keeps a user out of a channel until ExpiresAt, or for good
+// when it is nil. BannedBy is nil once the moderator's account is deleted.
+type ChannelBan struct {
+	ChannelID int        `json:"channel_id"`
+	UserID    int        `json:"user_id"`
+	BannedBy  *int       `json:"banned_by"`
+	Reason    string     `json:"reason"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type ModerationAction struct {
+	ChannelID int
+	ActorID   int
+	TargetID  int
+	Action    string
+	Reason    string
+}
+
+type MemberStore interface {
+	GetChannelMembers(channelID int) ([]*ChannelMemberInfo, error)
+	// RemoveMember drops the membership and the user's entries on the
+	// allow-lists of the channel's rooms.
+	RemoveMember(channelID, userID int) error
+	// BanMember removes the member, if they are one, and stores the ban.
+	BanMember(ban *ChannelBan) error
+	// GetActiveBan returns ErrBanNotFound when there is no unexpired ban.
+	GetActiveBan(channelID, userID int) (*ChannelBan, error)
+	GetBans(channelID int) ([]*ChannelBan, error)
+	Unban(channelID, userID int) error
+	LogModeration(action ModerationAction) error
+}
+
+type ChannelMembersResponse struct {
+	Members []*ChannelMemberInfo `json:"members"`
+}
+
+type ChannelBansResponse struct {
+	Bans []*ChannelBan `json:"bans"`
+}
+
+type KickPayload struct {
+	Reason string `json:"reason"`
+}
+
+type BanPayload struct {
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
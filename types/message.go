@@ -1,27 +1,69 @@
 package types
 
 import (
+	"errors"
 	"github.com/pion/webrtc/v4"
+	"log"
+	"strings"
 	"time"
+	"unicode/utf8"
+	"user/server/services/utils"
+)
+
+var (
+	ErrMessageNotFound  = errors.New("message not found")
+	ErrNotMessageAuthor = errors.New("not the author of the message")
+	ErrEmptyMessage     = errors.New("message is empty")
+	ErrReactionNotFound = errors.New("reaction not found")
+	ErrDuplicateMessage = errors.New("message was already sent")
+	ErrNonceReused      = errors.New("nonce was used for another message")
+)
+
+// Message event types the server broadcasts when a stored message changes.
+// Clients request the change with message-edit and message-delete frames.
+// Replies and their events only go to clients subscribed to the thread
+// with a thread-subscribe frame; everyone gets thread-updated.
+const (
+	MessageEdited  = "message-edited"
+	MessageDeleted = "message-deleted"
+	ThreadUpdated  = "thread-updated"
+
+	ReactionAdded   = "reaction-added"
+	ReactionRemoved = "reaction-removed"
 )
 
 // TODO: move ID, name and Avater to a separate Struct
 // TODO: move some fields to a different type so it's more performant
 // If they are always together
 type Message struct {
-	ID              int                        `json:"id,omitempty"`
-	Type            string                     `json:"type"`
-	TrackID         string                     `json:"track_id,omitempty"`
-	TrackType       string                     `json:"track_type,omitempty"`
-	StreamID        string                     `json:"stream_id,omitempty"`
-	RoomID          int                        `json:"room_id"`
-	SenderID        int                        `json:"sender_id"`
-	SenderName      string                     `json:"sender_name,omitempty"`
-	SenderAvatar    string                     `json:"sender_avatar,omitempty"`
-	Content         string                     `json:"content,omitempty"`
-	SeenBy          []SeenByUser               `json:"seen_by,omitempty"`
-	Timestamp       time.Time                  `json:"timestamp,omitempty"`
-	IsRead          bool                       `json:"is_read"`
+	ID           int          `json:"id,omitempty"`
+	Type         string       `json:"type"`
+	TrackID      string       `json:"track_id,omitempty"`
+	TrackType    string       `json:"track_type,omitempty"`
+	StreamID     string       `json:"stream_id,omitempty"`
+	RoomID       int          `json:"room_id"`
+	SenderID     int          `json:"sender_id"`
+	SenderName   string       `json:"sender_name,omitempty"`
+	SenderAvatar string       `json:"sender_avatar,omitempty"`
+	ParentID     *int         `json:"parent_id,omitempty"` // set on replies, threads are one level deep
+	Content      string       `json:"content,omitempty"`
+	SeenBy       []SeenByUser `json:"seen_by,omitempty"` // members whose read pointer is at this message
+	Timestamp    time.Time    `json:"timestamp,omitempty"`
+	IsRead       bool         `json:"is_read"`
+	EditedAt     *time.Time   `json:"edited_at,omitempty"`
+	// DeletedAt is set on messages their author deleted. Their content is
+	// gone but they stay in the history.
+	DeletedAt *time.Time      `json:"deleted_at,omitempty"`
+	Thread    *ThreadSummary  `json:"thread,omitempty"`
+	Reactions []ReactionCount `json:"reactions,omitempty"`
+	// AttachmentIDs lists uploads to send with a new chat-message. They
+	// come back as Attachments.
+	AttachmentIDs []int         `json:"attachment_ids,omitempty"`
+	Attachments   []*Attachment `json:"attachments,omitempty"`
+	// Nonce is chosen by the sender of a chat-message, unique among its
+	// messages, so a send retried after a reconnect is stored once. It is
+	// echoed in the message-ack and the broadcast message.
+	Nonce           string                     `json:"nonce,omitempty"`
 	IsVideoEnabled  *bool                      `json:"isVideoEnabled,omitempty"`
 	IsScreenEnabled *bool                      `json:"isScreenEnabled,omitempty"`
 	IsMicEnabled    *bool                      `json:"isMicEnabled,omitempty"`
@@ -30,12 +72,188 @@ type Message struct {
 	Candidate       *webrtc.ICECandidateInit   `json:"candidate,omitempty"`
 }
 
+// MessageCursor is a position in a room's history, which is ordered by
+// timestamp and then ID.
+type MessageCursor struct {
+	Timestamp time.Time
+	ID        int
+}
+
+// MessagePage selects up to Limit messages strictly before or after a
+// cursor. With neither set it selects the latest messages.
+type MessagePage struct {
+	Before *MessageCursor
+	After  *MessageCursor
+	Limit  int
+}
+
 type MessageStore interface {
-	GetMessagesInRoom(roomID int) ([]*Message, error)
+	// GetMessagesInRoom returns the page in chronological order. It may
+	// return one message more than the limit so callers can tell whether
+	// there are more; that message is the one furthest from the cursor.
+	// Reactions are flagged when viewerID made them.
+	GetMessagesInRoom(roomID, viewerID int, page MessagePage) ([]*Message, error)
+	// GetThread pages through the replies to a message like
+	// GetMessagesInRoom, which leaves replies out.
+	GetThread(parentID, viewerID int, page MessagePage) ([]*Message, error)
+	GetThreadSummary(parentID int) (*ThreadSummary, error)
+	GetMessage(messageID int) (*Message, error)
+	// CreateMessage links the message's AttachmentIDs, which must be
+	// complete uploads of the sender in the same room that aren't linked
+	// yet, or it fails with ErrAttachmentNotFound. A message whose Nonce
+	// the sender used before isn't stored again: CreateMessage fills in the
+	// ID and Timestamp of the first one and returns ErrDuplicateMessage,
+	// or ErrNonceReused when that one went to another room.
 	CreateMessage(message *Message) error
-	MarkMessageAsSeen(user int, messageID int) error
+	// EditMessage replaces the content of a message that isn't deleted and
+	// records the previous version.
+	EditMessage(messageID int, content string) (*Message, error)
+	GetMessageEdits(messageID int) ([]*MessageEdit, error)
+	// SoftDeleteMessage blanks the message and drops its edit history.
+	// Its attachments are unlinked, so they expire.
+	SoftDeleteMessage(messageID int) (*Message, error)
+	// DeleteMessage removes a message for good along with its replies,
+	// whose IDs it returns.
+	DeleteMessage(messageID int) ([]int, error)
+	// AddReaction reports whether the reaction is new.
+	AddReaction(messageID, userID int, emoji string) (bool, error)
+	RemoveReaction(messageID, userID int, emoji string) error
+	ReadStore
+}
+
+// ThreadSummary describes the replies to a message.
+type ThreadSummary struct {
+	ReplyCount     int        `json:"reply_count"`
+	LastReplyAt    *time.Time `json:"last_reply_at,omitempty"`
+	ParticipantIDs []int      `json:"participant_ids"`
+}
+
+// ReactionCount groups the reactions to a message with the same emoji. Me
+// is set when the user who fetched the message is among them.
+type ReactionCount struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+	Me    bool   `json:"me"`
+}
+
+// ReactionEvent is the payload of reaction-added and reaction-removed.
+type ReactionEvent struct {
+	Type      string `json:"type"`
+	RoomID    int    `json:"room_id"`
+	MessageID int    `json:"message_id"`
+	ParentID  *int   `json:"parent_id,omitempty"`
+	UserID    int    `json:"user_id"`
+	Emoji     string `json:"emoji"`
+}
+
+// MessageEdit is a previous version of a message.
+type MessageEdit struct {
+	MessageID int       `json:"message_id"`
+	Content   string    `json:"content"`
+	EditedAt  time.Time `json:"edited_at"`
+}
+
+type MessageEditsResponse struct {
+	Edits []*MessageEdit `json:"edits"`
+}
+
+type ThreadResponse struct {
+	Parent *Message `json:"parent"`
+	MessagesResponse
+}
+
+type EditMessagePayload struct {
+	Content string `json:"content"`
 }
 
 type MessagesResponse struct {
 	Messages []*Message `json:"messages"`
+	// HasMore tells whether there are messages past this page in the
+	// direction it was fetched: older for before= and the latest page,
+	// newer for after=.
+	HasMore bool `json:"has_more"`
+	// NextCursor continues in the same direction.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// EditMessage replaces the content of m, which only its author may do.
+func EditMessage(store MessageStore, m *Message, userID int, content string) (*Message, error) {
+	if m.SenderID != userID {
+		return nil, ErrNotMessageAuthor
+	}
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, ErrEmptyMessage
+	}
+	if utf8.RuneCountInString(content) > MaxMessageLength {
+		return nil, ErrMessageTooLong
+	}
+	return store.EditMessage(m.ID, content)
+}
+
+// DeleteMessage soft-deletes m for its author and removes it for good for
+// members who can delete messages. It returns the message-deleted payload
+// and the IDs of the replies removed with it.
+func DeleteMessage(store MessageStore, m *Message, userID int, canModerate bool) (*Message, []int, error) {
+	if m.SenderID == userID {
+		deleted, err := store.SoftDeleteMessage(m.ID)
+		return deleted, nil, err
+	}
+	if !canModerate {
+		return nil, nil, ErrNotMessageAuthor
+	}
+	replyIDs, err := store.DeleteMessage(m.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Message{ID: m.ID, RoomID: m.RoomID, SenderID: m.SenderID, ParentID: m.ParentID}, replyIDs, nil
+}
+
+// PublishDeletion sends the message-deleted events for a deleted message
+// and the replies removed with it, and the new summary of the thread it
+// was in or started.
+func (r *Room) PublishDeletion(store MessageStore, deleted *Message, replyIDs []int) {
+	r.PublishMessageEvent(MessageDeleted, deleted)
+	if deleted.ParentID != nil {
+		r.PublishThreadUpdate(store, *deleted.ParentID)
+		return
+	}
+	if len(replyIDs) == 0 {
+		return
+	}
+	parentID := deleted.ID
+	for _, replyID := range replyIDs {
+		r.PublishMessageEvent(MessageDeleted, &Message{ID: replyID, RoomID: r.ID, ParentID: &parentID})
+	}
+	r.PublishMessageEvent(ThreadUpdated, &Message{ID: parentID, RoomID: r.ID,
+		Thread: &ThreadSummary{ParticipantIDs: []int{}}})
+}
+
+// PublishMessageEvent puts a message-edited or message-deleted event on
+// the room's bus.
+func (r *Room) PublishMessageEvent(eventType string, m *Message) {
+	event := *m
+	event.Type = eventType
+	r.Publish(event)
+}
+
+// Publish puts a server event on the room's bus. Events about replies
+// need a parent_id so only the thread's subscribers get them.
+func (r *Room) Publish(event any) {
+	payload := utils.Marshal(event)
+	if payload == nil {
+		return
+	}
+	r.Bus.Publish(Event{Type: EventBroadcast, Payload: payload})
+}
+
+// PublishThreadUpdate sends everyone in the room the new summary of the
+// thread started by parentID.
+func (r *Room) PublishThreadUpdate(store MessageStore, parentID int) {
+	summary, err := store.GetThreadSummary(parentID)
+	if err != nil {
+		log.Println("Error getting thread summary:", err)
+		return
+	}
+	r.PublishMessageEvent(ThreadUpdated, &Message{ID: parentID, RoomID: r.ID, Thread: summary})
 }
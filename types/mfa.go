@@ -0,0 +1,76 @@
+package types
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrMFANotFound = errors.New("mfa not configured")
+var ErrMFAChallengeNotFound = errors.New("mfa challenge not found")
+
+// UserMFA holds a user's TOTP secret. The secret is pending until the user
+// proves their authenticator works, only then is it Enabled.
+type UserMFA struct {
+	UserID       int        `json:"user_id"`
+	Secret       string     `json:"-"`
+	Enabled      bool       `json:"enabled"`
+	LastUsedStep int64      `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+	EnabledAt    *time.Time `json:"enabled_at,omitempty"`
+}
+
+type MFAStore interface {
+	GetMFA(userID int) (*UserMFA, error)
+	SavePendingSecret(userID int, secret string) error
+	EnableMFA(userID int, recoveryCodeHashes []string) error
+	DisableMFA(userID int) error
+	// UseTOTPStep records the step of an accepted code, it returns false
+	// if that step or a later one was already used.
+	UseTOTPStep(userID int, step int64) (bool, error)
+	ReplaceRecoveryCodes(userID int, recoveryCodeHashes []string) error
+	UseRecoveryCode(userID int, codeHash string) (bool, error)
+	CountRecoveryCodes(userID int) (int, error)
+	CreateMFAChallenge(challenge *MFAChallenge) error
+	// UseMFAChallenge marks an unused, unexpired challenge of the user as
+	// used, or returns ErrMFAChallengeNotFound.
+	UseMFAChallenge(tokenID string, userID int) error
+}
+
+// MFAChallenge tracks an mfa_token by its ID so it can only complete one
+// login.
+type MFAChallenge struct {
+	TokenID   string     `json:"-"`
+	UserID    int        `json:"user_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
+
+type MFAEnrollResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type MFACodePayload struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type MFALoginPayload struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
+
+type MFAChallengeResponse struct {
+	Success     bool   `json:"success"`
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+type MFAStatusResponse struct {
+	Enabled                bool `json:"enabled"`
+	RemainingRecoveryCodes int  `json:"remaining_recovery_codes"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
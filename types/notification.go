@@ -0,0 +1,65 @@
+package types
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrNotificationNotFound = errors.New("notification not found")
+
+// NotificationExcerptLength is how many characters of the message a
+// notification carries.
+const NotificationExcerptLength = 200
+
+// Notification kinds, after the mention that caused them.
+const (
+	NotificationMention = "mention"
+	NotificationRoom    = "room"
+	NotificationChannel = "channel"
+)
+
+type Notification struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	MessageID int        `json:"message_id"`
+	RoomID    int        `json:"room_id"`
+	ChannelID int        `json:"channel_id"`
+	ActorID   int        `json:"actor_id"`
+	ActorName string     `json:"actor_name"`
+	Kind      string     `json:"kind"`
+	Excerpt   string     `json:"excerpt"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+}
+
+type NotificationStore interface {
+	// GetMentionedMembers returns the IDs of the channel's members whose
+	// usernames are listed, ignoring case.
+	GetMentionedMembers(channelID int, usernames []string) ([]int, error)
+	GetChannelMemberIDs(channelID int) ([]int, error)
+	// CreateNotification reports false when the user already has a
+	// notification for the message.
+	CreateNotification(n *Notification) (bool, error)
+	// GetNotifications returns the newest notifications with an ID below
+	// beforeID, or the newest overall when it is 0.
+	GetNotifications(userID int, unreadOnly bool, beforeID, limit int) ([]*Notification, error)
+	CountUnread(userID int) (int, error)
+	MarkRead(userID, notificationID int) error
+	MarkAllRead(userID int) error
+}
+
+// Notifier tells users about the mentions in a message that was just
+// created or edited, wherever they are connected.
+type Notifier interface {
+	NotifyMentions(msg *Message, channelID int)
+}
+
+type NotificationMessage struct {
+	Type    string        `json:"type"`
+	Payload *Notification `json:"payload"`
+}
+
+type NotificationsResponse struct {
+	Notifications []*Notification `json:"notifications"`
+	UnreadCount   int             `json:"unread_count"`
+}
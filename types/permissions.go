@@ -1,5 +1,159 @@
 package types
 
+import "errors"
+
+var ErrNotChannelMember = errors.New("user is not a member of the channel")
+var ErrChannelRoleNotFound = errors.New("channel role not found")
+
+// Permission is a bitset of what a member may do in a channel.
+type Permission int64
+
+const (
+	PermManageChannel Permission = 1 << iota
+	PermManageRoles
+	PermManageRooms
+	PermInvite
+	PermKick
+	PermDeleteMessages
+	PermSpeak
+	PermShareScreen
+	// PermPostAnnouncements allows chatting in announcement rooms.
+	PermPostAnnouncements
+	// PermStageSpeaker allows publishing media in stage rooms.
+	PermStageSpeaker
+	// PermMentionEveryone makes @room and @channel notify people.
+	PermMentionEveryone
+)
+
+const PermAll = PermManageChannel | PermManageRoles | PermManageRooms | PermInvite |
+	PermKick | PermDeleteMessages | PermSpeak | PermShareScreen | PermPostAnnouncements |
+	PermStageSpeaker | PermMentionEveryone
+
+func (p Permission) Has(perm Permission) bool {
+	return p&perm == perm
+}
+
+// Built-in channel roles, from most to least privileged.
+const (
+	RoleOwner     = "owner"
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+)
+
+var rolePermissions = map[string]Permission{
+	RoleOwner: PermAll,
+	RoleAdmin: PermAll,
+	RoleModerator: PermInvite | PermKick | PermDeleteMessages | PermSpeak | PermShareScreen |
+		PermPostAnnouncements | PermStageSpeaker | PermMentionEveryone,
+	RoleMember: PermInvite | PermSpeak | PermShareScreen,
+}
+
+var roleRanks = map[string]int{
+	RoleOwner:     3,
+	RoleAdmin:     2,
+	RoleModerator: 1,
+	RoleMember:    0,
+}
+
+func IsBuiltinRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// RolePermissions returns the default permissions of a built-in role.
+func RolePermissions(role string) Permission {
+	return rolePermissions[role]
+}
+
+// RolesWith lists the built-in roles whose defaults include perm, for
+// queries that check permissions in SQL.
+func RolesWith(perm Permission) []string {
+	roles := make([]string, 0, len(rolePermissions))
+	for role, permissions := range rolePermissions {
+		if permissions.Has(perm) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// RoleRank orders the built-in roles. Members can only manage members
+// ranked below them.
+func RoleRank(role string) int {
+	return roleRanks[role]
+}
+
+// ChannelRole is a custom role defined by a channel. Assigning one to a
+// member replaces the permissions of their built-in role.
+type ChannelRole struct {
+	ID          int        `json:"id"`
+	ChannelID   int        `json:"channel_id"`
+	Name        string     `json:"name"`
+	Permissions Permission `json:"permissions"`
+}
+
+type ChannelMember struct {
+	UserID       int        `json:"user_id"`
+	ChannelID    int        `json:"channel_id"`
+	Role         string     `json:"role"`
+	CustomRoleID *int       `json:"custom_role_id,omitempty"`
+	Permissions  Permission `json:"permissions"`
+}
+
+// RoomMember is a channel member looked up through one of the channel's
+// rooms, along with whether the room's allow-list lets them in.
+type RoomMember struct {
+	ChannelMember
+	RoomID    int  `json:"room_id"`
+	CanAccess bool `json:"can_access"`
+}
+
+func (m *ChannelMember) Can(perm Permission) bool {
+	return m.Permissions.Has(perm)
+}
+
+// Outranks reports whether m may manage other. Owners outrank everyone
+// except other owners.
+func (m *ChannelMember) Outranks(other *ChannelMember) bool {
+	return RoleRank(m.Role) > RoleRank(other.Role)
+}
+
 type PermissionsStore interface {
-	UserHasPermission(userID ,channelID int) bool
+	// GetChannelMember loads a member's role and effective permissions
+	// in one query, or returns ErrNotChannelMember.
+	GetChannelMember(userID, channelID int) (*ChannelMember, error)
+	// GetRoomMember does the same for the channel a room belongs to,
+	// and checks the room's allow-list in the same query. Members of a
+	// direct conversation come back with ChannelID 0 and no permissions.
+	GetRoomMember(userID, roomID int) (*RoomMember, error)
+	SetMemberRole(userID, channelID int, role string, customRoleID *int) error
+	GetChannelRoles(channelID int) ([]*ChannelRole, error)
+	GetChannelRole(channelID, roleID int) (*ChannelRole, error)
+	CreateChannelRole(role *ChannelRole) error
+	UpdateChannelRole(role *ChannelRole) error
+	DeleteChannelRole(channelID, roleID int) error
+	// GetRoleHolders lists the built-in roles of the members a custom
+	// role is assigned to.
+	GetRoleHolders(channelID, roleID int) ([]*ChannelMember, error)
+}
+
+type BuiltinRole struct {
+	Name        string     `json:"name"`
+	Permissions Permission `json:"permissions"`
+}
+
+type ChannelRolesResponse struct {
+	BuiltinRoles []BuiltinRole  `json:"builtin_roles"`
+	CustomRoles  []*ChannelRole `json:"custom_roles"`
+}
+
+type ChannelRolePayload struct {
+	Name        string     `json:"name"`
+	Permissions Permission `json:"permissions"`
+}
+
+type MemberRolePayload struct {
+	Role         string `json:"role"`
+	CustomRoleID *int   `json:"custom_role_id"`
 }
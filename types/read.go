@@ -0,0 +1,55 @@
+package types
+
+import "time"
+
+// ReadReceiptEvent is sent to a room when a member's read pointer moves,
+// whether with a mark-read frame or over REST.
+const ReadReceiptEvent = "read-receipt"
+
+// ReadReceipt says UserID has read the room up to MessageID.
+type ReadReceipt struct {
+	Type      string    `json:"type"`
+	RoomID    int       `json:"room_id"`
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	MessageID int       `json:"message_id"`
+	ReadAt    time.Time `json:"read_at"`
+}
+
+// UnreadCount is how much of a room the user hasn't read: the top level
+// messages from others past their read pointer, and the mentions of them
+// still unread in their notifications. ChannelID is 0 for direct
+// conversations.
+type UnreadCount struct {
+	RoomID       int `json:"room_id"`
+	ChannelID    int `json:"channel_id"`
+	UnreadCount  int `json:"unread_count"`
+	MentionCount int `json:"mention_count"`
+}
+
+// ReadStore keeps one read pointer per user and room.
+type ReadStore interface {
+	// MarkRead moves the user's read pointer up to messageID, which must
+	// be a message of the room, and marks their notifications for the
+	// room's messages up to it read. It reports false when the pointer was
+	// there already or past it.
+	MarkRead(userID, roomID, messageID int) (bool, error)
+	// GetUnreadCounts lists the rooms the user can read that have unread
+	// messages or mentions.
+	GetUnreadCounts(userID int) ([]*UnreadCount, error)
+	// GetChannelUnreadCounts is GetUnreadCounts for the rooms of one
+	// channel.
+	GetChannelUnreadCounts(userID, channelID int) ([]*UnreadCount, error)
+}
+
+// PublishReadReceipt tells the room the user read it up to messageID.
+func (r *Room) PublishReadReceipt(userID int, username string, messageID int) {
+	r.Publish(ReadReceipt{
+		Type:      ReadReceiptEvent,
+		RoomID:    r.ID,
+		UserID:    userID,
+		Username:  username,
+		MessageID: messageID,
+		ReadAt:    time.Now(),
+	})
+}
@@ -0,0 +1,119 @@
+package types
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+	"user/server/services/utils"
+)
+
+// Events a room sends a client when it connects. resumed carries the
+// events the client missed since the ResumePoint it connected with;
+// resync tells it they are gone, or that it didn't give one, so it has to
+// fetch the history again.
+const (
+	ResumedEvent = "resumed"
+	ResyncEvent  = "resync"
+)
+
+// The replay buffer keeps a room's latest sequenced events, up to
+// replayBufferSize of them and replayBufferBytes in total.
+const (
+	replayBufferSize  = 256
+	replayBufferBytes = 1 << 20
+)
+
+// ResumePoint is the last event a reconnecting client saw. Epoch changes
+// whenever the room's sequence starts over, such as after a restart.
+type ResumePoint struct {
+	Epoch string
+	Seq   int64
+}
+
+// ResumeMessage is the first event on every room socket. Seq is the
+// room's latest sequence number; Events are only set on resumed.
+type ResumeMessage struct {
+	Type   string            `json:"type"`
+	Epoch  string            `json:"epoch"`
+	Seq    int64             `json:"seq"`
+	Events []json.RawMessage `json:"events,omitempty"`
+}
+
+type replayEvent struct {
+	seq     int64
+	payload []byte
+}
+
+type replayBuffer struct {
+	events []replayEvent
+	bytes  int
+	// floor is the latest sequence number that can't be replayed.
+	floor int64
+}
+
+func (b *replayBuffer) add(seq int64, payload []byte) {
+	b.events = append(b.events, replayEvent{seq: seq, payload: payload})
+	b.bytes += len(payload)
+	for len(b.events) > replayBufferSize || b.bytes > replayBufferBytes {
+		b.floor = b.events[0].seq
+		b.bytes -= len(b.events[0].payload)
+		b.events = b.events[1:]
+	}
+}
+
+// since returns the events after seq, or false when some were dropped.
+func (b *replayBuffer) since(seq int64) ([]json.RawMessage, bool) {
+	if seq < b.floor {
+		return nil, false
+	}
+	events := make([]json.RawMessage, 0)
+	for _, e := range b.events {
+		if e.seq > seq {
+			events = append(events, e.payload)
+		}
+	}
+	return events, true
+}
+
+// startSequence gives the room a new epoch and an empty replay buffer.
+func (r *Room) startSequence() {
+	r.epoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+	r.seq = 0
+	r.replay = &replayBuffer{}
+}
+
+// sequence numbers an event the room is about to send to all its clients
+// and keeps it for replay. Thread events only go to the thread's followers,
+// wherever they are connected, so they aren't numbered: followers refetch
+// open threads when they reconnect. Only the room's goroutine calls it.
+func (r *Room) sequence(payload []byte) []byte {
+	var fields map[string]json.RawMessage
+	if err := utils.Unmarshal(payload, &fields); err != nil {
+		log.Printf("Error sequencing event in room %d: %v", r.ID, err)
+		return payload
+	}
+	r.seq++
+	fields["seq"] = json.RawMessage(strconv.FormatInt(r.seq, 10))
+	sequenced := utils.Marshal(fields)
+	if sequenced == nil {
+		return payload
+	}
+	if r.replay != nil {
+		r.replay.add(r.seq, sequenced)
+	}
+	return sequenced
+}
+
+// resume sends a client that just registered either the events it missed
+// or a resync.
+func (r *Room) resume(client *Client) {
+	msg := ResumeMessage{Type: ResyncEvent, Epoch: r.epoch, Seq: r.seq}
+	if p := client.Resume; p != nil && p.Epoch == r.epoch && p.Seq <= r.seq && r.replay != nil {
+		if events, ok := r.replay.since(p.Seq); ok {
+			msg.Type = ResumedEvent
+			msg.Events = events
+		}
+	}
+	sendToClient(r, client, utils.Marshal(msg))
+}
@@ -0,0 +1,144 @@
+package types
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"user/server/services/utils"
+)
+
+func sequencedRoom() *Room {
+	room := &Room{ID: 3, Kind: RoomKindText, Clients: map[*Client]*ClientInfo{}}
+	room.startSequence()
+	return room
+}
+
+func resumeFor(t *testing.T, room *Room, resume *ResumePoint) ResumeMessage {
+	t.Helper()
+	client := &Client{ID: "2", Send: make(chan []byte, 1), Resume: resume}
+	room.Clients[client] = &ClientInfo{}
+	room.resume(client)
+	var msg ResumeMessage
+	if err := json.Unmarshal(<-client.Send, &msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestSequenceNumbersEvents(t *testing.T) {
+	room := sequencedRoom()
+	for i := 0; i < 3; i++ {
+		var event struct {
+			Type string `json:"type"`
+			Seq  int64  `json:"seq"`
+		}
+		payload := room.sequence([]byte(`{"type":"chat-message","content":"hi"}`))
+		if err := json.Unmarshal(payload, &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Type != "chat-message" || event.Seq != int64(i+1) {
+			t.Errorf("event %d: got %+v", i, event)
+		}
+	}
+	if len(room.replay.events) != 3 {
+		t.Errorf("kept %d events for replay, want 3", len(room.replay.events))
+	}
+}
+
+func TestBroadcastSequencesRoomEventsOnly(t *testing.T) {
+	client := &Client{ID: "1", Send: make(chan []byte, 4)}
+	room := sequencedRoom()
+	room.Clients[client] = &ClientInfo{}
+	room.SubscribeThread("1", 9, true)
+
+	for _, event := range []string{
+		`{"type":"chat-message","parent_id":9}`,
+		`{"type":"` + ReadReceiptEvent + `","room_id":3}`,
+		`{"type":"chat-message"}`,
+	} {
+		room.handleBroadcast([]byte(event))
+	}
+	var got [3]struct {
+		Type string `json:"type"`
+		Seq  int64  `json:"seq"`
+	}
+	for i := range got {
+		if err := json.Unmarshal(<-client.Send, &got[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got[0].Seq != 0 || got[1].Seq != 1 || got[2].Seq != 2 {
+		t.Errorf("got %+v, want the reply unnumbered and the rest numbered 1 and 2", got)
+	}
+}
+
+func TestResumeReplaysMissedEvents(t *testing.T) {
+	room := sequencedRoom()
+	for i := 0; i < 3; i++ {
+		room.sequence([]byte(`{"type":"chat-message"}`))
+	}
+
+	msg := resumeFor(t, room, &ResumePoint{Epoch: room.epoch, Seq: 1})
+	if msg.Type != ResumedEvent || msg.Seq != 3 || len(msg.Events) != 2 {
+		t.Fatalf("got %+v", msg)
+	}
+	if msg := resumeFor(t, room, &ResumePoint{Epoch: room.epoch, Seq: 3}); msg.Type != ResumedEvent ||
+		len(msg.Events) != 0 {
+		t.Errorf("up to date client got %+v", msg)
+	}
+	if msg := resumeFor(t, room, &ResumePoint{Epoch: "old", Seq: 1}); msg.Type != ResyncEvent {
+		t.Errorf("client from another epoch got %+v", msg)
+	}
+	if msg := resumeFor(t, room, nil); msg.Type != ResyncEvent || msg.Epoch != room.epoch {
+		t.Errorf("new client got %+v", msg)
+	}
+}
+
+func TestResumeAfterEvictionResyncs(t *testing.T) {
+	room := sequencedRoom()
+	for i := 0; i < replayBufferSize+5; i++ {
+		room.sequence([]byte(`{"type":"chat-message","id":` + strconv.Itoa(i) + `}`))
+	}
+
+	if msg := resumeFor(t, room, &ResumePoint{Epoch: room.epoch, Seq: 2}); msg.Type != ResyncEvent {
+		t.Errorf("got %s, want resync", msg.Type)
+	}
+	msg := resumeFor(t, room, &ResumePoint{Epoch: room.epoch, Seq: 5})
+	if msg.Type != ResumedEvent || len(msg.Events) != replayBufferSize {
+		t.Errorf("got %s with %d events", msg.Type, len(msg.Events))
+	}
+}
+
+func TestRoomStateIsSequenced(t *testing.T) {
+	sender := &Client{ID: "1", Send: make(chan []byte, 2)}
+	other := &Client{ID: "2", Send: make(chan []byte, 2)}
+	room := sequencedRoom()
+	room.Kind = RoomKindVoice
+	room.Clients[sender] = &ClientInfo{}
+	room.Clients[other] = &ClientInfo{}
+
+	on := true
+	handleUserStateUpdate(room, Message{SenderID: 1, IsMicEnabled: &on})
+	room.handleBroadcast(utils.Marshal(RoomInfoMessage{Type: RoomUpdatedEvent}))
+	for _, client := range []*Client{sender, other} {
+		for seq := int64(1); seq <= 2; seq++ {
+			var msg RoomInfoMessage
+			var event struct {
+				Seq int64 `json:"seq"`
+			}
+			payload := <-client.Send
+			if err := json.Unmarshal(payload, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(payload, &event); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Type != RoomUpdatedEvent || event.Seq != seq || len(msg.Payload.Users) != 2 {
+				t.Errorf("client %s: got %s with seq %d", client.ID, payload, event.Seq)
+			}
+		}
+	}
+	if len(room.replay.events) != 2 {
+		t.Errorf("kept %d events for replay, want 2", len(room.replay.events))
+	}
+}
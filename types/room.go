@@ -2,6 +2,7 @@ package types
 
 import (
 	"encoding/base64"
+	"errors"
 	"log"
 	"strconv"
 	"sync"
@@ -28,39 +29,154 @@ var config = webrtc.Configuration{
 
 var trackLocals = LocalTracks{}
 
+var ErrRoomNotFound = errors.New("room not found")
+var ErrCategoryNotFound = errors.New("room category not found")
+
+// Room kinds. Voice rooms carry chat and media, text and announcement
+// rooms chat only. Only members with PermPostAnnouncements can post in
+// announcement rooms and only those with PermStageSpeaker can publish
+// media on a stage.
+const (
+	RoomKindText         = "text"
+	RoomKindVoice        = "voice"
+	RoomKindStage        = "stage"
+	RoomKindAnnouncement = "announcement"
+	// RoomKindDirect rooms hold direct conversations. They have no
+	// channel, so their ChannelID is 0, and only chat.
+	RoomKindDirect = "dm"
+)
+
+func IsRoomKind(kind string) bool {
+	switch kind {
+	case RoomKindText, RoomKindVoice, RoomKindStage, RoomKindAnnouncement:
+		return true
+	}
+	return false
+}
+
 type LocalTracks struct {
 	mu     sync.RWMutex
 	tracks map[string]*webrtc.TrackLocalStaticRTP
 }
 
 type Room struct {
-	mu        sync.RWMutex
-	ID        int                     `json:"id"`
-	Name      string                  `json:"name"`
-	ChannelID int                     `json:"channel_id"`
-	Clients   map[*Client]*ClientInfo `json:"-"`
-	Bus       *EventBus               `json:"-"`
+	mu         sync.RWMutex
+	ID         int                     `json:"id"`
+	Name       string                  `json:"name"`
+	ChannelID  int                     `json:"channel_id"`
+	IsPrivate  bool                    `json:"is_private"`
+	Topic      string                  `json:"topic"`
+	Position   int                     `json:"position"`
+	Kind       string                  `json:"kind"`
+	CategoryID *int                    `json:"category_id"`
+	Clients    map[*Client]*ClientInfo `json:"-"`
+	Bus        *EventBus               `json:"-"`
+	// Hub delivers thread events to followers wherever they are connected.
+	Hub *Hub `json:"-"`
+	// UnreadCount and MentionCount are set in room listings, for the
+	// member who fetched them.
+	UnreadCount  int `json:"unread_count,omitempty"`
+	MentionCount int `json:"mention_count,omitempty"`
+
+	// Events sent to every client of the room are numbered in the order
+	// the room sends them so a client that reconnects can get the ones it
+	// missed.
+	epoch  string
+	seq    int64
+	replay *replayBuffer
+	// followers maps the ID of a thread's first message to the IDs of the
+	// users following it. Following doesn't need a socket in the room.
+	followers map[int]map[string]bool
+	// users counts the holders of a direct conversation's room, see
+	// Hub.DirectRoom, and stop ends its Run once they are all gone.
+	users int
+	stop  chan struct{}
+}
+
+// RoomCategory groups rooms in a channel's sidebar.
+type RoomCategory struct {
+	ID        int    `json:"id"`
+	ChannelID int    `json:"channel_id"`
+	Name      string `json:"name"`
+	Position  int    `json:"position"`
+}
+
+type RoomCategoriesResponse struct {
+	Categories []*RoomCategory `json:"categories"`
+}
+
+// RoomCategoryMessage carries category-created, category-updated and
+// category-deleted events.
+type RoomCategoryMessage struct {
+	Type    string        `json:"type"`
+	Payload *RoomCategory `json:"payload"`
+}
+
+type RoomCategoryPayload struct {
+	Name     *string `json:"name"`
+	Position *int    `json:"position"`
 }
 
 type RoomInfo struct {
-	RoomID   int        `json:"roomId"`
-	RoomName string     `json:"roomName"`
-	Users    []UserInfo `json:"users"`
+	RoomID     int        `json:"roomId"`
+	RoomName   string     `json:"roomName"`
+	Topic      string     `json:"topic"`
+	Position   int        `json:"position"`
+	Kind       string     `json:"kind"`
+	CategoryID *int       `json:"categoryId"`
+	Users      []UserInfo `json:"users"`
 }
 
+// RoomUpdatedEvent carries a RoomInfo whenever the room's details or who
+// is in it change.
+const RoomUpdatedEvent = "room-updated"
+
 type RoomInfoMessage struct {
 	Type    string   `json:"type"`
 	Payload RoomInfo `json:"payload"`
 }
 
+// UpdateRoomPayload is the body of PATCH /Room/{id}. Nil fields are left
+// unchanged.
+type UpdateRoomPayload struct {
+	Name     *string `json:"name"`
+	Topic    *string `json:"topic"`
+	Position *int    `json:"position"`
+	// CategoryID moves the room into a category, 0 takes it out.
+	CategoryID *int `json:"category_id"`
+}
+
 type RoomsResponse struct {
 	Rooms []*Room `json:"rooms"`
 }
 
 type RoomStore interface {
 	GetRoomsInChannel(channelID int) ([]*Room, error)
+	// GetRoomsForMember lists the rooms of the member's channel they are
+	// allowed to see, which leaves out private rooms they aren't on.
+	GetRoomsForMember(member *ChannelMember) ([]*Room, error)
+	GetRoom(roomID int) (*Room, error)
 	CreateRoom(room *Room) error
+	UpdateRoom(room *Room) error
 	DeleteRoom(roomID int) (*Room, error)
+	GetRoomAccess(roomID int) (*RoomAccess, error)
+	SetRoomAccess(access *RoomAccess) error
+	GetCategories(channelID int) ([]*RoomCategory, error)
+	GetCategory(channelID, categoryID int) (*RoomCategory, error)
+	CreateCategory(category *RoomCategory) error
+	UpdateCategory(category *RoomCategory) error
+	DeleteCategory(channelID, categoryID int) error
+}
+
+// RoomAccess is the allow-list of a private room. A channel member gets
+// in if any entry matches their user ID, built-in role or custom role.
+// Members who can manage rooms always get in.
+type RoomAccess struct {
+	RoomID        int      `json:"room_id"`
+	IsPrivate     bool     `json:"is_private"`
+	UserIDs       []int    `json:"user_ids"`
+	Roles         []string `json:"roles"`
+	CustomRoleIDs []int    `json:"custom_role_ids"`
 }
 
 func (r *Room) Run() {
@@ -73,12 +189,15 @@ func (r *Room) Run() {
 	r.Bus.Subscribe(EventRegister, registerCh)
 	r.Bus.Subscribe(EventBroadcast, broadcastCh)
 	r.Bus.Subscribe(EventUnregister, unRegisterCh)
+	r.startSequence()
 
-	go func() {
-		for range time.NewTicker(time.Second * 3).C {
-			dispatchKeyFrame(r)
-		}
-	}()
+	if r.HasMedia() {
+		go func() {
+			for range time.NewTicker(time.Second * 3).C {
+				dispatchKeyFrame(r)
+			}
+		}()
+	}
 
 	for {
 		select {
@@ -86,8 +205,16 @@ func (r *Room) Run() {
 			r.handleRegister(event.Payload.(*Client))
 		case event := <-unRegisterCh:
 			r.handleUnregister(event.Payload.(*Client))
+			if r.Kind == RoomKindDirect && r.Hub != nil {
+				r.Hub.ReleaseDirectRoom(r)
+			}
 		case event := <-broadcastCh:
 			r.handleBroadcast(event.Payload.([]byte))
+		case <-r.stop:
+			r.Bus.Unsubscribe(EventRegister, registerCh)
+			r.Bus.Unsubscribe(EventBroadcast, broadcastCh)
+			r.Bus.Unsubscribe(EventUnregister, unRegisterCh)
+			return
 		}
 	}
 }
@@ -100,13 +227,27 @@ func (r *Room) handleRegister(client *Client) {
 		MediaTracks: make(map[string]*TrackInfo),
 	}
 	r.mu.Unlock()
+	r.resume(client)
+	if !r.HasMedia() {
+		handleChatMessage(r, r.sequence(utils.Marshal(r.Info())))
+		return
+	}
 	r.handleCreateOffer(client)
 }
 
+// HasMedia reports whether the room runs the SFU. Text and announcement
+// rooms never create PeerConnections.
+func (r *Room) HasMedia() bool {
+	return r.Kind == RoomKindVoice || r.Kind == RoomKindStage || r.Kind == ""
+}
+
 func dispatchKeyFrame(r *Room) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 	for client := range r.Clients {
+		if client.PeerConnection == nil {
+			continue
+		}
 		for _, receiver := range client.PeerConnection.GetReceivers() {
 			if receiver.Track() == nil {
 				continue
@@ -132,10 +273,33 @@ func (r *Room) handleUnregister(client *Client) {
 		}
 		delete(r.Clients, client)
 	}
-	handleChatMessageNoLock(r, utils.Marshal(r.ToResponse()))
+	handleChatMessageNoLock(r, r.sequence(utils.Marshal(r.ToResponse())))
 	close(client.Send)
 }
 
+// Update applies new details to the room kept in the hub.
+func (r *Room) Update(updated *Room) {
+	r.mu.Lock()
+	r.Name = updated.Name
+	r.Topic = updated.Topic
+	r.Position = updated.Position
+	r.CategoryID = updated.CategoryID
+	r.mu.Unlock()
+}
+
+// PublishUpdate has the room send its clients its current state, after
+// Update changed its details.
+func (r *Room) PublishUpdate() {
+	r.Publish(RoomInfoMessage{Type: RoomUpdatedEvent})
+}
+
+// Info returns the room's state under its lock.
+func (r *Room) Info() RoomInfoMessage {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.ToResponse()
+}
+
 func (r *Room) ToResponse() RoomInfoMessage {
 	// Use a map to track unique user IDs
 	userMap := make(map[string]UserInfo)
@@ -178,11 +342,15 @@ func (r *Room) ToResponse() RoomInfoMessage {
 	}
 
 	return RoomInfoMessage{
-		Type: "room-updated",
+		Type: RoomUpdatedEvent,
 		Payload: RoomInfo{
-			RoomID:   r.ID,
-			RoomName: r.Name,
-			Users:    users,
+			RoomID:     r.ID,
+			RoomName:   r.Name,
+			Topic:      r.Topic,
+			Position:   r.Position,
+			Kind:       r.Kind,
+			CategoryID: r.CategoryID,
+			Users:      users,
 		},
 	}
 }
@@ -194,6 +362,11 @@ func (r *Room) handleBroadcast(payload []byte) {
 		return
 	}
 
+	if !r.HasMedia() && !isChatEvent(msg.Type) {
+		log.Printf("Ignoring %s in %s room %d", msg.Type, r.Kind, r.ID)
+		return
+	}
+
 	switch msg.Type {
 	// TODO: handle tracks separately as we'll have to update client state on the hub
 	// TODO: this is only used for toggleMicSharing
@@ -201,8 +374,16 @@ func (r *Room) handleBroadcast(payload []byte) {
 		handleUserStateUpdate(r, msg)
 	case "track-metadata":
 		handleTrackMetadata(r, msg)
-	case "chat-message":
-		handleChatMessage(r, payload)
+	case "chat-message", MessageEdited, MessageDeleted, ReactionAdded, ReactionRemoved:
+		if msg.ParentID != nil {
+			handleThreadMessage(r, *msg.ParentID, payload)
+		} else {
+			handleChatMessage(r, r.sequence(payload))
+		}
+	case ThreadUpdated, ReadReceiptEvent:
+		handleChatMessage(r, r.sequence(payload))
+	case RoomUpdatedEvent:
+		handleChatMessage(r, r.sequence(utils.Marshal(r.Info())))
 	case "webrtc-answer", "webrtc-ice-candidate", "webrtc-offer":
 		handleWebRTCEvent(r, msg)
 	default:
@@ -210,6 +391,15 @@ func (r *Room) handleBroadcast(payload []byte) {
 	}
 }
 
+func isChatEvent(eventType string) bool {
+	switch eventType {
+	case "chat-message", MessageEdited, MessageDeleted, ThreadUpdated, ReactionAdded, ReactionRemoved,
+		ReadReceiptEvent, RoomUpdatedEvent:
+		return true
+	}
+	return false
+}
+
 func (r *Room) handleCreateOffer(client *Client) {
 	client.mu.Lock()
 	defer client.mu.Unlock()
@@ -282,23 +472,11 @@ func (r *Room) handleCreateOffer(client *Client) {
 		pc.OnTrack(func(track *webrtc.TrackRemote, reciever *webrtc.RTPReceiver) {
 			log.Printf("Received track of kind %s from client %d with id %s", track.Kind().String(), clientID, track.ID())
 
-			r.mu.Lock()
-			clientInfo, exists := r.Clients[client]
-			if !exists {
-				log.Printf("Client info not found for client %d", clientID)
-				r.mu.Unlock()
+			trackInfo, ok := r.acceptTrack(client, track)
+			if !ok {
 				return
 			}
 
-			// Look up the existing TrackInfo by track ID
-			trackInfo, trackExists := clientInfo.MediaTracks[track.StreamID()]
-			if !trackExists {
-				log.Printf("TrackInfo not found for track ID %s; and stream ID %s.", track.ID(), track.StreamID())
-				r.mu.Unlock()
-				return
-			}
-			r.mu.Unlock()
-
 			trackLocal := addTrack(r, track)
 			trackInfo.Track = trackLocal
 			defer removeTrack(r, client, trackLocal)
@@ -588,22 +766,10 @@ func (r *Room) handleWebRTCOffer(msg Message) {
 		}
 		log.Printf("Received track of kind %s from client %d with id %s", kind, senderID, track.ID())
 
-		r.mu.Lock()
-		clientInfo, exists := r.Clients[sender]
-		if !exists {
-			log.Printf("Client info not found for client %d", senderID)
-			r.mu.Unlock()
-			return
-		}
-
-		// Look up the existing TrackInfo by track ID
-		trackInfo, trackExists := clientInfo.MediaTracks[track.StreamID()]
-		if !trackExists {
-			log.Printf("TrackInfo not found for track ID %s; and stream ID %s.", track.ID(), track.StreamID())
-			r.mu.Unlock()
+		trackInfo, ok := r.acceptTrack(sender, track)
+		if !ok {
 			return
 		}
-		r.mu.Unlock()
 
 		trackLocal := addTrack(r, track)
 		trackInfo.Track = trackLocal
@@ -684,6 +850,41 @@ func (r *Room) handleWebRTCAnswer(msg Message) {
 	log.Printf("Successfully set answer remote description for client %d", senderID)
 }
 
+// acceptTrack returns the track-metadata a client declared for a track it
+// is sending. The track is refused when it wasn't declared, when its kind
+// doesn't match the declared type, or when the client isn't allowed to
+// publish that type, since the metadata is all requiredPermission saw.
+func (r *Room) acceptTrack(client *Client, track *webrtc.TrackRemote) (*TrackInfo, bool) {
+	r.mu.RLock()
+	var trackInfo *TrackInfo
+	if info, exists := r.Clients[client]; exists {
+		trackInfo = info.MediaTracks[track.StreamID()]
+	}
+	r.mu.RUnlock()
+	if trackInfo == nil {
+		log.Printf("TrackInfo not found for track ID %s; and stream ID %s.", track.ID(), track.StreamID())
+		return nil, false
+	}
+
+	kind := webrtc.RTPCodecTypeVideo
+	if trackInfo.Kind == "audio" {
+		kind = webrtc.RTPCodecTypeAudio
+	}
+	if track.Kind() != kind {
+		log.Printf("Refusing %s track %s from client %s declared as %s", track.Kind(), track.ID(), client.ID,
+			trackInfo.Kind)
+		return nil, false
+	}
+
+	perm := requiredPermission(&Message{Type: "track-metadata", TrackType: trackInfo.Kind}, r)
+	if perm != 0 && (client.Permissions == nil || !client.can(client.Permissions, r, perm)) {
+		log.Printf("Refusing %s track %s from client %s: missing permission %d", trackInfo.Kind, track.ID(),
+			client.ID, perm)
+		return nil, false
+	}
+	return trackInfo, true
+}
+
 func addTrack(r *Room, t *webrtc.TrackRemote) *webrtc.TrackLocalStaticRTP {
 	trackLocals.mu.Lock()
 	defer func() {
@@ -808,14 +1009,8 @@ func handleTrackMetadata(r *Room, msg Message) {
 	info.MediaTracks[msg.StreamID] = newTrack
 	log.Printf("New track added for client %s: %+v", client.ID, *newTrack)
 
-	// Notify all clients except the sender about the updated room state
-	roomState := r.ToResponse()
-	for otherClient := range r.Clients {
-		if otherClient.ID == strconv.Itoa(msg.SenderID) {
-			continue
-		}
-		otherClient.Send <- utils.Marshal(roomState)
-	}
+	// Notify all clients about the updated room state
+	sendStateNoLock(r)
 }
 
 func handleUserStateUpdate(r *Room, msg Message) {
@@ -853,14 +1048,8 @@ func handleUserStateUpdate(r *Room, msg Message) {
 		log.Printf("Updated ScreenEnabled for client %v: %v", client.ID, *msg.IsScreenEnabled)
 	}
 
-	// Notify all clients except sender about the updated state
-	roomState := r.ToResponse()
-	for client := range r.Clients {
-		if client.ID == strconv.Itoa(msg.SenderID) {
-			continue
-		}
-		client.Send <- utils.Marshal(roomState)
-	}
+	// Notify all clients about the updated room state
+	sendStateNoLock(r)
 }
 
 func removeTrackNoLock(r *Room, client *Client, trackID string) {
@@ -902,12 +1091,97 @@ func handleChatMessageNoLock(r *Room, msg []byte) {
 	}
 }
 
+// sendStateNoLock numbers the room's state and sends it to every client,
+// the one that changed it included so nobody sees a gap in the sequence.
+// A client that is behind misses it but can tell from the next seq. The
+// room's lock must be held.
+func sendStateNoLock(r *Room) {
+	msg := r.sequence(utils.Marshal(r.ToResponse()))
+	for client := range r.Clients {
+		select {
+		case client.Send <- msg:
+		default:
+			log.Printf("Dropping room state for slow client %s", client.ID)
+		}
+	}
+}
+
 func handleChatMessage(r *Room, msg []byte) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 	handleChatMessageNoLock(r, msg)
 }
 
+// handleThreadMessage sends a reply, or an event about one, to the users
+// following the thread, on every socket they have open.
+func handleThreadMessage(r *Room, parentID int, msg []byte) {
+	r.mu.RLock()
+	followers := make([]string, 0, len(r.followers[parentID]))
+	for userID := range r.followers[parentID] {
+		followers = append(followers, userID)
+	}
+	r.mu.RUnlock()
+
+	for _, userID := range followers {
+		if r.Hub != nil {
+			r.Hub.SendToUser(userID, msg)
+		} else {
+			r.SendToUser(userID, msg)
+		}
+	}
+}
+
+// SubscribeThread makes the user follow the thread started by parentID,
+// or stop following it.
+func (r *Room) SubscribeThread(userID string, parentID int, subscribe bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !subscribe {
+		delete(r.followers[parentID], userID)
+		if len(r.followers[parentID]) == 0 {
+			delete(r.followers, parentID)
+		}
+		return
+	}
+	if r.followers == nil {
+		r.followers = make(map[int]map[string]bool)
+	}
+	if r.followers[parentID] == nil {
+		r.followers[parentID] = make(map[string]bool)
+	}
+	r.followers[parentID][userID] = true
+}
+
+// ThreadFollowers lists the users following any of the room's threads.
+func (r *Room) ThreadFollowers() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	seen := make(map[string]bool)
+	followers := make([]string, 0)
+	for _, users := range r.followers {
+		for userID := range users {
+			if !seen[userID] {
+				seen[userID] = true
+				followers = append(followers, userID)
+			}
+		}
+	}
+	return followers
+}
+
+// UnfollowThreads stops every thread of the room the user follows, for
+// when they lose access to it.
+func (r *Room) UnfollowThreads(userID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for parentID, users := range r.followers {
+		delete(users, userID)
+		if len(users) == 0 {
+			delete(r.followers, parentID)
+		}
+	}
+}
+
 func handleWebRTCEvent(r *Room, message Message) {
 	switch message.Type {
 	case "webrtc-answer":
@@ -931,3 +1205,51 @@ func sendToClient(r *Room, client *Client, message []byte) {
 		r.mu.Unlock()
 	}
 }
+
+// Broadcast sends a server event to every client in the room, dropping it
+// for clients that are behind. Only the room's goroutine evicts them, so it
+// is safe to call from anywhere.
+func (r *Room) Broadcast(msg []byte) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for client := range r.Clients {
+		select {
+		case client.Send <- msg:
+		default:
+			log.Printf("Dropping event for slow client %s", client.ID)
+		}
+	}
+}
+
+// SendToUser runs outside the room's goroutine, so it holds the read lock
+// while sending to keep clients from being closed under it, and drops the
+// event for clients that are behind rather than removing them.
+func (r *Room) SendToUser(userID string, msg []byte) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for client := range r.Clients {
+		if client.ID != userID {
+			continue
+		}
+		select {
+		case client.Send <- msg:
+		default:
+			log.Printf("Dropping event for slow client %s", client.ID)
+		}
+	}
+}
+
+// SendToClient sends an event to one of the room's clients, dropping it
+// when the client isn't registered or is behind.
+func (r *Room) SendToClient(client *Client, msg []byte) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, ok := r.Clients[client]; !ok {
+		return
+	}
+	select {
+	case client.Send <- msg:
+	default:
+		log.Printf("Dropping event for slow client %s", client.ID)
+	}
+}
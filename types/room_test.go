@@ -0,0 +1,101 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestThreadEventsReachFollowersInOtherRooms(t *testing.T) {
+	follower := &Client{ID: "1", Send: make(chan []byte, 1)}
+	other := &Client{ID: "2", Send: make(chan []byte, 1)}
+	hub := &Hub{Channels: map[int]*Channel{}}
+	thread := &Room{ID: 3, ChannelID: 1, Kind: RoomKindText, Hub: hub,
+		Clients: map[*Client]*ClientInfo{other: {}}}
+	elsewhere := &Room{ID: 4, ChannelID: 1, Kind: RoomKindVoice, Hub: hub,
+		Clients: map[*Client]*ClientInfo{follower: {}}}
+	hub.Channels[1] = &Channel{ID: 1, Rooms: map[int]*Room{3: thread, 4: elsewhere}}
+
+	thread.SubscribeThread("1", 9, true)
+	handleThreadMessage(thread, 9, []byte(`{"type":"chat-message"}`))
+	if len(follower.Send) != 1 || len(other.Send) != 0 {
+		t.Fatalf("follower got %d events, other client got %d", len(follower.Send), len(other.Send))
+	}
+	<-follower.Send
+
+	thread.SubscribeThread("1", 9, false)
+	handleThreadMessage(thread, 9, []byte(`{"type":"chat-message"}`))
+	if len(follower.Send) != 0 {
+		t.Error("unfollowed thread still delivered")
+	}
+}
+
+func TestPublishDeletionOfThreadParent(t *testing.T) {
+	room := &Room{ID: 3, Kind: RoomKindText, Bus: NewEventBus()}
+	events := make(chan Event, 4)
+	room.Bus.Subscribe(EventBroadcast, events)
+
+	room.PublishDeletion(nil, &Message{ID: 5, RoomID: 3}, []int{6, 7})
+	if len(events) != 4 {
+		t.Fatalf("got %d events, want 4", len(events))
+	}
+	var got []Message
+	for len(events) > 0 {
+		var msg Message
+		if err := json.Unmarshal((<-events).Payload.([]byte), &msg); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, msg)
+	}
+	if got[0].Type != MessageDeleted || got[0].ID != 5 || got[0].ParentID != nil {
+		t.Errorf("parent: got %+v", got[0])
+	}
+	for i, id := range []int{6, 7} {
+		if reply := got[i+1]; reply.Type != MessageDeleted || reply.ID != id || reply.ParentID == nil ||
+			*reply.ParentID != 5 {
+			t.Errorf("reply %d: got %+v", id, reply)
+		}
+	}
+	if got[3].Type != ThreadUpdated || got[3].ID != 5 || got[3].Thread == nil || got[3].Thread.ReplyCount != 0 {
+		t.Errorf("thread: got %+v", got[3])
+	}
+}
+
+func TestDirectRoomStopsWhenReleased(t *testing.T) {
+	hub := &Hub{}
+	create := func() *Room {
+		return &Room{ID: 5, Kind: RoomKindDirect, Hub: hub, Clients: map[*Client]*ClientInfo{}, Bus: NewEventBus()}
+	}
+	room := hub.DirectRoom(5, create)
+	if again := hub.DirectRoom(5, create); again != room {
+		t.Fatal("second caller got another room")
+	}
+	room.SubscribeThread("1", 9, true)
+
+	hub.ReleaseDirectRoom(room)
+	hub.ReleaseDirectRoom(room)
+	if hub.DirectRoom(5, nil) != room {
+		t.Fatal("room with a followed thread was evicted")
+	}
+	room.SubscribeThread("1", 9, false)
+	hub.ReleaseDirectRoom(room)
+	if hub.DirectRoom(5, nil) != nil {
+		t.Fatal("unused room is still live")
+	}
+	select {
+	case <-room.stop:
+	default:
+		t.Error("evicted room wasn't stopped")
+	}
+}
+
+func TestBroadcastKeepsSlowClients(t *testing.T) {
+	slow := &Client{ID: "1", Send: make(chan []byte, 1)}
+	room := &Room{ID: 3, Kind: RoomKindText, Clients: map[*Client]*ClientInfo{slow: {}}}
+
+	for i := 0; i < 3; i++ {
+		room.Broadcast([]byte(`{"type":"channel-updated"}`))
+	}
+	if _, ok := room.Clients[slow]; !ok || len(slow.Send) != 1 {
+		t.Errorf("slow client was evicted or got %d events", len(slow.Send))
+	}
+}
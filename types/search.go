@@ -0,0 +1,44 @@
+package types
+
+import "time"
+
+// SearchQuery filters a message search. Results are limited to rooms
+// UserID can read.
+type SearchQuery struct {
+	UserID        int
+	Text          string
+	ChannelID     *int
+	RoomID        *int
+	SenderID      *int
+	Since         *time.Time
+	Until         *time.Time
+	HasAttachment bool
+	Limit         int
+	Offset        int
+}
+
+// SearchResult is a matching message. Snippet is HTML-escaped message text
+// with the matched terms wrapped in <mark> tags.
+type SearchResult struct {
+	MessageID  int       `json:"message_id"`
+	RoomID     int       `json:"room_id"`
+	ChannelID  int       `json:"channel_id"` // 0 in direct conversations
+	ParentID   *int      `json:"parent_id,omitempty"`
+	SenderID   int       `json:"sender_id"`
+	SenderName string    `json:"sender_name"`
+	Timestamp  time.Time `json:"timestamp"`
+	Snippet    string    `json:"snippet"`
+	Rank       float64   `json:"rank"`
+}
+
+type SearchStore interface {
+	// SearchMessages returns up to Limit+1 results, best match first, so
+	// callers can tell whether there is another page.
+	SearchMessages(query *SearchQuery) ([]*SearchResult, error)
+}
+
+type SearchResponse struct {
+	Results    []*SearchResult `json:"results"`
+	HasMore    bool            `json:"has_more"`
+	NextOffset int             `json:"next_offset,omitempty"`
+}
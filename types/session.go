@@ -0,0 +1,72 @@
+package types
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrSessionNotFound      = errors.New("session not found")
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenReused   = errors.New("refresh token reuse detected")
+)
+
+// A Session is a refresh token family. Every refresh rotates the token
+// but keeps the session, so revoking the session kills the whole family.
+type Session struct {
+	ID        string     `json:"id"`
+	UserID    int        `json:"user_id"`
+	Device    string     `json:"device"`
+	IPAddress string     `json:"ip_address"`
+	UserAgent string     `json:"user_agent"`
+	CreatedAt time.Time  `json:"created_at"`
+	LastSeen  time.Time  `json:"last_seen"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	Current   bool       `json:"current"`
+}
+
+func (s *Session) IsActive() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
+
+type RefreshToken struct {
+	TokenHash string
+	SessionID string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+type SessionStore interface {
+	CreateSession(session *Session) error
+	GetSession(sessionID string) (*Session, error)
+	GetActiveSessionsForUser(userID int) ([]*Session, error)
+	TouchSession(sessionID string) error
+	ExtendSession(sessionID string, expiresAt time.Time) error
+	RevokeSession(sessionID string) error
+	RevokeUserSessions(userID int) ([]string, error)
+	CreateRefreshToken(token *RefreshToken) error
+	UseRefreshToken(tokenHash string) (*RefreshToken, error)
+}
+
+type AuthTokens struct {
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+	SessionID        string
+}
+
+type RefreshRequestPayload struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type SessionsResponse struct {
+	Sessions []*Session `json:"sessions"`
+}
+
+type RefreshResponse struct {
+	Success   bool      `json:"success"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -1,15 +1,35 @@
 package types
 
 import (
+	"errors"
+	"strings"
 	"time"
 )
 
+var ErrUserNotFound = errors.New("user not found")
+
+// BotEmailDomain is where bot accounts get their address. It is reserved,
+// people can't register or sign in with an address there.
+const BotEmailDomain = "bots.invalid"
+
+func IsBotEmail(email string) bool {
+	return strings.HasSuffix(strings.ToLower(strings.TrimSpace(email)), "@"+BotEmailDomain)
+}
+
 type User struct {
 	ID        int       `json:"id"`
 	Username  string    `json:"email"`
 	Password  string    `json:"-"`
 	CreatedAt time.Time `json:"created_at"`
 	Avatar    []byte    `json:"avatar"`
+
+	EmailVerified bool `json:"email_verified"`
+	// Bots authenticate with API tokens only and belong to OwnerID.
+	IsBot   bool `json:"is_bot"`
+	OwnerID *int `json:"owner_id,omitempty"`
+	// DeactivatedAt is set on bots their owner turned off. They can't
+	// authenticate, get new tokens or join channels any more.
+	DeactivatedAt *time.Time `json:"deactivated_at,omitempty"`
 }
 
 type UserInfo struct {
@@ -31,6 +51,8 @@ type UserStore interface {
 	GetUserByEmail(email string) (*User, error)
 	GetUserByID(id int) (*User, error)
 	CreateUser(User) (int, error)
+	UpdatePassword(userID int, hashedPassword string) error
+	SetEmailVerified(userID int) error
 }
 
 // TODO: move to hub type definition file